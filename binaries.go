@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// ==================== OPTIONAL BINARIES ====================
+
+// Several tools shell out to programs that may not be installed. They are
+// looked up at startup (for the system prompt) and again on each call, so
+// a missing program yields an explicit "tool unavailable" result rather
+// than empty output. find, grep and the clipboard have Go fallbacks, used
+// when settings.NativeFallback is on.
+
+type optionalBinary struct {
+	Name     string
+	Tools    []string // tools that need it
+	Native   bool     // has a built-in fallback
+	Optional bool     // only reported missing in a project of type Project
+	Project  string
+}
+
+var optionalBinaries = []optionalBinary{
+	{Name: "find", Tools: []string{"find"}, Native: true},
+	{Name: "grep", Tools: []string{"grep"}, Native: true},
+	{Name: "git", Tools: []string{"git", "blame", "log"}},
+	{Name: "python3", Tools: []string{"python"}},
+	{Name: "node", Tools: []string{"node"}},
+	{Name: "go", Tools: []string{"gorun"}, Optional: true, Project: "go"},
+	{Name: "bash", Tools: []string{"bash"}, Optional: true},
+	{Name: "ruby", Tools: []string{"ruby"}, Optional: true, Project: "ruby"},
+	{Name: "chrome", Tools: []string{"browser"}, Optional: true},
+}
+
+// Whether a missing b is worth mentioning in this project
+func binaryMatters(b optionalBinary) bool {
+	return !b.Optional || b.Project != "" && b.Project == projectType
+}
+
+// Last lookup result per binary
+var binaryFound = map[string]bool{}
+
+func checkBinaries() {
+	for _, b := range optionalBinaries {
+		haveBinary(b.Name)
+	}
+}
+
+func haveBinary(name string) bool {
+	_, err := exec.LookPath(name)
+	if name == "chrome" { // under any of its names
+		err = nil
+		if chromePath() == "" {
+			err = exec.ErrNotFound
+		}
+	}
+	binaryFound[name] = err == nil
+	return err == nil
+}
+
+// The result handed back when a tool's program is missing
+func toolUnavailable(bin string, native bool) string {
+	if native {
+		return fmt.Sprintf("Error: tool unavailable: install %s or enable native fallback in /settings", bin)
+	}
+	return fmt.Sprintf("Error: tool unavailable: install %s", bin)
+}
+
+// Tools that can't run at all here, per the last lookup
+func unavailableTools() []string {
+	var tools []string
+	for _, b := range optionalBinaries {
+		if found, checked := binaryFound[b.Name]; checked && !found && !(b.Native && settings.NativeFallback) {
+			tools = append(tools, b.Tools...)
+		}
+	}
+	return tools
+}
+
+func isToolUnavailable(name string) bool {
+	for _, t := range unavailableTools() {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SISTEM line for the system prompt, "" when everything is there
+func binariesPrompt() string {
+	var missing []string
+	for _, b := range optionalBinaries {
+		if found, checked := binaryFound[b.Name]; checked && !found && binaryMatters(b) {
+			if b.Native && settings.NativeFallback {
+				missing = append(missing, b.Name+" (pakai fallback bawaan)")
+			} else {
+				missing = append(missing, b.Name+" (tool "+strings.Join(b.Tools, ", ")+" tidak bisa dipakai)")
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "\n- Tidak terpasang: " + strings.Join(missing, ", ")
+}
+
+// Startup notice listing what is missing
+func binariesNotice() string {
+	var missing []string
+	for _, b := range optionalBinaries {
+		if found, checked := binaryFound[b.Name]; checked && !found && binaryMatters(b) {
+			missing = append(missing, b.Name)
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	msg := fmt.Sprintf("%s⚠ Not installed: %s", colorYellow, strings.Join(missing, ", "))
+	var tools []string
+	for _, t := range unavailableTools() {
+		for _, b := range optionalBinaries {
+			if slices.Contains(b.Tools, t) && binaryMatters(b) {
+				tools = append(tools, t)
+			}
+		}
+	}
+	if len(tools) > 0 {
+		msg += fmt.Sprintf(" (tools off: %s)", strings.Join(tools, ", "))
+	}
+	return msg + colorReset
+}
+
+// ==================== NATIVE FALLBACKS ====================
+
+// Case-insensitive name match like find -iname '*pattern*', max depth 6
+func nativeFind(root, pattern string) []string {
+	var found []string
+	pattern = strings.ToLower(pattern)
+	depth := strings.Count(root, string(os.PathSeparator))
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == "node_modules" || d.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if path != root && strings.Contains(strings.ToLower(d.Name()), pattern) {
+			found = append(found, path)
+		}
+		if d.IsDir() && strings.Count(path, string(os.PathSeparator))-depth >= 6 {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return found
+}
+
+// Case-insensitive regexp search like grep -rni, as path:line:text
+func nativeGrep(root, pattern string) []string {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		re = regexp.MustCompile("(?i)" + regexp.QuoteMeta(pattern))
+	}
+	var matches []string
+	search := func(path string) {
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+			return // unreadable or binary
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for n := 1; sc.Scan(); n++ {
+			if re.MatchString(sc.Text()) {
+				matches = append(matches, fmt.Sprintf("%s:%d:%s", path, n, sc.Text()))
+			}
+		}
+	}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		search(root)
+		return matches
+	}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == "node_modules" || d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.Contains(d.Name(), ".") { // --include=*.*
+			search(path)
+		}
+		return nil
+	})
+	return matches
+}
+
+// Clipboard programs to try, in order, for this OS
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+	cmds := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}, {"clip.exe"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append([][]string{{"wl-copy"}}, cmds...)
+	}
+	return cmds
+}
+
+// OSC 52 asks the terminal itself to set the clipboard; works over SSH in
+// most modern terminals, silently ignored by the rest
+func osc52Copy(text string) {
+	fmt.Printf("\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+}
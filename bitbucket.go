@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ==================== BITBUCKET ====================
+
+// The Bitbucket Cloud REST API (2.0). Credentials are an access token in
+// BITBUCKET_TOKEN, or a username and app password in BITBUCKET_USERNAME
+// and BITBUCKET_APP_PASSWORD, or either kept in the keychain with
+// /pr login <token> or /pr login <user>:<app password>.
+
+func bitbucketToken() (string, error) {
+	if token := os.Getenv("BITBUCKET_TOKEN"); token != "" {
+		return token, nil
+	}
+	if user, pass := os.Getenv("BITBUCKET_USERNAME"), os.Getenv("BITBUCKET_APP_PASSWORD"); user != "" && pass != "" {
+		return user + ":" + pass, nil
+	}
+	if token, err := keychainGet(forgeKeychain(forgeBitbucket)); err == nil {
+		return token, nil
+	}
+	return "", fmt.Errorf("no Bitbucket credentials: set BITBUCKET_TOKEN (or BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD), or /pr login <token> to keep them in the keychain")
+}
+
+func bitbucketAPI(r forgeRepo, method, path string, in, out any) error {
+	token, err := bitbucketToken()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if strings.Contains(token, ":") {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+	} else {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	header.Set("Accept", "application/json")
+	base := "https://api.bitbucket.org/2.0/repositories/" + r.String()
+	if err := forgeHTTP(method, base+path, header, in, out); err != nil {
+		return fmt.Errorf("Bitbucket %s", err)
+	}
+	return nil
+}
+
+func bitbucketDefaultBranch(r forgeRepo) (string, error) {
+	var info struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	err := bitbucketAPI(r, "GET", "", nil, &info)
+	return info.MainBranch.Name, err
+}
+
+type bitbucketLinks struct {
+	HTML struct {
+		Href string `json:"href"`
+	} `json:"html"`
+}
+
+type bitbucketPull struct {
+	ID     int            `json:"id"`
+	Links  bitbucketLinks `json:"links"`
+	Source struct {
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+}
+
+func (p bitbucketPull) request() *forgeRequest {
+	return &forgeRequest{Number: p.ID, URL: p.Links.HTML.Href, SHA: p.Source.Commit.Hash}
+}
+
+func bitbucketOpenRequest(r forgeRepo, branch string) (*forgeRequest, error) {
+	var open struct {
+		Values []bitbucketPull `json:"values"`
+	}
+	q := fmt.Sprintf(`source.branch.name=%q AND state="OPEN"`, branch)
+	if err := bitbucketAPI(r, "GET", "/pullrequests?q="+url.QueryEscape(q), nil, &open); err != nil || len(open.Values) == 0 {
+		return nil, err
+	}
+	return open.Values[0].request(), nil
+}
+
+func bitbucketCreateRequest(r forgeRepo, branch, base, title, body string, draft bool) (*forgeRequest, error) {
+	var pr bitbucketPull
+	req := map[string]any{
+		"title": title, "description": body, "draft": draft,
+		"source":      map[string]any{"branch": map[string]string{"name": branch}},
+		"destination": map[string]any{"branch": map[string]string{"name": base}},
+	}
+	if err := bitbucketAPI(r, "POST", "/pullrequests", req, &pr); err != nil {
+		return nil, err
+	}
+	return pr.request(), nil
+}
+
+func bitbucketIssue(r forgeRepo, number string, pull bool) (*forgeIssue, error) {
+	type user struct {
+		DisplayName string `json:"display_name"`
+	}
+	type content struct {
+		Raw string `json:"raw"`
+	}
+	var issue struct {
+		Title       string         `json:"title"`
+		State       string         `json:"state"`
+		Description string         `json:"description"` // pull requests
+		Content     content        `json:"content"`     // issues
+		Author      user           `json:"author"`
+		Reporter    user           `json:"reporter"`
+		Links       bitbucketLinks `json:"links"`
+	}
+	path, kind := "/issues/"+number, "issue"
+	if pull {
+		path, kind = "/pullrequests/"+number, "pull request"
+	}
+	if err := bitbucketAPI(r, "GET", path, nil, &issue); err != nil {
+		return nil, err
+	}
+	result := &forgeIssue{Kind: kind, Title: issue.Title, State: strings.ToLower(issue.State), URL: issue.Links.HTML.Href,
+		Body: issue.Description, Author: issue.Author.DisplayName}
+	if !pull {
+		result.Body, result.Author = issue.Content.Raw, issue.Reporter.DisplayName
+	}
+
+	var comments struct {
+		Size   int `json:"size"`
+		Values []struct {
+			Content   content `json:"content"`
+			User      user    `json:"user"`
+			CreatedOn string  `json:"created_on"`
+			Deleted   bool    `json:"deleted"`
+		} `json:"values"`
+	}
+	query := fmt.Sprintf("/comments?sort=-created_on&pagelen=%d", forgeMentionComments)
+	if err := bitbucketAPI(r, "GET", path+query, nil, &comments); err != nil {
+		return nil, err
+	}
+	result.Total = comments.Size
+	for _, c := range comments.Values {
+		if !c.Deleted {
+			result.Comments = append([]forgeComment{{Author: c.User.DisplayName, Date: c.CreatedOn, Body: c.Content.Raw}}, result.Comments...)
+		}
+	}
+	return result, nil
+}
+
+func bitbucketChecks(r forgeRepo, sha string) ([]ciCheck, error) {
+	var statuses struct {
+		Values []struct {
+			Key   string `json:"key"`
+			Name  string `json:"name"`
+			State string `json:"state"`
+			URL   string `json:"url"`
+		} `json:"values"`
+	}
+	if err := bitbucketAPI(r, "GET", "/commit/"+sha+"/statuses?pagelen=100", nil, &statuses); err != nil {
+		return nil, err
+	}
+	var checks []ciCheck
+	for _, s := range statuses.Values {
+		state := "pending"
+		switch s.State {
+		case "SUCCESSFUL":
+			state = "success"
+		case "FAILED", "STOPPED":
+			state = "failure"
+		}
+		name := s.Name
+		if name == "" {
+			name = s.Key
+		}
+		checks = append(checks, ciCheck{Name: name, State: state, URL: s.URL})
+	}
+	return checks, nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// ==================== HEADLESS BROWSER ====================
+
+// The browser tool drives a headless Chrome or Chromium for pages that
+// are empty without JavaScript (single-page apps, many docs sites and
+// dashboards). The browser starts on first use with a throwaway profile
+// and stays up, on the same page, until browser:close or exit, so the
+// model can open a page, click, type and look again. It is driven with
+// chromedp. Pages come back as markdown like fetch; screenshots go to a
+// file the image tool can read.
+
+const (
+	browserWait    = 15 * time.Second // for a page to settle or an element to appear
+	browserTextMax = 8000
+)
+
+var browser struct {
+	ctx                 context.Context // the page; nil when not running
+	cancel, allocCancel context.CancelFunc
+	shots               int
+}
+
+// The Chrome, Chromium or Edge to run: CHROME_PATH, the PATH, then the
+// usual install locations
+func chromePath() string {
+	if path := os.Getenv("CHROME_PATH"); path != "" {
+		return path
+	}
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome", "microsoft-edge"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	var paths []string
+	switch runtime.GOOS {
+	case "darwin":
+		paths = []string{"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome", "/Applications/Chromium.app/Contents/MacOS/Chromium"}
+	case "windows":
+		for _, dir := range []string{os.Getenv("ProgramFiles"), os.Getenv("ProgramFiles(x86)"), os.Getenv("LocalAppData")} {
+			if dir != "" {
+				paths = append(paths, filepath.Join(dir, "Google", "Chrome", "Application", "chrome.exe"),
+					filepath.Join(dir, "Microsoft", "Edge", "Application", "msedge.exe"))
+			}
+		}
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Starts the browser, once; chromedp gives it a throwaway profile and
+// removes it again when the browser is closed
+func startBrowser() error {
+	if browser.ctx != nil {
+		return nil
+	}
+	path := chromePath()
+	if path == "" {
+		return fmt.Errorf("tool unavailable: install Chrome or Chromium (or set CHROME_PATH)")
+	}
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.ExecPath(path),
+		chromedp.Flag("headless", "new"),
+		chromedp.Flag("mute-audio", true),
+		chromedp.WindowSize(1280, 900),
+		chromedp.WSURLReadTimeout(browserWait))
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	ctx, cancel := chromedp.NewContext(allocCtx)
+	// The first Run starts the browser; it lives as long as ctx, so no
+	// timeout here
+	if err := chromedp.Run(ctx); err != nil {
+		cancel()
+		allocCancel()
+		return fmt.Errorf("starting %s: %s", filepath.Base(path), err)
+	}
+	browser.ctx, browser.cancel, browser.allocCancel = ctx, cancel, allocCancel
+	return nil
+}
+
+// Stops the browser; when mytool exits
+func closeBrowser() {
+	if browser.ctx == nil {
+		return
+	}
+	chromedp.Cancel(browser.ctx) // closes the browser cleanly
+	browser.cancel()
+	browser.allocCancel() // kills it if it didn't close, removes the profile
+	browser.ctx, browser.cancel, browser.allocCancel = nil, nil, nil
+}
+
+// Runs actions on the page, giving up after browserWait
+func browserRun(actions ...chromedp.Action) error {
+	ctx, cancel := context.WithTimeout(browser.ctx, browserWait)
+	defer cancel()
+	err := chromedp.Run(ctx, actions...)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("the page didn't respond within %s", browserWait)
+	}
+	return err
+}
+
+// Evaluates expression in the page and decodes its value into out
+func browserEval(expression string, out any) error {
+	return browserRun(chromedp.Evaluate(expression, out))
+}
+
+// browser:<action> [args]
+func cmdBrowser(arg string) string {
+	action, rest, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	rest = strings.TrimSpace(rest)
+	if action == "close" {
+		if browser.ctx == nil {
+			return "The browser isn't running"
+		}
+		closeBrowser()
+		return "Browser closed"
+	}
+	switch action {
+	case "open", "text", "click", "type", "wait", "screenshot":
+	default:
+		return "Usage: browser:open <url> | click <selector or text:Label> | type <selector>|||<text>[|||submit] | wait <selector> | text [selector] | screenshot [file] [full] | close"
+	}
+	if action == "click" || action == "type" {
+		if currentMode == ModeManual {
+			return fmt.Sprintf("%s[blocked] Manual mode%s", colorRed, colorReset)
+		}
+		if currentMode == ModeAsk && !confirmAction(fmt.Sprintf("%sBrowser %s:%s %s", colorYellow, action, colorReset, rest)) {
+			return "Cancelled"
+		}
+	}
+	if err := startBrowser(); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	var result string
+	var err error
+	switch action {
+	case "open":
+		result, err = browserOpen(rest)
+	case "text":
+		result, err = browserText(rest)
+	case "click":
+		result, err = browserClick(rest)
+	case "type":
+		result, err = browserType(rest)
+	case "wait":
+		if err = browserWaitFor(rest); err == nil {
+			result = "Found " + rest
+		}
+	case "screenshot":
+		result, err = browserScreenshot(rest)
+	}
+	if err != nil {
+		if browser.ctx.Err() != nil {
+			closeBrowser() // it went away; the next call starts a new one
+		}
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return result
+}
+
+// Only web pages: file:// and the like would get around .mytoolignore
+// and the read confinement
+func browserOpen(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", fmt.Errorf("no URL")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("only http and https URLs can be opened, not %s:", u.Scheme)
+	}
+	if settings.RespectRobots {
+		if _, err := robotsCheck(u); err != nil {
+			return "", err
+		}
+	}
+	waitForHost(u.Host, time.Duration(settings.WebHostDelayMs)*time.Millisecond)
+	if err := browserRun(chromedp.Navigate(rawURL)); err != nil {
+		return "", fmt.Errorf("loading %s: %s", rawURL, err)
+	}
+	browserSettle()
+	// A redirect may have landed somewhere its robots.txt keeps us out of
+	var location string
+	if err := browserRun(chromedp.Location(&location)); err == nil && location != rawURL && settings.RespectRobots {
+		if landed, err := url.Parse(location); err == nil && (landed.Scheme == "http" || landed.Scheme == "https") {
+			if _, err := robotsCheck(landed); err != nil {
+				browserRun(chromedp.Navigate("about:blank"))
+				return "", err
+			}
+		}
+	}
+	return browserPage()
+}
+
+// Waits until the page has loaded and its text stops changing, which is
+// when a single-page app has usually rendered
+func browserSettle() {
+	deadline := time.Now().Add(browserWait)
+	last, same := -1, 0
+	for time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+		var state struct {
+			Ready string `json:"ready"`
+			Size  int    `json:"size"`
+		}
+		if browserEval(`({ready: document.readyState, size: document.body ? document.body.innerText.length : 0})`, &state) != nil {
+			return
+		}
+		if state.Ready == "complete" && state.Size == last {
+			if same++; same >= 3 {
+				return
+			}
+		} else {
+			same = 0
+		}
+		last = state.Size
+	}
+}
+
+// The current page as markdown, as fetch would give it
+func browserPage() (string, error) {
+	var page struct {
+		HTML string `json:"html"`
+		URL  string `json:"url"`
+	}
+	if err := browserEval(`({html: document.documentElement.outerHTML, url: location.href})`, &page); err != nil {
+		return "", err
+	}
+	title, text := readablePage(page.HTML, page.URL)
+	if title != "" && !strings.HasPrefix(text, "# "+title+"\n") {
+		text = "# " + title + "\n\n" + text
+	}
+	size := len(text)
+	if size > browserTextMax {
+		text = text[:browserTextMax] + "\n... (truncated; browser:text <selector> for one part)"
+	}
+	return fmt.Sprintf("%sURL: %s (%d chars, rendered)%s\n%s", colorCyan, page.URL, size, colorReset, text), nil
+}
+
+func browserText(selector string) (string, error) {
+	if selector == "" {
+		return browserPage()
+	}
+	var text *string
+	if err := browserEval(fmt.Sprintf(`(() => { const el = document.querySelector(%s); return el ? el.innerText : null })()`, jsString(selector)), &text); err != nil {
+		return "", err
+	}
+	if text == nil {
+		return "", fmt.Errorf("nothing matches %s", selector)
+	}
+	return truncate(*text, browserTextMax), nil
+}
+
+// JavaScript that finds the element for target: a CSS selector, or
+// text:Label for the link or button that says Label
+func jsElement(target string) string {
+	if label, ok := strings.CutPrefix(target, "text:"); ok {
+		return fmt.Sprintf(`(() => { const want = %s.trim().toLowerCase();
+			const all = [...document.querySelectorAll('a, button, [role=button], input[type=submit], input[type=button], summary, label, [onclick]')];
+			return all.find(el => (el.innerText || el.value || '').trim().toLowerCase() === want)
+				|| all.find(el => (el.innerText || el.value || '').trim().toLowerCase().includes(want)) || null })()`, jsString(label))
+	}
+	return fmt.Sprintf(`document.querySelector(%s)`, jsString(target))
+}
+
+func browserClick(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("nothing to click")
+	}
+	var ok bool
+	js := fmt.Sprintf(`(() => { const el = %s; if (!el) return false; el.scrollIntoView({block: 'center'}); el.click(); return true })()`, jsElement(target))
+	if err := browserEval(js, &ok); err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("nothing matches %s", target)
+	}
+	browserSettle()
+	page, err := browserPage()
+	return "Clicked " + target + "\n" + page, err
+}
+
+// type <selector>|||<text>[|||submit]
+func browserType(arg string) (string, error) {
+	parts := strings.Split(arg, "|||")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("format: type <selector>|||<text>[|||submit]")
+	}
+	submit := len(parts) > 2 && strings.TrimSpace(parts[2]) == "submit"
+	js := fmt.Sprintf(`(() => { const el = %s; if (!el) return false;
+		el.focus();
+		const proto = el instanceof HTMLTextAreaElement ? HTMLTextAreaElement.prototype : HTMLInputElement.prototype;
+		const setter = Object.getOwnPropertyDescriptor(proto, 'value');
+		if (setter && (el instanceof HTMLInputElement || el instanceof HTMLTextAreaElement)) setter.set.call(el, %s); else el.textContent = %[2]s;
+		el.dispatchEvent(new Event('input', {bubbles: true}));
+		el.dispatchEvent(new Event('change', {bubbles: true}));
+		if (%t) {
+			const opts = {key: 'Enter', code: 'Enter', keyCode: 13, bubbles: true};
+			el.dispatchEvent(new KeyboardEvent('keydown', opts));
+			el.dispatchEvent(new KeyboardEvent('keyup', opts));
+			if (el.form) el.form.requestSubmit ? el.form.requestSubmit() : el.form.submit();
+		}
+		return true })()`, jsElement(strings.TrimSpace(parts[0])), jsString(parts[1]), submit)
+	var ok bool
+	if err := browserEval(js, &ok); err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("nothing matches %s", parts[0])
+	}
+	if !submit {
+		return "Typed into " + strings.TrimSpace(parts[0]), nil
+	}
+	browserSettle()
+	page, err := browserPage()
+	return "Submitted " + strings.TrimSpace(parts[0]) + "\n" + page, err
+}
+
+func browserWaitFor(target string) error {
+	if target == "" {
+		return fmt.Errorf("nothing to wait for")
+	}
+	for deadline := time.Now().Add(browserWait); time.Now().Before(deadline); time.Sleep(250 * time.Millisecond) {
+		var found bool
+		if err := browserEval(fmt.Sprintf(`!!(%s)`, jsElement(target)), &found); err != nil {
+			return err
+		}
+		if found {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s didn't appear within %s", target, browserWait)
+}
+
+// screenshot [file] [full]: the viewport, or the whole page with full
+func browserScreenshot(arg string) (string, error) {
+	path, full := "", false
+	for _, f := range strings.Fields(arg) {
+		if f == "full" {
+			full = true
+		} else {
+			path = f
+		}
+	}
+	var data []byte
+	shot := chromedp.CaptureScreenshot(&data)
+	if full {
+		shot = chromedp.FullScreenshot(&data, 100) // 100 is PNG
+	}
+	if err := browserRun(shot); err != nil {
+		return "", err
+	}
+	if path == "" {
+		browser.shots++
+		path = filepath.Join(os.TempDir(), fmt.Sprintf("mytool-%s-shot-%d.png", sessionID, browser.shots))
+	} else {
+		path = resolvePath(path)
+		saveForUndo(path, "screenshot")
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Screenshot saved to %s (%d KB); look at it with image:%s", path, len(data)/1024, path), nil
+}
+
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ==================== CONTEXT BUDGETS ====================
+
+// Each kind of context has a cap: memory (settings.MemoryBudget) and the
+// repo map (settings.RepoMapTokens) are cut when the system prompt is
+// built; file attachments (settings.FileBudget) and tool output
+// (settings.ToolOutputBudget) pile up in the history, so enforceBudgets
+// runs before every request and replaces the oldest of them with a short
+// note until each kind fits. Whatever remains is left for the conversation.
+
+const attachmentSep = "\n\n=== "
+
+func leftOutNote(tokens int, budget string) string {
+	return fmt.Sprintf("[left out: ~%d tokens, over the %s budget]", tokens, budget)
+}
+
+// Splits a user message into its text and @mention blocks ("=== ..." onward)
+func splitAttachments(content string) (string, []string) {
+	i := strings.Index(content, attachmentSep)
+	if i < 0 {
+		return content, nil
+	}
+	blocks := strings.Split(content[i+2:], attachmentSep)
+	for j := 1; j < len(blocks); j++ {
+		blocks[j] = "=== " + blocks[j]
+	}
+	return content[:i], blocks
+}
+
+func enforceBudgets(history []ChatMessage) []ChatMessage {
+	if settings.FileBudget > 0 {
+		enforceFileBudget(history, settings.FileBudget)
+	}
+	if settings.ToolOutputBudget > 0 {
+		enforceToolBudget(history, settings.ToolOutputBudget)
+	}
+	return history
+}
+
+func enforceFileBudget(history []ChatMessage, budget int) {
+	total := 0
+	for _, m := range history[1:] {
+		if m.Role == "user" {
+			_, blocks := splitAttachments(m.Content)
+			for _, b := range blocks {
+				total += estimateTokens(b)
+			}
+		}
+	}
+	for i := 1; i < len(history) && total > budget; i++ {
+		if history[i].Role != "user" {
+			continue
+		}
+		text, blocks := splitAttachments(history[i].Content)
+		changed := false
+		for j, b := range blocks {
+			header, body, _ := strings.Cut(b, "\n")
+			if total <= budget || strings.HasPrefix(body, "[left out:") {
+				continue
+			}
+			n := estimateTokens(b)
+			blocks[j] = header + "\n" + leftOutNote(n, "file")
+			total += estimateTokens(blocks[j]) - n
+			changed = true
+		}
+		if changed {
+			history[i].Content = text + "\n\n" + strings.Join(blocks, "\n\n")
+		}
+	}
+}
+
+func isToolResults(m ChatMessage) bool {
+	return m.Role == "user" && strings.HasPrefix(m.Content, "Results:\n")
+}
+
+// Oldest results go first; the newest is cut to the budget if it alone
+// is over
+func enforceToolBudget(history []ChatMessage, budget int) {
+	total, last := 0, -1
+	for i, m := range history {
+		if isToolResults(m) {
+			total += estimateTokens(m.Content)
+			last = i
+		}
+	}
+	for i := 1; i < last && total > budget; i++ {
+		if !isToolResults(history[i]) || strings.HasPrefix(history[i].Content, "Results:\n[left out:") {
+			continue
+		}
+		n := estimateTokens(history[i].Content)
+		history[i].Content = "Results:\n" + leftOutNote(n, "tool output")
+		total += estimateTokens(history[i].Content) - n
+	}
+	if last > 0 && total > budget {
+		// The closing instruction to the model stays
+		content, tail := history[last].Content, ""
+		if i := strings.LastIndex(content, "\n\n"); i > 0 && len(content)-i < 200 {
+			content, tail = content[:i], content[i:]
+		}
+		keep := max(0, len(content)-(total-budget)*4)
+		for keep > 0 && !utf8.RuneStart(content[keep]) {
+			keep--
+		}
+		history[last].Content = content[:keep] + "\n... (truncated to the tool output budget)" + tail
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== BUILD CHECK ====================
+
+// With Check after edits on, a turn that changed Go or TypeScript files
+// ends with go build or tsc --noEmit; errors go back to the model as the
+// next message, up to checkMaxRounds times in a row. A project sets its
+// own checks in <root>/.mytool/checks.json, file glob to command, where
+// {files} stands for the changed files that match:
+//
+//	{"*.go": "go vet ./...", "*.py": "ruff check {files}"}
+//
+// Checks go through run:, so the mode, the policy and the sandbox apply.
+
+const (
+	checkMaxRounds = 3
+	checkMaxLines  = 60 // of a failing check's output sent to the model
+)
+
+var checkRound int // consecutive rounds that ended with a failing check
+
+func projectChecksPath() string {
+	return filepath.Join(findProjectRoot(), ".mytool", "checks.json")
+}
+
+// The project's checks, or the built-in ones
+func checkCommands() map[string]string {
+	if data, err := os.ReadFile(projectChecksPath()); err == nil {
+		var checks map[string]string
+		if err := json.Unmarshal(data, &checks); err == nil {
+			return checks
+		}
+		fmt.Printf("%sInvalid .mytool/checks.json, using the built-in checks%s\n", colorYellow, colorReset)
+	}
+	checks := map[string]string{"*.go": "go build -o " + os.DevNull + " ./..."}
+	if _, err := os.Stat(filepath.Join(currentDir, "tsconfig.json")); err == nil {
+		checks["*.ts"] = "npx --no-install tsc --noEmit"
+		checks["*.tsx"] = checks["*.ts"]
+	}
+	return checks
+}
+
+// Files the model changed since t, from the undo stack
+func changedSince(t time.Time) []string {
+	seen := map[string]bool{}
+	var files []string
+	for _, action := range undoStack {
+		if action.Time.Before(t) {
+			continue
+		}
+		for _, f := range undoFiles(action) {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				files = append(files, f.Path)
+			}
+		}
+	}
+	return files
+}
+
+// Runs the checks that apply to files; the failures, "" when all pass
+func runChecks(files []string) string {
+	checks := checkCommands()
+	globs := make([]string, 0, len(checks))
+	for glob := range checks {
+		globs = append(globs, glob)
+	}
+	sort.Strings(globs)
+
+	ran := map[string]bool{}
+	var failures []string
+	for _, glob := range globs {
+		var matched []string
+		for _, f := range files {
+			if ok, _ := filepath.Match(glob, filepath.Base(f)); ok {
+				matched = append(matched, shellQuote(relPath(f)))
+			}
+		}
+		command := strings.ReplaceAll(checks[glob], "{files}", strings.Join(matched, " "))
+		if len(matched) == 0 || ran[command] {
+			continue
+		}
+		ran[command] = true
+		out := stripANSI(cmdRun(command))
+		i := strings.LastIndex(out, "\nExit: ")
+		if i < 0 {
+			i = strings.LastIndex(out, "\nKilled: ")
+		}
+		if i < 0 {
+			continue // passed, or was not allowed to run
+		}
+		lines := strings.Split(strings.TrimSpace(out[:i]), "\n")
+		if len(lines) > checkMaxLines {
+			lines = append(lines[:checkMaxLines], fmt.Sprintf("... (%d more lines)", len(lines)-checkMaxLines))
+		}
+		failures = append(failures, fmt.Sprintf("$ %s\n%s\n%s", command, strings.Join(lines, "\n"), out[i+1:]))
+	}
+	return strings.Join(failures, "\n\n")
+}
+
+// At the end of a turn: checks the files it changed and queues their
+// errors as autoPending. continuing is true when the turn was itself a
+// check round.
+func checkAfterEdits(since time.Time, continuing bool) {
+	if !continuing {
+		checkRound = 0
+	}
+	if !settings.CheckAfterEdits || autoPending != "" {
+		return
+	}
+	files := changedSince(since)
+	if len(files) == 0 {
+		return
+	}
+	fmt.Printf("\n%s─── Checking edits ───%s\n", colorCyan, colorReset)
+	failures := runChecks(files)
+	switch {
+	case failures == "":
+		if checkRound > 0 {
+			fmt.Printf("%s✓ Checks pass after %d round(s)%s\n", colorGreen, checkRound, colorReset)
+		}
+		checkRound = 0
+	case checkRound >= checkMaxRounds:
+		fmt.Printf("%s⚠ Checks still fail after %d round(s); fix them or ask again%s\n", colorYellow, checkRound, colorReset)
+		checkRound = 0
+	default:
+		checkRound++
+		autoPending = "[check] Your edits don't pass the project's checks:\n\n" + failures +
+			"\n\nFix these errors with tool calls."
+		autoLabel = fmt.Sprintf("Check round %d/%d: sending the errors", checkRound, checkMaxRounds)
+	}
+}
+
+// /check [on|off]
+func cmdCheck(arg string) string {
+	switch strings.TrimSpace(arg) {
+	case "on", "off":
+		settings.CheckAfterEdits = arg == "on"
+		saveSettings()
+	case "":
+	default:
+		return "Usage: /check [on|off]"
+	}
+	state := "off"
+	if settings.CheckAfterEdits {
+		state = "on"
+	}
+	checks := checkCommands()
+	var globs []string
+	for glob := range checks {
+		globs = append(globs, glob)
+	}
+	sort.Strings(globs)
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Check after edits: %s", state))
+	for _, glob := range globs {
+		b.WriteString(fmt.Sprintf("\n  %-8s %s", glob, checks[glob]))
+	}
+	b.WriteString(fmt.Sprintf("\n%sSet your own in %s%s", colorGray, relPath(projectChecksPath()), colorReset))
+	return b.String()
+}
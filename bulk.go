@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ==================== BULK EDIT ====================
+
+// One tool call for edits that span many files ("add this header to every
+// *.go file"): the glob is expanded, each file's change count is previewed,
+// a single approval applies all of them and one /undo reverts them.
+
+const bulkMaxFiles = 500
+
+// glob|||prepend|||text, glob|||append|||text, glob|||replace|||old|||new,
+// glob|||regex|||pattern|||replacement
+func cmdBulk(args string) string {
+	parts := strings.SplitN(args, "|||", 4)
+	if len(parts) < 3 {
+		return "Error: format glob|||prepend|append|||text or glob|||replace|regex|||old|||new"
+	}
+	glob, op := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if currentMode == ModeManual {
+		return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
+	}
+
+	var apply func(content string) (string, int)
+	switch op {
+	case "prepend", "append":
+		text := parts[2]
+		apply = func(content string) (string, int) {
+			if op == "prepend" && strings.HasPrefix(content, text) || op == "append" && strings.HasSuffix(content, text) {
+				return content, 0 // already there
+			}
+			if op == "prepend" {
+				return text + content, 1
+			}
+			return content + text, 1
+		}
+	case "replace", "regex":
+		if len(parts) < 4 {
+			return fmt.Sprintf("Error: format glob|||%s|||old|||new", op)
+		}
+		old, repl := parts[2], parts[3]
+		if op == "replace" {
+			apply = func(content string) (string, int) {
+				n := strings.Count(content, old)
+				return strings.ReplaceAll(content, old, repl), n
+			}
+			break
+		}
+		re, err := regexp.Compile(old)
+		if err != nil {
+			return fmt.Sprintf("Error: bad regex: %s", err)
+		}
+		apply = func(content string) (string, int) {
+			n := len(re.FindAllStringIndex(content, -1))
+			return re.ReplaceAllString(content, repl), n
+		}
+	default:
+		return "Error: unknown bulk operation " + op + " (prepend, append, replace, regex)"
+	}
+
+	files, err := expandGlob(glob)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if len(files) == 0 {
+		return "No files match " + glob
+	}
+	if len(files) > bulkMaxFiles {
+		return fmt.Sprintf("Error: %s matches %d files (max %d), narrow the glob", glob, len(files), bulkMaxFiles)
+	}
+
+	type change struct {
+		path, updated string
+		format        fileFormat
+		count         int
+	}
+	var changes []change
+	total := 0
+	for _, path := range files {
+		text, format, err := readText(path)
+		if err != nil || strings.IndexByte(text[:min(len(text), 8000)], 0) >= 0 {
+			continue // unreadable or binary
+		}
+		updated, n := apply(text)
+		if n == 0 || updated == text {
+			continue
+		}
+		changes = append(changes, change{path, updated, format, n})
+		total += n
+	}
+	if len(changes) == 0 {
+		return fmt.Sprintf("No changes: %d files match %s but none need %s", len(files), glob, op)
+	}
+
+	fmt.Printf("%s%s %s: %d changes in %d of %d files%s\n", colorCyan, op, glob, total, len(changes), len(files), colorReset)
+	for i, c := range changes {
+		if i == 30 {
+			fmt.Printf("  %s+%d more files%s\n", colorGray, len(changes)-30, colorReset)
+			break
+		}
+		fmt.Printf("  %s%4d%s  %s\n", colorYellow, c.count, colorReset, relPath(c.path))
+	}
+	if !confirmAction(fmt.Sprintf("%sApply to %d files?%s", colorYellow, len(changes), colorReset)) {
+		return "Cancelled"
+	}
+
+	batch := UndoAction{Type: "batch", Path: glob, Time: time.Now(), Op: "bulk " + op}
+	var summary strings.Builder
+	for _, c := range changes {
+		snap := undoSnapshot(c.path)
+		if err := writeText(c.path, c.updated, c.format); err != nil {
+			summary.WriteString(fmt.Sprintf("\n  ✗ %s: %s", relPath(c.path), err))
+			continue
+		}
+		batch.Files = append(batch.Files, snap)
+		refreshSeen(c.path)
+		summary.WriteString(fmt.Sprintf("\n  %s (%d)", relPath(c.path), c.count))
+	}
+	pushUndo(batch)
+	return fmt.Sprintf("%s✓ %s applied to %d files (%d changes, one /undo reverts all)%s%s",
+		colorGreen, op, len(batch.Files), total, colorReset, summary.String())
+}
+
+// Files under currentDir matching glob. Without a slash the pattern matches
+// file names at any depth; with one it matches the relative path, and **
+// spans directories.
+func expandGlob(glob string) ([]string, error) {
+	glob = filepath.ToSlash(glob)
+	byName := !strings.Contains(glob, "/")
+	re, err := regexp.Compile(globRegexp(glob))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	filepath.WalkDir(currentDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if projectSkipDirs[d.Name()] && path != currentDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if !byName {
+			rel, _ := filepath.Rel(currentDir, path)
+			name = filepath.ToSlash(rel)
+		}
+		if re.MatchString(name) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, nil
+}
+
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?") // **/ also matches no directory
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '{':
+			braces++
+			b.WriteString("(")
+		case '}':
+			if braces == 0 {
+				b.WriteString(`\}`)
+				break
+			}
+			braces--
+			b.WriteString(")")
+		case ',':
+			if braces == 0 {
+				b.WriteString(",")
+				break
+			}
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func relPath(path string) string {
+	if rel, err := filepath.Rel(currentDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
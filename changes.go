@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== SESSION CHANGES ====================
+
+// /changes lists the files this session has created, modified or deleted,
+// compared with how they were when the session first touched them: the
+// originals saveForUndo keeps for the model's own edits, plus whatever
+// differs from the session snapshot in git (shell commands, generators).
+// Files that are back to their original content drop off the list.
+// /changes diff shows the differences, /changes revert puts files back.
+
+type sessionChange struct {
+	Path          string
+	Before, After *string // nil: the file didn't exist
+}
+
+func (c sessionChange) Status() string {
+	switch {
+	case c.Before == nil:
+		return "new"
+	case c.After == nil:
+		return "deleted"
+	}
+	return ""
+}
+
+func readOptional(path string) *string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	content := string(data)
+	return &content
+}
+
+func sameContent(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// Files that differ from their state at the start of the session, by path
+func sessionChanges() []sessionChange {
+	var changes []sessionChange
+	for path, orig := range originalFiles {
+		if now := readOptional(path); !sameContent(orig, now) {
+			changes = append(changes, sessionChange{Path: path, Before: orig, After: now})
+		}
+	}
+
+	root := projectRootFor(currentDir)
+	if sessionSnapshot != nil && root != "" {
+		if tree, err := worktreeTree(root); err == nil {
+			out, _ := snapshotGit(root, "", "diff-tree", "-r", "--no-renames", "--name-status", "-z", sessionSnapshot.Commit, tree)
+			fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+			for i := 0; i+1 < len(fields); i += 2 {
+				status, path := fields[i], filepath.Join(root, fields[i+1])
+				if _, seen := originalFiles[path]; seen {
+					continue
+				}
+				change := sessionChange{Path: path, After: readOptional(path)}
+				if status != "A" {
+					cmd := exec.Command("git", "cat-file", "blob", sessionSnapshot.Commit+":"+fields[i+1])
+					cmd.Dir = root
+					data, err := cmd.Output()
+					if err != nil {
+						continue
+					}
+					before := string(data)
+					change.Before = &before
+				}
+				changes = append(changes, change)
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// /changes [diff [n|path...] | revert <n|path...>]
+func cmdChanges(arg string) string {
+	fields := strings.Fields(arg)
+	changes := sessionChanges()
+	if len(changes) == 0 {
+		return "No files changed this session"
+	}
+	if len(fields) == 0 {
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("%sChanged this session: %d files%s\n", colorCyan, len(changes), colorReset))
+		for i, c := range changes {
+			added, removed := 0, 0
+			for _, op := range lineDiff(splitLines(deref(c.Before)), splitLines(deref(c.After))) {
+				switch op.Kind {
+				case '+':
+					added++
+				case '-':
+					removed++
+				}
+			}
+			b.WriteString(fmt.Sprintf("  %2d. %s %s+%d%s %s-%d%s", i+1, relPath(c.Path), colorGreen, added, colorReset, colorRed, removed, colorReset))
+			if status := c.Status(); status != "" {
+				b.WriteString(fmt.Sprintf(" %s(%s)%s", colorGray, status, colorReset))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(fmt.Sprintf("%s/changes diff [n|path] · /changes revert <n|path>...%s", colorGray, colorReset))
+		return b.String()
+	}
+
+	targets := changes
+	if len(fields) > 1 {
+		var err error
+		if targets, err = pickChanges(changes, fields[1:]); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+	}
+	switch fields[0] {
+	case "diff":
+		var parts []string
+		for _, c := range targets {
+			before, after := deref(c.Before), deref(c.After)
+			if strings.ContainsRune(before, 0) || strings.ContainsRune(after, 0) {
+				parts = append(parts, fmt.Sprintf("%s%s%s %s(binary, %d → %d bytes)%s", colorBold, relPath(c.Path), colorReset, colorGray, len(before), len(after), colorReset))
+				continue
+			}
+			parts = append(parts, renderDiff(relPath(c.Path), before, after))
+		}
+		return strings.Join(parts, "\n\n")
+
+	case "revert":
+		if len(fields) == 1 {
+			return "Usage: /changes revert <n|path>... (numbers from /changes)"
+		}
+		batch := UndoAction{Type: "batch", Path: "changes revert", Op: "changes revert", Time: time.Now()}
+		for _, c := range targets {
+			if _, seen := originalFiles[c.Path]; !seen {
+				originalFiles[c.Path] = c.Before // known from the snapshot
+			}
+			batch.Files = append(batch.Files, undoSnapshot(c.Path))
+		}
+		var names []string
+		for _, c := range targets {
+			if c.Before == nil {
+				if err := os.Remove(c.Path); err != nil && !os.IsNotExist(err) {
+					return fmt.Sprintf("Error: %s", err)
+				}
+			} else {
+				os.MkdirAll(filepath.Dir(c.Path), 0755)
+				perm := os.FileMode(0644)
+				if info, err := os.Stat(c.Path); err == nil {
+					perm = info.Mode().Perm()
+				}
+				if err := writeFileAtomic(c.Path, []byte(*c.Before), perm); err != nil {
+					return fmt.Sprintf("Error: %s", err)
+				}
+			}
+			names = append(names, relPath(c.Path))
+		}
+		pushUndo(batch)
+		return fmt.Sprintf("%s✓ Reverted %s; /undo 1 brings the changes back%s", colorGreen, strings.Join(names, ", "), colorReset)
+	}
+	return "Usage: /changes [diff [n|path] | revert <n|path>...]"
+}
+
+// The changes named by list number or path
+func pickChanges(changes []sessionChange, args []string) ([]sessionChange, error) {
+	chosen := make([]bool, len(changes))
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			if n < 1 || n > len(changes) {
+				return nil, fmt.Errorf("no change %d (1-%d)", n, len(changes))
+			}
+			chosen[n-1] = true
+			continue
+		}
+		path, found := resolvePath(arg), false
+		for i, c := range changes {
+			if c.Path == path {
+				chosen[i], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s wasn't changed this session", arg)
+		}
+	}
+	var picked []sessionChange
+	for i, c := range changes {
+		if chosen[i] {
+			picked = append(picked, c)
+		}
+	}
+	return picked, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== CHECKPOINTS ====================
+
+// A checkpoint records the conversation length and the content of every
+// file the session has touched, so /rollback can rewind both together.
+type Checkpoint struct {
+	Name       string             `json:"name"`
+	HistoryLen int                `json:"history_len"`
+	Files      map[string]*string `json:"files"` // nil = file did not exist
+	Time       time.Time          `json:"time"`
+}
+
+var checkpoints []Checkpoint
+
+func createCheckpoint(name string, history []ChatMessage) string {
+	if name == "" {
+		return listCheckpoints()
+	}
+	cp := Checkpoint{Name: name, HistoryLen: len(history), Files: map[string]*string{}, Time: time.Now()}
+	for path := range originalFiles {
+		if data, err := os.ReadFile(path); err == nil {
+			content := string(data)
+			cp.Files[path] = &content
+		} else {
+			cp.Files[path] = nil
+		}
+	}
+
+	// Re-using a name moves the checkpoint
+	for i, existing := range checkpoints {
+		if existing.Name == name {
+			checkpoints = append(checkpoints[:i], checkpoints[i+1:]...)
+			break
+		}
+	}
+	checkpoints = append(checkpoints, cp)
+	return fmt.Sprintf("%s✓ Checkpoint %q: %d messages, %d files%s", colorGreen, name, cp.HistoryLen, len(cp.Files), colorReset)
+}
+
+func listCheckpoints() string {
+	if len(checkpoints) == 0 {
+		return "No checkpoints. Usage: /checkpoint <name>"
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%sCheckpoints:%s\n", colorCyan, colorReset))
+	for _, cp := range checkpoints {
+		b.WriteString(fmt.Sprintf("  %s%-16s%s %d msgs, %d files, %s ago\n", colorYellow, cp.Name, colorReset,
+			cp.HistoryLen, len(cp.Files), time.Since(cp.Time).Round(time.Second)))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Restores files and returns the rewound history
+func rollbackCheckpoint(name string, history []ChatMessage) ([]ChatMessage, string) {
+	if name == "" {
+		return history, "Usage: /rollback <name>\n" + listCheckpoints()
+	}
+	idx := -1
+	for i, cp := range checkpoints {
+		if cp.Name == name {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		return history, fmt.Sprintf("Unknown checkpoint: %s", name)
+	}
+	cp := checkpoints[idx]
+
+	// Files touched after the checkpoint go back to their pre-session state
+	restored := 0
+	var failed []string
+	for path, orig := range originalFiles {
+		target, ok := cp.Files[path]
+		if !ok {
+			target = orig
+		}
+		current, err := os.ReadFile(path)
+		if target == nil {
+			if err == nil {
+				saveForUndo(path, "rollback")
+				if err := os.Remove(path); err != nil {
+					failed = append(failed, fmt.Sprintf("%s (%s)", relPath(path), err))
+					continue
+				}
+				restored++
+			}
+			continue
+		}
+		if err != nil || string(current) != *target {
+			perm := rollbackMode(path)
+			saveForUndo(path, "rollback")
+			os.MkdirAll(filepath.Dir(path), 0755)
+			err := os.WriteFile(path, []byte(*target), perm)
+			if err == nil {
+				err = os.Chmod(path, perm) // WriteFile only applies perm to new files
+			}
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%s)", relPath(path), err))
+				continue
+			}
+			restored++
+		}
+	}
+
+	if cp.HistoryLen < len(history) {
+		history = history[:cp.HistoryLen]
+	}
+	checkpoints = checkpoints[:idx+1]
+	msg := fmt.Sprintf("%s✓ Rolled back to %q: %d messages, %d files restored%s",
+		colorGreen, name, len(history), restored, colorReset)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		msg += fmt.Sprintf("\n%s⚠ Not restored: %s%s", colorYellow, strings.Join(failed, ", "), colorReset)
+	}
+	return history, msg
+}
+
+// Mode to restore path with: its current one, or the last one the undo
+// stack saw if it has been deleted since
+func rollbackMode(path string) os.FileMode {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm()
+	}
+	for i := len(undoStack) - 1; i >= 0; i-- {
+		if a := undoStack[i]; a.Path == path && a.Mode != 0 {
+			return a.Mode
+		}
+	}
+	return 0644
+}
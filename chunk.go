@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== CHUNKED MENTIONS ====================
+
+// An @file that fits mentionFileTokens is attached whole. A larger one is
+// cut into chunks at its top-level definitions (go/parser for Go, the repo
+// map's patterns elsewhere, fixed windows as a last resort) and attached
+// as an outline plus the chunks most relevant to the message: definitions
+// named in it first, then by embedding similarity, or shared words when
+// embeddings are unavailable.
+
+const (
+	chunkMaxLines = 120 // longer chunks are split into windows
+	chunkWindow   = 80
+	chunkEmbedMax = 40 // chunks sent for embedding per file
+)
+
+type fileChunk struct {
+	Start, End int    // 1-based, inclusive
+	Name       string // definition starting the chunk, if any
+	score      float64
+}
+
+var markdownHeadingRe = regexp.MustCompile(`(?m)^#{1,3} +(.+)$`)
+
+// Definitions in a file as start line → name
+func definitionLines(path, content string) map[int]string {
+	defs := map[int]string{}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".go" {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, content, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return defs
+		}
+		for _, decl := range f.Decls {
+			start := decl.Pos()
+			name := ""
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Doc != nil {
+					start = d.Doc.Pos()
+				}
+				name = d.Name.Name
+				if d.Recv != nil && len(d.Recv.List) > 0 {
+					name = "(" + recvName(d.Recv.List[0].Type) + ")." + name
+				}
+			case *ast.GenDecl:
+				if d.Doc != nil {
+					start = d.Doc.Pos()
+				}
+				if len(d.Specs) > 0 {
+					switch s := d.Specs[0].(type) {
+					case *ast.TypeSpec:
+						name = "type " + s.Name.Name
+					case *ast.ValueSpec:
+						name = d.Tok.String() + " " + s.Names[0].Name
+					case *ast.ImportSpec:
+						name = "imports"
+					}
+				}
+			}
+			defs[fset.Position(start).Line] = name
+		}
+		return defs
+	}
+	re := symbolPatterns[ext]
+	if ext == ".md" || ext == ".markdown" {
+		re = markdownHeadingRe
+	}
+	if re == nil {
+		return defs
+	}
+	for _, m := range re.FindAllStringSubmatchIndex(content, -1) {
+		defs[strings.Count(content[:m[0]], "\n")+1] = content[m[2]:m[3]]
+	}
+	return defs
+}
+
+func recvName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + recvName(t.X)
+	case *ast.Ident:
+		return t.Name
+	case *ast.IndexExpr:
+		return recvName(t.X)
+	}
+	return "?"
+}
+
+// Chunks covering every line of a file
+func splitChunks(path string, lines []string) []fileChunk {
+	defs := definitionLines(path, strings.Join(lines, "\n"))
+	starts := []int{1}
+	for line := range defs {
+		if line > 1 {
+			starts = append(starts, line)
+		}
+	}
+	sort.Ints(starts)
+
+	var chunks []fileChunk
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1] - 1
+		}
+		for s := start; s <= end; s += chunkWindow {
+			e := end
+			if end-start+1 > chunkMaxLines {
+				e = min(s+chunkWindow-1, end)
+			}
+			name := defs[start]
+			if s != start && name != "" {
+				name += " (cont.)"
+			}
+			chunks = append(chunks, fileChunk{Start: s, End: e, Name: name})
+			if e == end {
+				break
+			}
+		}
+	}
+	return chunks
+}
+
+func chunkText(lines []string, c fileChunk) string {
+	return strings.Join(lines[c.Start-1:c.End], "\n")
+}
+
+// Scores chunks against query: a definition named in the query wins,
+// the rest is similarity
+func scoreChunks(query string, lines []string, chunks []fileChunk) {
+	lower := strings.ToLower(query)
+	texts := make(map[string]string, len(chunks))
+	keys := make([]string, len(chunks))
+	for i, c := range chunks {
+		keys[i] = fmt.Sprint(i)
+		texts[keys[i]] = truncate(chunkText(lines, c), 2000)
+	}
+
+	var sim map[string]float64
+	if len(chunks) <= chunkEmbedMax {
+		sim, _ = chunkEmbeddingScores(query, texts, keys)
+	}
+	if sim == nil {
+		sim = wordOverlap(query, texts, keys)
+	}
+	for i := range chunks {
+		chunks[i].score = sim[keys[i]]
+		name := chunks[i].Name
+		if j := strings.LastIndexAny(name, ". "); j >= 0 {
+			name = name[j+1:]
+		}
+		if len(name) > 2 && strings.Contains(lower, strings.ToLower(name)) {
+			chunks[i].score += 2
+		}
+	}
+}
+
+func chunkEmbeddingScores(query string, texts map[string]string, keys []string) (map[string]float64, error) {
+	apiKey := getAPIKey()
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key")
+	}
+	batch := make([]string, len(keys))
+	for i, k := range keys {
+		batch[i] = texts[k]
+	}
+	vectors, err := embedTexts(apiKey, batch, "db")
+	if err != nil {
+		return nil, err
+	}
+	q, err := embedTexts(apiKey, []string{query}, "query")
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[string]float64, len(keys))
+	for i, k := range keys {
+		scores[k] = cosine(q[0], vectors[i])
+	}
+	return scores, nil
+}
+
+// Attachment for a large file: outline plus the chunks that fit the budget,
+// best first, shown in file order. Returns the text and a short note.
+func chunkedMention(path, content, query string) (string, string) {
+	lines := strings.Split(content, "\n")
+	chunks := splitChunks(path, lines)
+
+	var outline []string
+	for _, c := range chunks {
+		if c.Name != "" && !strings.HasSuffix(c.Name, "(cont.)") {
+			outline = append(outline, fmt.Sprintf("L%d %s", c.Start, c.Name))
+		}
+	}
+	outlineText := strings.Join(outline, "\n")
+	if estimateTokens(outlineText) > mentionFileTokens/4 {
+		outlineText = truncate(outlineText, mentionFileTokens) // a quarter of the budget, in bytes
+	}
+
+	if strings.TrimSpace(query) != "" {
+		scoreChunks(query, lines, chunks)
+	}
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return chunks[order[a]].score > chunks[order[b]].score })
+
+	// Without any relevant chunk, the start of the file as before
+	budget := mentionFileTokens - estimateTokens(outlineText)
+	relevant := chunks[order[0]].score > 0
+	var picked []int
+	for _, i := range order {
+		n := estimateTokens(chunkText(lines, chunks[i])) + 8
+		if relevant && chunks[i].score <= 0 || !relevant && n > budget {
+			break
+		}
+		if n > budget {
+			continue
+		}
+		budget -= n
+		picked = append(picked, i)
+	}
+	sort.Ints(picked)
+
+	var b strings.Builder
+	if outlineText != "" {
+		b.WriteString("OUTLINE:\n" + outlineText + "\n")
+	}
+	next := 1
+	for _, i := range picked {
+		c := chunks[i]
+		if c.Start > next {
+			b.WriteString(fmt.Sprintf("\n... lines %d-%d omitted ...\n", next, c.Start-1))
+		}
+		b.WriteString(fmt.Sprintf("\n--- L%d-%d ---\n%s\n", c.Start, c.End, chunkText(lines, c)))
+		next = c.End + 1
+	}
+	if next <= len(lines) {
+		b.WriteString(fmt.Sprintf("\n... lines %d-%d omitted ...\n", next, len(lines)))
+	}
+	note := fmt.Sprintf("%d lines, outline + %d of %d chunks", len(lines), len(picked), len(chunks))
+	return strings.TrimSuffix(b.String(), "\n"), note
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ==================== CODE BLOCKS ====================
+
+type codeBlock struct {
+	Info string // everything after the opening ```, e.g. "go main.go"
+	Lang string
+	Code string
+}
+
+// Fenced blocks in the order they appear; an unclosed last block runs to
+// the end of the text
+func extractCodeBlocks(text string) []codeBlock {
+	var blocks []codeBlock
+	var cur *codeBlock
+	var body []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "```") {
+			if cur != nil {
+				body = append(body, line)
+			}
+			continue
+		}
+		if cur == nil {
+			info := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			cur = &codeBlock{Info: info}
+			if fields := strings.Fields(info); len(fields) > 0 {
+				cur.Lang = strings.ToLower(fields[0])
+			}
+			body = nil
+			continue
+		}
+		cur.Code = strings.Join(body, "\n") + "\n"
+		blocks = append(blocks, *cur)
+		cur = nil
+	}
+	if cur != nil && len(body) > 0 {
+		cur.Code = strings.Join(body, "\n") + "\n"
+		blocks = append(blocks, *cur)
+	}
+	return blocks
+}
+
+// The block meant for path: one whose language matches the extension,
+// else the longest
+func blockForFile(blocks []codeBlock, path string) (codeBlock, bool) {
+	if len(blocks) == 0 {
+		return codeBlock{}, false
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	best := 0
+	for i, b := range blocks {
+		if ext != "" && (b.Lang == ext || langExtension(b.Lang) == ext) {
+			return b, true
+		}
+		if len(b.Code) > len(blocks[best].Code) {
+			best = i
+		}
+	}
+	return blocks[best], true
+}
+
+func langExtension(lang string) string {
+	switch lang {
+	case "golang":
+		return "go"
+	case "yml":
+		return "yaml"
+	case "python":
+		return "py"
+	case "javascript":
+		return "js"
+	case "typescript":
+		return "ts"
+	case "bash", "shell", "sh":
+		return "sh"
+	case "markdown":
+		return "md"
+	case "dockerfile":
+		return ""
+	}
+	return lang
+}
+
+// --out streams the first fenced block of the answer into path while it
+// arrives. Modes and undo apply as for the write tool; ask mode confirms
+// before the request since there is nothing to review hunk by hunk yet.
+// An answer without a fence leaves the file alone.
+type fenceStream struct {
+	path   string
+	format fileFormat
+	undo   UndoAction
+	file   *os.File
+	line   string // incomplete last line
+	state  int    // 0 before the fence, 1 inside, 2 after
+	code   strings.Builder
+	err    error
+}
+
+// nil and why when the file may not be written
+func newFenceStream(path string) (*fenceStream, string) {
+	fullPath := resolvePath(path)
+	if currentMode == ModeManual {
+		return nil, fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
+	}
+	if currentMode == ModeAsk && !confirmAction(fmt.Sprintf("%sWrite the answer's code to %s?%s", colorYellow, fullPath, colorReset)) {
+		return nil, "Cancelled"
+	}
+	_, format, _ := readText(fullPath)
+	return &fenceStream{path: fullPath, format: format}, ""
+}
+
+func (s *fenceStream) Write(p []byte) (int, error) {
+	s.line += string(p)
+	for {
+		i := strings.IndexByte(s.line, '\n')
+		if i == -1 {
+			break
+		}
+		s.addLine(s.line[:i])
+		s.line = s.line[i+1:]
+	}
+	return len(p), nil
+}
+
+func (s *fenceStream) addLine(line string) {
+	if s.state == 2 || s.err != nil {
+		return
+	}
+	if strings.HasPrefix(strings.TrimSpace(line), "```") {
+		if s.state == 1 {
+			s.state = 2
+			return
+		}
+		s.state = 1
+		s.undo = undoSnapshot(s.path)
+		s.undo.Op = "write"
+		perm := s.format.Mode
+		if perm == 0 {
+			perm = 0644
+		}
+		os.MkdirAll(filepath.Dir(s.path), 0755)
+		s.file, s.err = os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		return
+	}
+	if s.state == 1 {
+		line = strings.TrimSuffix(line, "\r") + "\n"
+		s.code.WriteString(line)
+		_, s.err = s.file.WriteString(line)
+	}
+}
+
+// Completes the file once the answer is in
+func (s *fenceStream) finish() string {
+	if s.line != "" && !strings.HasPrefix(strings.TrimSpace(s.line), "```") {
+		s.addLine(s.line) // an unclosed block runs to the end
+	}
+	if s.state == 0 {
+		return fmt.Sprintf("Error: the answer has no fenced code block, %s was not written", s.path)
+	}
+	if s.file != nil {
+		if err := s.file.Close(); s.err == nil {
+			s.err = err
+		}
+	}
+	if s.err == nil && strings.TrimSpace(s.code.String()) == "" {
+		s.err = fmt.Errorf("the code block is empty")
+	}
+	if s.err != nil {
+		// Put back what was there; a new file goes away again
+		if s.undo.Mode != 0 {
+			os.WriteFile(s.path, []byte(s.undo.Content), s.undo.Mode)
+		} else {
+			os.Remove(s.path)
+		}
+		return fmt.Sprintf("Error: %s not written: %s", s.path, s.err)
+	}
+	// Streamed as LF; the file's own line endings and BOM go on now
+	if err := writeText(s.path, s.code.String(), s.format); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	pushUndo(s.undo)
+	if data, err := os.ReadFile(s.path); err == nil {
+		noteSeen(s.path, data)
+	}
+	return fmt.Sprintf("%s✓ Written: %s (%d bytes)%s", colorGreen, s.path, s.code.Len(), colorReset)
+}
+
+// Filename named in a fence info string: "go main.go", "python:app.py",
+// "yaml title=deploy.yaml" or just "main.go"
+func blockFilename(info string) string {
+	for i, field := range strings.Fields(info) {
+		for _, prefix := range []string{"title=", "file=", "filename=", "path="} {
+			if strings.HasPrefix(field, prefix) {
+				return strings.Trim(strings.TrimPrefix(field, prefix), `"'`)
+			}
+		}
+		if i == 0 {
+			if _, after, ok := strings.Cut(field, ":"); ok {
+				field = after
+			} else if !strings.Contains(field, ".") {
+				continue // just the language
+			}
+		}
+		if strings.ContainsAny(field, "./") && !strings.HasPrefix(field, "{") {
+			return field
+		}
+	}
+	return ""
+}
+
+// /extract [n] [path]: writes the nth code block of the last response
+// (the first by default) through the write tool. Without a path the
+// filename comes from the fence info string.
+func cmdExtract(arg, response string) string {
+	blocks := extractCodeBlocks(response)
+	if len(blocks) == 0 {
+		return "No code blocks in the last response"
+	}
+	n, path := 1, ""
+	fields := strings.Fields(arg)
+	if len(fields) > 0 {
+		if i, err := strconv.Atoi(fields[0]); err == nil {
+			n, fields = i, fields[1:]
+		}
+	}
+	if len(fields) > 0 {
+		path = strings.Join(fields, " ")
+	}
+	if n < 1 || n > len(blocks) {
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Usage: /extract [1-%d] [path]\n", len(blocks)))
+		for i, blk := range blocks {
+			lines := strings.Count(blk.Code, "\n")
+			b.WriteString(fmt.Sprintf("  %s%d%s %-20s %d lines\n", colorYellow, i+1, colorReset, truncate(blk.Info, 20), lines))
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	block := blocks[n-1]
+	if path == "" {
+		path = blockFilename(block.Info)
+	}
+	if path == "" {
+		return fmt.Sprintf("Block %d has no filename in its fence (%q), use /extract %d <path>", n, block.Info, n)
+	}
+	return cmdWrite(path + "|||" + block.Code)
+}
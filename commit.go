@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ==================== COMMIT ====================
+
+// /commit stages what the user picks, has the model write a conventional
+// commit message from the staged diff, lets the user edit or regenerate
+// it and commits. A Mytool-Session trailer ties the commit to the session
+// that made the changes. Commits go through git commit as the user's own
+// would: the repository's hooks run and commit.gpgsign is honoured. A hook
+// that rejects the commit can have its output sent to the model to fix,
+// and the git tool won't let the model skip hooks or signing.
+
+const commitDiffMax = 12000 // diff characters sent to the model
+
+var codeFenceRe = regexp.MustCompile("(?s)^```[a-z]*\\n(.*?)\\n?```$")
+
+// git in the project root; stdout, or stderr as the error
+func gitIn(root string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// /commit [-a | paths...]: with nothing staged and no arguments, asks
+// which changed files to stage
+func cmdCommit(arg string) string {
+	if !haveBinary("git") {
+		return toolUnavailable("git", false)
+	}
+	root := findProjectRoot()
+	if root == "" {
+		return "Error: not in a git repository"
+	}
+	fields := strings.Fields(arg)
+	switch {
+	case len(fields) == 1 && fields[0] == "-a":
+		if _, err := gitIn(root, "add", "-A"); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+	case len(fields) > 0:
+		var paths []string
+		for _, f := range fields {
+			paths = append(paths, resolvePath(f))
+		}
+		if _, err := gitIn(root, append([]string{"add", "--"}, paths...)...); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+	default:
+		if staged, _ := gitIn(root, "diff", "--cached", "--name-only"); staged == "" {
+			if msg := stageInteractively(root); msg != "" {
+				return msg
+			}
+		}
+	}
+
+	stat, _ := gitIn(root, "diff", "--cached", "--stat")
+	if stat == "" {
+		return "Nothing staged to commit"
+	}
+	fmt.Printf("%s%s%s\n", colorGray, stat, colorReset)
+	diff, err := gitIn(root, "diff", "--cached", "--no-color")
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if len(diff) > commitDiffMax {
+		diff = diff[:commitDiffMax] + "\n... (diff truncated)"
+	}
+	recent, _ := gitIn(root, "log", "-10", "--format=%s")
+
+	for {
+		fmt.Printf("%sWriting the commit message...%s\n", colorGray, colorReset)
+		message, err := commitMessage(stat, diff, recent)
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		for {
+			fmt.Printf("\n%s%s%s\n\n", colorCyan, message, colorReset)
+			switch strings.ToLower(readAnswer(fmt.Sprintf("%s[Enter] commit · e edit · r regenerate · n cancel:%s ", colorYellow, colorReset))) {
+			case "":
+				head, err := runGitCommit(root, message)
+				if err == nil {
+					return fmt.Sprintf("%s✓ Committed %s%s", colorGreen, head, colorReset)
+				}
+				fmt.Printf("Error: %s\n", err)
+				if ce, ok := err.(*commitError); ok && len(ce.Hooks) > 0 && !ce.Signing &&
+					confirmAction(fmt.Sprintf("%sSend the hook's output to the model to fix?%s", colorYellow, colorReset)) {
+					autoPending = fmt.Sprintf("[commit] The repository's %s hook rejected the commit:\n\n%s\n\n"+
+						"Fix these problems with tool calls and stage the fixes with git add; the user commits again with /commit.",
+						strings.Join(ce.Hooks, "/"), truncate(ce.Output, 6000))
+					autoLabel = "Commit hook failed: sending its output"
+				}
+				return "The changes stay staged"
+			case "e":
+				if edited, ok := editCommitMessage(message); ok && edited != "" {
+					message = edited
+				}
+				continue
+			case "r":
+			default:
+				return "Commit cancelled (the changes stay staged)"
+			}
+			break
+		}
+	}
+}
+
+// Lists the changed files and stages those the user picks
+func stageInteractively(root string) string {
+	status, err := gitIn(root, "status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if status == "" {
+		return "Nothing to commit, working tree clean"
+	}
+	var files []string
+	for i, line := range strings.Split(status, "\n") {
+		path := line[3:]
+		if _, to, ok := strings.Cut(path, " -> "); ok {
+			path = to
+		}
+		files = append(files, strings.Trim(path, `"`))
+		fmt.Printf("  %s%2d%s %s %s\n", colorYellow, i+1, colorReset, line[:2], path)
+	}
+	answer := readAnswer(fmt.Sprintf("%sStage which? (numbers like 1 3, Enter = all, n = cancel):%s ", colorYellow, colorReset))
+	if answer == "n" {
+		return "Commit cancelled"
+	}
+	picked := files
+	if answer != "" {
+		picked = nil
+		for _, f := range strings.Fields(strings.ReplaceAll(answer, ",", " ")) {
+			n, err := strconv.Atoi(f)
+			if err != nil || n < 1 || n > len(files) {
+				return fmt.Sprintf("Error: no file %s in the list", f)
+			}
+			picked = append(picked, files[n-1])
+		}
+	}
+	if _, err := gitIn(root, append([]string{"add", "-A", "--"}, picked...)...); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return ""
+}
+
+func commitMessage(stat, diff, recent string) (string, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "Write a git commit message for the staged changes, in the Conventional Commits format: " +
+			"a subject line `type(scope): summary` (types: feat, fix, refactor, perf, docs, test, build, ci, chore; " +
+			"scope optional, imperative mood, at most 72 characters, no final period), then a blank line and a short body " +
+			"wrapped at 72 columns saying what changed and why, if the subject alone isn't enough. " +
+			"Use the scopes and language of the recent commits. Reply with the message only, no code fences, no trailers."},
+		{Role: "user", Content: fmt.Sprintf("Recent commits:\n%s\n\nStaged:\n%s\n\n%s", recent, stat, diff)},
+	}
+	out, err := sendComplete(getAPIKey(), messages, 400, "commit_message")
+	if err != nil {
+		return "", err
+	}
+	out = strings.TrimSpace(out)
+	if m := codeFenceRe.FindStringSubmatch(out); m != nil {
+		out = strings.TrimSpace(m[1])
+	}
+	if out == "" {
+		return "", fmt.Errorf("the model returned an empty message")
+	}
+	return out, nil
+}
+
+func editCommitMessage(message string) (string, bool) {
+	if userEditor() != "" {
+		text, ok := editInEditor(message+"\n", "mytool-commit-*.txt")
+		return strings.TrimSpace(text), ok
+	}
+	fmt.Printf("%sType the new message (/save or /cancel; set $EDITOR to use an editor):%s\n", colorYellow, colorReset)
+	lines, ok := typeLines()
+	return strings.TrimSpace(strings.Join(lines, "\n")), ok
+}
+
+// Commits the staged changes with message and the session trailer
+func gitCommit(root, message string) string {
+	head, err := runGitCommit(root, message)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return fmt.Sprintf("%s✓ Committed %s%s", colorGreen, head, colorReset)
+}
+
+// Why git commit failed: a hook said no, or the commit couldn't be signed
+type commitError struct {
+	Hooks   []string // the commit hooks the repository has
+	Signing bool
+	Output  string
+}
+
+func (e *commitError) Error() string {
+	switch {
+	case e.Signing:
+		return "signing the commit failed (commit.gpgsign is on; check gpg-agent or the key in user.signingkey):\n" + e.Output
+	case len(e.Hooks) > 0:
+		return fmt.Sprintf("the %s hook rejected the commit:\n%s", strings.Join(e.Hooks, "/"), e.Output)
+	}
+	return "git commit: " + e.Output
+}
+
+// Runs git commit the way the user would: hooks run and the commit is
+// signed when the repository says so. Returns "<hash> <subject>", with
+// (signed) when it is.
+func runGitCommit(root, message string) (string, error) {
+	f, err := os.CreateTemp("", "mytool-commit-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(message + "\n\nMytool-Session: " + sessionID + "\n")
+	f.Close()
+
+	cmd := exec.Command("git", "commit", "-q", "-F", f.Name())
+	cmd.Dir = root
+	cmd.Env = gitSigningEnv()
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(out.String())
+		if output == "" {
+			output = err.Error()
+		}
+		return "", &commitError{Hooks: commitHooks(root), Signing: signingFailed(output), Output: output}
+	}
+	head, _ := gitIn(root, "log", "-1", "--format=%h %s")
+	if sig, _ := gitIn(root, "log", "-1", "--format=%G?"); sig != "" && sig != "N" {
+		head += " (signed)"
+	}
+	return head, nil
+}
+
+var signingFailedRe = regexp.MustCompile(`(?i)gpg failed to sign|failed to write commit object|error: (?:gpg|ssh-keygen|couldn't load public key)|signing failed`)
+
+func signingFailed(output string) bool {
+	return signingFailedRe.MatchString(output)
+}
+
+// The environment for git commands that may sign: gpg's pinentry needs to
+// know the terminal, which it can't find from a pipe
+func gitSigningEnv() []string {
+	env := os.Environ()
+	if os.Getenv("GPG_TTY") == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+		cmd := exec.Command("tty")
+		cmd.Stdin = os.Stdin
+		if out, err := cmd.Output(); err == nil {
+			env = append(env, "GPG_TTY="+strings.TrimSpace(string(out)))
+		}
+	}
+	return env
+}
+
+// The hooks git runs on commit that the repository has installed
+func commitHooks(root string) []string {
+	if root == "" {
+		return nil
+	}
+	dir, _ := gitIn(root, "config", "core.hooksPath")
+	if dir == "" {
+		dir, _ = gitIn(root, "rev-parse", "--git-path", "hooks")
+	}
+	if dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	var hooks []string
+	for _, name := range []string{"pre-commit", "prepare-commit-msg", "commit-msg"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && info.Mode().IsRegular() && (runtime.GOOS == "windows" || info.Mode()&0111 != 0) {
+			hooks = append(hooks, name)
+		}
+	}
+	return hooks
+}
+
+// A git tool call's arguments, split the way sh would split them but
+// without a shell: quotes group words and lose their meaning otherwise,
+// and unquoted ; & | < > ` and $( are refused, as they would start a
+// second command that no policy check saw. quoted marks the words that
+// were quoted (commit messages), which can't be options.
+func splitGitArgs(args string) (fields []string, quoted []bool, err error) {
+	var word strings.Builder
+	inWord, wasQuoted := false, false
+	flush := func() {
+		if inWord {
+			fields, quoted = append(fields, word.String()), append(quoted, wasQuoted)
+		}
+		word.Reset()
+		inWord, wasQuoted = false, false
+	}
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '\'':
+			end := strings.IndexByte(args[i+1:], '\'')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("unterminated ' quote")
+			}
+			word.WriteString(args[i+1 : i+1+end])
+			inWord, wasQuoted = true, true
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(args) && args[i] != '"'; i++ {
+				if args[i] == '\\' && i+1 < len(args) && strings.IndexByte("\"\\$`", args[i+1]) >= 0 {
+					i++
+				} else if args[i] == '`' || args[i] == '$' && i+1 < len(args) && args[i+1] == '(' {
+					return nil, nil, fmt.Errorf("command substitution isn't run in git arguments")
+				}
+				word.WriteByte(args[i])
+			}
+			if i == len(args) {
+				return nil, nil, fmt.Errorf("unterminated \" quote")
+			}
+			inWord, wasQuoted = true, true
+		case c == '\\' && i+1 < len(args):
+			i++
+			word.WriteByte(args[i])
+			inWord = true
+		case strings.IndexByte(";&|<>`", c) >= 0 || c == '$' && i+1 < len(args) && args[i+1] == '(':
+			return nil, nil, fmt.Errorf("%q isn't allowed in git arguments: give one git command per call, without shell operators", string(c))
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+	return fields, quoted, nil
+}
+
+// The subcommand of a git tool call, and any option in it that would
+// skip the hooks or the signature or run a shell command; the model is to
+// fix what the hooks report instead
+func gitSubcommand(args string) (string, string) {
+	fields, quoted, err := splitGitArgs(args)
+	if err != nil {
+		return "", ""
+	}
+	sub := -1
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if sub < 0 {
+			switch {
+			case (f == "-c" || f == "--config-env") && i+1 < len(fields):
+				i++
+				if bypassConfigRe.MatchString(fields[i]) || gitShellAliasRe.MatchString(fields[i]) {
+					return "", f + " " + fields[i]
+				}
+			case bypassConfigRe.MatchString(f) || gitShellAliasRe.MatchString(f):
+				return "", f
+			case f == "-C" || f == "--git-dir" || f == "--work-tree":
+				i++
+			case !strings.HasPrefix(f, "-"):
+				sub = i
+			}
+			continue
+		}
+		if !slices.Contains([]string{"commit", "merge", "am", "rebase", "cherry-pick", "revert", "push"}, fields[sub]) {
+			break
+		}
+		if quoted[i] {
+			continue
+		}
+		switch {
+		case f == "--no-verify" || f == "--no-gpg-sign":
+			return fields[sub], f
+		case fields[sub] == "commit" && gitShortNoVerifyRe.MatchString(f):
+			return fields[sub], f
+		}
+	}
+	if sub < 0 {
+		return "", ""
+	}
+	return fields[sub], ""
+}
+
+var bypassConfigRe = regexp.MustCompile(`(?i)commit\.gpgsign|core\.hookspath`)
+
+// An alias starting with ! runs its value through the shell
+var gitShellAliasRe = regexp.MustCompile(`(?i)^alias\.[^=]*=\s*!`)
+
+// -n, alone or among other single-letter commit options before one that
+// takes a value
+var gitShortNoVerifyRe = regexp.MustCompile(`^-[aqsvez]*n`)
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGitSubcommand(t *testing.T) {
+	tests := []struct {
+		args, sub, bypass string
+	}{
+		{"status", "status", ""},
+		{"-C sub log --oneline", "log", ""},
+		{`commit -m "skip --no-verify in docs"`, "commit", ""},
+		{`commit -m '-n is not an option here'`, "commit", ""},
+		{"commit --no-verify -m x", "commit", "--no-verify"},
+		{"commit -an -m x", "commit", "-an"},
+		{"push --no-verify", "push", "--no-verify"},
+		{"log -n 5", "log", ""},
+		{"-c core.hooksPath=/dev/null commit -m x", "", "-c core.hooksPath=/dev/null"},
+		{`-c "commit.gpgSign=false" commit`, "", "-c commit.gpgSign=false"},
+		{"-c alias.x=!rm x", "", "-c alias.x=!rm"},
+		{"status; git commit --no-verify", "", ""},
+	}
+	for _, tt := range tests {
+		sub, bypass := gitSubcommand(tt.args)
+		if sub != tt.sub || bypass != tt.bypass {
+			t.Errorf("gitSubcommand(%q) = %q, %q; want %q, %q", tt.args, sub, bypass, tt.sub, tt.bypass)
+		}
+	}
+}
+
+func TestSplitGitArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{`commit -m "a; b | c"`, []string{"commit", "-m", "a; b | c"}},
+		{`commit -m 'it''s' --amend`, []string{"commit", "-m", "its", "--amend"}},
+		{`log --format="%h \"x\""`, []string{"log", `--format=%h "x"`}},
+		{`add my\ file.go`, []string{"add", "my file.go"}},
+	}
+	for _, tt := range tests {
+		got, _, err := splitGitArgs(tt.args)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitGitArgs(%q) = %q, %v; want %q", tt.args, got, err, tt.want)
+		}
+	}
+
+	for _, args := range []string{
+		"status; git commit --no-verify",
+		"status && git -c core.hooksPath=/dev/null commit",
+		"log | sh",
+		"log > out.txt",
+		"commit -m `id`",
+		`commit -m "$(id)"`,
+		"log $(id)",
+		`commit -m "open`,
+	} {
+		if got, _, err := splitGitArgs(args); err == nil {
+			t.Errorf("splitGitArgs(%q) = %q, want an error", args, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==================== COMPACTION ====================
+
+// Before the history outgrows the context window, the oldest turns are
+// folded into one rolling summary message right after the system prompt;
+// the last few messages stay verbatim. /compact does the same on demand.
+// An earlier summary is part of what gets summarized, so there is only
+// ever one. Pinned exchanges stay verbatim, ahead of the summary.
+
+const (
+	compactAt     = 0.8 // of maxContextTokens
+	compactKeep   = 6   // recent messages kept as they are
+	compactMarker = "[Ringkasan percakapan sebelumnya]"
+)
+
+func historyTokens(history []ChatMessage) int {
+	n := 0
+	for _, m := range history {
+		n += estimateTokens(m.Content) + 4
+	}
+	return n
+}
+
+// The API's count for the last request when it is larger, since the
+// estimate runs low on code
+func contextTokens(history []ChatMessage) int {
+	return max(totalTokens, historyTokens(history))
+}
+
+func needsCompaction(history []ChatMessage) bool {
+	return settings.AutoCompact && len(history) > compactKeep+2 &&
+		float64(contextTokens(history)) > compactAt*float64(maxContextTokens)
+}
+
+// Returns the compacted history and a one-line report
+func compactHistory(apiKey string, history []ChatMessage) ([]ChatMessage, string) {
+	// The summary goes in as a user message, so the kept tail starts at an
+	// assistant reply and roles still alternate. Pins cover whole
+	// exchanges, so the cut never splits one.
+	cut := len(history) - compactKeep
+	for cut > 1 && (history[cut].Role != "assistant" || history[cut].Pinned) {
+		cut--
+	}
+	if cut <= 2 {
+		return history, "Nothing to compact yet"
+	}
+
+	var old, pinned []ChatMessage
+	for _, m := range history[1:cut] {
+		if m.Pinned {
+			pinned = append(pinned, m)
+		} else {
+			old = append(old, m)
+		}
+	}
+	if len(old) == 0 {
+		return history, "Nothing to compact yet (older messages are pinned)"
+	}
+
+	var transcript strings.Builder
+	for _, m := range old {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n\n", m.Role, truncate(stripANSI(m.Content), 2000)))
+	}
+	summary, err := sendComplete(apiKey, []ChatMessage{
+		{Role: "system", Content: "You compress conversations between a user and a coding assistant. Write a summary the assistant can continue from: the user's goals, decisions made, files read or changed (with paths), commands run and their outcome, facts learned, and open tasks. Use terse bullet points, keep exact names, no preamble. Reply in the conversation's language."},
+		{Role: "user", Content: transcript.String()},
+	}, 1500, "compact")
+	if err != nil || summary == "" {
+		return history, fmt.Sprintf("%sCompaction failed: %v%s", colorRed, err, colorReset)
+	}
+
+	compacted := append([]ChatMessage{history[0]}, pinned...)
+	compacted = append(compacted, ChatMessage{Role: "user", Content: compactMarker + "\n" + summary})
+	compacted = append(compacted, history[cut:]...)
+
+	// Checkpoints count messages; those inside the summary now point at it
+	removed := len(history) - len(compacted)
+	for i := range checkpoints {
+		checkpoints[i].HistoryLen = max(2, checkpoints[i].HistoryLen-removed)
+	}
+	totalTokens = historyTokens(compacted)
+	return compacted, fmt.Sprintf("%s🗜 Compacted %d messages → %d tokens%s", colorCyan, len(old), estimateTokens(summary), colorReset)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ==================== ROOT CONFINEMENT ====================
+
+// With Confine to project root on, cd can't leave the project and run:
+// and bash: refuse commands naming paths outside it (/etc/passwd,
+// ~/.ssh, ../other-repo). The root is the project's when confinement is
+// first checked. Arguments are checked as written, so this stops mistakes
+// and casual escapes, not a determined script; python: and node: aren't
+// inspected at all. /confine off lifts it for the rest of the session.
+
+var (
+	confineLifted bool   // /confine off for this session
+	confinedRoot  string // pinned on first use
+)
+
+func confined() bool {
+	return settings.ConfineToRoot && !confineLifted
+}
+
+func confinementRoot() string {
+	if confinedRoot == "" {
+		confinedRoot = findProjectRoot()
+		if confinedRoot == "" {
+			confinedRoot = currentDir
+		}
+	}
+	return confinedRoot
+}
+
+// Whether path is the root or below it, symlinks resolved where they exist
+func insideRoot(path string) bool {
+	root := confinementRoot()
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func confineError(path string) string {
+	return fmt.Sprintf("Error: %s is outside the project root %s (confinement is on; /confine off lifts it for this session)", path, confinementRoot())
+}
+
+// The first argument of command that points outside the root, or "".
+// Program names (/usr/bin/env) and /dev are fine.
+func commandEscape(command string) string {
+	for _, part := range commandSplitRe.Split(command, -1) {
+		for i, word := range strings.Fields(part) {
+			word = strings.Trim(word, `"'`)
+			word = strings.TrimLeft(word, "0123456789<>&")
+			if _, value, ok := strings.Cut(word, "="); ok && strings.HasPrefix(word, "-") {
+				word = value
+			}
+			if i == 0 || word == "" || !(strings.HasPrefix(word, "/") || strings.HasPrefix(word, "~") || strings.Contains(word, "..")) {
+				continue
+			}
+			if word == "~" {
+				word = "~/"
+			}
+			path := resolvePath(word)
+			if strings.HasPrefix(path, "/dev/") || path == "/dev" {
+				continue
+			}
+			if !insideRoot(path) {
+				return word
+			}
+		}
+	}
+	return ""
+}
+
+// Result for a command that leaves the root, or ""
+func confineBlock(command string) string {
+	if !confined() {
+		return ""
+	}
+	if path := commandEscape(command); path != "" {
+		return confineError(path)
+	}
+	return ""
+}
+
+// /confine [on|off] shows or changes confinement for this session
+func cmdConfine(arg string) string {
+	switch strings.TrimSpace(arg) {
+	case "":
+	case "on":
+		if !settings.ConfineToRoot {
+			settings.ConfineToRoot = true
+			saveSettings()
+		}
+		confineLifted = false
+		confinedRoot = ""
+	case "off":
+		confineLifted = true
+	default:
+		return "Usage: /confine [on|off]"
+	}
+	switch {
+	case confined():
+		return fmt.Sprintf("%s✓ Confined to %s%s: cd and run stay inside it", colorGreen, confinementRoot(), colorReset)
+	case settings.ConfineToRoot:
+		return fmt.Sprintf("%sConfinement lifted for this session%s (/confine on restores it)", colorYellow, colorReset)
+	}
+	return fmt.Sprintf("Not confined %s(/confine on, or Confine to project root in /settings)%s", colorGray, colorReset)
+}
+
+// Checked by cmdCd before moving
+func cdAllowed(path string) string {
+	if !confined() || insideRoot(path) {
+		return ""
+	}
+	return confineError(path)
+}
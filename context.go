@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ==================== CONTEXT BREAKDOWN ====================
+
+// /context splits the estimated context into what fills it: the parts of
+// the system prompt, files attached with @mentions, tool results and the
+// conversation itself. /context preview prints the messages the next
+// request would carry, or writes the exact request body to a file.
+
+// Sections of the system prompt, by their headings in getSystemPrompt
+var promptSections = []struct{ Label, Heading string }{
+	{"memory", "\n\nMEMORY:\n"},
+	{"repo map", "\n\nREPO MAP"},
+	{"mcp tools", "\n\nMCP ("},
+	{"instructions", "\n\nPROJECT INSTRUCTIONS"},
+}
+
+// Splits the system prompt into its sections; whatever is left is counted
+// as "system prompt". Project instructions come last and run to the end.
+func systemPromptParts(prompt string) map[string]string {
+	parts := map[string]string{}
+	for _, s := range promptSections {
+		start := strings.Index(prompt, s.Heading)
+		if start < 0 {
+			continue
+		}
+		end := len(prompt)
+		if s.Label != "instructions" {
+			if i := strings.Index(prompt[start+2:], "\n\n"); i >= 0 {
+				end = start + 2 + i
+			}
+		}
+		parts[s.Label] = prompt[start:end]
+		prompt = prompt[:start] + prompt[end:]
+	}
+	parts["system prompt"] = prompt
+	return parts
+}
+
+func cmdContext(history []ChatMessage) string {
+	var system string
+	if len(history) > 0 {
+		system = history[0].Content
+	}
+	tokens := map[string]int{}
+	for label, text := range systemPromptParts(system) {
+		tokens[label] = estimateTokens(text)
+	}
+
+	attachments, results, pinned := 0, 0, 0
+	for _, m := range history[min(1, len(history)):] {
+		content := m.Content
+		if m.Pinned {
+			pinned++
+		}
+		switch {
+		case isCompactSummary(m):
+			tokens["summary"] += estimateTokens(content) + 4
+			continue
+		case isToolResults(m):
+			tokens["tool results"] += estimateTokens(content) + 4
+			results++
+			continue
+		case m.Role == "user":
+			var blocks []string
+			content, blocks = splitAttachments(content)
+			for _, b := range blocks {
+				tokens["files"] += estimateTokens(b)
+			}
+			attachments += len(blocks)
+		}
+		tokens["history"] += estimateTokens(content) + 4
+	}
+
+	for _, path := range pinnedFiles {
+		if info, err := os.Stat(path); err == nil {
+			tokens["pinned files"] += min(int(info.Size()+3)/4, mentionFileTokens)
+		}
+	}
+
+	total := 0
+	for _, n := range tokens {
+		total += n
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Context: ~%d/%d (%.1f%%)", total, maxContextTokens, float64(total)/float64(maxContextTokens)*100))
+	if totalTokens > 0 {
+		b.WriteString(fmt.Sprintf("%s · last request %d by the API%s", colorGray, totalTokens, colorReset))
+	}
+	b.WriteString("\n")
+	notes := map[string]string{
+		"files":        fmt.Sprintf("%d attached", attachments),
+		"pinned files": fmt.Sprintf("%d, attached to every request", len(pinnedFiles)),
+		"tool results": fmt.Sprintf("%d messages", results),
+		"history":      fmt.Sprintf("%d messages, %d pinned", max(0, len(history)-1), pinned),
+	}
+	budgets := map[string]int{
+		"memory":       settings.MemoryBudget,
+		"repo map":     settings.RepoMapTokens,
+		"files":        settings.FileBudget,
+		"tool results": settings.ToolOutputBudget,
+	}
+	for _, label := range []string{"system prompt", "memory", "repo map", "mcp tools", "instructions", "summary", "pinned files", "files", "tool results", "history"} {
+		n, ok := tokens[label]
+		if !ok {
+			continue
+		}
+		bar := ""
+		if total > 0 {
+			bar = strings.Repeat("█", n*20/total)
+		}
+		line := fmt.Sprintf("  %-14s %7d  %s%-20s%s", label, n, colorCyan, bar, colorReset)
+		if budget := budgets[label]; budget > 0 {
+			line += fmt.Sprintf(" %s/ %d%s", colorGray, budget, colorReset)
+		}
+		if note := notes[label]; note != "" {
+			line += fmt.Sprintf(" %s%s%s", colorGray, note, colorReset)
+		}
+		b.WriteString(line + "\n")
+	}
+	if needsCompaction(history) {
+		b.WriteString(fmt.Sprintf("%sOver %.0f%%: the next message compacts the history%s\n", colorYellow, compactAt*100, colorReset))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// /context preview [file]: the messages of the next request (before the
+// new user message), or the exact JSON body written to file
+func cmdContextPreview(arg string, history []ChatMessage) string {
+	messages := apiMessages(history)
+	if arg != "" {
+		body, _ := json.MarshalIndent(ChatRequest{
+			Model:       activeModel(),
+			MaxTokens:   4096,
+			Temperature: 0.7,
+			Stream:      true,
+			Messages:    messages,
+		}, "", "  ")
+		path := resolvePath(arg)
+		if err := os.WriteFile(path, body, 0600); err != nil {
+			return fmt.Sprintf("%sError: %s%s", colorRed, err, colorReset)
+		}
+		return fmt.Sprintf("%s✓ Request body written to %s (%d bytes)%s", colorGreen, path, len(body), colorReset)
+	}
+	var b strings.Builder
+	for i, m := range messages {
+		b.WriteString(fmt.Sprintf("%s─── [%d] %s · ~%d tokens ───%s\n%s\n\n", colorCyan, i, m.Role, estimateTokens(m.Content), colorReset, m.Content))
+	}
+	b.WriteString(fmt.Sprintf("%s─── [%d] user · your next message ───%s", colorCyan, len(messages), colorReset))
+	if len(pinnedFiles) > 0 {
+		names := make([]string, len(pinnedFiles))
+		for i, path := range pinnedFiles {
+			names[i] = relPath(path)
+		}
+		b.WriteString(fmt.Sprintf("\n%s+ pinned files, read when sending: %s%s", colorGray, strings.Join(names, ", "), colorReset))
+	}
+	return b.String()
+}
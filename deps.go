@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== DEPENDENCY SIGNATURES ====================
+
+// With settings.MentionDeps, an @mentioned Go or TypeScript file brings
+// along the signatures (no bodies) of what it uses from local code: for
+// Go the exported API of imported packages in the same module and the
+// types and functions it uses from sibling files of its package, for
+// TypeScript the exports of relatively imported files. Capped at
+// depTokens per mention.
+
+const depTokens = 2000
+
+type depSignatures struct {
+	Source string // relative path of the file they come from
+	Text   string
+}
+
+// Signatures for the local dependencies of path, as one attachment block
+// and the number of files they come from
+func dependencySignatures(path, content string) (string, int) {
+	var deps []depSignatures
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		deps = goDependencies(path, content)
+	case ".ts", ".tsx", ".mts":
+		deps = tsDependencies(path, content)
+	default:
+		return "", 0
+	}
+	var b strings.Builder
+	files := 0
+	for _, d := range deps {
+		block := fmt.Sprintf("// %s\n%s\n", d.Source, strings.TrimSpace(d.Text))
+		if estimateTokens(b.String()+block) > depTokens {
+			b.WriteString(fmt.Sprintf("// ... more from %d files left out (budget)\n", len(deps)-files))
+			break
+		}
+		b.WriteString(block + "\n")
+		files++
+	}
+	if files == 0 {
+		return "", 0
+	}
+	return strings.TrimSpace(b.String()), files
+}
+
+// ---- Go ----
+
+// Module root and path for the go.mod above dir
+func goModule(dir string) (string, string) {
+	for {
+		if data, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			if m := regexp.MustCompile(`(?m)^module\s+(\S+)`).FindSubmatch(data); m != nil {
+				return dir, string(m[1])
+			}
+			return "", ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+func goDependencies(path, content string) []depSignatures {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var deps []depSignatures
+
+	// Imported packages of this module: their exported API
+	modRoot, modPath := goModule(filepath.Dir(path))
+	for _, imp := range f.Imports {
+		ip := strings.Trim(imp.Path.Value, `"`)
+		if modPath == "" || (ip != modPath && !strings.HasPrefix(ip, modPath+"/")) {
+			continue
+		}
+		dir := filepath.Join(modRoot, strings.TrimPrefix(ip, modPath))
+		var b strings.Builder
+		for _, file := range goPackageFiles(dir, "") {
+			for _, decl := range file.Decls {
+				if sig := goSignature(decl, func(name string) bool { return ast.IsExported(name) }); sig != "" {
+					b.WriteString(sig + "\n")
+				}
+			}
+		}
+		if b.Len() > 0 {
+			deps = append(deps, depSignatures{relPath(dir) + " (package " + filepath.Base(dir) + ")", b.String()})
+		}
+	}
+
+	// Sibling files of the same package: what this file uses but doesn't declare
+	declared, used := map[string]bool{}, map[string]bool{}
+	for _, decl := range f.Decls {
+		for _, name := range goDeclNames(decl) {
+			declared[name] = true
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && !declared[id.Name] {
+			used[id.Name] = true
+		}
+		return true
+	})
+	delete(used, f.Name.Name)
+	base := filepath.Base(path)
+	siblings := goPackageFiles(filepath.Dir(path), f.Name.Name)
+	names := make([]string, 0, len(siblings))
+	for name := range siblings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == base {
+			continue
+		}
+		var b strings.Builder
+		for _, decl := range siblings[name].Decls {
+			if sig := goSignature(decl, func(n string) bool { return used[n] }); sig != "" {
+				b.WriteString(sig + "\n")
+			}
+		}
+		if b.Len() > 0 {
+			deps = append(deps, depSignatures{relPath(filepath.Join(filepath.Dir(path), name)), b.String()})
+		}
+	}
+	return deps
+}
+
+// Parsed non-test files in dir, by file name; pkg "" accepts any package
+func goPackageFiles(dir, pkg string) map[string]*ast.File {
+	files := map[string]*ast.File{}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil || pkg != "" && f.Name.Name != pkg {
+			continue
+		}
+		files[name] = f
+	}
+	return files
+}
+
+func goDeclNames(decl ast.Decl) []string {
+	var names []string
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv == nil {
+			names = append(names, d.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range s.Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// A declaration without bodies or values, or "" when keep rejects it.
+// Methods are kept when their type is.
+func goSignature(decl ast.Decl, keep func(string) bool) string {
+	var node any
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		name := d.Name.Name
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			name = strings.TrimPrefix(recvName(d.Recv.List[0].Type), "*")
+			if !ast.IsExported(d.Name.Name) && !keep(d.Name.Name) {
+				return ""
+			}
+		}
+		if !keep(name) {
+			return ""
+		}
+		node = &ast.FuncDecl{Recv: d.Recv, Name: d.Name, Type: d.Type}
+	case *ast.GenDecl:
+		if d.Tok != token.TYPE {
+			return ""
+		}
+		var specs []ast.Spec
+		for _, spec := range d.Specs {
+			if ts := spec.(*ast.TypeSpec); keep(ts.Name.Name) {
+				specs = append(specs, ts)
+			}
+		}
+		if len(specs) == 0 {
+			return ""
+		}
+		node = &ast.GenDecl{Tok: token.TYPE, Specs: specs}
+	default:
+		return ""
+	}
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if cfg.Fprint(&buf, token.NewFileSet(), node) != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// ---- TypeScript ----
+
+var (
+	tsImportRe = regexp.MustCompile(`(?m)(?:^import\s[^;]*?from\s+|^export\s[^;]*?from\s+|require\()\s*['"](\.{1,2}/[^'"]+)['"]`)
+	tsExportRe = regexp.MustCompile(`^export\s+(?:default\s+)?(?:declare\s+)?(?:abstract\s+)?(interface|type|enum|class|function|async\s+function|const|let)\b`)
+)
+
+func tsDependencies(path, content string) []depSignatures {
+	var deps []depSignatures
+	seen := map[string]bool{}
+	for _, m := range tsImportRe.FindAllStringSubmatch(content, -1) {
+		file := tsResolve(filepath.Join(filepath.Dir(path), m[1]))
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if sigs := tsSignatures(string(data)); sigs != "" {
+			deps = append(deps, depSignatures{relPath(file), sigs})
+		}
+	}
+	return deps
+}
+
+func tsResolve(base string) string {
+	for _, suffix := range []string{"", ".ts", ".tsx", ".d.ts", "/index.ts", "/index.tsx"} {
+		if info, err := os.Stat(base + suffix); err == nil && !info.IsDir() {
+			return base + suffix
+		}
+	}
+	return ""
+}
+
+// Exported declarations: interfaces, types and enums whole, functions and
+// class members up to their body, constants up to their value
+func tsSignatures(src string) string {
+	var out []string
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		m := tsExportRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		switch kind := m[1]; {
+		case kind == "interface" || kind == "type" || kind == "enum":
+			end := tsBlockEnd(lines, i)
+			out = append(out, strings.Join(lines[i:end+1], "\n"))
+			i = end
+		case kind == "class":
+			end := tsBlockEnd(lines, i)
+			out = append(out, strings.TrimSuffix(tsHead(line), ";")+" {")
+			depth := 0
+			for _, member := range lines[i+1 : end] {
+				t := strings.TrimSpace(member)
+				if depth == 0 && t != "" && !strings.HasPrefix(t, "//") && !strings.HasPrefix(t, "*") && !strings.HasPrefix(t, "/*") &&
+					!strings.HasPrefix(t, "}") && !strings.HasPrefix(t, "private ") && !strings.HasPrefix(t, "#") {
+					out = append(out, "  "+tsHead(t))
+				}
+				depth += strings.Count(member, "{") - strings.Count(member, "}")
+			}
+			out = append(out, "}")
+			i = end
+		case kind == "const" || kind == "let":
+			if eq := strings.Index(line, "="); eq > 0 {
+				line = strings.TrimSpace(line[:eq])
+			}
+			out = append(out, strings.TrimSuffix(line, ";")+";")
+		default: // functions
+			out = append(out, tsHead(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// Text before the body of a declaration
+func tsHead(line string) string {
+	if i := strings.LastIndex(line, "{"); i > 0 && !strings.HasSuffix(strings.TrimSpace(line[:i]), ":") {
+		return strings.TrimSpace(line[:i]) + ";"
+	}
+	return strings.TrimSuffix(line, ";") + ";"
+}
+
+// Last line of the declaration starting at line i: where braces balance
+// again, or the first line ending in ";" for brace-less type aliases
+func tsBlockEnd(lines []string, i int) int {
+	depth, opened := 0, false
+	for j := i; j < len(lines); j++ {
+		depth += strings.Count(lines[j], "{") - strings.Count(lines[j], "}")
+		opened = opened || strings.Contains(lines[j], "{")
+		if opened && depth <= 0 || !opened && strings.HasSuffix(strings.TrimSpace(lines[j]), ";") {
+			return j
+		}
+	}
+	return len(lines) - 1
+}
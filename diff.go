@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ==================== DIFF RENDERING ====================
+
+// Edits are shown as a real line diff before they are confirmed: hunks
+// with three lines of context and @@ headers, and the changed part of a
+// modified line highlighted. settings.DiffDisplayMode picks the layout:
+// "GitHub" puts old and new side by side (unified when the terminal is
+// too narrow), "Unified" is the classic one-column form.
+
+const (
+	diffContext  = 3
+	diffMaxRows  = 120     // rendered rows before the rest is summarized
+	diffMaxCells = 4000000 // LCS table size before falling back to replace-all
+	diffSplitMin = 100     // terminal columns needed for side by side
+	colorInverse = "\033[7m"
+	colorNoInv   = "\033[27m"
+)
+
+type diffOp struct {
+	Kind byte // ' ', '-' or '+'
+	A, B int  // 0-based line in before/after; -1 when absent
+	Text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Line diff of a and b: common prefix and suffix are split off, the
+// middle is an LCS
+func lineDiff(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var ops []diffOp
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', i, i, a[i]})
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if (len(ma)+1)*(len(mb)+1) > diffMaxCells {
+		for i, l := range ma {
+			ops = append(ops, diffOp{'-', pre + i, -1, l})
+		}
+		for j, l := range mb {
+			ops = append(ops, diffOp{'+', -1, pre + j, l})
+		}
+	} else {
+		ops = append(ops, lcsDiff(ma, mb, pre)...)
+	}
+	for k := suf; k > 0; k-- {
+		ops = append(ops, diffOp{' ', len(a) - k, len(b) - k, a[len(a)-k]})
+	}
+	return ops
+}
+
+func lcsDiff(a, b []string, offset int) []diffOp {
+	n, m := len(a), len(b)
+	// lcs[i][j] = LCS length of a[i:] and b[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', offset + i, offset + j, a[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, diffOp{'+', -1, offset + j, b[j]})
+			j++
+		default:
+			ops = append(ops, diffOp{'-', offset + i, -1, a[i]})
+			i++
+		}
+	}
+	return ops
+}
+
+// Groups ops into hunks of changes with ctx lines of context around them
+func diffHunks(ops []diffOp, ctx int) [][]diffOp {
+	var hunks [][]diffOp
+	for _, b := range hunkBounds(ops, ctx) {
+		hunks = append(hunks, ops[b[0]:b[1]])
+	}
+	return hunks
+}
+
+// [start, end) of each hunk in ops
+func hunkBounds(ops []diffOp, ctx int) [][2]int {
+	var bounds [][2]int
+	start, end := -1, -1
+	for i, op := range ops {
+		if op.Kind == ' ' {
+			continue
+		}
+		lo, hi := max(0, i-ctx), min(len(ops), i+ctx+1)
+		if start >= 0 && lo <= end {
+			end = hi
+			continue
+		}
+		if start >= 0 {
+			bounds = append(bounds, [2]int{start, end})
+		}
+		start, end = lo, hi
+	}
+	if start >= 0 {
+		bounds = append(bounds, [2]int{start, end})
+	}
+	return bounds
+}
+
+func hunkHeader(h []diffOp) string {
+	aStart, bStart, aLen, bLen := -1, -1, 0, 0
+	for _, op := range h {
+		if op.A >= 0 {
+			if aStart < 0 {
+				aStart = op.A
+			}
+			aLen++
+		}
+		if op.B >= 0 {
+			if bStart < 0 {
+				bStart = op.B
+			}
+			bLen++
+		}
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", aStart+1, aLen, bStart+1, bLen)
+}
+
+// Old and new line with the part that differs set in inverse video
+func highlightPair(old, new string) (string, string) {
+	pre := 0
+	for pre < len(old) && pre < len(new) && old[pre] == new[pre] {
+		pre++
+	}
+	for pre > 0 && pre < len(old) && !utf8.RuneStart(old[pre]) {
+		pre--
+	}
+	suf := 0
+	for suf < len(old)-pre && suf < len(new)-pre && old[len(old)-1-suf] == new[len(new)-1-suf] {
+		suf++
+	}
+	for suf > 0 && !utf8.RuneStart(old[len(old)-suf]) {
+		suf--
+	}
+	mark := func(s string) string {
+		mid := s[pre : len(s)-suf]
+		if mid == "" {
+			return s
+		}
+		return s[:pre] + colorInverse + mid + colorNoInv + s[len(s)-suf:]
+	}
+	return mark(old), mark(new)
+}
+
+// Pairs the removed and added lines of one change block for highlighting;
+// only blocks with as many removals as additions are treated as edits
+func changeBlocks(h []diffOp) [][2][]diffOp {
+	var blocks [][2][]diffOp
+	for i := 0; i < len(h); {
+		if h[i].Kind == ' ' {
+			blocks = append(blocks, [2][]diffOp{{h[i]}, {h[i]}})
+			i++
+			continue
+		}
+		var del, add []diffOp
+		for i < len(h) && h[i].Kind == '-' {
+			del = append(del, h[i])
+			i++
+		}
+		for i < len(h) && h[i].Kind == '+' {
+			add = append(add, h[i])
+			i++
+		}
+		blocks = append(blocks, [2][]diffOp{del, add})
+	}
+	return blocks
+}
+
+func terminalWidth() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// Diff of before → after for display, in the configured layout
+func renderDiff(path, before, after string) string {
+	ops := lineDiff(splitLines(before), splitLines(after))
+	hunks := diffHunks(ops, diffContext)
+	if len(hunks) == 0 {
+		return fmt.Sprintf("%s(no changes to %s)%s", colorGray, path, colorReset)
+	}
+	added, removed := 0, 0
+	for _, op := range ops {
+		switch op.Kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%s%s%s %s+%d%s %s-%d%s\n", colorBold, path, colorReset, colorGreen, added, colorReset, colorRed, removed, colorReset))
+
+	width := terminalWidth()
+	split := settings.DiffDisplayMode == "GitHub" && width >= diffSplitMin
+	rows := 0
+	for _, h := range hunks {
+		b.WriteString(colorCyan + hunkHeader(h) + colorReset + "\n")
+		for _, blk := range changeBlocks(h) {
+			del, add := blk[0], blk[1]
+			var lines []string
+			if split {
+				lines = splitRows(del, add, width)
+			} else {
+				lines = unifiedRows(del, add)
+			}
+			for _, l := range lines {
+				if rows == diffMaxRows {
+					b.WriteString(fmt.Sprintf("%s... diff truncated (%d hunks in total)%s\n", colorGray, len(hunks), colorReset))
+					return strings.TrimSuffix(b.String(), "\n")
+				}
+				b.WriteString(l + "\n")
+				rows++
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func unifiedRows(del, add []diffOp) []string {
+	if len(del) == 1 && len(add) == 1 && del[0].Kind == ' ' {
+		return []string{fmt.Sprintf("%s %s%s", colorGray, expandTabs(del[0].Text), colorReset)}
+	}
+	oldText, newText := blockTexts(del, add)
+	var rows []string
+	for _, t := range oldText {
+		rows = append(rows, fmt.Sprintf("%s-%s%s", colorRed, t, colorReset))
+	}
+	for _, t := range newText {
+		rows = append(rows, fmt.Sprintf("%s+%s%s", colorGreen, t, colorReset))
+	}
+	return rows
+}
+
+// Texts of a change block, highlighted when the lines pair up
+func blockTexts(del, add []diffOp) ([]string, []string) {
+	oldText, newText := make([]string, len(del)), make([]string, len(add))
+	for i, op := range del {
+		oldText[i] = expandTabs(op.Text)
+	}
+	for i, op := range add {
+		newText[i] = expandTabs(op.Text)
+	}
+	if len(del) == len(add) {
+		for i := range del {
+			oldText[i], newText[i] = highlightPair(oldText[i], newText[i])
+		}
+	}
+	return oldText, newText
+}
+
+func splitRows(del, add []diffOp, width int) []string {
+	col := (width - 13) / 2 // two line numbers of 4, markers and a separator
+	if len(del) == 1 && len(add) == 1 && del[0].Kind == ' ' {
+		t := fitColumn(expandTabs(del[0].Text), col)
+		return []string{fmt.Sprintf("%s%4d  %s │%4d  %s%s", colorGray, del[0].A+1, t, del[0].B+1, t, colorReset)}
+	}
+	oldText, newText := blockTexts(del, add)
+	var rows []string
+	for i := 0; i < max(len(del), len(add)); i++ {
+		left := fmt.Sprintf("%4s  %s", "", strings.Repeat(" ", col))
+		right := ""
+		if i < len(del) {
+			left = fmt.Sprintf("%s%4d -%s%s", colorRed, del[i].A+1, fitColumn(oldText[i], col), colorReset)
+		}
+		if i < len(add) {
+			right = fmt.Sprintf("%s%4d +%s%s", colorGreen, add[i].B+1, fitColumn(newText[i], col), colorReset)
+		}
+		rows = append(rows, left+" │"+right)
+	}
+	return rows
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", "    ")
+}
+
+// Pads or cuts s to n visible runes; highlight escapes don't count
+func fitColumn(s string, n int) string {
+	plain := strings.ReplaceAll(strings.ReplaceAll(s, colorInverse, ""), colorNoInv, "")
+	fits := utf8.RuneCountInString(plain) <= n
+	var b strings.Builder
+	visible, inverse := 0, false
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], colorInverse) {
+			b.WriteString(colorInverse)
+			inverse = true
+			i += len(colorInverse)
+			continue
+		}
+		if strings.HasPrefix(s[i:], colorNoInv) {
+			b.WriteString(colorNoInv)
+			inverse = false
+			i += len(colorNoInv)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !fits && visible == n-1 {
+			b.WriteString("…")
+			visible++
+			break
+		}
+		b.WriteRune(r)
+		visible++
+		i += size
+	}
+	if inverse {
+		b.WriteString(colorNoInv)
+	}
+	return b.String() + strings.Repeat(" ", max(0, n-visible))
+}
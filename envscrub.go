@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ==================== CHILD ENVIRONMENT ====================
+
+// Commands the model runs inherit mytool's environment, API keys and
+// cloud credentials included, so a prompt-injected run:env would hand
+// them over. With Scrub environment on, run, the script runtimes and
+// background jobs only get baseEnvVars plus the names in
+// settings.EnvPassthrough ("NODE_*" passes a prefix). The sandbox needs
+// no scrubbing: containers get none of the host environment.
+
+// What programs commonly need to work at all
+var baseEnvVars = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_*", "TZ", "TMPDIR",
+	// Windows
+	"SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// Whether name matches one of the patterns; names compare case-insensitively
+// for Windows
+func envNameMatches(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(strings.ToUpper(p), strings.ToUpper(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Environment for a child process on the host; nil (inherit everything)
+// unless scrubbing is on
+func childEnv() []string {
+	if !settings.ScrubEnv {
+		return nil
+	}
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if envNameMatches(name, baseEnvVars) || envNameMatches(name, settings.EnvPassthrough) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// /env [on|off|pass NAME...|drop NAME...]
+func cmdEnv(arg string) string {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		state := "off: commands inherit the whole environment"
+		if settings.ScrubEnv {
+			var names []string
+			for _, kv := range childEnv() {
+				name, _, _ := strings.Cut(kv, "=")
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			state = fmt.Sprintf("on: commands get %d of %d variables: %s", len(names), len(os.Environ()), strings.Join(names, " "))
+		}
+		pass := "none"
+		if len(settings.EnvPassthrough) > 0 {
+			pass = strings.Join(settings.EnvPassthrough, " ")
+		}
+		return fmt.Sprintf("Scrub environment %s\nPassthrough: %s\n%sUsage: /env on|off, /env pass NAME [PREFIX_*], /env drop NAME%s",
+			state, pass, colorGray, colorReset)
+	}
+	switch {
+	case arg == "on" || arg == "off":
+		settings.ScrubEnv = arg == "on"
+	case fields[0] == "pass" && len(fields) > 1:
+		for _, name := range fields[1:] {
+			if _, err := filepath.Match(name, ""); err != nil {
+				return fmt.Sprintf("Error: bad name %q", name)
+			}
+			if !envNameMatches(name, settings.EnvPassthrough) {
+				settings.EnvPassthrough = append(settings.EnvPassthrough, name)
+			}
+		}
+	case fields[0] == "drop" && len(fields) > 1:
+		kept := settings.EnvPassthrough[:0]
+		for _, name := range settings.EnvPassthrough {
+			if !envNameMatches(name, fields[1:]) {
+				kept = append(kept, name)
+			}
+		}
+		settings.EnvPassthrough = kept
+	default:
+		return "Usage: /env [on|off|pass NAME...|drop NAME...]"
+	}
+	saveSettings()
+	status, _, _ := strings.Cut(cmdEnv(""), "\n")
+	return fmt.Sprintf("%s✓ %s%s", colorGreen, truncate(status, 200), colorReset)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// ==================== FILE FORMAT ====================
+
+// Edits work on text with \n line endings and no byte order mark, which
+// is what the model reads and writes. The file's own conventions are put
+// back on the way out: CRLF, the UTF-8 BOM or UTF-16, whether it ends
+// with a newline, and its permissions, so editing a Windows file or an
+// executable script leaves it as it was apart from the change.
+
+type fileFormat struct {
+	Encoding string // "", "utf-8-bom", "utf-16le" or "utf-16be"
+	CRLF     bool
+	FinalEOL bool
+	Mode     os.FileMode // 0: new file, written as the model produced it
+	known    bool
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Text of data as edits see it, and the format to write it back in
+func decodeText(data []byte) (string, fileFormat) {
+	f := fileFormat{known: true}
+	var text string
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		f.Encoding, text = "utf-8-bom", string(data[3:])
+	case bytes.HasPrefix(data, bomUTF16LE) && len(data)%2 == 0:
+		f.Encoding, text = "utf-16le", decodeUTF16(data[2:], binary.LittleEndian)
+	case bytes.HasPrefix(data, bomUTF16BE) && len(data)%2 == 0:
+		f.Encoding, text = "utf-16be", decodeUTF16(data[2:], binary.BigEndian)
+	default:
+		text = string(data)
+	}
+	// CRLF when most lines use it; stray CRs in an LF file are left alone
+	if crlf := strings.Count(text, "\r\n"); crlf > 0 && crlf*2 >= strings.Count(text, "\n") {
+		f.CRLF = true
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	f.FinalEOL = text == "" || strings.HasSuffix(text, "\n")
+	return text, f
+}
+
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// Reads path as text; a missing file gives an empty text and a format
+// that writes the model's output unchanged
+func readText(path string) (string, fileFormat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fileFormat{}, err
+	}
+	text, f := decodeText(data)
+	if info, err := os.Stat(path); err == nil {
+		f.Mode = info.Mode().Perm()
+	}
+	return text, f, nil
+}
+
+// text with the file's final newline state; what will be written
+func (f fileFormat) normalize(text string) string {
+	if !f.known {
+		return text
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	switch {
+	case text == "":
+	case f.FinalEOL && !strings.HasSuffix(text, "\n"):
+		text += "\n"
+	case !f.FinalEOL:
+		text = strings.TrimRight(text, "\n")
+	}
+	return text
+}
+
+// Bytes of an already normalized text in the file's line endings and encoding
+func (f fileFormat) encode(text string) []byte {
+	if !f.known {
+		return []byte(text)
+	}
+	if f.CRLF {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	switch f.Encoding {
+	case "utf-8-bom":
+		return append(append([]byte{}, bomUTF8...), text...)
+	case "utf-16le", "utf-16be":
+		units := utf16.Encode([]rune(text))
+		out := make([]byte, 2+2*len(units))
+		var order binary.ByteOrder = binary.LittleEndian
+		copy(out, bomUTF16LE)
+		if f.Encoding == "utf-16be" {
+			order = binary.BigEndian
+			copy(out, bomUTF16BE)
+		}
+		for i, u := range units {
+			order.PutUint16(out[2+2*i:], u)
+		}
+		return out
+	}
+	return []byte(text)
+}
+
+// Writes text to path in format f, keeping its permissions (a renamed
+// file keeps those of the original)
+func writeText(path, text string, f fileFormat) error {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	perm := f.Mode
+	if perm == 0 {
+		perm = 0644
+	}
+	if err := os.WriteFile(path, f.encode(f.normalize(text)), perm); err != nil {
+		return err
+	}
+	if f.Mode != 0 {
+		return os.Chmod(path, f.Mode) // WriteFile only applies perm to new files
+	}
+	return nil
+}
+
+// Short description for messages, "" for plain LF UTF-8
+func (f fileFormat) String() string {
+	var parts []string
+	if f.Encoding != "" {
+		parts = append(parts, f.Encoding)
+	}
+	if f.CRLF {
+		parts = append(parts, "CRLF")
+	}
+	return strings.Join(parts, ", ")
+}
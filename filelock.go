@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ==================== FILE LOCKING ====================
+
+// Several mytool instances share ~/.mytool. Writers take <file>.lock (an
+// O_EXCL lock file, so it works the same on every OS), re-read the file,
+// merge and replace it with a rename so readers never see half a file.
+// Sessions don't need this: SQLite does its own locking.
+
+const (
+	lockWait  = 5 * time.Second
+	lockStale = 30 * time.Second // a crashed holder never removes its lock
+)
+
+func lockFile(path string) (func(), error) {
+	lock := path + ".lock"
+	os.MkdirAll(filepath.Dir(path), 0755)
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if info, serr := os.Stat(lock); serr == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another mytool instance", filepath.Base(path))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	os.Chmod(tmp.Name(), perm)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Read-modify-write under the lock. fn gets the current content (nil if
+// the file doesn't exist) and returns the new one, or nil to leave it.
+func updateFile(path string, perm os.FileMode, fn func(old []byte) []byte) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	old, _ := os.ReadFile(path)
+	data := fn(old)
+	if data == nil {
+		return nil
+	}
+	return writeFileAtomic(path, data, perm)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== FILE MANAGEMENT ====================
+
+// delete, rename, move, mkdir and chmod as tools, so the model doesn't
+// fall back to run:mv and friends: each asks in ask mode, is blocked in
+// manual mode and leaves one /undo step. A deleted directory is kept
+// in the undo record file by file, up to deleteMaxFiles/deleteMaxBytes.
+
+const (
+	deleteMaxFiles = 500
+	deleteMaxBytes = 20 << 20
+)
+
+// Common checks; returns a message when the operation may not go ahead
+func fileOpAllowed(question string) string {
+	if currentMode == ModeManual {
+		return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
+	}
+	if currentMode == ModeAsk && !confirmAction(fmt.Sprintf("%s%s?%s", colorYellow, question, colorReset)) {
+		return "Cancelled"
+	}
+	return ""
+}
+
+func cmdDelete(arg string) string {
+	if arg == "" {
+		return "Usage: delete:path"
+	}
+	path := resolvePath(arg)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if path == currentDir || path == findProjectRoot() {
+		return "Error: refusing to delete the working directory"
+	}
+
+	batch := UndoAction{Type: "batch", Path: relPath(path), Op: "delete", Time: time.Now()}
+	if info.IsDir() {
+		files, size := 0, int64(0)
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				if d.Type()&fs.ModeSymlink != 0 || !d.Type().IsRegular() {
+					return fmt.Errorf("%s is not a regular file", relPath(p))
+				}
+				info, _ := d.Info()
+				files++
+				size += info.Size()
+				if files > deleteMaxFiles || size > deleteMaxBytes {
+					return fmt.Errorf("%s is too big to keep for undo (over %d files or %s)", relPath(path), deleteMaxFiles, formatSize(deleteMaxBytes))
+				}
+			}
+			batch.Files = append(batch.Files, undoSnapshot(p))
+			return nil
+		})
+		if err != nil {
+			return fmt.Sprintf("Error: %s; delete it with run:rm if you are sure", err)
+		}
+		if msg := fileOpAllowed(fmt.Sprintf("Delete directory %s (%d files, %s)", relPath(path), files, formatSize(size))); msg != "" {
+			return msg
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		pushUndo(batch)
+		return fmt.Sprintf("%s✓ Deleted %s/ (%d files)%s", colorGreen, relPath(path), files, colorReset)
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Sprintf("Error: %s is not a regular file", relPath(path))
+	}
+	if msg := fileOpAllowed(fmt.Sprintf("Delete %s (%s)", relPath(path), formatSize(info.Size()))); msg != "" {
+		return msg
+	}
+	saveForUndo(path, "delete")
+	if err := os.Remove(path); err != nil {
+		undoStack = undoStack[:len(undoStack)-1]
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return fmt.Sprintf("%s✓ Deleted %s%s", colorGreen, relPath(path), colorReset)
+}
+
+// rename:old|||new renames or moves a file or directory; the target must
+// not exist
+func cmdRename(args string) string {
+	parts := strings.SplitN(args, "|||", 2)
+	if len(parts) < 2 {
+		return "Error: format old|||new"
+	}
+	return movePath(resolvePath(strings.TrimSpace(parts[0])), resolvePath(strings.TrimSpace(parts[1])), "rename")
+}
+
+// move:path|||dir moves into an existing directory (created if missing)
+func cmdMove(args string) string {
+	parts := strings.SplitN(args, "|||", 2)
+	if len(parts) < 2 {
+		return "Error: format path|||dir"
+	}
+	src, dir := resolvePath(strings.TrimSpace(parts[0])), resolvePath(strings.TrimSpace(parts[1]))
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return fmt.Sprintf("Error: %s is not a directory", relPath(dir))
+	}
+	return movePath(src, filepath.Join(dir, filepath.Base(src)), "move")
+}
+
+func movePath(src, dst, op string) string {
+	if _, err := os.Lstat(src); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Sprintf("Error: %s already exists", relPath(dst))
+	}
+	if strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		return fmt.Sprintf("Error: cannot move %s into itself", relPath(src))
+	}
+	if msg := fileOpAllowed(fmt.Sprintf("%s %s → %s", strings.ToUpper(op[:1])+op[1:], relPath(src), relPath(dst))); msg != "" {
+		return msg
+	}
+	os.MkdirAll(filepath.Dir(dst), 0755)
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	pushUndo(UndoAction{Type: "move", Path: dst, From: src, Op: op, Time: time.Now()})
+	return fmt.Sprintf("%s✓ %s → %s%s", colorGreen, relPath(src), relPath(dst), colorReset)
+}
+
+func cmdMkdir(arg string) string {
+	if arg == "" {
+		return "Usage: mkdir:dir"
+	}
+	path := resolvePath(arg)
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return fmt.Sprintf("%s already exists", relPath(path))
+		}
+		return fmt.Sprintf("Error: %s exists and is not a directory", relPath(path))
+	}
+	if msg := fileOpAllowed("Create directory " + relPath(path)); msg != "" {
+		return msg
+	}
+	// Every missing level is recorded so /undo removes them all
+	var missing []string
+	for p := path; ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil || filepath.Dir(p) == p {
+			break
+		}
+		missing = append([]string{p}, missing...)
+	}
+	batch := UndoAction{Type: "batch", Path: relPath(path), Op: "mkdir", Time: time.Now()}
+	for _, p := range missing {
+		batch.Files = append(batch.Files, UndoAction{Type: "file", Path: p, Time: time.Now()})
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	pushUndo(batch)
+	return fmt.Sprintf("%s✓ Created %s/%s", colorGreen, relPath(path), colorReset)
+}
+
+// chmod:755 path, chmod:+x path or chmod:-w path
+func cmdChmod(args string) string {
+	mode, arg, ok := strings.Cut(strings.TrimSpace(args), " ")
+	if !ok || strings.TrimSpace(arg) == "" {
+		return "Usage: chmod:<mode> path (755, +x, -w)"
+	}
+	path := resolvePath(strings.TrimSpace(arg))
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	old := info.Mode().Perm()
+	perm, err := parseChmod(mode, old)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if perm == old {
+		return fmt.Sprintf("%s is already %s", relPath(path), old)
+	}
+	if msg := fileOpAllowed(fmt.Sprintf("chmod %s: %s → %s", relPath(path), old, perm)); msg != "" {
+		return msg
+	}
+	action := UndoAction{Type: "dir", Path: path, Mode: old, Op: "chmod", Time: time.Now()}
+	if !info.IsDir() {
+		action = undoSnapshot(path)
+		action.Op = "chmod"
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	pushUndo(action)
+	return fmt.Sprintf("%s✓ %s: %s → %s%s", colorGreen, relPath(path), old, perm, colorReset)
+}
+
+// Octal modes, or [ugoa]*[+-][rwx]+ relative to old
+func parseChmod(mode string, old os.FileMode) (os.FileMode, error) {
+	if n, err := strconv.ParseUint(mode, 8, 32); err == nil {
+		if n > 0o777 {
+			return 0, fmt.Errorf("mode %s out of range", mode)
+		}
+		return os.FileMode(n), nil
+	}
+	i := strings.IndexAny(mode, "+-")
+	if i < 0 || i == len(mode)-1 {
+		return 0, fmt.Errorf("bad mode %q (use 755, +x, u+w, go-w)", mode)
+	}
+	who, bits := mode[:i], mode[i+1:]
+	if who == "" || who == "a" {
+		who = "ugo"
+	}
+	var mask os.FileMode
+	for _, w := range who {
+		shift, ok := map[rune]uint{'u': 6, 'g': 3, 'o': 0}[w]
+		if !ok {
+			return 0, fmt.Errorf("bad mode %q", mode)
+		}
+		for _, b := range bits {
+			bit, ok := map[rune]os.FileMode{'r': 4, 'w': 2, 'x': 1}[b]
+			if !ok {
+				return 0, fmt.Errorf("bad mode %q", mode)
+			}
+			mask |= bit << shift
+		}
+	}
+	if mode[i] == '+' {
+		return old | mask, nil
+	}
+	return old &^ mask, nil
+}
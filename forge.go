@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ==================== FORGES ====================
+
+// /pr, /pr status and issue mentions work with GitHub, GitLab (also
+// self-hosted) and Bitbucket Cloud; which one is picked from the remote's
+// host. Tokens are sent only to hosts known to run that forge: github.com,
+// gitlab.com, bitbucket.org, the host of GITHUB_API_URL and those listed
+// in settings.ForgeHosts. Any other host is an error rather than a guess,
+// since a wrong one would hand it the user's token. Each forge's API lives
+// in its own file; the functions here dispatch on forgeRepo.Kind.
+
+const (
+	forgeGitHub    = "github"
+	forgeGitLab    = "gitlab"
+	forgeBitbucket = "bitbucket"
+)
+
+var forgeRemoteRe = regexp.MustCompile(`^(?:https?://(?:[^@/]+@)?|ssh://(?:[^@/]+@)?|[^@/]+@)([^/:]+)(?::\d+)?[:/](?:\d+/)?(.+?)(?:\.git)?/?$`)
+
+type forgeRepo struct {
+	Kind              string
+	Host, Owner, Name string // Owner may hold GitLab subgroups: group/sub
+}
+
+func (r forgeRepo) String() string {
+	return r.Owner + "/" + r.Name
+}
+
+func (r forgeRepo) Label() string {
+	switch r.Kind {
+	case forgeGitLab:
+		return "GitLab"
+	case forgeBitbucket:
+		return "Bitbucket"
+	}
+	return "GitHub"
+}
+
+// What the forge calls a pull request
+func (r forgeRepo) RequestName() string {
+	if r.Kind == forgeGitLab {
+		return "merge request"
+	}
+	return "pull request"
+}
+
+func forgeKeychain(kind string) string {
+	return "mytool-" + kind
+}
+
+// A pull or merge request
+type forgeRequest struct {
+	Number int
+	URL    string
+	SHA    string // head commit, when the forge says
+}
+
+// An issue or pull request with its latest comments, for mentions
+type forgeIssue struct {
+	Kind, Title, State, Body, Author, URL string
+	Labels                                []string
+	Comments                              []forgeComment
+	Total                                 int // comments in all
+}
+
+type forgeComment struct {
+	Author, Date, Body string
+}
+
+// One CI job or status on a commit; State is success, failure, pending
+// or skipped
+type ciCheck struct {
+	Name, State, URL string
+}
+
+// The repository a remote URL points to and the forge its host runs
+func parseRemoteURL(url string) (forgeRepo, error) {
+	m := forgeRemoteRe.FindStringSubmatch(strings.TrimSpace(url))
+	i := -1
+	if m != nil {
+		i = strings.LastIndex(m[2], "/")
+	}
+	if i <= 0 {
+		return forgeRepo{}, fmt.Errorf("can't tell the repository from %s", url)
+	}
+	kind, err := forgeKindOf(m[1])
+	if err != nil {
+		return forgeRepo{}, err
+	}
+	return forgeRepo{Kind: kind, Host: m[1], Owner: m[2][:i], Name: m[2][i+1:]}, nil
+}
+
+// The forge host runs, only when that is known for certain
+func forgeKindOf(host string) (string, error) {
+	host = strings.ToLower(host)
+	switch host {
+	case "github.com":
+		return forgeGitHub, nil
+	case "gitlab.com":
+		return forgeGitLab, nil
+	case "bitbucket.org":
+		return forgeBitbucket, nil
+	}
+	switch kind := settings.ForgeHosts[host]; kind {
+	case forgeGitHub, forgeGitLab, forgeBitbucket:
+		return kind, nil
+	case "":
+	default:
+		return "", fmt.Errorf("forge_hosts: %q for %s is not github, gitlab or bitbucket", kind, host)
+	}
+	if u, err := url.Parse(os.Getenv("GITHUB_API_URL")); err == nil && strings.EqualFold(u.Hostname(), host) {
+		return forgeGitHub, nil
+	}
+	return "", fmt.Errorf("don't know which forge %s runs; add it to ~/.mytool/settings.json as \"forge_hosts\": {\"%s\": \"gitlab\"} (or github, bitbucket)", host, host)
+}
+
+// The repository of remote in root
+func forgeRemote(root, remote string) (forgeRepo, error) {
+	url, err := gitIn(root, "remote", "get-url", remote)
+	if err != nil {
+		return forgeRepo{}, fmt.Errorf("no remote %q", remote)
+	}
+	return parseRemoteURL(url)
+}
+
+func forgeToken(r forgeRepo) (string, error) {
+	switch r.Kind {
+	case forgeGitLab:
+		return gitlabToken(r.Host)
+	case forgeBitbucket:
+		return bitbucketToken()
+	}
+	return githubToken()
+}
+
+// Sends a request to a forge API; in, when not nil, goes as JSON and the
+// response is decoded into out. Error messages are taken from the body in
+// any of the forges' formats.
+func forgeHTTP(method, url string, header http.Header, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, _ := json.Marshal(in)
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("User-Agent", "mytool/"+version)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message any    `json:"message"` // GitLab sends an object for validation errors
+			Text    string `json:"error_description"`
+			Error   any    `json:"error"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		json.Unmarshal(data, &apiErr)
+		var parts []string
+		for _, v := range []any{apiErr.Message, apiErr.Error} {
+			switch v := v.(type) {
+			case string:
+				parts = append(parts, v)
+			case map[string]any:
+				if m, ok := v["message"].(string); ok { // Bitbucket
+					parts = append(parts, m)
+				} else if b, err := json.Marshal(v); err == nil {
+					parts = append(parts, string(b))
+				}
+			case []any:
+				b, _ := json.Marshal(v)
+				parts = append(parts, string(b))
+			}
+		}
+		if apiErr.Text != "" {
+			parts = append(parts, apiErr.Text)
+		}
+		for _, e := range apiErr.Errors {
+			if e.Message != "" {
+				parts = append(parts, e.Message)
+			}
+		}
+		msg := strings.Join(parts, ": ")
+		if msg == "" {
+			msg = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s %s: %d %s", method, strings.SplitN(url, "?", 2)[0], resp.StatusCode, truncate(msg, 300))
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func forgeDefaultBranch(r forgeRepo) (string, error) {
+	switch r.Kind {
+	case forgeGitLab:
+		return gitlabDefaultBranch(r)
+	case forgeBitbucket:
+		return bitbucketDefaultBranch(r)
+	}
+	return githubDefaultBranch(r)
+}
+
+// The open pull request from branch, nil when there is none
+func forgeOpenRequest(r forgeRepo, branch string) (*forgeRequest, error) {
+	switch r.Kind {
+	case forgeGitLab:
+		return gitlabOpenRequest(r, branch)
+	case forgeBitbucket:
+		return bitbucketOpenRequest(r, branch)
+	}
+	return githubOpenRequest(r, branch)
+}
+
+func forgeCreateRequest(r forgeRepo, branch, base, title, body string, draft bool) (*forgeRequest, error) {
+	switch r.Kind {
+	case forgeGitLab:
+		return gitlabCreateRequest(r, branch, base, title, body, draft)
+	case forgeBitbucket:
+		return bitbucketCreateRequest(r, branch, base, title, body, draft)
+	}
+	return githubCreateRequest(r, branch, base, title, body, draft)
+}
+
+// Issue number, or pull request number when pull is set; on GitHub the
+// two share numbers and either works
+func forgeGetIssue(r forgeRepo, number string, pull bool) (*forgeIssue, error) {
+	switch r.Kind {
+	case forgeGitLab:
+		return gitlabIssue(r, number, pull)
+	case forgeBitbucket:
+		return bitbucketIssue(r, number, pull)
+	}
+	return githubIssue(r, number)
+}
+
+// CI results for commit sha
+func forgeChecks(r forgeRepo, sha string) ([]ciCheck, error) {
+	switch r.Kind {
+	case forgeGitLab:
+		return gitlabChecks(r, sha)
+	case forgeBitbucket:
+		return bitbucketChecks(r, sha)
+	}
+	return githubChecks(r, sha)
+}
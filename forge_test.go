@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestParseRemoteURL(t *testing.T) {
+	t.Setenv("GITHUB_API_URL", "")
+	saved := settings.ForgeHosts
+	defer func() { settings.ForgeHosts = saved }()
+	settings.ForgeHosts = map[string]string{"git.example.com": "gitlab"}
+
+	tests := []struct {
+		url  string
+		want forgeRepo
+	}{
+		{"https://github.com/zesbe/mytool.git", forgeRepo{Kind: forgeGitHub, Host: "github.com", Owner: "zesbe", Name: "mytool"}},
+		{"git@github.com:zesbe/mytool.git", forgeRepo{Kind: forgeGitHub, Host: "github.com", Owner: "zesbe", Name: "mytool"}},
+		{"ssh://git@gitlab.com:2222/group/sub/proj", forgeRepo{Kind: forgeGitLab, Host: "gitlab.com", Owner: "group/sub", Name: "proj"}},
+		{"https://user@bitbucket.org/team/repo.git", forgeRepo{Kind: forgeBitbucket, Host: "bitbucket.org", Owner: "team", Name: "repo"}},
+		{"https://git.example.com/team/repo/", forgeRepo{Kind: forgeGitLab, Host: "git.example.com", Owner: "team", Name: "repo"}},
+	}
+	for _, tt := range tests {
+		got, err := parseRemoteURL(tt.url)
+		if err != nil || got != tt.want {
+			t.Errorf("parseRemoteURL(%q) = %+v, %v; want %+v", tt.url, got, err, tt.want)
+		}
+	}
+
+	for _, url := range []string{"https://github.com/just-owner", "not a url", "https://github.evil.example/a/b", "git@example.org:a/b.git"} {
+		if got, err := parseRemoteURL(url); err == nil {
+			t.Errorf("parseRemoteURL(%q) = %+v, want an error", url, got)
+		}
+	}
+}
+
+func TestForgeKindOf(t *testing.T) {
+	saved := settings.ForgeHosts
+	defer func() { settings.ForgeHosts = saved }()
+	settings.ForgeHosts = map[string]string{"code.corp": "github", "bad.corp": "gitea"}
+	t.Setenv("GITHUB_API_URL", "https://ghe.corp/api/v3")
+
+	tests := []struct {
+		host, want string
+		ok         bool
+	}{
+		{"github.com", forgeGitHub, true},
+		{"GitHub.com", forgeGitHub, true},
+		{"gitlab.com", forgeGitLab, true},
+		{"bitbucket.org", forgeBitbucket, true},
+		{"code.corp", forgeGitHub, true},
+		{"ghe.corp", forgeGitHub, true},
+		{"bad.corp", "", false},
+		{"github.evil.example", "", false},
+		{"gitlab.evil.example", "", false},
+		{"git.example.com", "", false},
+	}
+	for _, tt := range tests {
+		got, err := forgeKindOf(tt.host)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("forgeKindOf(%q) = %q, %v; want %q (ok %v)", tt.host, got, err, tt.want, tt.ok)
+		}
+	}
+}
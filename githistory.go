@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== GIT HISTORY TOOLS ====================
+
+// blame and log answer "who changed this and why" without the model
+// shelling out to git and wading through its raw output. blame puts the
+// commit, date and author next to each line and then quotes the full
+// message of each commit involved; log lists the commits that touched a
+// file, or only a range of its lines.
+
+const (
+	blameContext    = 2   // lines around a single blamed line
+	blameMaxLines   = 200 // lines blamed when no range is given
+	blameMaxCommits = 5   // commits whose messages are quoted
+	logCount        = 10
+)
+
+var (
+	historySpecRe = regexp.MustCompile(`^(.*?)(?::(\d+)(?:-(\d+))?)?$`)
+	blameHeaderRe = regexp.MustCompile(`^([0-9a-f]{40}) \d+ (\d+)`)
+	blankLinesRe  = regexp.MustCompile(`\n\s*\n\s*\n`)
+)
+
+// Splits path[:line[-line]]; from and to are 0 without a range
+func historySpec(arg string) (path string, from, to int) {
+	m := historySpecRe.FindStringSubmatch(strings.TrimSpace(arg))
+	path = m[1]
+	from, _ = strconv.Atoi(m[2])
+	to, _ = strconv.Atoi(m[3])
+	if from > 0 && to == 0 {
+		to = from
+	}
+	return path, from, to
+}
+
+// The absolute path of a file git tracks, or the error to return
+func historyFile(path string) (string, string) {
+	if !haveBinary("git") {
+		return "", toolUnavailable("git", false)
+	}
+	full := resolvePath(path)
+	if _, err := gitIn(filepath.Dir(full), "ls-files", "--error-unmatch", "--", full); err != nil {
+		return "", fmt.Sprintf("Error: %s is not tracked by git", path)
+	}
+	return full, ""
+}
+
+// blame:path:line, blame:path:from-to or blame:path
+func cmdBlame(arg string) string {
+	path, from, to := historySpec(arg)
+	if path == "" {
+		return "Usage: blame:path:line"
+	}
+	full, errMsg := historyFile(path)
+	if errMsg != "" {
+		return errMsg
+	}
+	args := []string{"blame", "--porcelain"}
+	switch {
+	case from > 0 && from == to:
+		args = append(args, "-L", fmt.Sprintf("%d,%d", max(from-blameContext, 1), to+blameContext))
+	case from > 0:
+		args = append(args, "-L", fmt.Sprintf("%d,%d", from, to))
+	default:
+		args = append(args, "-L", fmt.Sprintf("1,%d", blameMaxLines))
+	}
+	out, err := gitIn(filepath.Dir(full), append(args, "--", full)...)
+	if err != nil && from == 0 && strings.Contains(err.Error(), "has only") {
+		out, err = gitIn(filepath.Dir(full), "blame", "--porcelain", "--", full)
+	}
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+
+	type commit struct{ author, date string }
+	commits := map[string]*commit{}
+	var order []string
+	var b strings.Builder
+	var sha string
+	line := 0
+	for _, l := range strings.Split(out, "\n") {
+		if m := blameHeaderRe.FindStringSubmatch(l); m != nil {
+			sha = m[1]
+			line, _ = strconv.Atoi(m[2])
+			if commits[sha] == nil {
+				commits[sha] = &commit{}
+				order = append(order, sha)
+			}
+			continue
+		}
+		c := commits[sha]
+		switch {
+		case c == nil:
+		case strings.HasPrefix(l, "author "):
+			c.author = strings.TrimPrefix(l, "author ")
+		case strings.HasPrefix(l, "author-time "):
+			if t, err := strconv.ParseInt(strings.TrimPrefix(l, "author-time "), 10, 64); err == nil {
+				c.date = time.Unix(t, 0).Format("2006-01-02")
+			}
+		case strings.HasPrefix(l, "\t"):
+			mark := " "
+			if line >= from && line <= to && from == to {
+				mark = ">"
+			}
+			who := fmt.Sprintf("%s %s %s", sha[:8], c.date, truncate(c.author, 16))
+			if strings.Trim(sha, "0") == "" {
+				who = "(not committed yet)"
+			}
+			b.WriteString(fmt.Sprintf("%s%5d %-37s │ %s\n", mark, line, who, l[1:]))
+		}
+	}
+
+	b.WriteString("\n")
+	quoted := 0
+	for _, sha := range order {
+		if strings.Trim(sha, "0") == "" {
+			continue
+		}
+		if quoted == blameMaxCommits {
+			b.WriteString(fmt.Sprintf("(%d more commits; log:%s for the rest)\n", len(order)-quoted, path))
+			break
+		}
+		quoted++
+		message, _ := gitIn(filepath.Dir(full), "log", "-1", "--format=%B", sha)
+		c := commits[sha]
+		b.WriteString(fmt.Sprintf("commit %s, %s, %s\n%s\n\n", sha[:8], c.author, c.date, indentLines(truncate(strings.TrimSpace(message), 1500))))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// log:path, or log:path:line / log:path:from-to for the commits that
+// touched those lines
+func cmdLog(arg string) string {
+	path, from, to := historySpec(arg)
+	if path == "" {
+		return "Usage: log:path"
+	}
+	full, errMsg := historyFile(path)
+	if errMsg != "" {
+		return errMsg
+	}
+	args := []string{"log", "-n", strconv.Itoa(logCount), "--date=short", "--format=%x00%h %ad %an%n%B"}
+	if from > 0 {
+		args = append(args, "-s", "-L", fmt.Sprintf("%d,%d:%s", from, to, filepath.Base(full)))
+	} else {
+		args = append(args, "--follow", "--shortstat", "--", full)
+	}
+	out, err := gitIn(filepath.Dir(full), args...)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if strings.TrimSpace(out) == "" {
+		return "No commits touch " + arg
+	}
+	var b strings.Builder
+	for _, entry := range strings.Split(out, "\x00") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		head, rest, _ := strings.Cut(entry, "\n")
+		rest = blankLinesRe.ReplaceAllString(rest, "\n\n")
+		b.WriteString(fmt.Sprintf("commit %s\n%s\n\n", head, indentLines(truncate(strings.TrimSpace(rest), 1500))))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func indentLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = "    " + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ==================== GITHUB ====================
+
+// The GitHub REST API. The token comes from GITHUB_TOKEN or GH_TOKEN,
+// then the keychain (/pr login <token>), then the GitHub CLI's own login.
+// Enterprise servers are reached at https://<host>/api/v3, or wherever
+// GITHUB_API_URL points.
+
+func githubToken() (string, error) {
+	for _, name := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token, nil
+		}
+	}
+	if token, err := keychainGet(forgeKeychain(forgeGitHub)); err == nil {
+		return token, nil
+	}
+	if haveBinary("gh") {
+		if out, err := exec.Command("gh", "auth", "token").Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+			return string(bytes.TrimSpace(out)), nil
+		}
+	}
+	return "", fmt.Errorf("no GitHub token: set GITHUB_TOKEN, run gh auth login, or /pr login <token> to keep one in the keychain")
+}
+
+func githubAPIBase(host string) string {
+	if base := os.Getenv("GITHUB_API_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	if host == "" || host == "github.com" {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+func githubAPI(r forgeRepo, method, path string, in, out any) error {
+	token, err := githubToken()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if err := forgeHTTP(method, githubAPIBase(r.Host)+path, header, in, out); err != nil {
+		return fmt.Errorf("GitHub %s", err)
+	}
+	return nil
+}
+
+func githubDefaultBranch(r forgeRepo) (string, error) {
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := githubAPI(r, "GET", "/repos/"+r.String(), nil, &info)
+	return info.DefaultBranch, err
+}
+
+type githubPull struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+func (p githubPull) request() *forgeRequest {
+	return &forgeRequest{Number: p.Number, URL: p.HTMLURL, SHA: p.Head.SHA}
+}
+
+func githubOpenRequest(r forgeRepo, branch string) (*forgeRequest, error) {
+	var open []githubPull
+	query := url.Values{"head": {r.Owner + ":" + branch}, "state": {"open"}}
+	if err := githubAPI(r, "GET", "/repos/"+r.String()+"/pulls?"+query.Encode(), nil, &open); err != nil || len(open) == 0 {
+		return nil, err
+	}
+	return open[0].request(), nil
+}
+
+func githubCreateRequest(r forgeRepo, branch, base, title, body string, draft bool) (*forgeRequest, error) {
+	var pr githubPull
+	req := map[string]any{"title": title, "body": body, "head": branch, "base": base, "draft": draft}
+	if err := githubAPI(r, "POST", "/repos/"+r.String()+"/pulls", req, &pr); err != nil {
+		return nil, err
+	}
+	return pr.request(), nil
+}
+
+func githubIssue(r forgeRepo, number string) (*forgeIssue, error) {
+	type user struct {
+		Login string `json:"login"`
+	}
+	var issue struct {
+		Title       string    `json:"title"`
+		State       string    `json:"state"`
+		Body        string    `json:"body"`
+		HTMLURL     string    `json:"html_url"`
+		User        user      `json:"user"`
+		Comments    int       `json:"comments"`
+		PullRequest *struct{} `json:"pull_request"`
+		Labels      []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	}
+	path := "/repos/" + r.String() + "/issues/" + number
+	if err := githubAPI(r, "GET", path, nil, &issue); err != nil {
+		return nil, err
+	}
+	result := &forgeIssue{Kind: "issue", Title: issue.Title, State: issue.State, Body: issue.Body,
+		Author: issue.User.Login, URL: issue.HTMLURL, Total: issue.Comments}
+	if issue.PullRequest != nil {
+		result.Kind = "pull request"
+	}
+	for _, l := range issue.Labels {
+		result.Labels = append(result.Labels, l.Name)
+	}
+
+	// The latest comments: the last page, and the one before when it's short
+	type comment struct {
+		Body      string `json:"body"`
+		User      user   `json:"user"`
+		CreatedAt string `json:"created_at"`
+	}
+	var comments []comment
+	for page := (issue.Comments + forgeMentionComments - 1) / forgeMentionComments; page > 0 && len(comments) < forgeMentionComments; page-- {
+		var batch []comment
+		if err := githubAPI(r, "GET", fmt.Sprintf("%s/comments?per_page=%d&page=%d", path, forgeMentionComments, page), nil, &batch); err != nil {
+			return nil, err
+		}
+		comments = append(batch, comments...)
+	}
+	if len(comments) > forgeMentionComments {
+		comments = comments[len(comments)-forgeMentionComments:]
+	}
+	for _, c := range comments {
+		result.Comments = append(result.Comments, forgeComment{Author: c.User.Login, Date: c.CreatedAt, Body: c.Body})
+	}
+	return result, nil
+}
+
+// Check runs (Actions and apps) and commit statuses (older integrations)
+func githubChecks(r forgeRepo, sha string) ([]ciCheck, error) {
+	var runs struct {
+		CheckRuns []struct {
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+			HTMLURL    string `json:"html_url"`
+		} `json:"check_runs"`
+	}
+	if err := githubAPI(r, "GET", "/repos/"+r.String()+"/commits/"+sha+"/check-runs?per_page=100", nil, &runs); err != nil {
+		return nil, err
+	}
+	var checks []ciCheck
+	for _, c := range runs.CheckRuns {
+		state := "pending"
+		if c.Status == "completed" {
+			switch c.Conclusion {
+			case "success":
+				state = "success"
+			case "neutral", "skipped":
+				state = "skipped"
+			default:
+				state = "failure"
+			}
+		}
+		checks = append(checks, ciCheck{Name: c.Name, State: state, URL: c.HTMLURL})
+	}
+	var status struct {
+		Statuses []struct {
+			Context   string `json:"context"`
+			State     string `json:"state"`
+			TargetURL string `json:"target_url"`
+		} `json:"statuses"`
+	}
+	if err := githubAPI(r, "GET", "/repos/"+r.String()+"/commits/"+sha+"/status", nil, &status); err != nil {
+		return nil, err
+	}
+	for _, s := range status.Statuses {
+		state := s.State
+		if state == "error" {
+			state = "failure"
+		}
+		checks = append(checks, ciCheck{Name: s.Context, State: state, URL: s.TargetURL})
+	}
+	return checks, nil
+}
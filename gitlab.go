@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ==================== GITLAB ====================
+
+// The GitLab REST API (v4), on gitlab.com or a self-hosted server at the
+// remote's host. The token comes from GITLAB_TOKEN, then the keychain
+// (/pr login <token>), then the GitLab CLI's login for that host.
+
+func gitlabToken(host string) (string, error) {
+	if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+		return token, nil
+	}
+	if token, err := keychainGet(forgeKeychain(forgeGitLab)); err == nil {
+		return token, nil
+	}
+	if haveBinary("glab") {
+		if out, err := exec.Command("glab", "config", "get", "token", "--host", host).Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+			return string(bytes.TrimSpace(out)), nil
+		}
+	}
+	return "", fmt.Errorf("no GitLab token: set GITLAB_TOKEN, run glab auth login, or /pr login <token> to keep one in the keychain")
+}
+
+func gitlabAPI(r forgeRepo, method, path string, in, out any) error {
+	token, err := gitlabToken(r.Host)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("PRIVATE-TOKEN", token)
+	base := "https://" + r.Host + "/api/v4/projects/" + url.PathEscape(r.String())
+	if err := forgeHTTP(method, base+path, header, in, out); err != nil {
+		return fmt.Errorf("GitLab %s", err)
+	}
+	return nil
+}
+
+func gitlabDefaultBranch(r forgeRepo) (string, error) {
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := gitlabAPI(r, "GET", "", nil, &info)
+	return info.DefaultBranch, err
+}
+
+type gitlabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+	SHA    string `json:"sha"`
+}
+
+func (m gitlabMergeRequest) request() *forgeRequest {
+	return &forgeRequest{Number: m.IID, URL: m.WebURL, SHA: m.SHA}
+}
+
+func gitlabOpenRequest(r forgeRepo, branch string) (*forgeRequest, error) {
+	var open []gitlabMergeRequest
+	query := url.Values{"source_branch": {branch}, "state": {"opened"}}
+	if err := gitlabAPI(r, "GET", "/merge_requests?"+query.Encode(), nil, &open); err != nil || len(open) == 0 {
+		return nil, err
+	}
+	return open[0].request(), nil
+}
+
+func gitlabCreateRequest(r forgeRepo, branch, base, title, body string, draft bool) (*forgeRequest, error) {
+	if draft {
+		title = "Draft: " + title
+	}
+	var mr gitlabMergeRequest
+	req := map[string]any{"source_branch": branch, "target_branch": base, "title": title, "description": body}
+	if err := gitlabAPI(r, "POST", "/merge_requests", req, &mr); err != nil {
+		return nil, err
+	}
+	return mr.request(), nil
+}
+
+func gitlabIssue(r forgeRepo, number string, pull bool) (*forgeIssue, error) {
+	path, kind := "/issues/"+number, "issue"
+	if pull {
+		path, kind = "/merge_requests/"+number, "merge request"
+	}
+	var issue struct {
+		Title       string   `json:"title"`
+		State       string   `json:"state"`
+		Description string   `json:"description"`
+		WebURL      string   `json:"web_url"`
+		Labels      []string `json:"labels"`
+		Notes       int      `json:"user_notes_count"`
+		Author      struct {
+			Username string `json:"username"`
+		} `json:"author"`
+	}
+	if err := gitlabAPI(r, "GET", path, nil, &issue); err != nil {
+		return nil, err
+	}
+	result := &forgeIssue{Kind: kind, Title: issue.Title, State: issue.State, Body: issue.Description,
+		Author: issue.Author.Username, URL: issue.WebURL, Labels: issue.Labels, Total: issue.Notes}
+
+	// Newest first, without the system notes (label changes, pushes)
+	var notes []struct {
+		Body      string `json:"body"`
+		System    bool   `json:"system"`
+		CreatedAt string `json:"created_at"`
+		Author    struct {
+			Username string `json:"username"`
+		} `json:"author"`
+	}
+	if err := gitlabAPI(r, "GET", path+"/notes?sort=desc&order_by=created_at&per_page=50", nil, &notes); err != nil {
+		return nil, err
+	}
+	for _, n := range notes {
+		if n.System {
+			continue
+		}
+		result.Comments = append([]forgeComment{{Author: n.Author.Username, Date: n.CreatedAt, Body: n.Body}}, result.Comments...)
+		if len(result.Comments) == forgeMentionComments {
+			break
+		}
+	}
+	return result, nil
+}
+
+// The jobs of the latest pipeline for sha
+func gitlabChecks(r forgeRepo, sha string) ([]ciCheck, error) {
+	var pipelines []struct {
+		ID int `json:"id"`
+	}
+	if err := gitlabAPI(r, "GET", "/pipelines?per_page=1&sha="+url.QueryEscape(sha), nil, &pipelines); err != nil || len(pipelines) == 0 {
+		return nil, err
+	}
+	var jobs []struct {
+		Name         string `json:"name"`
+		Status       string `json:"status"`
+		WebURL       string `json:"web_url"`
+		AllowFailure bool   `json:"allow_failure"`
+	}
+	if err := gitlabAPI(r, "GET", fmt.Sprintf("/pipelines/%d/jobs?per_page=100", pipelines[0].ID), nil, &jobs); err != nil {
+		return nil, err
+	}
+	var checks []ciCheck
+	for _, j := range jobs {
+		state := "pending"
+		switch j.Status {
+		case "success":
+			state = "success"
+		case "failed", "canceled":
+			state = "failure"
+			if j.AllowFailure {
+				state = "skipped"
+			}
+		case "skipped", "manual":
+			state = "skipped"
+		}
+		checks = append(checks, ciCheck{Name: strings.TrimSpace(j.Name), State: state, URL: j.WebURL})
+	}
+	return checks, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ==================== BINARY / OVERSIZED GUARD ====================
+
+// read and @mentions used to paste images, databases and minified bundles
+// into the prompt as garbage. Such files are now described instead: type,
+// size and the first printable strings. read:path --force and @path!
+// include them anyway.
+
+const (
+	guardMaxBytes   = 2 << 20 // whole-file attachments above this are summarized
+	guardMaxLineLen = 2000    // a longer line means minified or generated text
+	guardHead       = 8000    // bytes sniffed for binary content
+)
+
+// Why data should not go into the prompt as text, or "". maxBytes 0 skips
+// the size check.
+func unreadableReason(data []byte, maxBytes int) string {
+	head := data[:min(len(data), guardHead)]
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "binary"
+	}
+	invalid := 0
+	for i := 0; i < len(head); {
+		r, size := utf8.DecodeRune(head[i:])
+		if r == utf8.RuneError && size == 1 && i < len(head)-utf8.UTFMax {
+			invalid++
+		}
+		i += size
+	}
+	if invalid > len(head)/10 {
+		return "binary"
+	}
+	if maxBytes > 0 && len(data) > maxBytes {
+		return "too large"
+	}
+	longest, start := 0, 0
+	for {
+		i := bytes.IndexByte(data[start:], '\n')
+		if i < 0 {
+			longest = max(longest, len(data)-start)
+			break
+		}
+		longest = max(longest, i)
+		start += i + 1
+	}
+	if longest > guardMaxLineLen {
+		return fmt.Sprintf("minified, a line of %d chars", longest)
+	}
+	return ""
+}
+
+func fileType(path string, data []byte) string {
+	head := data[:min(len(data), 512)]
+	if bytes.HasPrefix(head, []byte("SQLite format 3\x00")) {
+		return "SQLite database"
+	}
+	kind := http.DetectContentType(head)
+	if strings.HasPrefix(kind, "application/octet-stream") || strings.HasPrefix(kind, "text/plain") {
+		if byExt := mime.TypeByExtension(filepath.Ext(path)); byExt != "" {
+			kind = byExt
+		}
+	}
+	return strings.TrimSuffix(kind, "; charset=utf-8")
+}
+
+// Runs of at least six printable ASCII characters, like strings(1)
+func printableStrings(data []byte, limit int) []string {
+	var out []string
+	start := -1
+	for i := 0; i <= len(data) && len(out) < limit; i++ {
+		if i < len(data) && data[i] >= 0x20 && data[i] < 0x7f {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= 6 {
+			out = append(out, truncate(string(data[start:i]), 60))
+		}
+		start = -1
+	}
+	return out
+}
+
+// Stand-in text for a file left out for reason
+func fileSummary(path string, data []byte, reason string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%s: %s, %s; not shown (%s)\n", path, fileType(path, data), formatSize(int64(len(data))), reason))
+	if reason == "binary" {
+		if s := printableStrings(data[:min(len(data), 64<<10)], 12); len(s) > 0 {
+			b.WriteString("strings: " + strings.Join(s, " | ") + "\n")
+		}
+	} else {
+		b.WriteString("starts with: " + truncate(string(data[:min(len(data), 400)]), 300) + "\n")
+	}
+	b.WriteString(fmt.Sprintf("(read:%s --force or @%s! includes it anyway)", relPath(path), relPath(path)))
+	return b.String()
+}
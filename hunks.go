@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ==================== HUNK REVIEW ====================
+
+// In ask mode a change to an existing file is reviewed hunk by hunk, like
+// git add -p: each hunk can be applied, skipped or edited before anything
+// is written. The model is told which hunks the user left out.
+
+const hunkHelp = `y - apply this hunk
+n - skip this hunk
+a - apply this and all remaining hunks
+d - skip this and all remaining hunks
+e - edit the new version of this hunk
+q - cancel, write nothing
+? - this help`
+
+const (
+	hunkApply = iota
+	hunkSkip
+	hunkEdit
+)
+
+// Walks the user through the hunks of before → after. Returns the content
+// to write, a note on what was left out ("" if everything was applied)
+// and false when nothing is to be written.
+func reviewHunks(path, before, after string) (string, string, bool) {
+	ops := lineDiff(splitLines(before), splitLines(after))
+	bounds := hunkBounds(ops, diffContext)
+	if len(bounds) == 0 {
+		return after, "", true
+	}
+	fmt.Printf("%s%s%s: %d hunk(s)\n", colorBold, path, colorReset, len(bounds))
+
+	decisions := make([]int, len(bounds))
+	edited := map[int][]string{}
+	rest := -1 // decision for the remaining hunks, once a or d is given
+	for i := 0; i < len(bounds); i++ {
+		if rest >= 0 {
+			decisions[i] = rest
+			continue
+		}
+		h := ops[bounds[i][0]:bounds[i][1]]
+		fmt.Println(colorCyan + hunkHeader(h) + colorReset)
+		for _, blk := range changeBlocks(h) {
+			for _, row := range unifiedRows(blk[0], blk[1]) {
+				fmt.Println(row)
+			}
+		}
+		for {
+			answer := strings.ToLower(readAnswer(fmt.Sprintf("%s(%d/%d) Apply this hunk [y,n,a,d,e,q,?]? %s", colorYellow, i+1, len(bounds), colorReset)))
+			switch answer {
+			case "y":
+				decisions[i] = hunkApply
+			case "n":
+				decisions[i] = hunkSkip
+			case "a", "d":
+				rest = map[string]int{"a": hunkApply, "d": hunkSkip}[answer]
+				decisions[i] = rest
+			case "e":
+				lines, ok := editHunk(h)
+				if !ok {
+					continue
+				}
+				decisions[i], edited[i] = hunkEdit, lines
+			case "q", "":
+				return before, "", false
+			default:
+				fmt.Println(colorGray + hunkHelp + colorReset)
+				continue
+			}
+			break
+		}
+	}
+
+	// Rebuild the file: each hunk contributes its new side, its old side or
+	// the edited lines; everything between hunks is unchanged context
+	var out []string
+	applied, skipped, changed := 0, 0, 0
+	next := 0
+	for i, b := range bounds {
+		for _, op := range ops[next:b[0]] {
+			out = append(out, op.Text)
+		}
+		next = b[1]
+		switch decisions[i] {
+		case hunkEdit:
+			out = append(out, edited[i]...)
+			changed++
+		case hunkApply:
+			applied++
+		case hunkSkip:
+			skipped++
+		}
+		if decisions[i] == hunkEdit {
+			continue
+		}
+		for _, op := range ops[b[0]:b[1]] {
+			if op.Kind == ' ' || op.Kind == '+' && decisions[i] == hunkApply || op.Kind == '-' && decisions[i] == hunkSkip {
+				out = append(out, op.Text)
+			}
+		}
+	}
+	for _, op := range ops[next:] {
+		out = append(out, op.Text)
+	}
+	if applied == 0 && changed == 0 {
+		return before, "", false
+	}
+	if skipped == 0 && changed == 0 {
+		return after, "", true
+	}
+
+	content := strings.Join(out, "\n")
+	if len(out) > 0 && (strings.HasSuffix(after, "\n") || after == "" && strings.HasSuffix(before, "\n")) {
+		content += "\n"
+	}
+	var notes []string
+	if skipped > 0 {
+		notes = append(notes, fmt.Sprintf("the user skipped %d of %d hunks", skipped, len(bounds)))
+	}
+	if changed > 0 {
+		notes = append(notes, fmt.Sprintf("the user edited %d hunk(s)", changed))
+	}
+	return content, strings.Join(notes, ", ") + "; read the file before changing it again", true
+}
+
+// New version of a hunk (context and added lines), as changed by the user
+// in $VISUAL/$EDITOR or typed in when neither is set
+func editHunk(h []diffOp) ([]string, bool) {
+	var lines []string
+	for _, op := range h {
+		if op.Kind != '-' {
+			lines = append(lines, op.Text)
+		}
+	}
+	if userEditor() != "" {
+		text, ok := editInEditor(strings.Join(lines, "\n")+"\n", "mytool-hunk-*.txt")
+		if !ok {
+			return nil, false
+		}
+		return splitLines(text), true
+	}
+
+	fmt.Printf("%sNew version of the hunk:%s\n", colorGray, colorReset)
+	for _, l := range lines {
+		fmt.Printf("%s │%s %s\n", colorGray, colorReset, l)
+	}
+	fmt.Printf("%sType the lines to put there instead, context included (/save or /cancel; set $EDITOR to use an editor):%s\n", colorYellow, colorReset)
+	return typeLines()
+}
+
+func userEditor() string {
+	if editor := os.Getenv("VISUAL"); editor != "" {
+		return editor
+	}
+	return os.Getenv("EDITOR")
+}
+
+// text after the user edited it in userEditor(); pattern names the temp file
+func editInEditor(text, pattern string) (string, bool) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", false
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(text)
+	f.Close()
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/c"
+	}
+	cmd := exec.Command(shell, flag, userEditor()+" "+f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("%sEditor failed: %s%s\n", colorRed, err, colorReset)
+		return "", false
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", false
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), true
+}
+
+// Lines typed until /save; false on /cancel or end of input
+func typeLines() ([]string, bool) {
+	var typed []string
+	for {
+		line, ok := readInputLine(colorGray + " │ " + colorReset)
+		switch {
+		case !ok || line == "/cancel":
+			return nil, false
+		case line == "/save":
+			return typed, true
+		}
+		typed = append(typed, line)
+	}
+}
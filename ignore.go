@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ==================== .mytoolignore ====================
+
+// A .mytoolignore in the project root (gitignore syntax: #comments, !negation,
+// trailing / for directories, leading or inner / to anchor, *, ?, [..], **)
+// keeps paths out of tree, find, grep, @mentions and the repo map, so build
+// output, fixtures and secrets never end up in the context. As in git, a
+// file below an ignored directory can't be re-included.
+
+const ignoreFileName = ".mytoolignore"
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	byName  bool // no slash: matches the name at any depth
+}
+
+var ignoreCache struct {
+	root  string
+	mod   time.Time
+	rules []ignoreRule
+}
+
+func ignoreRoot() string {
+	if root := findProjectRoot(); root != "" {
+		return root
+	}
+	return currentDir
+}
+
+func parseIgnore(text string) []ignoreRule {
+	var rules []ignoreRule
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // \# and \! escape a literal first character
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		r.byName = !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		re, err := regexp.Compile(ignoreRegexp(line))
+		if err != nil {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// globRegexp plus [...] character classes, which gitignore allows
+func ignoreRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for pattern != "" {
+		open := strings.Index(pattern, "[")
+		end := -1
+		if open >= 0 {
+			end = strings.Index(pattern[open+1:], "]")
+		}
+		if open < 0 || end < 0 {
+			b.WriteString(strings.TrimSuffix(strings.TrimPrefix(globRegexp(pattern), "^"), "$"))
+			break
+		}
+		b.WriteString(strings.TrimSuffix(strings.TrimPrefix(globRegexp(pattern[:open]), "^"), "$"))
+		class := pattern[open+1 : open+1+end]
+		if strings.HasPrefix(class, "!") {
+			class = "^" + class[1:]
+		}
+		b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+		pattern = pattern[open+end+2:]
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Rules for the current project, reloaded when the file changes
+func ignoreRules() (string, []ignoreRule) {
+	root := ignoreRoot()
+	info, err := os.Stat(filepath.Join(root, ignoreFileName))
+	if err != nil {
+		return root, nil
+	}
+	if ignoreCache.root != root || !ignoreCache.mod.Equal(info.ModTime()) {
+		data, _ := os.ReadFile(filepath.Join(root, ignoreFileName))
+		ignoreCache.root, ignoreCache.mod, ignoreCache.rules = root, info.ModTime(), parseIgnore(string(data))
+	}
+	return root, ignoreCache.rules
+}
+
+func matchIgnore(rules []ignoreRule, rel string, isDir bool) bool {
+	ignored := false
+	name := rel[strings.LastIndex(rel, "/")+1:]
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		subject := rel
+		if r.byName {
+			subject = name
+		}
+		if r.re.MatchString(subject) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// Whether path (absolute or relative to currentDir) is excluded by
+// .mytoolignore, directly or through one of its parent directories
+func isIgnored(path string, isDir bool) bool {
+	root, rules := ignoreRules()
+	if len(rules) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolvePath(path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		last := i == len(parts)-1
+		if matchIgnore(rules, strings.Join(parts[:i+1], "/"), !last || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Drops ignored entries from find/grep output lines; pathOf extracts the
+// path from a line
+func filterIgnored(lines []string, pathOf func(string) string) []string {
+	if _, rules := ignoreRules(); len(rules) == 0 {
+		return lines
+	}
+	kept := lines[:0]
+	for _, line := range lines {
+		path := pathOf(line)
+		info, err := os.Stat(path)
+		if !isIgnored(path, err == nil && info.IsDir()) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== CODE INDEX ====================
+
+// `mytool index` embeds every project file, chunked at its definitions
+// (splitChunks), into .mytool/index.json in the project root. Runs are
+// incremental: only files whose content changed are embedded again.
+// While chatting, each question is embedded too and the closest chunks
+// are read from disk and attached to it, up to settings.IndexSnippets.
+
+const (
+	indexBatch       = 32   // texts per embeddings request
+	indexChunkChars  = 2000 // of each chunk sent for embedding
+	indexMaxFileSize = 512 * 1024
+	indexTokens      = 3000 // all attached snippets together
+	indexSnippetMax  = 60   // lines per snippet
+)
+
+type codeIndex struct {
+	Version int                    `json:"version"`
+	Model   string                 `json:"model"`
+	Updated time.Time              `json:"updated"`
+	Files   map[string]indexedFile `json:"files"` // by slash-separated path relative to the root
+}
+
+type indexedFile struct {
+	Hash   string       `json:"hash"`
+	Chunks []indexChunk `json:"chunks"`
+}
+
+type indexChunk struct {
+	Start  int       `json:"start"`
+	End    int       `json:"end"`
+	Name   string    `json:"name,omitempty"`
+	Vector []float32 `json:"vector"`
+}
+
+var indexCache struct {
+	path string
+	mod  time.Time
+	idx  *codeIndex
+}
+
+func indexPath(root string) string {
+	return filepath.Join(root, ".mytool", "index.json")
+}
+
+func loadCodeIndex(root string) *codeIndex {
+	path := indexPath(root)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if indexCache.path == path && indexCache.mod.Equal(info.ModTime()) {
+		return indexCache.idx
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var idx codeIndex
+	if json.Unmarshal(data, &idx) != nil || idx.Model != embeddingsModel {
+		return nil
+	}
+	indexCache.path, indexCache.mod, indexCache.idx = path, info.ModTime(), &idx
+	return &idx
+}
+
+func saveCodeIndex(root string, idx *codeIndex) error {
+	idx.Version, idx.Model, idx.Updated = 1, embeddingsModel, time.Now()
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Join(root, ".mytool"), 0755)
+	ignoreProjectCaches(root)
+	return updateFile(indexPath(root), 0644, func([]byte) []byte { return data })
+}
+
+// Text content of an indexable file, or false for binaries and big files
+func indexableText(path string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > indexMaxFileSize || info.Size() == 0 {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return "", false
+	}
+	return string(data), true
+}
+
+func contentHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:12])
+}
+
+func runIndexCmd(args []string) {
+	root := repoMapRoot()
+	if root == "" {
+		fmt.Println("Not in a project (no .git or project file here)")
+		return
+	}
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch sub {
+	case "", "--rebuild":
+		fmt.Println(buildCodeIndex(root, sub == "--rebuild"))
+	case "status":
+		fmt.Println(indexStatus(root))
+	case "clear":
+		if err := os.Remove(indexPath(root)); err != nil {
+			fmt.Println("No index here")
+			return
+		}
+		fmt.Println("Index removed")
+	default:
+		fmt.Println("Usage: mytool index [--rebuild|status|clear]")
+	}
+}
+
+func buildCodeIndex(root string, rebuild bool) string {
+	apiKey := getAPIKey()
+	if apiKey == "" {
+		return "No API key"
+	}
+	idx := loadCodeIndex(root)
+	if idx == nil || rebuild {
+		idx = &codeIndex{}
+	}
+	old := idx.Files
+	idx.Files = map[string]indexedFile{}
+
+	updated := 0
+	var chunkFiles, chunkTexts []string
+	var chunkIdx []int // position within its file's chunks
+	files, _ := repoFiles(root)
+	for _, rel := range files {
+		text, ok := indexableText(filepath.Join(root, rel))
+		if !ok {
+			continue
+		}
+		hash := contentHash(text)
+		if f, ok := old[rel]; ok && f.Hash == hash {
+			idx.Files[rel] = f
+			continue
+		}
+		lines := strings.Split(text, "\n")
+		var chunks []indexChunk
+		for _, c := range splitChunks(rel, lines) {
+			body := strings.TrimSpace(chunkText(lines, c))
+			if body == "" {
+				continue
+			}
+			chunkIdx = append(chunkIdx, len(chunks))
+			chunkFiles = append(chunkFiles, rel)
+			chunkTexts = append(chunkTexts, truncate(rel+" "+c.Name+"\n"+body, indexChunkChars))
+			chunks = append(chunks, indexChunk{Start: c.Start, End: c.End, Name: c.Name})
+		}
+		idx.Files[rel] = indexedFile{Hash: hash, Chunks: chunks}
+		updated++
+	}
+
+	for start := 0; start < len(chunkTexts); start += indexBatch {
+		end := min(start+indexBatch, len(chunkTexts))
+		fmt.Printf("\r%sEmbedding %d/%d chunks...%s", colorGray, end, len(chunkTexts), colorReset)
+		vectors, err := embedTexts(apiKey, chunkTexts[start:end], "db")
+		if err != nil {
+			fmt.Println()
+			// Keep what is done; the files left out are picked up next run
+			for _, rel := range chunkFiles[start:] {
+				delete(idx.Files, rel)
+			}
+			saveCodeIndex(root, idx)
+			return fmt.Sprintf("%sIndexing stopped: %s (run again to continue)%s", colorRed, err, colorReset)
+		}
+		for i, v := range vectors {
+			idx.Files[chunkFiles[start+i]].Chunks[chunkIdx[start+i]].Vector = toFloat32(v)
+		}
+	}
+	if len(chunkTexts) > 0 {
+		fmt.Println()
+	}
+	if err := saveCodeIndex(root, idx); err != nil {
+		return fmt.Sprintf("%sError: %s%s", colorRed, err, colorReset)
+	}
+	return fmt.Sprintf("%s✓ Indexed %d files (%d updated, %d chunks embedded) → %s%s",
+		colorGreen, len(idx.Files), updated, len(chunkTexts), relPath(indexPath(root)), colorReset)
+}
+
+func toFloat32(v []float64) []float32 {
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(x)
+	}
+	return out
+}
+
+func cosine32(q []float64, v []float32) float64 {
+	if len(q) != len(v) || len(q) == 0 {
+		return 0
+	}
+	var dot, nq, nv float64
+	for i := range q {
+		x := float64(v[i])
+		dot += q[i] * x
+		nq += q[i] * q[i]
+		nv += x * x
+	}
+	if nq == 0 || nv == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(nq) * math.Sqrt(nv))
+}
+
+func indexStatus(root string) string {
+	idx := loadCodeIndex(root)
+	if idx == nil {
+		return "No index for this project (run: mytool index)"
+	}
+	chunks, stale := 0, 0
+	for rel, f := range idx.Files {
+		chunks += len(f.Chunks)
+		if text, ok := indexableText(filepath.Join(root, rel)); !ok || contentHash(text) != f.Hash {
+			stale++
+		}
+	}
+	return fmt.Sprintf("Index: %d files, %d chunks, updated %s; %d files changed since (mytool index refreshes them)",
+		len(idx.Files), chunks, idx.Updated.Format("2006-01-02 15:04"), stale)
+}
+
+type indexHit struct {
+	Rel   string
+	Chunk indexChunk
+	Score float64
+}
+
+// Snippets from the index closest to the question, as an attachment and
+// the line shown to the user. Files already attached to message are skipped.
+func indexSnippets(question, message string) (string, string) {
+	root := repoMapRoot()
+	if settings.IndexSnippets <= 0 || root == "" || len(strings.Fields(question)) < 3 {
+		return "", ""
+	}
+	idx := loadCodeIndex(root)
+	apiKey := getAPIKey()
+	if idx == nil || apiKey == "" {
+		return "", ""
+	}
+	q, err := embedTexts(apiKey, []string{question}, "query")
+	if err != nil {
+		return "", ""
+	}
+
+	var hits []indexHit
+	for rel, f := range idx.Files {
+		if strings.Contains(message, "=== "+filepath.Join(root, rel)) {
+			continue
+		}
+		for _, c := range f.Chunks {
+			hits = append(hits, indexHit{rel, c, cosine32(q[0], c.Vector)})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+
+	var parts, names []string
+	used := 0
+	for _, h := range hits {
+		if len(parts) >= settings.IndexSnippets {
+			break
+		}
+		data, err := os.ReadFile(filepath.Join(root, h.Rel))
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(data), "\n")
+		start, end := h.Chunk.Start, min(h.Chunk.End, h.Chunk.Start+indexSnippetMax-1, len(lines))
+		if start > end {
+			continue // the file shrank since it was indexed
+		}
+		text := strings.Join(lines[start-1:end], "\n")
+		if used+estimateTokens(text) > indexTokens {
+			continue
+		}
+		used += estimateTokens(text)
+		parts = append(parts, fmt.Sprintf("=== %s L%d-%d (index match %.2f) ===\n%s", filepath.Join(root, h.Rel), start, end, h.Score, text))
+		names = append(names, fmt.Sprintf("%s:%d", h.Rel, start))
+	}
+	if len(parts) == 0 {
+		return "", ""
+	}
+	display := fmt.Sprintf("%s  🔎 index: %s%s", colorGray, strings.Join(names, ", "), colorReset)
+	return strings.Join(parts, "\n\n"), display
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== BACKGROUND JOBS ====================
+
+// run:cmd & (with Allow background on) starts cmd as a job instead of
+// waiting for it: dev servers, watchers, long builds. The last
+// jobLogMax bytes of its output are kept; /jobs lists, tails and stops
+// jobs and the model reads them with job_output:<id>. Jobs that end are
+// reported with the next message, and all are stopped when mytool exits.
+
+const (
+	jobLogMax   = 256 << 10
+	jobSettle   = 2 * time.Second // output shown when a job starts
+	jobTailRows = 100             // lines job_output returns
+)
+
+type job struct {
+	ID      int
+	Command string
+	Started time.Time
+	Ended   time.Time
+	Err     error // exit error once ended
+	cmd     *exec.Cmd
+	stop    func() // extra cleanup, e.g. the sandbox's container
+	log     tailBuffer
+	done    chan struct{}
+	noticed bool // its end was reported
+	stopped bool // ended by /jobs stop
+}
+
+var (
+	jobsMu    sync.Mutex
+	jobs      []*job
+	nextJobID = 1
+)
+
+// Keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - jobLogMax; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+// Last n lines (all kept lines when n <= 0)
+func (t *tailBuffer) Tail(n int) string {
+	t.mu.Lock()
+	text := string(t.buf)
+	t.mu.Unlock()
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Strips a trailing lone & (not &&) off a command
+func backgroundCommand(command string) (string, bool) {
+	trimmed := strings.TrimSpace(command)
+	if strings.HasSuffix(trimmed, "&") && !strings.HasSuffix(trimmed, "&&") {
+		return strings.TrimSpace(strings.TrimSuffix(trimmed, "&")), true
+	}
+	return command, false
+}
+
+func startJob(command string) string {
+	if !settings.AllowBackground {
+		return "Error: background jobs are disabled in settings (Allow background); run it without &"
+	}
+	j := &job{Command: command, Started: time.Now(), done: make(chan struct{})}
+	if sandboxConfig().Enabled {
+		cmd, stop, err := sandboxCommand("", "sh", "-c", command)
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		j.cmd, j.stop = cmd, stop
+	} else {
+		j.cmd = exec.Command("sh", "-c", command)
+		j.cmd.Dir = currentDir
+		j.cmd.Env = childEnv()
+		applyLimits(j.cmd, false)
+	}
+	j.cmd.Stdout, j.cmd.Stderr = &j.log, &j.log
+	setProcessGroup(j.cmd) // Ctrl+C in mytool must not reach it
+	if err := j.cmd.Start(); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	jobsMu.Lock()
+	j.ID = nextJobID
+	nextJobID++
+	jobs = append(jobs, j)
+	jobsMu.Unlock()
+	go func() {
+		err := j.cmd.Wait()
+		jobsMu.Lock()
+		j.Err, j.Ended = err, time.Now()
+		jobsMu.Unlock()
+		close(j.done)
+	}()
+
+	// A server prints its address, a typo fails at once: wait a moment
+	select {
+	case <-j.done:
+	case <-time.After(jobSettle):
+	}
+	out := j.log.Tail(20)
+	if out != "" {
+		out = "\n" + out
+	}
+	if status := jobStatus(j); status != "running" {
+		jobsMu.Lock()
+		j.noticed = true
+		jobsMu.Unlock()
+		return fmt.Sprintf("%s⚠ Job %d already ended (%s): %s%s%s", colorYellow, j.ID, status, command, colorReset, out)
+	}
+	return fmt.Sprintf("%s✓ Started job %d (pid %d): %s%s\n%sjob_output:%d for its output, /jobs stop %d to stop it%s",
+		colorGreen, j.ID, j.cmd.Process.Pid, command, colorReset+out, colorGray, j.ID, j.ID, colorReset)
+}
+
+func jobStatus(j *job) string {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	switch {
+	case j.Ended.IsZero():
+		return "running"
+	case j.stopped:
+		return "stopped"
+	case j.Err != nil:
+		return j.Err.Error()
+	}
+	return "exited 0"
+}
+
+func findJob(arg string) *job {
+	id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(arg), "%"))
+	if err != nil {
+		return nil
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func stopJob(j *job) {
+	if jobStatus(j) != "running" {
+		return
+	}
+	jobsMu.Lock()
+	j.stopped = true
+	j.noticed = true
+	jobsMu.Unlock()
+	killProcessGroup(j.cmd)
+	if j.stop != nil {
+		j.stop()
+	}
+	select {
+	case <-j.done:
+	case <-time.After(3 * time.Second):
+	}
+}
+
+// Stops every running job; when mytool exits
+func stopAllJobs() {
+	jobsMu.Lock()
+	all := append([]*job{}, jobs...)
+	jobsMu.Unlock()
+	for _, j := range all {
+		stopJob(j)
+	}
+}
+
+// job_output:<id> tool
+func cmdJobOutput(arg string) string {
+	j := findJob(arg)
+	if j == nil {
+		return fmt.Sprintf("Error: no job %s; %s", strings.TrimSpace(arg), jobList())
+	}
+	return fmt.Sprintf("Job %d (%s, %s): %s\n%s", j.ID, jobStatus(j), jobAge(j), j.Command, j.log.Tail(jobTailRows))
+}
+
+func jobAge(j *job) string {
+	jobsMu.Lock()
+	end := j.Ended
+	jobsMu.Unlock()
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(j.Started).Round(time.Second).String()
+}
+
+func jobList() string {
+	jobsMu.Lock()
+	all := append([]*job{}, jobs...)
+	jobsMu.Unlock()
+	if len(all) == 0 {
+		return "no background jobs (start one with run:cmd &)"
+	}
+	var b strings.Builder
+	for _, j := range all {
+		b.WriteString(fmt.Sprintf("\n  %d  %-14s %6s  %s", j.ID, jobStatus(j), jobAge(j), truncate(j.Command, 60)))
+	}
+	return "jobs:" + b.String()
+}
+
+// /jobs, /jobs log <id> [lines], /jobs stop <id|all>, /jobs clear
+func cmdJobs(arg string) string {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return jobList() + fmt.Sprintf("\n%s/jobs log <id> [lines], /jobs stop <id|all>, /jobs clear%s", colorGray, colorReset)
+	}
+	switch fields[0] {
+	case "log", "tail":
+		if len(fields) < 2 {
+			return "Usage: /jobs log <id> [lines]"
+		}
+		j := findJob(fields[1])
+		if j == nil {
+			return "Error: no job " + fields[1]
+		}
+		n := 30
+		if len(fields) > 2 {
+			n, _ = strconv.Atoi(fields[2])
+		}
+		return fmt.Sprintf("%s─── job %d: %s (%s) ───%s\n%s", colorCyan, j.ID, j.Command, jobStatus(j), colorReset, j.log.Tail(n))
+	case "stop", "kill":
+		if len(fields) < 2 {
+			return "Usage: /jobs stop <id|all>"
+		}
+		if fields[1] == "all" {
+			stopAllJobs()
+			return fmt.Sprintf("%s✓ Stopped all jobs%s", colorGreen, colorReset)
+		}
+		j := findJob(fields[1])
+		if j == nil {
+			return "Error: no job " + fields[1]
+		}
+		stopJob(j)
+		return fmt.Sprintf("%s✓ Job %d: %s%s", colorGreen, j.ID, jobStatus(j), colorReset)
+	case "clear":
+		jobsMu.Lock()
+		kept := jobs[:0]
+		for _, j := range jobs {
+			if j.Ended.IsZero() {
+				kept = append(kept, j)
+			}
+		}
+		jobs = kept
+		jobsMu.Unlock()
+		return fmt.Sprintf("%s✓ Removed finished jobs%s", colorGreen, colorReset)
+	}
+	return "Usage: /jobs [log <id> [lines] | stop <id|all> | clear]"
+}
+
+// Jobs that ended on their own since the last message, for the model;
+// each is reported once (and to the webhook, if one is set)
+func jobsNotice() string {
+	jobsMu.Lock()
+	var ended []*job
+	for _, j := range jobs {
+		if !j.Ended.IsZero() && !j.noticed {
+			j.noticed = true
+			ended = append(ended, j)
+		}
+	}
+	jobsMu.Unlock()
+	var parts []string
+	for _, j := range ended {
+		status := jobStatus(j)
+		fmt.Printf("%s⚠ Job %d ended (%s): %s%s\n", colorYellow, j.ID, status, j.Command, colorReset)
+		parts = append(parts, fmt.Sprintf("Background job %d (%s) ended: %s\nlast output:\n%s", j.ID, j.Command, status, j.log.Tail(20)))
+		webhookStatus := "ok"
+		<-j.done // Err is set before done closes
+		if j.Err != nil {
+			webhookStatus = "error"
+		}
+		notifyWebhook(webhookPayload{Event: "job.exited", Status: webhookStatus, Summary: j.Command + "\n\n" + j.log.Tail(20)}, j.Started)
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== USAGE LEDGER ====================
+
+// Every API call is recorded with its turn, purpose and model. The ledger
+// is saved with the session, so /cost survives resume.
+
+type UsageEntry struct {
+	Time       time.Time `json:"time"`
+	Turn       int       `json:"turn"`
+	Kind       string    `json:"kind"` // message, tool_followup, title, follow_ups, embedding
+	Model      string    `json:"model"`
+	Prompt     int       `json:"prompt_tokens"`
+	Completion int       `json:"completion_tokens"`
+	Cost       float64   `json:"cost"`
+}
+
+type TokenUsage struct {
+	TotalTokens  int `json:"total_tokens"`
+	PromptTokens int `json:"prompt_tokens"`
+}
+
+var (
+	usageLedger []UsageEntry
+	lastUsage   TokenUsage // set by the last API call
+	turnBase    int        // turns recorded before this run (resume)
+)
+
+func restoreLedger(entries []UsageEntry) {
+	usageLedger = entries
+	turnBase = 0
+	for _, e := range entries {
+		if e.Turn > turnBase {
+			turnBase = e.Turn
+		}
+	}
+}
+
+// Books lastUsage under kind and resets it
+func recordUsage(kind string) {
+	recordModelUsage(kind, activeModel())
+}
+
+func recordModelUsage(kind, model string) {
+	u := lastUsage
+	lastUsage = TokenUsage{}
+	if u.TotalTokens == 0 {
+		return
+	}
+	e := UsageEntry{
+		Time:       time.Now(),
+		Turn:       turnBase + turnCount,
+		Kind:       kind,
+		Model:      model,
+		Prompt:     u.PromptTokens,
+		Completion: u.TotalTokens - u.PromptTokens,
+		Cost:       float64(u.TotalTokens) / 1000 * costPer1KTokens,
+	}
+	usageLedger = append(usageLedger, e)
+	totalCost += e.Cost
+}
+
+func ledgerTokens() int {
+	n := 0
+	for _, e := range usageLedger {
+		n += e.Prompt + e.Completion
+	}
+	return n
+}
+
+// /cost [--detail]
+func cmdCost(arg string) string {
+	summary := fmt.Sprintf("Tokens: %d in %d calls | Cost: $%.4f", ledgerTokens(), len(usageLedger), totalCost)
+	if arg != "--detail" {
+		return summary
+	}
+	if len(usageLedger) == 0 {
+		return summary + "\nNo API calls recorded yet"
+	}
+
+	type bucket struct {
+		calls, prompt, completion int
+		cost                      float64
+	}
+	add := func(m map[string]*bucket, key string, e UsageEntry) {
+		b, ok := m[key]
+		if !ok {
+			b = &bucket{}
+			m[key] = b
+		}
+		b.calls++
+		b.prompt += e.Prompt
+		b.completion += e.Completion
+		b.cost += e.Cost
+	}
+	byTurn, byModel, byKind := map[string]*bucket{}, map[string]*bucket{}, map[string]*bucket{}
+	var turns []int
+	for _, e := range usageLedger {
+		key := fmt.Sprint(e.Turn)
+		if _, ok := byTurn[key]; !ok {
+			turns = append(turns, e.Turn)
+		}
+		add(byTurn, key, e)
+		add(byModel, e.Model, e)
+		add(byKind, e.Kind, e)
+	}
+
+	var b strings.Builder
+	b.WriteString(summary + "\n")
+	row := func(label string, x *bucket) {
+		b.WriteString(fmt.Sprintf("  %-22s %5d %9d %9d  $%.4f\n", label, x.calls, x.prompt, x.completion, x.cost))
+	}
+	header := func(title string) {
+		b.WriteString(fmt.Sprintf("\n%s%-24s calls    prompt   output  cost%s\n", colorCyan, title, colorReset))
+	}
+
+	header("By turn")
+	for _, t := range turns {
+		row(fmt.Sprintf("#%d", t), byTurn[fmt.Sprint(t)])
+	}
+	for _, section := range []struct {
+		title string
+		m     map[string]*bucket
+	}{{"By model", byModel}, {"By purpose", byKind}} {
+		header(section.title)
+		var keys []string
+		for k := range section.m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			row(truncate(k, 22), section.m[k])
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// ==================== TURN STATS ====================
+
+// One entry per user turn: where the time went (model vs tools) and what
+// it cost. Shown by `mytool sessions stats <id>`.
+
+type TurnStat struct {
+	Turn     int       `json:"turn"`
+	Time     time.Time `json:"time"`
+	Model    string    `json:"model"`
+	ModelMs  int64     `json:"model_ms"` // waiting for the API, follow-up included
+	ToolMs   int64     `json:"tool_ms"`
+	Tools    int       `json:"tools"`
+	Tokens   int       `json:"tokens"`
+	Cost     float64   `json:"cost"`
+	Canceled bool      `json:"canceled,omitempty"`
+}
+
+var turnStats []TurnStat
+
+func recordTurn(start time.Time, modelTime, toolTime time.Duration, tools int, canceled bool) {
+	t := TurnStat{
+		Turn:     turnBase + turnCount,
+		Time:     start,
+		Model:    activeModel(),
+		ModelMs:  modelTime.Milliseconds(),
+		ToolMs:   toolTime.Milliseconds(),
+		Tools:    tools,
+		Canceled: canceled,
+	}
+	for _, e := range usageLedger {
+		if e.Turn == t.Turn {
+			t.Tokens += e.Prompt + e.Completion
+			t.Cost += e.Cost
+		}
+	}
+	turnStats = append(turnStats, t)
+}
+
+func printSessionStats(s *Session) {
+	fmt.Printf("%sSession %s%s  %s\n", colorCyan, s.ID, colorReset, sessionLabel(s))
+	if len(s.Turns) == 0 {
+		fmt.Println("No turn data recorded for this session")
+		return
+	}
+
+	var model, tool time.Duration
+	var tokens, tools int
+	var cost float64
+	fmt.Printf("\n%s%5s  %-20s %9s %9s %6s %8s %9s%s\n", colorGray, "turn", "model", "model", "tools", "calls", "tokens", "cost", colorReset)
+	for _, t := range s.Turns {
+		mark := ""
+		if t.Canceled {
+			mark = " (canceled)"
+		}
+		fmt.Printf("%5d  %-20s %9s %9s %6d %8d %9s%s\n", t.Turn, truncate(t.Model, 20),
+			msDuration(t.ModelMs), msDuration(t.ToolMs), t.Tools, t.Tokens, fmt.Sprintf("$%.4f", t.Cost), mark)
+		model += time.Duration(t.ModelMs) * time.Millisecond
+		tool += time.Duration(t.ToolMs) * time.Millisecond
+		tokens += t.Tokens
+		tools += t.Tools
+		cost += t.Cost
+	}
+	fmt.Printf("%s%5s  %-20s %9s %9s %6d %8d %9s%s\n", colorBold, "total", "", msDuration(model.Milliseconds()),
+		msDuration(tool.Milliseconds()), tools, tokens, fmt.Sprintf("$%.4f", cost), colorReset)
+
+	slowest := append([]TurnStat{}, s.Turns...)
+	sort.Slice(slowest, func(i, j int) bool {
+		return slowest[i].ModelMs+slowest[i].ToolMs > slowest[j].ModelMs+slowest[j].ToolMs
+	})
+	if len(slowest) > 3 {
+		slowest = slowest[:3]
+	}
+	fmt.Printf("\n%sSlowest turns:%s", colorGray, colorReset)
+	for _, t := range slowest {
+		fmt.Printf(" #%d (%s)", t.Turn, msDuration(t.ModelMs+t.ToolMs))
+	}
+	fmt.Println()
+	if total := model + tool; total > 0 {
+		fmt.Printf("%sTime in model: %.0f%%, in tools: %.0f%%%s\n", colorGray,
+			float64(model)/float64(total)*100, float64(tool)/float64(total)*100, colorReset)
+	}
+}
+
+func msDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(100 * time.Millisecond).String()
+}
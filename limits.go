@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ==================== RESOURCE LIMITS ====================
+
+// Commands the model runs get resource limits so a runaway loop, a memory
+// leak or a fork bomb fails instead of taking the machine with it. On the
+// host mytool starts itself as a small launcher (limitsArg) that sets the
+// rlimits and execs the command; in the sandbox the container runtime
+// applies them. Output past OutputMB kills the command too. Background
+// jobs get everything but the CPU limit. Windows has no rlimits: there
+// only the output cap and the timeout apply.
+
+type ResourceLimits struct {
+	CPUSeconds int `json:"cpu_seconds"` // CPU time per process; 0 = none (the timeout still applies)
+	MemoryMB   int `json:"memory_mb"`   // data segment per process
+	FileMB     int `json:"file_mb"`     // largest file a command may write
+	Processes  int `json:"processes"`   // processes it may start (on top of the user's current ones)
+	OutputMB   int `json:"output_mb"`   // output before it is killed
+}
+
+// First argument of the launcher: mytool __limits <spec> <path> <args...>
+const limitsArg = "__limits"
+
+var defaultLimits = ResourceLimits{MemoryMB: 4096, FileMB: 2048, Processes: 512, OutputMB: 20}
+
+var limitPresets = []struct {
+	name   string
+	limits ResourceLimits
+}{
+	{"Default", defaultLimits},
+	{"Strict", ResourceLimits{CPUSeconds: 120, MemoryMB: 1024, FileMB: 256, Processes: 128, OutputMB: 5}},
+	{"Off", ResourceLimits{}},
+}
+
+func limitsLabel(l ResourceLimits) string {
+	var parts []string
+	add := func(n int, format string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf(format, n))
+		}
+	}
+	add(l.MemoryMB, "memory %dMB")
+	add(l.Processes, "%d processes")
+	add(l.FileMB, "files %dMB")
+	add(l.CPUSeconds, "CPU %ds")
+	add(l.OutputMB, "output %dMB")
+	if len(parts) == 0 {
+		return "off"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The launcher's spec: cpu,memory,file,processes
+func limitSpec(l ResourceLimits, cpu bool) string {
+	if !cpu {
+		l.CPUSeconds = 0
+	}
+	return fmt.Sprintf("%d,%d,%d,%d", l.CPUSeconds, l.MemoryMB, l.FileMB, l.Processes)
+}
+
+func parseLimitSpec(spec string) (ResourceLimits, error) {
+	var n [4]int
+	fields := strings.Split(spec, ",")
+	if len(fields) != len(n) {
+		return ResourceLimits{}, fmt.Errorf("bad limits %q", spec)
+	}
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return ResourceLimits{}, fmt.Errorf("bad limits %q", spec)
+		}
+		n[i] = v
+	}
+	return ResourceLimits{CPUSeconds: n[0], MemoryMB: n[1], FileMB: n[2], Processes: n[3]}, nil
+}
+
+// Container flags for the limits; pids counts the container's own
+// processes only
+func sandboxLimitArgs(l ResourceLimits) []string {
+	var args []string
+	if l.MemoryMB > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", l.MemoryMB))
+	}
+	if l.Processes > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(l.Processes))
+	}
+	if l.FileMB > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("fsize=%d", int64(l.FileMB)<<20))
+	}
+	if l.CPUSeconds > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("cpu=%d", l.CPUSeconds))
+	}
+	return args
+}
+
+// /limits [default|strict|off|memory N|processes N|files N|cpu N|output N]
+func cmdLimits(arg string) string {
+	fields := strings.Fields(strings.ToLower(arg))
+	switch {
+	case len(fields) == 0:
+		return fmt.Sprintf("Resource limits for commands: %s\n%sUsage: /limits default|strict|off, or /limits memory|processes|files|cpu|output N (MB, count or seconds; 0 = none)%s",
+			limitsLabel(settings.Limits), colorGray, colorReset)
+	case len(fields) == 1:
+		found := false
+		for _, p := range limitPresets {
+			if strings.EqualFold(p.name, fields[0]) {
+				settings.Limits, found = p.limits, true
+			}
+		}
+		if !found {
+			return "Usage: /limits default|strict|off"
+		}
+	case len(fields) == 2:
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			return "Error: expected a number, 0 for no limit"
+		}
+		l := &settings.Limits
+		switch fields[0] {
+		case "memory", "mem":
+			l.MemoryMB = n
+		case "processes", "procs":
+			l.Processes = n
+		case "files", "file":
+			l.FileMB = n
+		case "cpu":
+			l.CPUSeconds = n
+		case "output":
+			l.OutputMB = n
+		default:
+			return "Usage: /limits memory|processes|files|cpu|output N"
+		}
+	default:
+		return "Usage: /limits [default|strict|off|memory|processes|files|cpu|output N]"
+	}
+	saveSettings()
+	return fmt.Sprintf("%s✓ Resource limits: %s%s", colorGreen, limitsLabel(settings.Limits), colorReset)
+}
@@ -0,0 +1,100 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// Linux's RLIMIT_NPROC; syscall doesn't name it
+const rlimitNproc = 6
+
+// Makes cmd start through the limits launcher. Without cpu the CPU limit
+// is left out (background jobs run for hours).
+func applyLimits(cmd *exec.Cmd, cpu bool) {
+	l := settings.Limits
+	if cmd.Err != nil || l.CPUSeconds <= 0 && l.MemoryMB <= 0 && l.FileMB <= 0 && l.Processes <= 0 {
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	args := append([]string{self, limitsArg, limitSpec(l, cpu), cmd.Path}, cmd.Args...)
+	cmd.Path, cmd.Args = self, args
+}
+
+// The launcher: mytool __limits <spec> <path> <argv...>. Sets the limits
+// and becomes the command.
+func runLimited(args []string) {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "usage: mytool __limits <spec> <path> <args...>")
+		os.Exit(2)
+	}
+	l, err := parseLimitSpec(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	set := func(resource int, value uint64) {
+		limit := syscall.Rlimit{Cur: value, Max: value}
+		var old syscall.Rlimit
+		// Never raise a limit the user already has
+		if syscall.Getrlimit(resource, &old) == nil && old.Max < value {
+			limit = syscall.Rlimit{Cur: min(old.Cur, value), Max: old.Max}
+		}
+		syscall.Setrlimit(resource, &limit)
+	}
+	if l.CPUSeconds > 0 {
+		set(syscall.RLIMIT_CPU, uint64(l.CPUSeconds))
+	}
+	// The data segment rather than address space: V8 and Go reserve far
+	// more address space than they use
+	if l.MemoryMB > 0 {
+		set(syscall.RLIMIT_DATA, uint64(l.MemoryMB)<<20)
+	}
+	if l.FileMB > 0 {
+		set(syscall.RLIMIT_FSIZE, uint64(l.FileMB)<<20)
+	}
+	// The process limit is per user, so it sits above what the user runs
+	// already; only Linux can count that
+	if n := userTasks(); l.Processes > 0 && n > 0 {
+		set(rlimitNproc, uint64(n+l.Processes))
+	}
+	err = syscall.Exec(args[1], args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "%s: %s\n", args[1], err)
+	os.Exit(127)
+}
+
+// Processes and threads of this user, which Linux counts against
+// RLIMIT_NPROC; 0 where there is no /proc
+func userTasks() int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	uid := uint32(os.Getuid())
+	n := 0
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		info, err := os.Stat("/proc/" + e.Name())
+		if err != nil {
+			continue
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); !ok || st.Uid != uid {
+			continue
+		}
+		if tasks, err := os.ReadDir("/proc/" + e.Name() + "/task"); err == nil {
+			n += len(tasks)
+		} else {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func applyLimits(cmd *exec.Cmd, cpu bool) {}
+
+func runLimited(args []string) {
+	fmt.Fprintln(os.Stderr, "resource limits are not supported on Windows")
+	os.Exit(2)
+}
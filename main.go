@@ -16,6 +16,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -63,15 +65,22 @@ var (
 	currentMode     = ModeAuto
 	currentDir      string
 	undoStack       []UndoAction
+	redoStack       []UndoAction
 	totalTokens     int
 	totalCost       float64
 	sessionID       string
+	sessionName     string
+	sessionTags     []string
+	sessionParent   string
+	sessionForkedAt int
+	sessionTitle    string
+	sessionSummary  string
+	summarizedLen   int // history length the title/summary were generated from
 	projectType     string
 	lastResponse    string
 	isThinking      bool
 	thinkingFrames  = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 	memory          = make(map[string]string)
-	chatExportFile  string
 	settings        Settings
 	
 	// Concurrent chat
@@ -79,29 +88,83 @@ var (
 	streamCancel    chan struct{}
 	streamMutex     sync.Mutex
 	mcpServers      []MCPServer
+	shadowedMCP     []MCPServer // global servers a project server of the same name replaces
+	followUps       []string
+	verifierPending string
+	autoPending     string // next message, sent without waiting for input (fix rounds)
+	autoLabel       string // shown when it is sent
+	serveMode       bool
+
+	// Session metrics
+	sessionStart  = time.Now()
+	turnCount     int
+	toolCounts    = make(map[string]int)
+	originalFiles = make(map[string]*string) // content before first change, nil if new
 )
 
 // Settings structure
 type Settings struct {
-	Model             string `json:"model"`
-	ReasoningLevel    string `json:"reasoning_level"`
-	DiffDisplayMode   string `json:"diff_display_mode"`
-	TodoDisplayMode   string `json:"todo_display_mode"`
-	CloudSync         bool   `json:"cloud_sync"`
-	ShowThinking      bool   `json:"show_thinking"`
-	PlaySounds        bool   `json:"play_sounds"`
-	CompletionSound   string `json:"completion_sound"`
-	AllowBackground   bool   `json:"allow_background"`
-	CustomDroids      bool   `json:"custom_droids"`
+	Model              string                      `json:"model"`
+	ReasoningLevel     string                      `json:"reasoning_level"`
+	DiffDisplayMode    string                      `json:"diff_display_mode"`
+	TodoDisplayMode    string                      `json:"todo_display_mode"`
+	CloudSync          bool                        `json:"cloud_sync"`
+	ShowThinking       bool                        `json:"show_thinking"`
+	PlaySounds         bool                        `json:"play_sounds"`
+	CompletionSound    string                      `json:"completion_sound"`
+	AllowBackground    bool                        `json:"allow_background"`
+	CustomDroids       bool                        `json:"custom_droids"`
+	FollowUps          bool                        `json:"follow_ups"`
+	AutoResumeHours    int                         `json:"auto_resume_hours"` // 0 = never offer
+	AutoPruneDays      int                         `json:"auto_prune_days"`   // 0 = keep sessions forever
+	DisabledTools      []string                    `json:"disabled_tools"`
+	WebCacheTTLMinutes int                         `json:"web_cache_ttl_minutes"` // 0 = no caching
+	WebCacheMaxMB      int                         `json:"web_cache_max_mb"`
+	ExportRedaction    string                      `json:"export_redaction"`
+	RedactionProfiles  map[string]RedactionProfile `json:"redaction_profiles,omitempty"`
+	Sync               SyncSettings                `json:"sync"` // used when CloudSync is on
+	RespectRobots      bool                        `json:"respect_robots"`
+	WebUserAgent       string                      `json:"web_user_agent,omitempty"` // default mytool/<version>
+	WebHostDelayMs     int                         `json:"web_host_delay_ms"`        // min gap between requests to one host
+	MemoryRecall       int                         `json:"memory_recall"`            // facts per prompt once memory is large; 0 = all
+	MemoryReviewDays   int                         `json:"memory_review_days"`       // offer facts unused this long for review; 0 = never
+	MemoryBudget       int                         `json:"memory_budget"`            // max tokens for the MEMORY block; 0 = unlimited
+	RepoMapTokens      int                         `json:"repo_map_tokens"`          // size of the REPO MAP block; 0 = off
+	AutoCompact        bool                        `json:"auto_compact"`             // summarize old turns near the context limit
+	CopyShellCheck     bool                        `json:"copy_shell_check"`         // warn before /copy of a risky shell snippet
+	LintPrompts        bool                        `json:"lint_prompts"`             // check file/symbol names in messages before sending
+	WebhookURL         string                      `json:"webhook_url,omitempty"`    // POSTed a summary when a headless run ends
+	WebhookSecret      string                      `json:"webhook_secret,omitempty"` // HMAC key for X-Mytool-Signature
+	NativeFallback     bool                        `json:"native_fallback"`          // built-in find/grep/clipboard when the program is missing
+	IndexSnippets      int                         `json:"index_snippets"`           // code index chunks attached per question; 0 = off
+	MentionDeps        bool                        `json:"mention_deps"`             // @file.go/.ts also attaches signatures of local imports
+	FileBudget         int                         `json:"file_budget"`              // max tokens of @attachments in the history; 0 = unlimited
+	ToolOutputBudget   int                         `json:"tool_output_budget"`       // max tokens of tool results in the history; 0 = unlimited
+	Sandbox            SandboxSettings             `json:"sandbox"`                  // run/python/node in a container
+	RunTimeout         int                         `json:"run_timeout"`              // seconds run/python/node may take; 0 = no limit
+	ConfineToRoot      bool                        `json:"confine_to_root"`          // keep cd and run inside the project root
+	ScrubEnv           bool                        `json:"scrub_env"`                // commands get a minimal environment
+	EnvPassthrough     []string                    `json:"env_passthrough"`          // extra variables they get then; NAME or PREFIX_*
+	Limits             ResourceLimits              `json:"limits"`                   // for commands the model runs
+	CheckAfterEdits    bool                        `json:"check_after_edits"`        // build/lint what a turn changed, errors go back to the model
+	ShadowCommits      bool                        `json:"shadow_commits"`           // commit each turn's changes to mytool/shadow
+	WorktreeSessions   bool                        `json:"worktree_sessions"`        // new sessions work in a git worktree of their own
+	ForgeHosts         map[string]string           `json:"forge_hosts,omitempty"`    // self-hosted forge host → github, gitlab or bitbucket
 }
 
 // MCP Server structure  
 type MCPServer struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	Type      string `json:"type"`
-	Connected bool   `json:"connected"`
-	Tools     []string `json:"tools"`
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	Type          string   `json:"type"`
+	Connected     bool     `json:"connected"`
+	Tools         []string `json:"tools"`
+	Auth          string   `json:"auth,omitempty"` // "" or "oauth"
+	ClientID      string   `json:"client_id,omitempty"`
+	Scopes        string   `json:"scopes,omitempty"`
+	Command       string   `json:"command,omitempty"` // stdio servers
+	DisabledTools []string `json:"disabled_tools,omitempty"`
+	Project       string   `json:"-"` // set when loaded from <root>/.mytool/mcp.json
 }
 
 type UndoAction struct {
@@ -109,6 +172,10 @@ type UndoAction struct {
 	Path    string
 	Content string
 	Time    time.Time
+	Files   []UndoAction // Type "batch": undone together
+	Op      string       // write, replace, bulk, patch, ...
+	From    string       // Type "move": where Path was before
+	Mode    os.FileMode  // permissions to restore, 0 if unknown
 }
 
 type StreamChoice struct {
@@ -119,15 +186,20 @@ type StreamChoice struct {
 
 type StreamResponse struct {
 	Choices []StreamChoice `json:"choices"`
-	Usage   struct {
-		TotalTokens  int `json:"total_tokens"`
-		PromptTokens int `json:"prompt_tokens"`
-	} `json:"usage"`
+	Usage   TokenUsage     `json:"usage"`
 }
 
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	Pinned  bool   `json:"pinned,omitempty"` // kept through compaction and trimming; stripped before sending
+}
+
+type CompletionResponse struct {
+	Choices []struct {
+		Message ChatMessage `json:"message"`
+	} `json:"choices"`
+	Usage TokenUsage `json:"usage"`
 }
 
 type ChatRequest struct {
@@ -139,35 +211,89 @@ type ChatRequest struct {
 }
 
 type Session struct {
-	ID       string            `json:"id"`
-	Dir      string            `json:"dir"`
-	Mode     string            `json:"mode"`
-	History  []ChatMessage     `json:"history"`
-	Tokens   int               `json:"tokens"`
-	Cost     float64           `json:"cost"`
-	Memory   map[string]string `json:"memory"`
-	Created  time.Time         `json:"created"`
-	Updated  time.Time         `json:"updated"`
+	ID          string             `json:"id"`
+	Name        string             `json:"name,omitempty"`
+	Title       string             `json:"title,omitempty"`   // generated by the model
+	Summary     string             `json:"summary,omitempty"` // short abstract, ditto
+	Tags        []string           `json:"tags,omitempty"`
+	Parent      string             `json:"parent,omitempty"` // session this one was forked from
+	ForkedAt    int                `json:"forked_at,omitempty"`
+	Dir         string             `json:"dir"`
+	Mode        string             `json:"mode"`
+	History     []ChatMessage      `json:"history"`
+	Tokens      int                `json:"tokens"`
+	Cost        float64            `json:"cost"`
+	Facts       map[string]string  `json:"facts,omitempty"` // session-scoped memory
+	Created     time.Time          `json:"created"`
+	Updated     time.Time          `json:"updated"`
+	Checkpoints []Checkpoint       `json:"checkpoints,omitempty"`
+	Originals   map[string]*string `json:"originals,omitempty"` // file contents before the session touched them
+	Vars        map[string]string  `json:"vars,omitempty"`      // /set variables
+	PinnedFiles []string           `json:"pinned_files,omitempty"`
+	Undo        []undoRecord       `json:"undo,omitempty"` // contents in .mytool/undo, see undoRecords
+	Snapshots   []turnSnapshot     `json:"snapshots,omitempty"`
+	FirstSnap   *turnSnapshot      `json:"first_snapshot,omitempty"`
+	Ledger      []UsageEntry       `json:"ledger,omitempty"`
+	Turns       []TurnStat         `json:"turns,omitempty"`
+	State       string             `json:"-"` // kept in the store, see sessionActive
 }
 
 type Memory struct {
 	Facts map[string]string `json:"facts"`
 }
 
+var shutdownSignals = make(chan os.Signal, 1)
+
+// The chat loop holds chatMu except while it waits for input, when
+// chatHistory is its current history; the signal handler takes it to save
+// the session without racing the loop
+var (
+	chatMu      sync.Mutex
+	chatHistory []ChatMessage
+)
+
+// Exit from the chat on Ctrl+C between turns or on SIGTERM. If the loop
+// is busy and doesn't get to its next prompt soon, the session is left
+// as it was last autosaved, to be offered as recovered next time.
+func shutdownChat() {
+	stopAllJobs()
+	closeBrowser()
+	for deadline := time.Now().Add(2 * time.Second); !chatMu.TryLock(); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			fmt.Printf("\n%s👋 Interrupted; the session is kept as of the last turn%s\n", colorYellow, colorReset)
+			os.Exit(1)
+		}
+	}
+	saveMemory()
+	closeSession(chatHistory)
+	fmt.Println()
+	printSessionSummary()
+	fmt.Printf("%s👋 Bye!%s\n", colorCyan, colorReset)
+	os.Exit(0)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == limitsArg {
+		runLimited(os.Args[2:])
+	}
 	currentDir, _ = os.Getwd()
 	sessionID = generateSessionID()
 	detectProject()
+	unlockVault()
 	loadMemory()
+	loadProjectMemory()
 	loadSettings()
+	loadMemoryMeta()
 	loadMCPServers()
+	checkBinaries()
 
-	// Graceful shutdown
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	// Graceful shutdown (the chat loop takes over Ctrl+C)
+	signal.Notify(shutdownSignals, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
+		<-shutdownSignals
 		fmt.Printf("\n%s👋 Interrupted%s\n", colorYellow, colorReset)
+		stopAllJobs()
+		closeBrowser()
 		saveMemory()
 		os.Exit(0)
 	}()
@@ -188,17 +314,25 @@ func main() {
 	case "help", "-h", "--help":
 		printHelp()
 	case "resume":
-		resumeSession()
+		resumeSession(args[1:])
 	case "sessions":
-		listSessions()
+		runSessionsCmd(args[1:])
 	case "export":
-		if len(args) > 1 {
-			exportChat(args[1])
-		} else {
-			exportChat("")
-		}
+		exportSessionCmd(args[1:])
 	case "memory":
-		showMemory()
+		runMemoryCmd(args[1:])
+	case "mcp-serve":
+		runMCPServe(args[1:])
+	case "sync":
+		runSyncCmd(args[1:], bufio.NewScanner(os.Stdin))
+	case "migrate":
+		runMigrate(args[1:])
+	case "tour":
+		runTour()
+	case "vault":
+		runVaultCmd(args[1:])
+	case "index":
+		runIndexCmd(args[1:])
 	default:
 		runChat(args)
 	}
@@ -250,15 +384,34 @@ func printHelp() {
 %sUSAGE%s
   mytool              Start interactive chat
   mytool "message"    Send single message
-  mytool resume       Resume last session
-  mytool sessions     List all sessions
-  mytool export [f]   Export chat to file
+  mytool "message" --out <file>  Write the reply's code block to a file
+  mytool --worktree              Work on a branch in a separate git worktree
+  mytool resume       Pick a session to resume
+  mytool resume <id>  Resume by ID, prefix or name (--last: newest here)
+  mytool sessions     List this project's sessions (--all for every project)
+  mytool sessions --search <q>  Full-text search sessions
+  mytool sessions --tag <t> --dir <d>  Filter sessions
+  mytool sessions rm <id>       Delete a session
+  mytool sessions prune --older-than 30d [--max-size 50MB]
+  mytool sessions stats [id]    Per-turn time, tokens and cost
+  mytool sessions export <id> [--out f.json] [--redact public]
+  mytool sessions import <f.json>  Import an exported session
+  mytool sync [push|pull|setup]    Encrypted WebDAV sync
+  mytool export [f]   Export latest session (--session, --profile, --raw)
   mytool memory       Show AI memory
+  mytool memory edit [q]  Browse and edit memories
+  mytool memory export [--scope project] [--out f]  Share memory
+  mytool memory import <f> [--strategy ours|theirs|interactive]
+  mytool migrate "Express 4 to 5"  Batched, tested upgrade (--status, --abandon)
+  mytool mcp-serve    Serve built-in tools over MCP (stdio)
+  mytool tour         Guided walkthrough in a scratch directory
+  mytool vault [enable [--keychain]|disable]  Encrypt memory, sessions and key at rest
+  mytool index [--rebuild|status|clear]  Embed project files for automatic retrieval
 
 %sFEATURES%s
   ✓ Full system access (read/write/execute)
   ✓ Git integration
-  ✓ Web search & URL fetch
+  ✓ Web search & URL fetch, headless browser for JS pages
   ✓ Image analysis
   ✓ Code execution (Python/JS/Shell)
   ✓ Syntax highlighting
@@ -272,23 +425,57 @@ func printHelp() {
 
 %sCOMMANDS%s
   /mode         Toggle mode (auto/ask/manual)
-  /undo         Undo last file change
+  /model [m]    Show/switch model
+  /undo [n]     List changes / undo change n
+  /redo         Redo the last undo
+  /fork [n]     Fork session at message n
+  /checkpoint   Name a restore point
+  /rollback <n> Rewind chat + files
+  /revert-turn [n]  Restore the tree from before the last n turns
+  /revert-session   Restore the tree from before the session
+  /changes [diff|revert] Files changed this session: list, diff, revert
   /save         Save current session
-  /export [f]   Export chat to file
+  /rename <n>   Name session
+  /tag <t>      Tag session
+  /export [f]   Export chat (redacted; --raw)
+  /set n=v      Session variable, use as {{n}}
+  /cache [list|clear] Web cache
+  /sync         Cloud sync now
   /copy         Copy last response
+  /extract [n] [f] Save the nth code block to a file
   /memory       Show/manage memory
   /forget <k>   Forget memory item
-  /remember     Remember something
-  /sessions     List sessions
+  /remember k=v Remember (--project, --session, --ttl 30d)
+  /memory review Keep or forget long-unused memories
+  /memory edit [q] Browse, edit, delete and search memories
+  /sessions     List this project's sessions (--all, --search q)
   /clear        Clear history
-  /context      Show context usage
-  /cost         Show API cost
-  /run <cmd>    Run shell command
+  /context      Token breakdown (preview [f]: next request)
+  /compact      Summarize older messages to free context
+  /pin [n|list] Always keep an exchange (/unpin n|all)
+  /pin-file [f] Attach a file's current contents every turn (/unpin-file)
+  /index [status] Embed project files; questions get matching snippets
+  /cost         API cost (--detail breakdown)
+  /run <cmd>    Run shell command (--timeout 5m)
   /python <c>   Run Python code
   /node <c>     Run JavaScript
+  /sandbox      Run commands in a container (on|off, network, ro, image)
+  /jobs         Background jobs (log <id>, stop <id|all>); start with /run cmd &
+  /policy       Project allow/deny lists for commands (check <cmd>)
+  /confine      Keep cd and run inside the project root (on|off)
+  /env          Minimal environment for commands (on|off, pass NAME, drop NAME)
+  /limits       Memory/process/file/CPU/output limits for commands
+  /test [name]  Run the project's tests (--fix [n]: let the AI fix failures, n rounds)
+  /check        Build/lint after edits, errors go back to the AI (on|off)
+  /commit [-a|f] Stage, write a commit message from the diff, commit
+  /shadow       Commit every turn's changes to mytool/shadow (on|off|log)
+  /pr           Push the branch and open a pull/merge request (--draft, --base b; status)
+  /review [ref] Review uncommitted changes, or the branch since ref: bugs, security, style
+  /resolve [f]  Resolve merge conflicts one by one with proposed resolutions
+  /worktree     Session worktree: status, start, merge, leave, discard
   /git <cmd>    Git command
   /search <q>   Web search
-  /read <f>     Read file
+  /read <f>     Read file (f:10-50 or f#Func for a part)
   /edit <f>     Edit file
   /ls [d]       List directory
   /find <n>     Find files
@@ -298,7 +485,8 @@ func printHelp() {
   exit          Quit
 
 %sSHORTCUTS%s
-  @file         Include file content
+  @file         Include file content (@dir/, @**/*.go, @https://url)
+  gh#123        Include an issue (or gl!12, bb!12: a merge/pull request) with its latest comments
   \             Multi-line input
   Ctrl+C        Cancel/Exit
 
@@ -314,7 +502,7 @@ func printVersion() {
   Project:  %s
   Session:  %s
   Build:    %s
-`, colorCyan, colorReset, version, modelName, runtime.GOOS, runtime.GOARCH,
+`, colorCyan, colorReset, version, activeModel(), runtime.GOOS, runtime.GOARCH,
 		projectType, sessionID, buildTime)
 }
 
@@ -328,15 +516,10 @@ func printStatusBar() {
 		proj = fmt.Sprintf("[%s]", projectType)
 	}
 	
-	git := ""
-	if branch := getGitBranch(); branch != "" {
-		git = fmt.Sprintf("⎇ %s", branch)
-	}
-	
 	bar := fmt.Sprintf("%s │ %s%s │ %s%s │ %s │ %s",
 		mode, colorGray, tokens, cost, colorReset, currentDir, proj)
-	if git != "" {
-		bar += fmt.Sprintf(" %s%s%s", colorBlue, git, colorReset)
+	if git := getGitStatus(); git.Branch != "" {
+		bar += " " + git.Segment()
 	}
 	fmt.Println(bar)
 }
@@ -451,62 +634,176 @@ func formatCodeBlock(code, lang string) string {
 
 // ==================== MEMORY ====================
 
+// memory.json as this instance last read or wrote it; saveMemory only
+// applies the differences, so facts stored by other instances survive
+var memoryBase = map[string]string{}
+
 func loadMemory() {
 	home, _ := os.UserHomeDir()
-	data, err := os.ReadFile(filepath.Join(home, ".mytool", "memory.json"))
+	data, err := readPrivateFile(filepath.Join(home, ".mytool", "memory.json"))
 	if err != nil {
 		return
 	}
 	json.Unmarshal(data, &memory)
+	memoryBase = copyMemory(memory)
+}
+
+func copyMemory(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
 }
 
 func saveMemory() {
 	home, _ := os.UserHomeDir()
-	os.MkdirAll(filepath.Join(home, ".mytool"), 0755)
-	data, _ := json.MarshalIndent(memory, "", "  ")
-	os.WriteFile(filepath.Join(home, ".mytool", "memory.json"), data, 0644)
+	path := filepath.Join(home, ".mytool", "memory.json")
+	err := updatePrivateFile(path, 0644, func(old []byte) []byte {
+		disk := map[string]string{}
+		json.Unmarshal(old, &disk)
+		for k, v := range memory {
+			if base, ok := memoryBase[k]; !ok || base != v {
+				disk[k] = v
+			}
+		}
+		for k := range memoryBase {
+			if _, ok := memory[k]; !ok {
+				delete(disk, k)
+			}
+		}
+		memory, memoryBase = disk, copyMemory(disk)
+
+		data, _ := json.MarshalIndent(disk, "", "  ")
+		if bytes.Equal(old, data) {
+			return nil // nothing changed, leave the file alone
+		}
+		return data
+	})
+	if err != nil {
+		fmt.Printf("%sMemory not saved: %s%s\n", colorYellow, err, colorReset)
+	}
 }
 
+// Facts grouped by scope, narrowest first; shadowed ones are marked
 func showMemory() {
-	if len(memory) == 0 {
+	effective := effectiveMemory()
+	if len(effective) == 0 {
 		fmt.Println("No memories stored")
 		return
 	}
-	fmt.Printf("%sMemory (%d items):%s\n", colorCyan, len(memory), colorReset)
-	for k, v := range memory {
-		fmt.Printf("  %s%s%s: %s\n", colorYellow, k, colorReset, truncate(v, 50))
+	var lines []string
+	for k, f := range effective {
+		lines = append(lines, memoryLine(k, f.Value))
+	}
+	fmt.Printf("%sMemory (%d items, ~%d tokens, budget %s):%s\n", colorCyan, len(effective), memoryTokens(lines), budgetLabel(settings.MemoryBudget), colorReset)
+	for _, scope := range memoryScopes {
+		m := scopeMemory(scope)
+		if len(m) == 0 {
+			continue
+		}
+		fmt.Printf("  %s[%s]%s\n", colorGray, scopeLabel(scope), colorReset)
+		for _, k := range sortedKeys(m) {
+			note := ""
+			if effective[k].Scope != scope {
+				note = fmt.Sprintf(" %s(overridden by %s)%s", colorGray, effective[k].Scope, colorReset)
+			}
+			if n := factNote(scope, k); n != "" {
+				note += fmt.Sprintf(" %s(%s)%s", colorGray, n, colorReset)
+			}
+			fmt.Printf("    %s%s%s: %s%s\n", colorYellow, k, colorReset, truncate(m[k], 50), note)
+		}
 	}
 }
 
-func rememberFact(key, value string) {
-	memory[key] = value
-	saveMemory()
+// ttl 0 keeps the fact until it is forgotten
+func rememberFact(scope, key, value string, ttl time.Duration) {
+	scopeMemory(scope)[key] = value
+	saveScope(scope)
+	trackFact(scope, key, ttl)
 }
 
-func forgetFact(key string) {
-	delete(memory, key)
-	saveMemory()
+// The line a fact contributes to the MEMORY section of the system prompt
+func memoryLine(key, value string) string {
+	return fmt.Sprintf("- %s: %s", key, value)
+}
+
+// Shows a model-proposed fact as a diff against the stored one and how it
+// will appear in every future prompt; nothing is stored without a yes.
+func reviewMemoryChange(scope, key, value string) bool {
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	fmt.Printf("\n%s🧠 Memory change proposed%s %s[%s]%s\n", colorCyan, colorReset, colorGray, scopeLabel(scope), colorReset)
+	if old, ok := scopeMemory(scope)[key]; ok {
+		if old == value {
+			fmt.Printf("  %s(unchanged)%s %s\n", colorGray, colorReset, memoryLine(key, value))
+			return true
+		}
+		fmt.Printf("  %s%s%s\n", colorRed, memoryLine(key, old), colorReset)
+	}
+	fmt.Printf("  %s%s%s\n", colorGreen, memoryLine(key, value), colorReset)
+	where := map[string]string{
+		scopeGlobal:  "every future session",
+		scopeProject: "every session in " + memoryProject,
+		scopeSession: "this session",
+	}[scope]
+	fmt.Printf("  %sAdded to the MEMORY section of the system prompt in %s%s\n", colorGray, where, colorReset)
+	return confirmAction("  Store it?")
+}
+
+// Removes key from scope, or from the narrowest scope holding it when
+// scope is ""; returns the scope it was removed from
+func forgetFact(scope, key string) string {
+	if scope == "" {
+		scope = factScope(key)
+	}
+	if _, ok := scopeMemory(scope)[key]; scope == "" || !ok {
+		return ""
+	}
+	delete(scopeMemory(scope), key)
+	saveScope(scope)
+	untrackFact(scope, key)
+	return scope
 }
 
 // ==================== SETTINGS ====================
 
 func loadSettings() {
+	// Defaults, overridden by whatever the settings file contains
+	settings = Settings{
+		Model:              modelName,
+		ReasoningLevel:     "High",
+		DiffDisplayMode:    "GitHub",
+		TodoDisplayMode:    "In message flow",
+		CloudSync:          false,
+		ShowThinking:       true,
+		PlaySounds:         false,
+		CompletionSound:    "FX-OK01",
+		AllowBackground:    true,
+		CustomDroids:       true,
+		AutoResumeHours:    12,
+		ExportRedaction:    "public",
+		WebCacheTTLMinutes: 60,
+		WebCacheMaxMB:      50,
+		RespectRobots:      true,
+		WebHostDelayMs:     1000,
+		MemoryRecall:       8,
+		MemoryReviewDays:   60,
+		MemoryBudget:       1000,
+		RepoMapTokens:      1500,
+		AutoCompact:        true,
+		CopyShellCheck:     true,
+		LintPrompts:        true,
+		NativeFallback:     true,
+		IndexSnippets:      5,
+		MentionDeps:        true,
+		FileBudget:         40000,
+		ToolOutputBudget:   20000,
+		RunTimeout:         600,
+		Limits:             defaultLimits,
+	}
 	home, _ := os.UserHomeDir()
 	data, err := os.ReadFile(filepath.Join(home, ".mytool", "settings.json"))
 	if err != nil {
-		// Default settings
-		settings = Settings{
-			Model:           modelName,
-			ReasoningLevel:  "High",
-			DiffDisplayMode: "GitHub",
-			TodoDisplayMode: "In message flow",
-			CloudSync:       false,
-			ShowThinking:    true,
-			PlaySounds:      false,
-			CompletionSound: "FX-OK01",
-			AllowBackground: true,
-			CustomDroids:    true,
-		}
 		return
 	}
 	json.Unmarshal(data, &settings)
@@ -516,7 +813,7 @@ func saveSettings() {
 	home, _ := os.UserHomeDir()
 	os.MkdirAll(filepath.Join(home, ".mytool"), 0755)
 	data, _ := json.MarshalIndent(settings, "", "  ")
-	os.WriteFile(filepath.Join(home, ".mytool", "settings.json"), data, 0644)
+	updateFile(filepath.Join(home, ".mytool", "settings.json"), 0644, func([]byte) []byte { return data })
 }
 
 func showSettings(scanner *bufio.Scanner) {
@@ -529,8 +826,38 @@ func showSettings(scanner *bufio.Scanner) {
 			fmt.Sprintf("Cloud sync: %s", boolToStr(settings.CloudSync)),
 			fmt.Sprintf("Show thinking: %s", boolToStr(settings.ShowThinking)),
 			fmt.Sprintf("Play sounds: %s", boolToStr(settings.PlaySounds)),
-			fmt.Sprintf("Allow background: %s", boolToStr(settings.AllowBackground)),
+			fmt.Sprintf("Allow background jobs (run:cmd &): %s", boolToStr(settings.AllowBackground)),
 			fmt.Sprintf("Custom droids: %s", boolToStr(settings.CustomDroids)),
+			fmt.Sprintf("Follow-up suggestions: %s", boolToStr(settings.FollowUps)),
+			fmt.Sprintf("Offer resume within: %dh", settings.AutoResumeHours),
+			fmt.Sprintf("Auto-prune sessions after: %s", daysOrOff(settings.AutoPruneDays)),
+			fmt.Sprintf("Tools: %d disabled", len(settings.DisabledTools)),
+			fmt.Sprintf("Export redaction: %s", settings.ExportRedaction),
+			fmt.Sprintf("Web cache TTL: %s", minutesOrOff(settings.WebCacheTTLMinutes)),
+			fmt.Sprintf("Web cache max size: %dMB", settings.WebCacheMaxMB),
+			fmt.Sprintf("Respect robots.txt: %s", boolToStr(settings.RespectRobots)),
+			fmt.Sprintf("Delay between requests to a host: %dms", settings.WebHostDelayMs),
+			fmt.Sprintf("Memory in prompt: %s", recallLabel(settings.MemoryRecall)),
+			fmt.Sprintf("Review unused memories after: %s", daysOrOff(settings.MemoryReviewDays)),
+			fmt.Sprintf("Check shell snippets on /copy: %s", boolToStr(settings.CopyShellCheck)),
+			fmt.Sprintf("Check file names in messages: %s", boolToStr(settings.LintPrompts)),
+			fmt.Sprintf("Completion webhook: %s", valueOrOff(settings.WebhookURL)),
+			fmt.Sprintf("Native fallback for missing programs: %s", boolToStr(settings.NativeFallback)),
+			fmt.Sprintf("Memory budget: %s", budgetLabel(settings.MemoryBudget)),
+			fmt.Sprintf("Repo map in prompt: %s", tokensOrOff(settings.RepoMapTokens)),
+			fmt.Sprintf("Auto-compact near the context limit: %s", boolToStr(settings.AutoCompact)),
+			fmt.Sprintf("Snippets from the code index: %s", snippetsOrOff(settings.IndexSnippets)),
+			fmt.Sprintf("Signatures of imports with @file: %s", boolToStr(settings.MentionDeps)),
+			fmt.Sprintf("File attachment budget: %s", budgetLabel(settings.FileBudget)),
+			fmt.Sprintf("Tool output budget: %s", budgetLabel(settings.ToolOutputBudget)),
+			fmt.Sprintf("Run commands in a container: %s", boolToStr(settings.Sandbox.Enabled)),
+			fmt.Sprintf("Command timeout: %s", secondsOrOff(settings.RunTimeout)),
+			fmt.Sprintf("Confine cd and run to the project root: %s", boolToStr(settings.ConfineToRoot)),
+			fmt.Sprintf("Scrub environment of commands: %s", boolToStr(settings.ScrubEnv)),
+			fmt.Sprintf("Resource limits for commands: %s", limitsLabel(settings.Limits)),
+			fmt.Sprintf("Build/lint check after edits: %s", boolToStr(settings.CheckAfterEdits)),
+			fmt.Sprintf("Commit each turn to mytool/shadow: %s", boolToStr(settings.ShadowCommits)),
+			fmt.Sprintf("New sessions in a git worktree: %s", boolToStr(settings.WorktreeSessions)),
 			"← Back to chat",
 		}
 		
@@ -561,7 +888,7 @@ func showSettings(scanner *bufio.Scanner) {
 			}
 		case 2: // Diff mode
 			modes := []string{"GitHub", "Unified", "← Back"}
-			idx := selectMenu("Diff Display Mode", modes, 0)
+			idx := selectMenu("Diff Display Mode (GitHub: side by side)", modes, 0)
 			if idx >= 0 && idx < 2 {
 				settings.DiffDisplayMode = modes[idx]
 			}
@@ -572,7 +899,12 @@ func showSettings(scanner *bufio.Scanner) {
 				settings.TodoDisplayMode = modes[idx]
 			}
 		case 4:
-			settings.CloudSync = !settings.CloudSync
+			if !settings.CloudSync && settings.Sync.URL == "" {
+				fmt.Print("\033[H\033[2J")
+				setupSync(scanner)
+			} else {
+				settings.CloudSync = !settings.CloudSync
+			}
 		case 5:
 			settings.ShowThinking = !settings.ShowThinking
 		case 6:
@@ -581,11 +913,224 @@ func showSettings(scanner *bufio.Scanner) {
 			settings.AllowBackground = !settings.AllowBackground
 		case 8:
 			settings.CustomDroids = !settings.CustomDroids
+		case 9:
+			settings.FollowUps = !settings.FollowUps
+		case 10:
+			hours := []string{"Never", "1h", "4h", "12h", "24h", "72h", "← Back"}
+			values := []int{0, 1, 4, 12, 24, 72}
+			idx := selectMenu("Offer to resume sessions updated within", hours, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.AutoResumeHours = values[idx]
+			}
+		case 11:
+			days := []string{"Off", "7 days", "30 days", "90 days", "365 days", "← Back"}
+			values := []int{0, 7, 30, 90, 365}
+			idx := selectMenu("Delete sessions not updated for", days, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.AutoPruneDays = values[idx]
+			}
+		case 12:
+			showToolMatrix()
+		case 13:
+			names := redactionProfileNames()
+			idx := selectMenu("Redaction profile for /export", append(names, "← Back"), 0)
+			if idx >= 0 && idx < len(names) {
+				settings.ExportRedaction = names[idx]
+			}
+		case 14:
+			ttls := []string{"Off", "10m", "1h", "6h", "24h", "← Back"}
+			values := []int{0, 10, 60, 360, 1440}
+			idx := selectMenu("Reuse fetch/search results for", ttls, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.WebCacheTTLMinutes = values[idx]
+			}
+		case 15:
+			sizes := []string{"10MB", "50MB", "200MB", "1000MB", "← Back"}
+			values := []int{10, 50, 200, 1000}
+			idx := selectMenu("Web cache size limit", sizes, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.WebCacheMaxMB = values[idx]
+				trimWebCache(int64(settings.WebCacheMaxMB) << 20)
+			}
+		case 16:
+			settings.RespectRobots = !settings.RespectRobots
+		case 17:
+			delays := []string{"None", "250ms", "1s", "2s", "5s", "← Back"}
+			values := []int{0, 250, 1000, 2000, 5000}
+			idx := selectMenu("Minimum delay between requests to the same host", delays, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.WebHostDelayMs = values[idx]
+			}
+		case 18:
+			opts := []string{"All facts", "Top 5 relevant", "Top 8 relevant", "Top 15 relevant", "Top 30 relevant", "← Back"}
+			values := []int{0, 5, 8, 15, 30}
+			idx := selectMenu("Memory facts sent with each message", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.MemoryRecall = values[idx]
+			}
+		case 19:
+			opts := []string{"Never", "30 days", "60 days", "90 days", "180 days", "← Back"}
+			values := []int{0, 30, 60, 90, 180}
+			idx := selectMenu("Offer memories for review when unused for", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.MemoryReviewDays = values[idx]
+			}
+		case 20:
+			settings.CopyShellCheck = !settings.CopyShellCheck
+		case 21:
+			settings.LintPrompts = !settings.LintPrompts
+		case 22:
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("Webhook URL (Enter to keep %s, \"off\" to disable): ", valueOrOff(settings.WebhookURL))
+			if scanner.Scan() {
+				switch url := strings.TrimSpace(scanner.Text()); {
+				case url == "off":
+					settings.WebhookURL, settings.WebhookSecret = "", ""
+				case url != "":
+					settings.WebhookURL = url
+					fmt.Print("Signing secret (optional): ")
+					if scanner.Scan() {
+						settings.WebhookSecret = strings.TrimSpace(scanner.Text())
+					}
+				}
+			}
+		case 23:
+			settings.NativeFallback = !settings.NativeFallback
+		case 24:
+			opts := []string{"Unlimited", "500 tokens", "1000 tokens", "2000 tokens", "4000 tokens", "← Back"}
+			values := []int{0, 500, 1000, 2000, 4000}
+			idx := selectMenu("Most tokens the MEMORY block may use", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.MemoryBudget = values[idx]
+			}
+		case 25:
+			opts := []string{"Off", "500 tokens", "1500 tokens", "3000 tokens", "6000 tokens", "← Back"}
+			values := []int{0, 500, 1500, 3000, 6000}
+			idx := selectMenu("Repository map in the system prompt", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.RepoMapTokens = values[idx]
+			}
+		case 26:
+			settings.AutoCompact = !settings.AutoCompact
+		case 27:
+			opts := []string{"Off", "3 per question", "5 per question", "10 per question", "← Back"}
+			values := []int{0, 3, 5, 10}
+			idx := selectMenu("Chunks from `mytool index` attached to each question", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.IndexSnippets = values[idx]
+			}
+		case 28:
+			settings.MentionDeps = !settings.MentionDeps
+		case 29:
+			opts := []string{"Unlimited", "10000 tokens", "20000 tokens", "40000 tokens", "80000 tokens", "← Back"}
+			values := []int{0, 10000, 20000, 40000, 80000}
+			idx := selectMenu("Most tokens @file attachments may use in the history", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.FileBudget = values[idx]
+			}
+		case 30:
+			opts := []string{"Unlimited", "5000 tokens", "10000 tokens", "20000 tokens", "40000 tokens", "← Back"}
+			values := []int{0, 5000, 10000, 20000, 40000}
+			idx := selectMenu("Most tokens tool results may use in the history", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.ToolOutputBudget = values[idx]
+			}
+		case 31:
+			settings.Sandbox.Enabled = !settings.Sandbox.Enabled
+		case 32:
+			opts := []string{"No limit", "1 minute", "5 minutes", "10 minutes", "30 minutes", "← Back"}
+			values := []int{0, 60, 300, 600, 1800}
+			idx := selectMenu("Longest a run/python/node command may take", opts, 0)
+			if idx >= 0 && idx < len(values) {
+				settings.RunTimeout = values[idx]
+			}
+		case 33:
+			settings.ConfineToRoot = !settings.ConfineToRoot
+			confinedRoot = ""
+		case 34:
+			settings.ScrubEnv = !settings.ScrubEnv
+		case 35:
+			var opts []string
+			for _, p := range limitPresets {
+				opts = append(opts, fmt.Sprintf("%s: %s", p.name, limitsLabel(p.limits)))
+			}
+			idx := selectMenu("Limits for run/python/node (fine-tune with /limits)", append(opts, "← Back"), 0)
+			if idx >= 0 && idx < len(limitPresets) {
+				settings.Limits = limitPresets[idx].limits
+			}
+		case 36:
+			settings.CheckAfterEdits = !settings.CheckAfterEdits
+		case 37:
+			settings.ShadowCommits = !settings.ShadowCommits
+		case 38:
+			settings.WorktreeSessions = !settings.WorktreeSessions
+		}
+		saveSettings()
+	}
+}
+
+// Enable/disable individual built-in tools
+func showToolMatrix() {
+	cursor := 0
+	for {
+		var options []string
+		for _, t := range toolDocs {
+			mark := fmt.Sprintf("%s✓%s", colorGreen, colorReset)
+			if isToolDisabled(t.Name) {
+				mark = fmt.Sprintf("%s✗%s", colorRed, colorReset)
+			}
+			options = append(options, fmt.Sprintf("%s %-8s %s%s%s", mark, t.Name, colorGray, strings.ToLower(t.Group), colorReset))
+		}
+		options = append(options, "← Back")
+
+		cursor = selectMenu("🧰 Tools (disabled tools are hidden from the model)", options, cursor)
+		if cursor == -1 || cursor == len(options)-1 {
+			return
+		}
+		name := toolDocs[cursor].Name
+		if isToolDisabled(name) {
+			var kept []string
+			for _, t := range settings.DisabledTools {
+				if t != name {
+					kept = append(kept, t)
+				}
+			}
+			settings.DisabledTools = kept
+		} else {
+			settings.DisabledTools = append(settings.DisabledTools, name)
 		}
 		saveSettings()
 	}
 }
 
+func valueOrOff(s string) string {
+	if s == "" {
+		return "Off"
+	}
+	return s
+}
+
+func tokensOrOff(tokens int) string {
+	if tokens <= 0 {
+		return "Off"
+	}
+	return fmt.Sprintf("%d tokens", tokens)
+}
+
+func snippetsOrOff(n int) string {
+	if n <= 0 {
+		return "Off"
+	}
+	return fmt.Sprintf("%d per question", n)
+}
+
+func daysOrOff(days int) string {
+	if days <= 0 {
+		return "Off"
+	}
+	return fmt.Sprintf("%dd", days)
+}
+
 func boolToStr(b bool) string {
 	if b {
 		return "On"
@@ -666,16 +1211,116 @@ func loadMCPServers() {
 			{Name: "browser-use", URL: "localhost:3000", Type: "browser", Connected: false, Tools: []string{"browse", "click", "type", "screenshot"}},
 			{Name: "context7", URL: "localhost:3001", Type: "context", Connected: false, Tools: []string{"search_docs", "get_context"}},
 		}
+	} else {
+		json.Unmarshal(data, &mcpServers)
+	}
+	shadowedMCP = nil
+	loadProjectMCPServers()
+}
+
+// Merges servers from <project root>/.mytool/mcp.json; project entries
+// override global ones with the same name, which are kept in shadowedMCP
+// so saving still writes them.
+func loadProjectMCPServers() {
+	root := findProjectRoot()
+	if root == "" {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".mytool", "mcp.json"))
+	if err != nil {
+		return
+	}
+	var project []MCPServer
+	if err := json.Unmarshal(data, &project); err != nil {
+		fmt.Printf("%sInvalid .mytool/mcp.json: %s%s\n", colorYellow, err, colorReset)
 		return
 	}
-	json.Unmarshal(data, &mcpServers)
+	for _, ps := range project {
+		ps.Project = root
+		ps.Connected = false
+		replaced := false
+		for i := range mcpServers {
+			if mcpServers[i].Name == ps.Name {
+				if mcpServers[i].Project == "" {
+					shadowedMCP = append(shadowedMCP, mcpServers[i])
+				}
+				mcpServers[i] = ps
+				replaced = true
+			}
+		}
+		if !replaced {
+			mcpServers = append(mcpServers, ps)
+		}
+	}
 }
 
 func saveMCPServers() {
 	home, _ := os.UserHomeDir()
 	os.MkdirAll(filepath.Join(home, ".mytool"), 0755)
-	data, _ := json.MarshalIndent(mcpServers, "", "  ")
-	os.WriteFile(filepath.Join(home, ".mytool", "mcp_servers.json"), data, 0644)
+	var global []MCPServer
+	for _, s := range mcpServers {
+		if s.Project == "" {
+			global = append(global, s)
+			continue
+		}
+		for _, g := range shadowedMCP {
+			if g.Name == s.Name {
+				global = append(global, g)
+			}
+		}
+	}
+	// Also when its project server was removed from the list
+	for _, g := range shadowedMCP {
+		if !slices.ContainsFunc(global, func(s MCPServer) bool { return s.Name == g.Name }) {
+			global = append(global, g)
+		}
+	}
+	data, _ := json.MarshalIndent(global, "", "  ")
+	updateFile(filepath.Join(home, ".mytool", "mcp_servers.json"), 0644, func([]byte) []byte { return data })
+}
+
+// Project-local servers must be trusted once before they are launched.
+// Trust is keyed by project, name and definition, so edits re-prompt.
+func mcpTrustKey(s MCPServer) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s.Project+"|"+s.Name+"|"+s.URL+"|"+s.Command)))
+}
+
+func isMCPTrusted(s MCPServer) bool {
+	if s.Project == "" {
+		return true
+	}
+	home, _ := os.UserHomeDir()
+	trusted := map[string]string{}
+	if data, err := os.ReadFile(filepath.Join(home, ".mytool", "mcp_trusted.json")); err == nil {
+		json.Unmarshal(data, &trusted)
+	}
+	_, ok := trusted[mcpTrustKey(s)]
+	return ok
+}
+
+func trustMCPServer(s MCPServer) {
+	home, _ := os.UserHomeDir()
+	updateFile(filepath.Join(home, ".mytool", "mcp_trusted.json"), 0644, func(old []byte) []byte {
+		trusted := map[string]string{}
+		json.Unmarshal(old, &trusted)
+		trusted[mcpTrustKey(s)] = s.Project + ": " + s.Name
+		data, _ := json.MarshalIndent(trusted, "", "  ")
+		return data
+	})
+}
+
+func confirmMCPTrust(s MCPServer) bool {
+	target := s.URL
+	if s.Command != "" {
+		target = s.Command
+	}
+	title := fmt.Sprintf("⚠️  Trust project MCP server %q?\n\n  From:   %s\n  Runs:   %s",
+		s.Name, filepath.Join(s.Project, ".mytool", "mcp.json"), target)
+	if selectMenu(title, []string{"Trust and connect", "Cancel"}, 1) != 0 {
+		return false
+	}
+	trustMCPServer(s)
+	return true
 }
 
 func showMCPServers(scanner *bufio.Scanner) {
@@ -687,7 +1332,11 @@ func showMCPServers(scanner *bufio.Scanner) {
 			if server.Connected {
 				status = "●"
 			}
-			options = append(options, fmt.Sprintf("%s %s", status, server.Name))
+			label := fmt.Sprintf("%s %s", status, server.Name)
+			if server.Project != "" {
+				label += " (project)"
+			}
+			options = append(options, label)
 		}
 		options = append(options, "+ Add MCP server")
 		options = append(options, "← Back to chat")
@@ -711,6 +1360,12 @@ func showMCPServers(scanner *bufio.Scanner) {
 			if name == "" {
 				continue
 			}
+			if err := validMCPServerName(name); err != nil {
+				fmt.Printf("%s%s%s\n", colorRed, err, colorReset)
+				fmt.Printf("%sPress Enter to continue%s", colorGray, colorReset)
+				scanner.Scan()
+				continue
+			}
 			
 			fmt.Printf("Server URL: ")
 			if !scanner.Scan() {
@@ -721,14 +1376,39 @@ func showMCPServers(scanner *bufio.Scanner) {
 				continue
 			}
 			
-			mcpServers = append(mcpServers, MCPServer{
+			server := MCPServer{
 				Name:      name,
 				URL:       url,
 				Type:      "custom",
 				Connected: false,
 				Tools:     []string{},
-			})
+			}
+			
+			fmt.Printf("Requires OAuth? [y/N]: ")
+			if scanner.Scan() && strings.ToLower(strings.TrimSpace(scanner.Text())) == "y" {
+				server.Auth = "oauth"
+				fmt.Printf("Client ID (empty = register automatically): ")
+				if scanner.Scan() {
+					server.ClientID = strings.TrimSpace(scanner.Text())
+				}
+				fmt.Printf("Scopes (optional): ")
+				if scanner.Scan() {
+					server.Scopes = strings.TrimSpace(scanner.Text())
+				}
+			}
+			
+			mcpServers = append(mcpServers, server)
 			saveMCPServers()
+			
+			if server.Auth == "oauth" {
+				if err := authenticateMCPServer(server); err != nil {
+					fmt.Printf("%sOAuth failed: %s%s\n", colorRed, err, colorReset)
+				} else {
+					fmt.Printf("%s✓ Authenticated %s%s\n", colorGreen, name, colorReset)
+				}
+				fmt.Printf("%sPress Enter to continue%s", colorGray, colorReset)
+				scanner.Scan()
+			}
 			continue
 		}
 		
@@ -737,7 +1417,10 @@ func showMCPServers(scanner *bufio.Scanner) {
 			serverIdx := choice
 			actions := []string{
 				"Toggle connection",
+				"Manage tools",
 				"Delete server",
+				"Authenticate (OAuth)",
+				"Sign out",
 				"← Back",
 			}
 			
@@ -745,14 +1428,71 @@ func showMCPServers(scanner *bufio.Scanner) {
 			
 			switch actionChoice {
 			case 0: // Toggle
-				mcpServers[serverIdx].Connected = !mcpServers[serverIdx].Connected
+				server := mcpServers[serverIdx]
+				if !server.Connected && !isMCPTrusted(server) && !confirmMCPTrust(server) {
+					continue
+				}
+				if !server.Connected && server.Auth == "oauth" {
+					if _, err := mcpAccessToken(server); err != nil {
+						fmt.Print("\033[H\033[2J")
+						fmt.Printf("%s%s%s\n", colorYellow, err, colorReset)
+						fmt.Printf("%sPress Enter to continue%s", colorGray, colorReset)
+						scanner.Scan()
+						continue
+					}
+				}
+				if server.Connected {
+					mcpDisconnect(serverIdx)
+					saveMCPServers()
+					continue
+				}
+				fmt.Print("\033[H\033[2J")
+				fmt.Printf("Connecting to %s...\n", server.Name)
+				warnings, err := mcpConnect(serverIdx)
+				if err != nil {
+					fmt.Printf("%sConnect failed: %s%s\n", colorRed, err, colorReset)
+				} else {
+					fmt.Printf("%s✓ Connected: %d tools%s\n", colorGreen, len(mcpServers[serverIdx].Tools), colorReset)
+					for _, w := range warnings {
+						fmt.Printf("%s⚠ %s%s\n", colorYellow, w, colorReset)
+					}
+				}
 				saveMCPServers()
-			case 1: // Delete
+				fmt.Printf("%sPress Enter to continue%s", colorGray, colorReset)
+				scanner.Scan()
+			case 1: // Tools
+				manageMCPTools(serverIdx)
+			case 2: // Delete
+				if mcpServers[serverIdx].Project != "" {
+					fmt.Print("\033[H\033[2J")
+					fmt.Printf("%sDefined in %s — edit that file to remove it%s\n", colorYellow,
+						filepath.Join(mcpServers[serverIdx].Project, ".mytool", "mcp.json"), colorReset)
+					fmt.Printf("%sPress Enter to continue%s", colorGray, colorReset)
+					scanner.Scan()
+					continue
+				}
 				confirm := []string{"Yes, delete", "No, cancel"}
 				if selectMenu("Delete "+mcpServers[serverIdx].Name+"?", confirm, 1) == 0 {
+					saveOAuthToken(mcpServers[serverIdx].Name, nil)
+					mcpDisconnect(serverIdx)
 					mcpServers = append(mcpServers[:serverIdx], mcpServers[serverIdx+1:]...)
 					saveMCPServers()
 				}
+			case 3: // OAuth
+				fmt.Print("\033[H\033[2J")
+				mcpServers[serverIdx].Auth = "oauth"
+				saveMCPServers()
+				if err := authenticateMCPServer(mcpServers[serverIdx]); err != nil {
+					fmt.Printf("%sOAuth failed: %s%s\n", colorRed, err, colorReset)
+				} else {
+					fmt.Printf("%s✓ Authenticated %s%s\n", colorGreen, mcpServers[serverIdx].Name, colorReset)
+				}
+				fmt.Printf("%sPress Enter to continue%s", colorGray, colorReset)
+				scanner.Scan()
+			case 4: // Sign out
+				saveOAuthToken(mcpServers[serverIdx].Name, nil)
+				mcpDisconnect(serverIdx)
+				saveMCPServers()
 			}
 		}
 	}
@@ -769,7 +1509,9 @@ func getMCPTools() []string {
 	for _, server := range mcpServers {
 		if server.Connected {
 			for _, tool := range server.Tools {
-				tools = append(tools, fmt.Sprintf("%s:%s", server.Name, tool))
+				if !isMCPToolDisabled(server, tool) {
+					tools = append(tools, fmt.Sprintf("%s.%s", server.Name, tool))
+				}
 			}
 		}
 	}
@@ -778,178 +1520,683 @@ func getMCPTools() []string {
 
 // ==================== SESSIONS ====================
 
+func currentSession(history []ChatMessage, state string) *Session {
+	return &Session{
+		ID:          sessionID,
+		Name:        sessionName,
+		Title:       sessionTitle,
+		Summary:     sessionSummary,
+		Tags:        sessionTags,
+		Parent:      sessionParent,
+		ForkedAt:    sessionForkedAt,
+		Dir:         currentDir,
+		Mode:        currentMode,
+		History:     plainHistory(history),
+		Tokens:      totalTokens,
+		Cost:        totalCost,
+		Facts:       sessionMemory,
+		Updated:     time.Now(),
+		Checkpoints: checkpoints,
+		Originals:   originalFiles,
+		Vars:        sessionVars,
+		PinnedFiles: pinnedFiles,
+		Undo:        undoRecords(undoStack),
+		Snapshots:   turnSnapshots,
+		FirstSnap:   sessionSnapshot,
+		Ledger:      usageLedger,
+		Turns:       turnStats,
+		State:       state,
+	}
+}
+
 func saveSession(history []ChatMessage) {
-	home, _ := os.UserHomeDir()
-	sessionDir := filepath.Join(home, ".mytool", "sessions")
-	os.MkdirAll(sessionDir, 0755)
-
-	session := Session{
-		ID:      sessionID,
-		Dir:     currentDir,
-		Mode:    currentMode,
-		History: history,
-		Tokens:  totalTokens,
-		Cost:    totalCost,
-		Memory:  memory,
-		Updated: time.Now(),
-	}
-
-	data, _ := json.MarshalIndent(session, "", "  ")
-	os.WriteFile(filepath.Join(sessionDir, sessionID+".json"), data, 0644)
+	refreshSessionTitle(history, true)
+	if err := storeSession(currentSession(history, sessionActive)); err != nil {
+		fmt.Printf("%sSave failed: %s%s\n", colorRed, err, colorReset)
+		return
+	}
 	fmt.Printf("%s✓ Session saved: %s%s\n", colorGreen, sessionID, colorReset)
 }
 
-func loadSession(id string) (*Session, error) {
-	home, _ := os.UserHomeDir()
-	data, err := os.ReadFile(filepath.Join(home, ".mytool", "sessions", id+".json"))
-	if err != nil {
-		return nil, err
+// Quiet save after every assistant turn so a crash loses nothing
+func autoSaveSession(history []ChatMessage) {
+	if len(history) < 2 {
+		return
+	}
+	refreshSessionTitle(history, false)
+	if err := storeSession(currentSession(history, sessionActive)); err != nil {
+		fmt.Printf("%sAutosave failed: %s%s\n", colorGray, err, colorReset)
 	}
-	var session Session
-	json.Unmarshal(data, &session)
-	return &session, nil
 }
 
-func resumeSession() {
-	home, _ := os.UserHomeDir()
-	sessionDir := filepath.Join(home, ".mytool", "sessions")
-	
-	// Find most recent session for this directory
-	entries, _ := os.ReadDir(sessionDir)
-	var latest *Session
-	var latestTime time.Time
-	
-	for _, e := range entries {
-		if s, err := loadSession(strings.TrimSuffix(e.Name(), ".json")); err == nil {
-			if s.Dir == currentDir && s.Updated.After(latestTime) {
-				latest = s
-				latestTime = s.Updated
+// Final save on a clean exit; clears the active state
+func closeSession(history []ChatMessage) {
+	if len(history) < 2 {
+		return
+	}
+	refreshSessionTitle(history, true)
+	storeSession(currentSession(history, ""))
+	if settings.CloudSync {
+		if pushed, _, err := runSync("push"); err != nil {
+			fmt.Printf("%sCloud sync failed: %s%s\n", colorYellow, err, colorReset)
+		} else if pushed > 0 {
+			fmt.Printf("%s☁ Pushed %d item(s)%s\n", colorGray, pushed, colorReset)
+		}
+	}
+}
+
+// mytool resume [<id|prefix|name> | --last]; no argument opens a picker
+func resumeSession(args []string) {
+	if len(args) > 0 && args[0] != "--last" {
+		id, err := resolveSessionID(args[0])
+		if err == nil {
+			var s *Session
+			if s, err = loadSession(id); err == nil {
+				restoreSession(s)
+				return
 			}
 		}
+		fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+		return
+	}
+	
+	if len(args) == 0 {
+		if s := pickSession(); s != nil {
+			restoreSession(s)
+		}
+		return
 	}
 	
-	if latest == nil {
+	// Find most recent session for this directory
+	latest, err := latestProjectSession(currentDir)
+	if err != nil {
 		fmt.Printf("%sNo session found for this directory%s\n", colorYellow, colorReset)
 		runChat([]string{})
 		return
 	}
-	
-	sessionID = latest.ID
-	currentMode = latest.Mode
-	totalTokens = latest.Tokens
-	totalCost = latest.Cost
-	memory = latest.Memory
-	
-	fmt.Printf("%s✓ Resumed: %s (%d msgs)%s\n", colorGreen, sessionID, len(latest.History), colorReset)
-	runChatWithHistory(latest.History)
+	restoreSession(latest)
 }
 
-func listSessions() {
-	home, _ := os.UserHomeDir()
-	sessionDir := filepath.Join(home, ".mytool", "sessions")
-	entries, err := os.ReadDir(sessionDir)
-	if err != nil || len(entries) == 0 {
+// Arrow-key session picker; sessions for the current project come first
+func pickSession() *Session {
+	rows, err := listSessionRows(sessionFilter{Limit: 50})
+	if err != nil || len(rows) == 0 {
 		fmt.Println("No sessions found")
-		return
+		return nil
 	}
+	project := sessionProject(currentDir)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Project == project && rows[j].Project != project
+	})
 	
-	fmt.Printf("%sSessions:%s\n", colorCyan, colorReset)
-	for _, e := range entries {
-		if s, err := loadSession(strings.TrimSuffix(e.Name(), ".json")); err == nil {
-			age := time.Since(s.Updated).Round(time.Minute)
-			fmt.Printf("  %s%s%s  %s  %d msgs  %s ago\n",
-				colorYellow, s.ID, colorReset, truncate(s.Dir, 30), len(s.History), age)
+	var options []string
+	for _, r := range rows {
+		title := r.Name
+		if title == "" {
+			title = r.Title
+		}
+		if title == "" {
+			if s, err := loadSession(r.ID); err == nil {
+				title = sessionLabel(s)
+			}
 		}
+		options = append(options, fmt.Sprintf("%-8s  %-40s  %-30s  %3d msgs  %s ago",
+			r.ID, truncate(title, 40), truncate(r.Dir, 30), r.Messages, time.Since(r.Updated).Round(time.Minute)))
+	}
+	options = append(options, "← Cancel")
+	
+	choice := selectMenu("📂 Resume session", options, 0)
+	fmt.Print("\033[H\033[2J")
+	if choice == -1 || choice == len(options)-1 {
+		return nil
+	}
+	s, err := loadSession(rows[choice].ID)
+	if err != nil {
+		fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+		return nil
 	}
+	return s
 }
 
-// ==================== EXPORT ====================
-
-func exportChat(filename string) {
-	if filename == "" {
-		filename = fmt.Sprintf("chat_%s_%s.md", sessionID, time.Now().Format("20060102_150405"))
+func restoreSession(s *Session) {
+	sessionID = s.ID
+	sessionName = s.Name
+	sessionTags = s.Tags
+	sessionParent = s.Parent
+	sessionForkedAt = s.ForkedAt
+	sessionTitle = s.Title
+	sessionSummary = s.Summary
+	summarizedLen = len(s.History)
+	currentMode = s.Mode
+	totalTokens = s.Tokens
+	totalCost = s.Cost
+	sessionMemory = s.Facts
+	if sessionMemory == nil {
+		sessionMemory = map[string]string{}
 	}
-	
-	if chatExportFile == "" {
-		fmt.Printf("%sNo chat to export%s\n", colorYellow, colorReset)
-		return
+	checkpoints = s.Checkpoints
+	if s.Originals != nil {
+		originalFiles = s.Originals
+	}
+	if s.Vars != nil {
+		sessionVars = s.Vars
+	}
+	pinnedFiles, pinnedModTime = s.PinnedFiles, map[string]time.Time{}
+	undoStack, redoStack = restoreUndo(s.Undo), nil
+	turnSnapshots, sessionSnapshot = s.Snapshots, s.FirstSnap
+	restoreLedger(s.Ledger)
+	turnStats = s.Turns
+
+	// Two instances writing one session would overwrite each other's turns
+	if pid := sessionOwner(s.ID); pid != 0 {
+		sessionParent, sessionForkedAt = s.ID, len(s.History)-1
+		sessionID = generateSessionID()
+		fmt.Printf("%s⚠ %s is open in another mytool (pid %d); continuing as fork %s%s\n",
+			colorYellow, s.ID, pid, sessionID, colorReset)
 	}
 	
-	os.WriteFile(filename, []byte(chatExportFile), 0644)
-	fmt.Printf("%s✓ Exported: %s%s\n", colorGreen, filename, colorReset)
-}
-
-func appendToExport(role, content string) {
-	chatExportFile += fmt.Sprintf("\n## %s\n%s\n", role, content)
+	fmt.Printf("%s✓ Resumed: %s (%d msgs)%s\n", colorGreen, sessionID, len(s.History), colorReset)
+	runChatWithHistory(s.History)
 }
 
-// ==================== CODE EXECUTION ====================
-
-func runPython(code string) string {
-	tmpFile := filepath.Join(os.TempDir(), "mytool_py.py")
-	os.WriteFile(tmpFile, []byte(code), 0644)
-	defer os.Remove(tmpFile)
-	
-	cmd := exec.Command("python3", tmpFile)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Sprintf("%s%s\n%s%s", string(output), colorRed, err, colorReset)
+// Generated title, else the first user message
+func sessionLabel(s *Session) string {
+	if s.Title != "" {
+		return s.Title
 	}
-	return string(output)
-}
-
-func runNode(code string) string {
-	tmpFile := filepath.Join(os.TempDir(), "mytool_js.js")
-	os.WriteFile(tmpFile, []byte(code), 0644)
-	defer os.Remove(tmpFile)
-	
-	cmd := exec.Command("node", tmpFile)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Sprintf("%s%s\n%s%s", string(output), colorRed, err, colorReset)
+	for _, m := range s.History {
+		if m.Role == "user" {
+			return truncate(strings.Join(strings.Fields(m.Content), " "), 50)
+		}
 	}
-	return string(output)
+	return "(empty)"
 }
 
-// ==================== IMAGE ANALYSIS ====================
-
-func analyzeImage(path string) string {
-	fullPath := resolvePath(path)
-	
-	data, err := os.ReadFile(fullPath)
-	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+// Offers the most recent session for this directory when starting fresh
+func offerAutoResume(scanner *bufio.Scanner) *Session {
+	if settings.AutoResumeHours <= 0 {
+		return nil
 	}
-	
-	// Check file size
-	if len(data) > 5*1024*1024 {
-		return "Error: Image too large (max 5MB)"
+	latest, err := latestProjectSession(currentDir)
+	if err != nil || len(latest.History) < 2 {
+		return nil
 	}
-	
-	// Get mime type
-	ext := strings.ToLower(filepath.Ext(path))
-	mimeTypes := map[string]string{
-		".jpg": "image/jpeg", ".jpeg": "image/jpeg",
-		".png": "image/png", ".gif": "image/gif", ".webp": "image/webp",
+	age := time.Since(latest.Updated)
+	if age > time.Duration(settings.AutoResumeHours)*time.Hour {
+		return nil
 	}
-	mime, ok := mimeTypes[ext]
-	if !ok {
-		return "Error: Unsupported image format"
+	fmt.Printf("%sResume last session?%s %s%s%s \"%s\" (%s ago, %d msgs) [Y/n] ",
+		colorCyan, colorReset, colorYellow, latest.ID, colorReset,
+		sessionLabel(latest), age.Round(time.Minute), len(latest.History))
+	if !scanner.Scan() {
+		return nil
 	}
-	
-	b64 := base64.StdEncoding.EncodeToString(data)
-	return fmt.Sprintf("Image loaded: %s (%s, %d bytes)\nBase64: %s...%s",
-		fullPath, mime, len(data), b64[:50], b64[len(b64)-20:])
+	if answer := strings.ToLower(strings.TrimSpace(scanner.Text())); answer != "" && answer != "y" {
+		return nil
+	}
+	return latest
 }
 
-// ==================== WEB SEARCH ====================
-
+// mytool sessions [rm <id>... | prune --older-than 30d --max-size 50MB |
+// stats [id] | export <id> --out f | import <file> | list flags]
+func runSessionsCmd(args []string) {
+	if len(args) == 0 {
+		listSessions(nil)
+		return
+	}
+	switch args[0] {
+	case "rm", "delete":
+		if len(args) < 2 {
+			fmt.Println("Usage: mytool sessions rm <id> [id...]")
+			return
+		}
+		for _, id := range args[1:] {
+			if deleted, err := deleteSession(id); err != nil {
+				fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			} else {
+				fmt.Printf("%s✓ Deleted %s%s\n", colorGreen, deleted, colorReset)
+			}
+		}
+	case "prune":
+		var olderThan time.Duration
+		var maxBytes int64
+		var err error
+		for i := 1; i < len(args); i++ {
+			switch {
+			case args[i] == "--older-than" && i+1 < len(args):
+				olderThan, err = parseAge(args[i+1])
+				i++
+			case args[i] == "--max-size" && i+1 < len(args):
+				maxBytes, err = parseSizeArg(args[i+1])
+				i++
+			}
+			if err != nil {
+				fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+				return
+			}
+		}
+		if olderThan == 0 && maxBytes == 0 {
+			fmt.Println("Usage: mytool sessions prune --older-than 30d [--max-size 50MB]")
+			return
+		}
+		n, err := pruneSessions(olderThan, maxBytes)
+		if err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		fmt.Printf("%s✓ Pruned %d sessions%s\n", colorGreen, n, colorReset)
+	case "stats":
+		ref := "--last"
+		if len(args) > 1 {
+			ref = args[1]
+		}
+		var s *Session
+		var err error
+		if ref == "--last" {
+			s, err = latestProjectSession(currentDir)
+		} else if id, rerr := resolveSessionID(ref); rerr != nil {
+			err = rerr
+		} else {
+			s, err = loadSession(id)
+		}
+		if err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		printSessionStats(s)
+	case "export":
+		ref, out := "", ""
+		var redact *RedactionProfile
+		for i := 1; i < len(args); i++ {
+			switch {
+			case args[i] == "--out" && i+1 < len(args):
+				out = args[i+1]
+				i++
+			case args[i] == "--redact" && i+1 < len(args):
+				p, ok := redactionProfile(args[i+1])
+				if !ok {
+					fmt.Printf("%sUnknown redaction profile %q%s\n", colorRed, args[i+1], colorReset)
+					return
+				}
+				redact = &p
+				i++
+			default:
+				ref = args[i]
+			}
+		}
+		if ref == "" {
+			fmt.Println("Usage: mytool sessions export <id> [--out file.json] [--redact profile]")
+			return
+		}
+		path, err := exportSessionBundle(ref, out, redact)
+		if err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		fmt.Printf("%s✓ Exported to %s%s\n", colorGreen, path, colorReset)
+	case "import":
+		if len(args) < 2 {
+			fmt.Println("Usage: mytool sessions import <file.json> [file...]")
+			return
+		}
+		for _, path := range args[1:] {
+			id, err := importSessionBundle(path)
+			if err != nil {
+				fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+				continue
+			}
+			fmt.Printf("%s✓ Imported %s as %s%s (mytool resume %s)\n", colorGreen, path, id, colorReset, id)
+		}
+	default:
+		listSessions(args)
+	}
+}
+
+// Durations like 30d, 2w, 12h, 90m
+func parseAge(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	n := parseInt(s[:len(s)-1])
+	switch s[len(s)-1] {
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, nil
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Sizes like 500KB, 50MB, 1GB
+func parseSizeArg(s string) (int64, error) {
+	units := map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "B": 1}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	for _, suffix := range []string{"KB", "MB", "GB", "K", "M", "G", "B"} {
+		if strings.HasSuffix(upper, suffix) {
+			if n := parseInt(strings.TrimSuffix(upper, suffix)); n > 0 {
+				return int64(n) * units[suffix], nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q", s)
+}
+
+// Lists the current project's sessions unless --all or --dir is given
+func listSessions(args []string) {
+	filter := sessionFilter{Limit: 100}
+	query, all := "", false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--all":
+			all = true
+		case args[i] == "--search" && i+1 < len(args):
+			query = args[i+1]
+			i++
+		case args[i] == "--tag" && i+1 < len(args):
+			filter.Tag = args[i+1]
+			i++
+		case args[i] == "--dir" && i+1 < len(args):
+			filter.Dir = resolvePath(args[i+1])
+			i++
+		}
+	}
+	if !all && filter.Dir == "" {
+		filter.Project = sessionProject(currentDir)
+	}
+	
+	var rows []sessionRow
+	var err error
+	if query != "" {
+		filter.Limit = 20
+		rows, err = searchSessions(query, filter)
+	} else {
+		rows, err = listSessionRows(filter)
+	}
+	if err != nil {
+		fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+		return
+	}
+	if len(rows) == 0 {
+		fmt.Println("No sessions found")
+		if filter.Project != "" {
+			fmt.Printf("%sOnly %s is shown; use --all for every project%s\n", colorGray, filter.Project, colorReset)
+		}
+		return
+	}
+	
+	if filter.Project != "" {
+		fmt.Printf("%sSessions in %s:%s\n", colorCyan, filter.Project, colorReset)
+	} else {
+		fmt.Printf("%sSessions:%s\n", colorCyan, colorReset)
+	}
+	for _, r := range rows {
+		age := time.Since(r.Updated).Round(time.Minute)
+		where := r.Dir
+		if filter.Project != "" {
+			if rel, err := filepath.Rel(filter.Project, r.Dir); err == nil && rel != "." {
+				where = rel
+			} else {
+				where = "."
+			}
+		}
+		fmt.Printf("  %s  %s  %d msgs  %s ago\n",
+			sessionDisplayName(r.ID, r.Name, r.Tags), truncate(where, 30), r.Messages, age)
+		if r.Title != "" && r.Title != r.Name {
+			fmt.Printf("      %s%s%s\n", colorBold, r.Title, colorReset)
+		}
+		if r.Summary != "" {
+			fmt.Printf("      %s%s%s\n", colorGray, truncate(r.Summary, 140), colorReset)
+		}
+		if r.State == sessionRecovered {
+			fmt.Printf("      %s⚠ recovered after a crash%s\n", colorYellow, colorReset)
+		}
+		if r.Parent != "" {
+			fmt.Printf("      %s↳ fork of %s%s\n", colorGray, r.Parent, colorReset)
+		}
+		if r.Snippet != "" {
+			fmt.Printf("      %s%s%s\n", colorGray, strings.Join(strings.Fields(r.Snippet), " "), colorReset)
+		}
+	}
+}
+
+// /fork [n]: saves the current session, then continues in a new one that
+// keeps the first n messages (all by default). The original is untouched.
+func forkSession(arg string, history []ChatMessage) ([]ChatMessage, string) {
+	var convo []int // indexes of non-system messages
+	for i, m := range history {
+		if m.Role != "system" {
+			convo = append(convo, i)
+		}
+	}
+	n := len(convo)
+	if arg != "" {
+		n = parseInt(arg)
+		if n < 1 || n > len(convo) {
+			var b strings.Builder
+			b.WriteString(fmt.Sprintf("Usage: /fork [1-%d]\n", len(convo)))
+			for i, idx := range convo {
+				b.WriteString(fmt.Sprintf("  %s%3d%s %-9s %s\n", colorYellow, i+1, colorReset,
+					history[idx].Role, truncate(strings.Join(strings.Fields(history[idx].Content), " "), 60)))
+			}
+			return history, strings.TrimSuffix(b.String(), "\n")
+		}
+	}
+	
+	storeSession(currentSession(history, "")) // the parent is done with
+	parent := sessionID
+	cut := len(history)
+	if n < len(convo) {
+		cut = convo[n]
+	}
+	forked := append([]ChatMessage{}, history[:cut]...)
+	
+	sessionID = generateSessionID()
+	sessionParent = parent
+	sessionForkedAt = n
+	sessionTitle, sessionSummary, summarizedLen = "", "", 0
+	if sessionName != "" {
+		sessionName += "-fork"
+	}
+	saveSession(forked)
+	return forked, fmt.Sprintf("%s✓ Forked %s → %s at message %d%s\n%sOriginal kept: mytool resume %s%s",
+		colorGreen, parent, sessionID, n, colorReset, colorGray, parent, colorReset)
+}
+
+// "fix-auth-bug [backend] (1a2b3c4d)", or just the ID when unnamed
+func sessionDisplayName(id, name string, tags []string) string {
+	label := fmt.Sprintf("%s%s%s", colorYellow, id, colorReset)
+	if name != "" {
+		label = fmt.Sprintf("%s%s%s %s(%s)%s", colorYellow, name, colorReset, colorGray, id, colorReset)
+	}
+	if len(tags) > 0 {
+		label += fmt.Sprintf(" %s[%s]%s", colorBlue, strings.Join(tags, ", "), colorReset)
+	}
+	return label
+}
+
+// /rename <name>, /tag <t>..., /untag <t>...
+func cmdSessionMeta(cmd, arg string) string {
+	switch cmd {
+	case "/rename":
+		if arg == "" {
+			return "Usage: /rename <name>"
+		}
+		sessionName = arg
+	case "/tag":
+		if arg == "" {
+			if len(sessionTags) == 0 {
+				return "No tags. Usage: /tag <tag> [tag...]"
+			}
+			return "Tags: " + strings.Join(sessionTags, ", ")
+		}
+		for _, t := range strings.Fields(arg) {
+			t = strings.Trim(t, ",")
+			exists := false
+			for _, existing := range sessionTags {
+				if existing == t {
+					exists = true
+				}
+			}
+			if t != "" && !exists {
+				sessionTags = append(sessionTags, t)
+			}
+		}
+	case "/untag":
+		var kept []string
+		for _, existing := range sessionTags {
+			if !strings.Contains(" "+arg+" ", " "+existing+" ") {
+				kept = append(kept, existing)
+			}
+		}
+		sessionTags = kept
+	}
+	label := sessionDisplayName(sessionID, sessionName, sessionTags)
+	if updateSessionMeta(sessionID, sessionName, sessionTags) {
+		return fmt.Sprintf("%s✓%s %s", colorGreen, colorReset, label)
+	}
+	return fmt.Sprintf("%s✓%s %s %s(stored on /save)%s", colorGreen, colorReset, label, colorGray, colorReset)
+}
+
+// Wrap-up screen printed on exit
+func printSessionSummary() {
+	fmt.Printf("%s─── Session summary ───%s\n", colorCyan, colorReset)
+	fmt.Printf("  Duration:  %s\n", time.Since(sessionStart).Round(time.Second))
+	fmt.Printf("  Turns:     %d\n", turnCount)
+
+	if len(toolCounts) > 0 {
+		var names []string
+		total := 0
+		for name, n := range toolCounts {
+			names = append(names, fmt.Sprintf("%s×%d", name, n))
+			total += n
+		}
+		sort.Strings(names)
+		fmt.Printf("  Tools:     %d (%s)\n", total, strings.Join(names, ", "))
+	}
+
+	if len(originalFiles) > 0 {
+		var paths []string
+		for p := range originalFiles {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		fmt.Printf("  Files:     %d changed\n", len(paths))
+		for _, p := range paths {
+			before := ""
+			if originalFiles[p] != nil {
+				before = *originalFiles[p]
+			}
+			after := ""
+			if data, err := os.ReadFile(p); err == nil {
+				after = string(data)
+			}
+			added, removed := diffStat(before, after)
+			rel, err := filepath.Rel(currentDir, p)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				rel = p
+			}
+			fmt.Printf("    %s %s+%d%s %s-%d%s\n", rel, colorGreen, added, colorReset, colorRed, removed, colorReset)
+		}
+	}
+
+	fmt.Printf("  Tokens:    %d  Cost: $%.4f\n", totalTokens, totalCost)
+	if _, err := loadSession(sessionID); err == nil {
+		fmt.Printf("  Session:   %s%s%s  (resume: mytool resume)\n", colorYellow, sessionID, colorReset)
+	} else {
+		fmt.Printf("  Session:   %s%s%s  %s(not saved — use /save to resume later)%s\n", colorYellow, sessionID, colorReset, colorGray, colorReset)
+	}
+}
+
+// Line-based added/removed counts (order-insensitive, like a quick --stat)
+func diffStat(before, after string) (int, int) {
+	counts := make(map[string]int)
+	if before != "" {
+		for _, l := range strings.Split(before, "\n") {
+			counts[l]--
+		}
+	}
+	if after != "" {
+		for _, l := range strings.Split(after, "\n") {
+			counts[l]++
+		}
+	}
+	added, removed := 0, 0
+	for _, n := range counts {
+		if n > 0 {
+			added += n
+		} else {
+			removed -= n
+		}
+	}
+	return added, removed
+}
+
+// ==================== EXPORT ====================
+
+// History is stored as plain text; colors are applied only when rendering
+func plainHistory(history []ChatMessage) []ChatMessage {
+	plain := make([]ChatMessage, len(history))
+	for i, m := range history {
+		plain[i] = ChatMessage{Role: m.Role, Content: stripANSI(m.Content), Pinned: m.Pinned}
+	}
+	return plain
+}
+
+// ==================== CODE EXECUTION ====================
+
+func runPython(code string) string {
+	return runScript("python3", ".py", code, "python3", "-")
+}
+
+func runNode(code string) string {
+	return runScript("node", ".js", code, "node", "-")
+}
+
+// ==================== IMAGE ANALYSIS ====================
+
+func analyzeImage(path string) string {
+	fullPath := resolvePath(path)
+	
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	
+	// Check file size
+	if len(data) > 5*1024*1024 {
+		return "Error: Image too large (max 5MB)"
+	}
+	
+	// Get mime type
+	ext := strings.ToLower(filepath.Ext(path))
+	mimeTypes := map[string]string{
+		".jpg": "image/jpeg", ".jpeg": "image/jpeg",
+		".png": "image/png", ".gif": "image/gif", ".webp": "image/webp",
+	}
+	mime, ok := mimeTypes[ext]
+	if !ok {
+		return "Error: Unsupported image format"
+	}
+	
+	b64 := base64.StdEncoding.EncodeToString(data)
+	return fmt.Sprintf("Image loaded: %s (%s, %d bytes)\nBase64: %s...%s",
+		fullPath, mime, len(data), b64[:50], b64[len(b64)-20:])
+}
+
+// ==================== WEB SEARCH ====================
+
 func webSearch(query string) string {
+	return cachedWeb("search", query, func() (string, bool) {
+		out := duckDuckGoSearch(query)
+		return out, !strings.HasPrefix(out, "Search error:")
+	})
+}
+
+func duckDuckGoSearch(query string) string {
 	// Using DuckDuckGo instant answers API (free, no auth needed)
 	url := fmt.Sprintf("https://api.duckduckgo.com/?q=%s&format=json&no_html=1", strings.ReplaceAll(query, " ", "+"))
 	
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
+	resp, err := politeGet(url, 10*time.Second, false)
 	if err != nil {
 		return fmt.Sprintf("Search error: %s", err)
 	}
@@ -986,77 +2233,150 @@ func webSearch(query string) string {
 // ==================== CLIPBOARD ====================
 
 func copyToClipboard(text string) string {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = exec.Command("pbcopy")
-	case "linux":
-		cmd = exec.Command("xclip", "-selection", "clipboard")
-	default:
-		return "Clipboard not supported on this OS"
+	var tried []string
+	for _, args := range clipboardCommands() {
+		tried = append(tried, args[0])
+		if !haveBinary(args[0]) {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		return fmt.Sprintf("%s✓ Copied to clipboard (%d chars)%s", colorGreen, len(text), colorReset)
 	}
-	
-	cmd.Stdin = strings.NewReader(text)
-	if err := cmd.Run(); err != nil {
-		return fmt.Sprintf("Error: %s", err)
+	if settings.NativeFallback {
+		osc52Copy(text)
+		return fmt.Sprintf("%s✓ Sent to the terminal clipboard (%d chars)%s %s(no %s found; needs OSC 52 support)%s",
+			colorGreen, len(text), colorReset, colorGray, strings.Join(tried, "/"), colorReset)
 	}
-	return fmt.Sprintf("%s✓ Copied to clipboard (%d chars)%s", colorGreen, len(text), colorReset)
+	return toolUnavailable(strings.Join(tried, " or "), true)
 }
 
 // ==================== FILE OPERATIONS ====================
 
+// Asks a y/N question. In mcp-serve mode stdin carries the protocol, so
+// the answer is read from the controlling terminal (denied if there is none).
+func confirmAction(prompt string) bool {
+	return strings.ToLower(readAnswer(prompt+" [y/N] ")) == "y"
+}
+
+// One line of input after prompt, trimmed; "" when there is no terminal
+func readAnswer(prompt string) string {
+	line, _ := readInputLine(prompt)
+	return strings.TrimSpace(line)
+}
+
+// One line as typed, without its line ending; false at end of input
+func readInputLine(prompt string) (string, bool) {
+	in := io.Reader(os.Stdin)
+	if serveMode {
+		tty, err := openTerminal()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sCan't ask for approval, no terminal (%s): denied. Use --mode auto or manual when serving without one.%s\n", colorRed, err, colorReset)
+			return "", false
+		}
+		defer tty.Close()
+		in = tty
+	}
+	fmt.Print(prompt)
+	reader := bufio.NewReader(in)
+	line, err := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err == nil
+}
+
 func saveForUndo(path, desc string) {
+	action := undoSnapshot(path)
+	action.Op = desc
+	pushUndo(action)
+}
+
+// Records a new change; whatever was undone before can no longer be redone
+func pushUndo(action UndoAction) {
+	undoStack = appendUndo(undoStack, action)
+	redoStack = nil
+}
+
+// Current content of path as an undo step; also remembers the original
+func undoSnapshot(path string) UndoAction {
 	fullPath := resolvePath(path)
+	info, statErr := os.Stat(fullPath)
+	if statErr == nil && info.IsDir() {
+		return UndoAction{Type: "dir", Path: fullPath, Mode: info.Mode().Perm(), Time: time.Now()}
+	}
 	content := ""
 	if data, err := os.ReadFile(fullPath); err == nil {
 		content = string(data)
 	}
-	undoStack = append(undoStack, UndoAction{
-		Type: "file", Path: fullPath, Content: content, Time: time.Now(),
-	})
-	if len(undoStack) > 20 {
-		undoStack = undoStack[1:]
-	}
-}
-
-func doUndo() string {
-	if len(undoStack) == 0 {
-		return "Nothing to undo"
+	if _, seen := originalFiles[fullPath]; !seen {
+		if statErr == nil {
+			orig := content
+			originalFiles[fullPath] = &orig
+		} else {
+			originalFiles[fullPath] = nil
+		}
 	}
-	action := undoStack[len(undoStack)-1]
-	undoStack = undoStack[:len(undoStack)-1]
-	
-	if action.Content == "" {
-		os.Remove(action.Path)
-		return fmt.Sprintf("%s✓ Undone: removed %s%s", colorGreen, action.Path, colorReset)
+	action := UndoAction{Type: "file", Path: fullPath, Content: content, Time: time.Now()}
+	if statErr == nil {
+		action.Mode = info.Mode().Perm()
 	}
-	os.WriteFile(action.Path, []byte(action.Content), 0644)
-	return fmt.Sprintf("%s✓ Undone: restored %s%s", colorGreen, action.Path, colorReset)
+	return action
 }
 
 func cmdRead(path string) string {
 	if path == "" {
-		return "Usage: /read <file>"
+		return "Usage: /read <file>[:from-to | #symbol]"
 	}
+	path, force := strings.CutSuffix(path, " --force")
+	path, from, to, symbol := parseReadSpec(strings.TrimSpace(path))
 	fullPath := resolvePath(path)
 	data, err := os.ReadFile(fullPath)
 	if err != nil {
 		return fmt.Sprintf("Error: %s", err)
 	}
+	content, format := decodeText(data)
+	if reason := unreadableReason([]byte(content), 0); reason != "" && !force {
+		return fileSummary(fullPath, data, reason)
+	}
+	noteSeen(fullPath, data)
+	title := fullPath
+	if format.String() != "" {
+		title += " [" + format.String() + "]"
+	}
 	
-	content := string(data)
 	lines := strings.Split(content, "\n")
 	ext := strings.TrimPrefix(filepath.Ext(path), ".")
 	
 	var result strings.Builder
-	result.WriteString(fmt.Sprintf("%s─── %s (%d lines) ───%s\n", colorCyan, fullPath, len(lines), colorReset))
+	limit := readMaxLines
+	switch {
+	case symbol != "":
+		if from, to, err = symbolRange(fullPath, content, symbol); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		result.WriteString(fmt.Sprintf("%s─── %s#%s: lines %d-%d of %d ───%s\n", colorCyan, title, symbol, from, to, len(lines), colorReset))
+		limit = readRangeMaxLines
+	case from > 0:
+		if from > len(lines) {
+			return fmt.Sprintf("Error: %s has %d lines", path, len(lines))
+		}
+		if to == 0 || to > len(lines) {
+			to = min(len(lines), from+readMaxLines-1)
+		}
+		result.WriteString(fmt.Sprintf("%s─── %s: lines %d-%d of %d ───%s\n", colorCyan, title, from, to, len(lines), colorReset))
+		limit = readRangeMaxLines
+	default:
+		from, to = 1, len(lines)
+		result.WriteString(fmt.Sprintf("%s─── %s (%d lines) ───%s\n", colorCyan, title, len(lines), colorReset))
+	}
 	
-	for i, line := range lines {
-		if i >= 200 {
-			result.WriteString(fmt.Sprintf("%s... +%d more lines%s\n", colorGray, len(lines)-200, colorReset))
+	for i := from - 1; i < to; i++ {
+		if i-from+1 >= limit {
+			result.WriteString(fmt.Sprintf("%s... +%d more lines (read:%s:%d-%d)%s\n", colorGray, to-i, path, i+1, min(to, i+limit), colorReset))
 			break
 		}
-		hl := highlightCode(line, ext)
+		hl := highlightCode(lines[i], ext)
 		result.WriteString(fmt.Sprintf("%s%4d│%s %s\n", colorGray, i+1, colorReset, hl))
 	}
 	
@@ -1120,30 +2440,40 @@ func getFileIcon(name string) string {
 }
 
 func cmdRun(command string) string {
+	timeout, command, err := parseRunTimeout(command)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
 	if command == "" {
-		return "Usage: /run <command>"
+		return "Usage: /run [--timeout 5m] <command>"
+	}
+	if msg := policyBlock(command); msg != "" {
+		return msg
+	}
+	if msg := confineBlock(command); msg != "" {
+		return msg
 	}
 	if currentMode == ModeManual {
 		return fmt.Sprintf("%s[blocked] Manual mode%s", colorRed, colorReset)
 	}
 	if currentMode == ModeAsk {
-		fmt.Printf("%sRun:%s %s [y/N] ", colorYellow, colorReset, command)
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
-		if strings.ToLower(strings.TrimSpace(input)) != "y" {
+		if !confirmAction(fmt.Sprintf("%sRun:%s %s", colorYellow, colorReset, command)) {
 			return "Cancelled"
 		}
 	}
 	
 	fmt.Printf("%s$ %s%s\n", colorGray, command, colorReset)
+	if cmdline, bg := backgroundCommand(command); bg {
+		return startJob(cmdline)
+	}
+	if sandboxConfig().Enabled {
+		return sandboxRun("", timeout, "sh", "-c", command)
+	}
 	cmd := exec.Command("sh", "-c", command)
 	cmd.Dir = currentDir
-	output, err := cmd.CombinedOutput()
-	result := string(output)
-	if err != nil {
-		result += fmt.Sprintf("\n%sExit: %s%s", colorRed, err, colorReset)
-	}
-	return result
+	cmd.Env = childEnv()
+	applyLimits(cmd, true)
+	return runStreamed(cmd, timeout, nil)
 }
 
 func cmdCd(path string) string {
@@ -1154,8 +2484,17 @@ func cmdCd(path string) string {
 	if info, err := os.Stat(newPath); err != nil || !info.IsDir() {
 		return "Error: not a directory"
 	}
+	if msg := cdAllowed(newPath); msg != "" {
+		return msg
+	}
+	oldRoot := findProjectRoot()
 	currentDir = newPath
 	detectProject()
+	if findProjectRoot() != oldRoot {
+		loadMCPServers()
+		loadProjectMemory()
+		loadMemoryMeta()
+	}
 	return fmt.Sprintf("→ %s", currentDir)
 }
 
@@ -1163,14 +2502,26 @@ func cmdFind(pattern string) string {
 	if pattern == "" {
 		return "Usage: /find <pattern>"
 	}
-	cmd := exec.Command("find", currentDir, "-maxdepth", "6", "-iname", "*"+pattern+"*",
-		"-not", "-path", "*/node_modules/*", "-not", "-path", "*/.git/*")
-	output, _ := cmd.CombinedOutput()
-	result := strings.TrimSpace(string(output))
+	var result string
+	switch {
+	case haveBinary("find"):
+		cmd := exec.Command("find", currentDir, "-maxdepth", "6", "-iname", "*"+pattern+"*",
+			"-not", "-path", "*/node_modules/*", "-not", "-path", "*/.git/*")
+		output, _ := cmd.CombinedOutput()
+		result = strings.TrimSpace(string(output))
+	case settings.NativeFallback:
+		result = strings.Join(nativeFind(currentDir, pattern), "\n")
+	default:
+		return toolUnavailable("find", true)
+	}
 	if result == "" {
 		return "No files found"
 	}
-	lines := strings.Split(result, "\n")
+	lines := filterIgnored(strings.Split(result, "\n"), func(line string) string { return line })
+	if len(lines) == 0 {
+		return "No files found"
+	}
+	result = strings.Join(lines, "\n")
 	if len(lines) > 30 {
 		result = strings.Join(lines[:30], "\n") + fmt.Sprintf("\n%s+%d more%s", colorGray, len(lines)-30, colorReset)
 	}
@@ -1184,14 +2535,32 @@ func cmdGrep(args string) string {
 	if len(parts) > 1 {
 		searchPath = resolvePath(parts[1])
 	}
-	cmd := exec.Command("grep", "-rn", "-i", "--include=*.*",
-		"--exclude-dir=node_modules", "--exclude-dir=.git", pattern, searchPath)
-	output, _ := cmd.CombinedOutput()
-	result := strings.TrimSpace(string(output))
+	var result string
+	switch {
+	case haveBinary("grep"):
+		cmd := exec.Command("grep", "-rn", "-i", "--include=*.*",
+			"--exclude-dir=node_modules", "--exclude-dir=.git", pattern, searchPath)
+		output, _ := cmd.CombinedOutput()
+		result = strings.TrimSpace(string(output))
+	case settings.NativeFallback:
+		result = strings.Join(nativeGrep(searchPath, pattern), "\n")
+	default:
+		return toolUnavailable("grep", true)
+	}
 	if result == "" {
 		return "No matches"
 	}
 	lines := strings.Split(result, "\n")
+	if info, err := os.Stat(searchPath); err == nil && info.IsDir() {
+		lines = filterIgnored(lines, func(line string) string {
+			path, _, _ := strings.Cut(line, ":")
+			return path
+		})
+		if len(lines) == 0 {
+			return "No matches"
+		}
+		result = strings.Join(lines, "\n")
+	}
 	if len(lines) > 25 {
 		result = strings.Join(lines[:25], "\n") + fmt.Sprintf("\n%s+%d more%s", colorGray, len(lines)-25, colorReset)
 	}
@@ -1218,7 +2587,7 @@ func walkDir(path, prefix string, result *strings.Builder, depth, maxDepth int)
 	var filtered []os.DirEntry
 	for _, e := range entries {
 		name := e.Name()
-		if strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" {
+		if strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" || isIgnored(filepath.Join(path, name), e.IsDir()) {
 			continue
 		}
 		filtered = append(filtered, e)
@@ -1256,18 +2625,37 @@ func cmdWrite(args string) string {
 	if currentMode == ModeManual {
 		return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
 	}
+	if msg := staleEdit(fullPath); msg != "" {
+		return msg
+	}
+	// An existing file keeps its line endings, encoding, final newline and mode
+	before, format, err := readText(fullPath)
+	exists := err == nil
+	content = format.normalize(content)
+	note := ""
 	if currentMode == ModeAsk {
-		fmt.Printf("%sWrite %s?%s [y/N] ", colorYellow, fullPath, colorReset)
-		reader := bufio.NewReader(os.Stdin)
-		if in, _ := reader.ReadString('\n'); strings.ToLower(strings.TrimSpace(in)) != "y" {
+		if exists {
+			var ok bool
+			if content, note, ok = reviewHunks(relPath(fullPath), before, content); !ok {
+				return "Cancelled"
+			}
+		} else if !confirmAction(fmt.Sprintf("%sWrite %s?%s", colorYellow, fullPath, colorReset)) {
 			return "Cancelled"
 		}
 	}
 	
 	saveForUndo(path, "write")
-	os.MkdirAll(filepath.Dir(fullPath), 0755)
-	os.WriteFile(fullPath, []byte(content), 0644)
-	return fmt.Sprintf("%s✓ Written: %s (%d bytes)%s", colorGreen, fullPath, len(content), colorReset)
+	if err := writeText(fullPath, content, format); err != nil {
+		undoStack = undoStack[:len(undoStack)-1]
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if data, err := os.ReadFile(fullPath); err == nil {
+		noteSeen(fullPath, data)
+	}
+	if note != "" {
+		note = " (" + note + ")"
+	}
+	return fmt.Sprintf("%s✓ Written: %s (%d bytes)%s%s", colorGreen, fullPath, len(content), note, colorReset)
 }
 
 func cmdReplace(args string) string {
@@ -1282,31 +2670,42 @@ func cmdReplace(args string) string {
 		return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
 	}
 	
-	data, err := os.ReadFile(fullPath)
+	content, format, err := readText(fullPath)
 	if err != nil {
 		return fmt.Sprintf("Error: %s", err)
 	}
-	content := string(data)
+	old, new = strings.ReplaceAll(old, "\r\n", "\n"), strings.ReplaceAll(new, "\r\n", "\n")
+	stale, _ := staleFile(fullPath)
 	if !strings.Contains(content, old) {
+		if stale {
+			return staleEdit(fullPath)
+		}
 		return "Text not found"
 	}
 	
-	fmt.Printf("%s--- %s%s\n%s- %s%s\n%s+ %s%s\n",
-		colorRed, fullPath, colorReset,
-		colorRed, truncate(old, 80), colorReset,
-		colorGreen, truncate(new, 80), colorReset)
-	
+	updated, note := strings.Replace(content, old, new, 1), ""
 	if currentMode == ModeAsk {
-		fmt.Printf("%sApply?%s [y/N] ", colorYellow, colorReset)
-		reader := bufio.NewReader(os.Stdin)
-		if in, _ := reader.ReadString('\n'); strings.ToLower(strings.TrimSpace(in)) != "y" {
+		var ok bool
+		if updated, note, ok = reviewHunks(relPath(fullPath), content, updated); !ok {
 			return "Cancelled"
 		}
+	} else {
+		fmt.Println(renderDiff(relPath(fullPath), content, updated))
+	}
+	if stale {
+		note = strings.TrimPrefix(note+"; the file had changed on disk since you read it, the edit went into the current version", "; ")
+	}
+	if note != "" {
+		note = " (" + note + ")"
 	}
 	
 	saveForUndo(path, "replace")
-	os.WriteFile(fullPath, []byte(strings.Replace(content, old, new, 1)), 0644)
-	return fmt.Sprintf("%s✓ Replaced in %s%s", colorGreen, fullPath, colorReset)
+	if err := writeText(fullPath, updated, format); err != nil {
+		undoStack = undoStack[:len(undoStack)-1]
+		return fmt.Sprintf("Error: %s", err)
+	}
+	refreshSeen(fullPath)
+	return fmt.Sprintf("%s✓ Replaced in %s%s%s", colorGreen, fullPath, note, colorReset)
 }
 
 func cmdAppend(args string) string {
@@ -1322,9 +2721,16 @@ func cmdAppend(args string) string {
 	}
 	
 	saveForUndo(path, "append")
-	f, _ := os.OpenFile(fullPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	f.WriteString(content)
-	f.Close()
+	// Appended text takes the file's line endings and encoding; the end of
+	// the file is whatever was appended
+	before, format, _ := readText(fullPath)
+	text := before + strings.ReplaceAll(content, "\r\n", "\n")
+	format.FinalEOL = strings.HasSuffix(text, "\n")
+	if err := writeText(fullPath, text, format); err != nil {
+		undoStack = undoStack[:len(undoStack)-1]
+		return fmt.Sprintf("Error: %s", err)
+	}
+	refreshSeen(fullPath)
 	return fmt.Sprintf("%s✓ Appended to %s%s", colorGreen, fullPath, colorReset)
 }
 
@@ -1332,9 +2738,42 @@ func cmdGit(args string) string {
 	if args == "" {
 		args = "status"
 	}
-	cmd := exec.Command("sh", "-c", "git "+args)
+	if !haveBinary("git") {
+		return toolUnavailable("git", false)
+	}
+	fields, _, err := splitGitArgs(args)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	sub, bypass := gitSubcommand(args)
+	if f := strings.Fields(bypass); len(f) > 0 && gitShellAliasRe.MatchString(f[len(f)-1]) {
+		return fmt.Sprintf("Error: %s would run a shell command; use the run tool for that", bypass)
+	}
+	if bypass != "" {
+		return fmt.Sprintf("Error: %s would skip the repository's hooks or commit signing; fix what the hooks report and run git %s again without it", bypass, sub)
+	}
+	if msg := policyBlock("git " + args); msg != "" {
+		return msg
+	}
+	if msg := confineBlock("git " + args); msg != "" {
+		return msg
+	}
+	cmd := exec.Command("git", fields...)
 	cmd.Dir = currentDir
-	output, _ := cmd.CombinedOutput()
+	cmd.Env = gitSigningEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		toolFailure = &ToolError{Code: "exit_status", Message: "git " + sub + ": " + err.Error(), Output: string(output)}
+	}
+	if err != nil && sub == "commit" {
+		if signingFailed(string(output)) {
+			return string(output) + "\n(Signing failed: the repository signs commits. Ask the user to unlock their key; don't turn signing off.)"
+		}
+		if hooks := commitHooks(findProjectRoot()); len(hooks) > 0 {
+			return string(output) + fmt.Sprintf("\n(The repository's %s hook rejected the commit. Fix the problems it reports, stage the fixes and commit again; don't skip the hook.)",
+				strings.Join(hooks, "/"))
+		}
+	}
 	return string(output)
 }
 
@@ -1342,25 +2781,87 @@ func cmdFetch(url string) string {
 	if !strings.HasPrefix(url, "http") {
 		url = "https://" + url
 	}
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+	content := fetchReadable(url)
+	if strings.HasPrefix(content, "Error:") {
+		return content
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	content := string(body)
-	if len(content) > 8000 {
+	size := len(content)
+	if size > 8000 {
 		content = content[:8000] + "\n... (truncated)"
 	}
-	return fmt.Sprintf("%sURL: %s (%d bytes)%s\n%s", colorCyan, url, len(body), colorReset, content)
+	return fmt.Sprintf("%sURL: %s (%d chars)%s\n%s", colorCyan, url, size, colorReset, content)
+}
+
+type gitStatus struct {
+	Branch                                 string // or the short commit when detached
+	Staged, Modified, Untracked, Conflicts int
+	Ahead, Behind                          int
 }
 
-func getGitBranch() string {
-	cmd := exec.Command("git", "branch", "--show-current")
+func getGitStatus() gitStatus {
+	var st gitStatus
+	cmd := exec.Command("git", "status", "--porcelain=v2", "--branch")
 	cmd.Dir = currentDir
-	out, _ := cmd.Output()
-	return strings.TrimSpace(string(out))
+	out, err := cmd.Output()
+	if err != nil {
+		return st
+	}
+	oid := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# branch.oid "):
+			oid = strings.TrimPrefix(line, "# branch.oid ")
+		case strings.HasPrefix(line, "# branch.head "):
+			st.Branch = strings.TrimPrefix(line, "# branch.head ")
+		case strings.HasPrefix(line, "# branch.ab "):
+			fmt.Sscanf(strings.TrimPrefix(line, "# branch.ab "), "+%d -%d", &st.Ahead, &st.Behind)
+		case strings.HasPrefix(line, "u "):
+			st.Conflicts++
+		case strings.HasPrefix(line, "1 ") || strings.HasPrefix(line, "2 "):
+			if line[2] != '.' {
+				st.Staged++
+			}
+			if line[3] != '.' {
+				st.Modified++
+			}
+		case strings.HasPrefix(line, "? "):
+			st.Untracked++
+		}
+	}
+	if st.Branch == "(detached)" && len(oid) >= 7 {
+		st.Branch = oid[:7]
+	}
+	return st
+}
+
+// ⎇ main +1 ~2 ?3 ⇡2⇣1: staged, modified, untracked, ahead/behind upstream
+func (st gitStatus) Segment() string {
+	seg := fmt.Sprintf("%s⎇ %s%s", colorBlue, st.Branch, colorReset)
+	for _, c := range []struct {
+		n     int
+		sign  string
+		color string
+	}{
+		{st.Conflicts, "!", colorRed},
+		{st.Staged, "+", colorGreen},
+		{st.Modified, "~", colorYellow},
+		{st.Untracked, "?", colorGray},
+	} {
+		if c.n > 0 {
+			seg += fmt.Sprintf(" %s%s%d%s", c.color, c.sign, c.n, colorReset)
+		}
+	}
+	if st.Ahead > 0 || st.Behind > 0 {
+		seg += " " + colorCyan
+		if st.Ahead > 0 {
+			seg += fmt.Sprintf("⇡%d", st.Ahead)
+		}
+		if st.Behind > 0 {
+			seg += fmt.Sprintf("⇣%d", st.Behind)
+		}
+		seg += colorReset
+	}
+	return seg
 }
 
 func cmdEdit(path string, scanner *bufio.Scanner) string {
@@ -1417,6 +2918,24 @@ func resolvePath(path string) string {
 	return filepath.Clean(path)
 }
 
+// Nearest ancestor of currentDir containing .git ("" if none)
+func findProjectRoot() string {
+	return projectRootFor(currentDir)
+}
+
+func projectRootFor(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
 func formatSize(size int64) string {
 	const unit = 1024
 	if size < unit {
@@ -1430,6 +2949,12 @@ func formatSize(size int64) string {
 	return fmt.Sprintf("%.1f%cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+func stripANSI(s string) string {
+	return ansiRe.ReplaceAllString(s, "")
+}
+
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
@@ -1438,23 +2963,82 @@ func truncate(s string, max int) string {
 }
 
 func processAtMentions(input string) string {
-	re := regexp.MustCompile(`@([\w./\-_]+)`)
-	matches := re.FindAllStringSubmatch(input, -1)
-	if len(matches) == 0 {
-		return input
-	}
-	
+	re := regexp.MustCompile(`@([\w./\-_*?]*\{[\w.,\-*/]*\}[\w./\-_*?]*|[\w./\-_*?]+)(!?)`)
 	var files []string
+	seenIssues := map[string]bool{}
+	for _, m := range forgeMentionRe.FindAllStringSubmatch(input, -1) {
+		repo, err := forgeRemote(findProjectRoot(), "origin")
+		ref := strings.TrimLeft(strings.TrimSpace(m[0]), "@")
+		if err != nil {
+			fmt.Printf("%s  ✗ %s: %s%s\n", colorYellow, ref, err, colorReset)
+			continue
+		}
+		if key := repo.String() + m[1] + m[2]; !seenIssues[key] {
+			seenIssues[key] = true
+			text, display := expandForgeMention(ref, repo, m[2], m[1] == "!")
+			fmt.Println(display)
+			if text != "" {
+				files = append(files, text)
+			}
+		}
+	}
+	for _, m := range mentionURLRe.FindAllStringSubmatch(input, -1) {
+		text, display := "", ""
+		if repo, number, pull, ok := forgeIssueURL(strings.TrimRight(m[1], ".,;:!?)]}")); ok {
+			sep := "#"
+			if pull && repo.Kind != forgeGitHub {
+				sep = "!"
+			}
+			key := repo.String() + sep + number
+			if seenIssues[key] {
+				continue
+			}
+			seenIssues[key] = true
+			text, display = expandForgeMention(key, repo, number, pull)
+		} else {
+			text, display = expandURLMention(m[1])
+		}
+		fmt.Println(display)
+		if text != "" {
+			files = append(files, text)
+		}
+	}
+	matches := re.FindAllStringSubmatch(forgeMentionRe.ReplaceAllString(mentionURLRe.ReplaceAllString(input, ""), " "), -1)
 	for _, m := range matches {
 		filename := m[1]
 		fullPath := resolvePath(filename)
+		if info, err := os.Stat(fullPath); isGlobMention(filename) || err == nil && info.IsDir() {
+			text, display := expandMultiMention(filename)
+			fmt.Println(display)
+			if text != "" {
+				files = append(files, text)
+			}
+			continue
+		}
+		if isIgnored(fullPath, false) {
+			fmt.Printf("%s  ✗ @%s: ignored by %s%s\n", colorYellow, filename, ignoreFileName, colorReset)
+			continue
+		}
 		if data, err := os.ReadFile(fullPath); err == nil {
-			content := string(data)
-			if lines := strings.Split(content, "\n"); len(lines) > 100 {
-				content = strings.Join(lines[:100], "\n") + fmt.Sprintf("\n... +%d lines", len(lines)-100)
+			content, note := string(data), ""
+			if reason := unreadableReason(data, guardMaxBytes); reason != "" && m[2] != "!" {
+				files = append(files, fmt.Sprintf("=== %s (summary only) ===\n%s", fullPath, fileSummary(fullPath, data, reason)))
+				fmt.Printf("%s  ⚠ @%s: %s, summary only (@%s! to attach)%s\n", colorYellow, filename, reason, filename, colorReset)
+				continue
 			}
-			files = append(files, fmt.Sprintf("=== %s ===\n%s", fullPath, content))
-			fmt.Printf("%s  ✓ @%s%s\n", colorGray, filename, colorReset)
+			if estimateTokens(content) > mentionFileTokens {
+				content, note = chunkedMention(fullPath, content, re.ReplaceAllString(input, ""))
+				note = " (" + note + ")"
+			}
+			files = append(files, fmt.Sprintf("=== %s%s ===\n%s", fullPath, note, content))
+			noteSeen(fullPath, data)
+			if settings.MentionDeps {
+				if sigs, n := dependencySignatures(fullPath, string(data)); n > 0 {
+					files = append(files, fmt.Sprintf("=== signatures used by %s (bodies left out) ===\n%s", fullPath, sigs))
+					note += fmt.Sprintf(" + signatures from %d file(s)", n)
+				}
+			}
+			fmt.Printf("%s  ✓ @%s%s%s\n", colorGray, filename, note, colorReset)
 		}
 	}
 	
@@ -1464,28 +3048,148 @@ func processAtMentions(input string) string {
 	return input
 }
 
-func readMultiLine(scanner *bufio.Scanner) string {
+func readMultiLine(scanner *bufio.Scanner) string {
+	var lines []string
+	for {
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		if strings.HasSuffix(line, "\\") {
+			lines = append(lines, strings.TrimSuffix(line, "\\"))
+			fmt.Printf("%s. %s", colorGray, colorReset)
+			continue
+		}
+		lines = append(lines, line)
+		break
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ==================== TOOLS ====================
+
+// Structured error handed to the model instead of colored terminal text
+type ToolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+	Output  string `json:"output,omitempty"`
+}
+
+type ToolResult struct {
+	Tool   string
+	Output string // as rendered in the terminal
+	Err    *ToolError
+	Shown  bool // Output was already streamed to the terminal
+}
+
+func (r ToolResult) Display() string {
+	if r.Shown {
+		return fmt.Sprintf("[%s] %s", r.Tool, streamedTail(r.Output))
+	}
+	return fmt.Sprintf("[%s] %s", r.Tool, r.Output)
+}
+
+func (r ToolResult) ForModel() string {
+	if r.Err != nil {
+		data, _ := json.Marshal(map[string]*ToolError{"error": r.Err})
+		return fmt.Sprintf("[%s] %s", r.Tool, data)
+	}
+	return fmt.Sprintf("[%s] %s", r.Tool, stripANSI(r.Output))
+}
+
+func resultsForModel(results []ToolResult) string {
 	var lines []string
-	for {
-		if !scanner.Scan() {
-			break
-		}
-		line := scanner.Text()
-		if strings.HasSuffix(line, "\\") {
-			lines = append(lines, strings.TrimSuffix(line, "\\"))
-			fmt.Printf("%s. %s", colorGray, colorReset)
-			continue
-		}
-		lines = append(lines, line)
-		break
+	for _, r := range results {
+		lines = append(lines, r.ForModel())
 	}
 	return strings.Join(lines, "\n")
 }
 
-// ==================== TOOLS ====================
+var toolSyntax = map[string]string{
+	"write":       "write:path|||content",
+	"replace":     "replace:path|||old|||new",
+	"append":      "append:path|||content",
+	"apply_patch": "apply_patch:<unified diff with --- a/path, +++ b/path and @@ hunks>",
+	"rename":      "rename:old|||new",
+	"move":        "move:path|||dir",
+	"chmod":       "chmod:755 path",
+	"remember":    "remember:key:value",
+	"job_output":  "job_output:<job id>",
+	"sql":         "sql:path/to/db.sqlite|||SELECT ...",
+	"blame":       "blame:path:line",
+	"log":         "log:path",
+	"crawl":       "crawl:url [depth=2] [pages=20]",
+	"download":    "download:url|||dest[|||sha256]",
+	"browser":     "browser:open <url> | click <selector> | type <selector>|||<text> | wait <selector> | text [selector] | screenshot [file] [full] | close",
+}
+
+// The error code and hint for a tool's result: what the tool recorded in
+// toolFailure, else read from the messages tools write themselves
+// ("Error: ...", "Usage: ...", [blocked])
+func classifyToolResult(tool, out string) *ToolError {
+	// Programs the tool ran say how they ended through toolFailure; their
+	// output is theirs and never read for error messages
+	if toolFailure != nil {
+		return toolFailure
+	}
+	if outputShown {
+		return nil
+	}
+	plain := strings.TrimSpace(stripANSI(out))
+	lower := strings.ToLower(plain)
+
+	switch {
+	case strings.Contains(plain, "[blocked]"):
+		return &ToolError{Code: "blocked", Message: "blocked in manual mode", Hint: "ask the user to switch mode with /mode"}
+	case plain == "Cancelled":
+		return &ToolError{Code: "cancelled", Message: "the user declined this action", Hint: "ask the user how to proceed"}
+	case strings.HasPrefix(plain, "Unknown tool:"):
+		return &ToolError{Code: "unknown_tool", Message: plain, Hint: "use one of the tools listed in the system prompt"}
+	case strings.HasPrefix(plain, "Usage:"), strings.HasPrefix(plain, "Error: format"):
+		hint := plain
+		if syn, ok := toolSyntax[tool]; ok {
+			hint = "expected <tool>" + syn + "</tool>"
+		}
+		return &ToolError{Code: "bad_arguments", Message: "invalid arguments", Hint: hint}
+	case strings.HasPrefix(plain, "Patch failed:"):
+		return &ToolError{Code: "no_match", Message: plain, Hint: "read the files again and regenerate the hunks with exact context lines"}
+	case plain == "Text not found":
+		return &ToolError{Code: "no_match", Message: "old text not found in file", Hint: "read the file again and copy the exact text, including whitespace"}
+	case strings.HasPrefix(plain, "Search error:"):
+		return &ToolError{Code: "network", Message: strings.TrimPrefix(plain, "Search error: "), Hint: "retry later or use fetch"}
+	case strings.HasPrefix(plain, "Error:"):
+		msg := strings.TrimSpace(strings.TrimPrefix(plain, "Error:"))
+		switch {
+		case strings.HasPrefix(msg, "tool unavailable:"):
+			return &ToolError{Code: "tool_unavailable", Message: msg, Hint: "a required program is not installed; do not retry, use another tool or tell the user"}
+		case strings.Contains(msg, "changed on disk since you last read it"):
+			first, rest, _ := strings.Cut(msg, "\n")
+			return &ToolError{Code: "stale", Message: strings.TrimSuffix(first, "; current content:"), Hint: "the file was read again (output); redo the edit against this version", Output: rest}
+		case strings.Contains(msg, "outside the project root"):
+			return &ToolError{Code: "confined", Message: msg, Hint: "stay inside the project: use paths relative to it"}
+		case strings.HasPrefix(msg, "blocked by policy"):
+			return &ToolError{Code: "policy", Message: msg, Hint: "the project does not allow this command; do not retry it or work around it, ask the user"}
+		case strings.HasSuffix(msg, "disabled in settings"):
+			return &ToolError{Code: "disabled", Message: msg, Hint: "this tool is not available, do not retry it"}
+		case strings.Contains(lower, "no such file"), strings.Contains(lower, "cannot find"):
+			return &ToolError{Code: "not_found", Message: msg, Hint: "check the path with ls or find"}
+		case strings.Contains(lower, "disallowed by") && strings.Contains(lower, "robots.txt"):
+			return &ToolError{Code: "robots_disallowed", Message: msg, Hint: "the site asks bots not to fetch this page; do not retry, ask the user"}
+		case strings.Contains(lower, "permission denied"):
+			return &ToolError{Code: "permission_denied", Message: msg}
+		case strings.Contains(lower, "is a directory"), strings.Contains(lower, "not a directory"):
+			return &ToolError{Code: "wrong_type", Message: msg, Hint: "use ls for directories and read for files"}
+		}
+		return &ToolError{Code: "failed", Message: msg}
+	}
+
+	return nil
+}
 
-func parseAndExecuteTools(response string) (string, []string) {
-	var results []string
+func parseAndExecuteTools(response string) (string, []ToolResult) {
+	var results []ToolResult
+	snapped := false
 	for {
 		start := strings.Index(response, "<tool>")
 		if start == -1 {
@@ -1505,56 +3209,181 @@ func parseAndExecuteTools(response string) (string, []string) {
 			toolArg = strings.TrimSpace(parts[1])
 		}
 		
-		var result string
-		switch toolName {
-		case "read":
-			result = cmdRead(toolArg)
-		case "ls":
-			result = cmdList(toolArg)
-		case "run":
-			result = cmdRun(toolArg)
-		case "find":
-			result = cmdFind(toolArg)
-		case "grep":
-			result = cmdGrep(toolArg)
-		case "tree":
-			result = cmdTree(toolArg)
-		case "write":
-			result = cmdWrite(toolArg)
-		case "replace":
-			result = cmdReplace(toolArg)
-		case "append":
-			result = cmdAppend(toolArg)
-		case "git":
-			result = cmdGit(toolArg)
-		case "fetch":
-			result = cmdFetch(toolArg)
-		case "cd":
-			result = cmdCd(toolArg)
-		case "python":
-			result = runPython(toolArg)
-		case "node":
-			result = runNode(toolArg)
-		case "search":
-			result = webSearch(toolArg)
-		case "image":
-			result = analyzeImage(toolArg)
-		case "remember":
-			p := strings.SplitN(toolArg, ":", 2)
-			if len(p) == 2 {
-				rememberFact(p[0], p[1])
-				result = "Remembered: " + p[0]
-			}
-		default:
-			result = "Unknown tool: " + toolName
-		}
+		snapshotBeforeTool(toolName, &snapped)
+		result := executeTool(toolName, toolArg)
+		toolCounts[toolName]++
 		
-		results = append(results, fmt.Sprintf("[%s] %s", toolName, result))
+		results = append(results, ToolResult{Tool: toolName, Output: result, Err: classifyToolResult(toolName, result), Shown: outputShown})
 		response = response[:start] + response[end+7:]
 	}
 	return strings.TrimSpace(response), results
 }
 
+// Built-in tools as documented in the system prompt, grouped by section
+var toolDocs = []struct{ Group, Name, Doc string }{
+	{"READ", "read", "<tool>read:file</tool> - Baca file (200 baris pertama); <tool>read:file:120-180</tool> rentang baris, <tool>read:file#NamaFungsi</tool> satu definisi; file biner/minified hanya diringkas, <tool>read:file --force</tool> untuk isi mentah"},
+	{"READ", "ls", "<tool>ls:dir</tool> - List direktori"},
+	{"READ", "tree", "<tool>tree:dir</tool> - Struktur folder"},
+	{"READ", "find", "<tool>find:pattern</tool> - Cari file"},
+	{"READ", "grep", "<tool>grep:pattern path</tool> - Cari teks"},
+	{"READ", "image", "<tool>image:file</tool> - Analisa gambar"},
+	{"READ", "blame", "<tool>blame:file:42</tool> atau <tool>blame:file:10-30</tool> - Siapa yang terakhir mengubah baris itu, kapan, dan pesan commit-nya"},
+	{"READ", "log", "<tool>log:file</tool> atau <tool>log:file:10-30</tool> - Riwayat commit file (atau rentang baris) beserta pesannya"},
+	{"WRITE", "write", "<tool>write:path|||content</tool> - Buat/tulis file"},
+	{"WRITE", "replace", "<tool>replace:path|||old|||new</tool> - Ganti teks"},
+	{"WRITE", "append", "<tool>append:path|||content</tool> - Tambah ke file"},
+	{"WRITE", "apply_patch", "<tool>apply_patch:unified diff (--- a/file, +++ b/file, @@ hunks; boleh banyak file)</tool> - Edit besar/multi-file, lebih andal dari replace"},
+	{"WRITE", "delete", "<tool>delete:path</tool> - Hapus file/folder (bisa di-undo)"},
+	{"WRITE", "rename", "<tool>rename:old|||new</tool> - Ganti nama file/folder"},
+	{"WRITE", "move", "<tool>move:path|||dir</tool> - Pindah ke folder"},
+	{"WRITE", "mkdir", "<tool>mkdir:dir</tool> - Buat folder"},
+	{"WRITE", "chmod", "<tool>chmod:755 path</tool> atau <tool>chmod:+x path</tool> - Ubah permission"},
+	{"WRITE", "bulk", "<tool>bulk:glob|||prepend/append|||text</tool> atau <tool>bulk:glob|||replace/regex|||old|||new</tool> - Ubah banyak file sekaligus (glob: *.go, src/**/*.ts)"},
+	{"EXECUTE", "run", "<tool>run:cmd</tool> - Shell command (batas waktu default; <tool>run:--timeout 30m cmd</tool> untuk yang lama, <tool>run:cmd &</tool> jalan di background)"},
+	{"EXECUTE", "test", "<tool>test:</tool> atau <tool>test:nama_test</tool> - Jalankan test proyek; hasilnya daftar test yang gagal (nama, lokasi, pesan)"},
+	{"EXECUTE", "job_output", "<tool>job_output:id</tool> - Output terakhir & status job background"},
+	{"EXECUTE", "git", "<tool>git:cmd</tool> - Git command"},
+	{"EXECUTE", "python", "<tool>python:code</tool> - Jalankan Python"},
+	{"EXECUTE", "node", "<tool>node:code</tool> - Jalankan JavaScript"},
+	{"EXECUTE", "gorun", "<tool>gorun:code</tool> - Jalankan program Go (package main boleh dihilangkan)"},
+	{"EXECUTE", "bash", "<tool>bash:script</tool> - Jalankan skrip bash banyak baris"},
+	{"EXECUTE", "ruby", "<tool>ruby:code</tool> - Jalankan Ruby"},
+	{"EXECUTE", "sql", "<tool>sql:db.sqlite|||query</tool> - SQL ke file SQLite (query atau file .sql)"},
+	{"WEB", "fetch", "<tool>fetch:url</tool> - Ambil konten URL (halaman HTML jadi markdown)"},
+	{"WEB", "crawl", "<tool>crawl:url depth=2 pages=20</tool> - Baca halaman dan halaman yang ditautkannya (host dan folder yang sama), misalnya seluruh bagian dokumentasi"},
+	{"WEB", "download", "<tool>download:url|||path</tool> - Unduh file (arsip, binary, dataset) langsung ke disk dengan progress; tambah |||sha256 untuk verifikasi checksum. Path berupa folder memakai nama file dari URL"},
+	{"WEB", "browser", "<tool>browser:open url</tool> - Buka halaman di Chrome headless (untuk situs yang butuh JavaScript) dan ambil isinya sebagai markdown; lalu <tool>browser:click selector</tool> atau <tool>browser:click text:Label</tool>, <tool>browser:type selector|||teks</tool> (tambah |||submit untuk kirim), <tool>browser:wait selector</tool>, <tool>browser:text selector</tool>, <tool>browser:screenshot [file] [full]</tool>, <tool>browser:close</tool>"},
+	{"WEB", "search", "<tool>search:query</tool> - Cari di web"},
+	{"MEMORY", "remember", "<tool>remember:key:value</tool> - Ingat sesuatu (remember:project:key:value khusus proyek ini, remember:session:key:value khusus sesi ini)"},
+}
+
+func isToolDisabled(name string) bool {
+	for _, t := range settings.DisabledTools {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Tool section of the system prompt, without disabled tools
+func toolListPrompt() string {
+	var b strings.Builder
+	group := ""
+	for _, t := range toolDocs {
+		if isToolDisabled(t.Name) || isToolUnavailable(t.Name) {
+			continue
+		}
+		if t.Group != group {
+			group = t.Group
+			b.WriteString("\n" + group + ":\n")
+		}
+		b.WriteString("- " + t.Doc + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func executeTool(toolName, toolArg string) string {
+	if isToolDisabled(toolName) {
+		return fmt.Sprintf("Error: tool %s is disabled in settings", toolName)
+	}
+	outputShown, toolFailure = false, nil
+	var result string
+	switch toolName {
+	case "read":
+		result = cmdRead(toolArg)
+	case "ls":
+		result = cmdList(toolArg)
+	case "run":
+		result = cmdRun(toolArg)
+	case "find":
+		result = cmdFind(toolArg)
+	case "grep":
+		result = cmdGrep(toolArg)
+	case "tree":
+		result = cmdTree(toolArg)
+	case "write":
+		result = cmdWrite(toolArg)
+	case "replace":
+		result = cmdReplace(toolArg)
+	case "append":
+		result = cmdAppend(toolArg)
+	case "bulk":
+		result = cmdBulk(toolArg)
+	case "apply_patch":
+		result = cmdApplyPatch(toolArg)
+	case "delete":
+		result = cmdDelete(toolArg)
+	case "rename":
+		result = cmdRename(toolArg)
+	case "move":
+		result = cmdMove(toolArg)
+	case "mkdir":
+		result = cmdMkdir(toolArg)
+	case "chmod":
+		result = cmdChmod(toolArg)
+	case "git":
+		result = cmdGit(toolArg)
+	case "blame":
+		result = cmdBlame(toolArg)
+	case "log":
+		result = cmdLog(toolArg)
+	case "fetch":
+		result = cmdFetch(toolArg)
+	case "crawl":
+		result = cmdCrawl(toolArg)
+	case "download":
+		result = cmdDownload(toolArg)
+	case "browser":
+		result = cmdBrowser(toolArg)
+	case "cd":
+		result = cmdCd(toolArg)
+	case "job_output":
+		result = cmdJobOutput(toolArg)
+	case "test":
+		result = cmdTestTool(toolArg)
+	case "gorun":
+		result = runGo(toolArg)
+	case "bash":
+		result = runBash(toolArg)
+	case "ruby":
+		result = runRuby(toolArg)
+	case "sql":
+		result = cmdSQL(toolArg)
+	case "python":
+		result = runPython(toolArg)
+	case "node":
+		result = runNode(toolArg)
+	case "search":
+		result = webSearch(toolArg)
+	case "image":
+		result = analyzeImage(toolArg)
+	case "remember":
+		scope := scopeGlobal
+		for _, sc := range memoryScopes {
+			if rest, ok := strings.CutPrefix(toolArg, sc+":"); ok {
+				scope, toolArg = sc, rest
+			}
+		}
+		p := strings.SplitN(toolArg, ":", 2)
+		if len(p) != 2 {
+			result = "Usage: remember:[project:|session:]key:value"
+		} else if !reviewMemoryChange(scope, p[0], p[1]) {
+			result = "Cancelled"
+		} else {
+			rememberFact(scope, strings.TrimSpace(p[0]), strings.TrimSpace(p[1]), 0)
+			result = fmt.Sprintf("Remembered (%s): %s", scope, p[0])
+		}
+	default:
+		if strings.Contains(toolName, ".") {
+			result = callMCPTool(toolName, toolArg)
+		} else {
+			result = "Unknown tool: " + toolName
+		}
+	}
+	return result
+}
+
 // ==================== CHAT ====================
 
 func getAPIKey() string {
@@ -1562,7 +3391,7 @@ func getAPIKey() string {
 		return key
 	}
 	home, _ := os.UserHomeDir()
-	if data, err := os.ReadFile(filepath.Join(home, ".mytool_key")); err == nil {
+	if data, err := readPrivateFile(filepath.Join(home, ".mytool_key")); err == nil {
 		return strings.TrimSpace(string(data))
 	}
 	return ""
@@ -1570,63 +3399,66 @@ func getAPIKey() string {
 
 func saveAPIKey(key string) {
 	home, _ := os.UserHomeDir()
-	os.WriteFile(filepath.Join(home, ".mytool_key"), []byte(key), 0600)
+	writePrivateFile(filepath.Join(home, ".mytool_key"), []byte(key), 0600)
 }
 
 func getSystemPrompt() string {
 	hostname, _ := os.Hostname()
 	
 	memoryStr := ""
-	if len(memory) > 0 {
-		var facts []string
-		for k, v := range memory {
-			facts = append(facts, fmt.Sprintf("- %s: %s", k, v))
-		}
+	if facts := promptMemory(); len(facts) > 0 {
 		memoryStr = "\n\nMEMORY:\n" + strings.Join(facts, "\n")
 	}
 	
-	return fmt.Sprintf(`Kamu mytool v%s, AI terminal assistant dengan akses penuh ke sistem.
+	mcpStr := ""
+	if tools := getMCPTools(); len(tools) > 0 {
+		mcpStr = "\n\nMCP (format: <tool>server.tool:{\"arg\":\"value\"}</tool>):\n- " + strings.Join(tools, "\n- ")
+	}
+	
+	instructions, _ := projectInstructions()
+	repoMapStr := ""
+	if m := repoMap(); m != "" {
+		repoMapStr = "\n\nREPO MAP (file: definisi; pakai ini, jangan tebak nama file):\n" + m
+	}
+	
+	prompt := fmt.Sprintf(`Kamu mytool v%s, AI terminal assistant dengan akses penuh ke sistem.
 
 SISTEM:
 - Host: %s | OS: %s/%s | User: %s
-- Dir: %s | Project: %s | Mode: %s%s
+- Dir: %s | Project: %s | Mode: %s%s%s
 
 TOOLS (format: <tool>nama:arg</tool>):
-
-READ:
-- <tool>read:file</tool> - Baca file
-- <tool>ls:dir</tool> - List direktori
-- <tool>tree:dir</tool> - Struktur folder
-- <tool>find:pattern</tool> - Cari file
-- <tool>grep:pattern path</tool> - Cari teks
-- <tool>image:file</tool> - Analisa gambar
-
-WRITE:
-- <tool>write:path|||content</tool> - Buat/tulis file
-- <tool>replace:path|||old|||new</tool> - Ganti teks
-- <tool>append:path|||content</tool> - Tambah ke file
-
-EXECUTE:
-- <tool>run:cmd</tool> - Shell command
-- <tool>git:cmd</tool> - Git command
-- <tool>python:code</tool> - Jalankan Python
-- <tool>node:code</tool> - Jalankan JavaScript
-
-WEB:
-- <tool>fetch:url</tool> - Ambil konten URL
-- <tool>search:query</tool> - Cari di web
-
-MEMORY:
-- <tool>remember:key:value</tool> - Ingat sesuatu
+%s%s
 
 ATURAN:
 1. LANGSUNG gunakan tools - jangan suruh user manual
-2. Untuk edit: baca dulu, lalu replace dengan exact text
+2. Untuk edit: baca dulu, lalu replace dengan exact text (edit besar/banyak hunk: apply_patch)
 3. Tampilkan diff sebelum edit
 4. Bahasa Indonesia jika user pakai Indonesia
-5. Respons singkat dan informatif`,
+5. Respons singkat dan informatif
+6. Error tool berformat JSON {"error":{"code","message","hint"}} - ikuti hint-nya`,
 		version, hostname, runtime.GOOS, runtime.GOARCH, os.Getenv("USER"),
-		currentDir, projectType, currentMode, memoryStr)
+		currentDir, projectType, currentMode, binariesPrompt(), memoryStr+repoMapStr, toolListPrompt(), mcpStr)
+	if instructions != "" {
+		prompt += "\n\nPROJECT INSTRUCTIONS (follow these for this repository):\n" + instructions
+	}
+
+	return applyPromptVariant(map[string]string{
+		"default":      prompt,
+		"version":      version,
+		"host":         hostname,
+		"os":           runtime.GOOS + "/" + runtime.GOARCH,
+		"user":         os.Getenv("USER"),
+		"dir":          currentDir,
+		"project":      projectType,
+		"mode":         currentMode,
+		"model":        activeModel(),
+		"memory":       strings.TrimSpace(memoryStr),
+		"tools":        toolListPrompt(),
+		"mcp":          strings.TrimSpace(mcpStr),
+		"instructions": instructions,
+		"repo_map":     strings.TrimSpace(repoMapStr),
+	})
 }
 
 func runChat(args []string) {
@@ -1648,27 +3480,105 @@ func runChat(args []string) {
 		}
 	}
 
+	outPath := ""
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "--out" {
+			outPath = args[i+1]
+			args = append(args[:i:i], args[i+2:]...)
+			break
+		}
+	}
+	worktree := settings.WorktreeSessions
+	for i, a := range args {
+		if a == "--worktree" {
+			worktree = true
+			args = append(args[:i:i], args[i+1:]...)
+			break
+		}
+	}
 	if len(args) > 0 {
+		if worktree {
+			startWorktree()
+		}
 		msg := processAtMentions(strings.Join(args, " "))
+		memoryQuery = msg
+		if outPath != "" {
+			msg += fmt.Sprintf("\n\nThe result is saved as %s: give the complete file content in a single fenced code block.", filepath.Base(outPath))
+		}
 		messages := []ChatMessage{
 			{Role: "system", Content: getSystemPrompt()},
 			{Role: "user", Content: msg},
 		}
+		var out *fenceStream
+		var tap io.Writer
+		if outPath != "" {
+			var msg string
+			if out, msg = newFenceStream(outPath); out == nil {
+				fmt.Println(msg)
+				return
+			}
+			tap = out
+		}
+		started := time.Now()
 		showThinking()
-		response, _ := sendStream(apiKey, messages)
+		turnCount++
+		response, err := sendStream(apiKey, messages, tap)
 		stopThinking()
+		recordUsage("message")
+		if out != nil && err == nil {
+			fmt.Println(out.finish())
+		}
+		if err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			notifyWebhook(webhookPayload{Event: "run.completed", Status: "error", Error: err.Error()}, started)
+			return
+		}
 		fmt.Printf("%s%s%s\n", colorGreen, response, colorReset)
 		
 		_, results := parseAndExecuteTools(response)
 		if len(results) > 0 {
 			fmt.Printf("\n%s─── Results ───%s\n", colorCyan, colorReset)
 			for _, r := range results {
-				fmt.Println(r)
+				fmt.Println(r.Display())
 			}
 		}
+		notifyWebhook(webhookPayload{Event: "run.completed", Status: "ok", Summary: stripANSI(response)}, started)
+		return
+	}
+
+	if settings.AutoPruneDays > 0 {
+		if n, err := pruneSessions(time.Duration(settings.AutoPruneDays)*24*time.Hour, 0); err == nil && n > 0 {
+			fmt.Printf("%sPruned %d sessions older than %dd%s\n", colorGray, n, settings.AutoPruneDays, colorReset)
+		}
+	}
+	if settings.CloudSync {
+		if _, pulled, err := runSync("pull"); err != nil {
+			fmt.Printf("%sCloud sync failed: %s%s\n", colorYellow, err, colorReset)
+		} else if pulled > 0 {
+			fmt.Printf("%s☁ Pulled %d item(s)%s\n", colorGray, pulled, colorReset)
+		}
+	}
+	if recovered, err := recoverSessions(); err == nil && len(recovered) > 0 {
+		fmt.Printf("%s⚠ Recovered %d session(s) from an interrupted run:%s\n", colorYellow, len(recovered), colorReset)
+		for _, r := range recovered {
+			fmt.Printf("  %s  %s  %d msgs  %s(mytool resume %s)%s\n",
+				sessionDisplayName(r.ID, r.Name, nil), truncate(r.Dir, 30), r.Messages, colorGray, r.ID, colorReset)
+		}
+	}
+	if expired := expireFacts(); len(expired) > 0 {
+		fmt.Printf("%s🧠 Expired %d memories: %s%s\n", colorGray, len(expired), strings.Join(expired, ", "), colorReset)
+	}
+	if msg := reviewStaleFacts(bufio.NewScanner(os.Stdin), false); msg != "" {
+		fmt.Println(msg)
+	}
+	if s := offerAutoResume(bufio.NewScanner(os.Stdin)); s != nil {
+		restoreSession(s)
 		return
 	}
 
+	if worktree {
+		startWorktree()
+	}
 	history := []ChatMessage{{Role: "system", Content: getSystemPrompt()}}
 	runChatWithHistory(history)
 }
@@ -1681,6 +3591,12 @@ func runChatWithHistory(history []ChatMessage) {
 	fmt.Printf("\n%sENTER%s send • %sCtrl+C%s cancel • %s@file%s include • %s/help%s commands\n", 
 		colorYellow, colorReset, colorYellow, colorReset, colorYellow, colorReset, colorYellow, colorReset)
 	printStatusBar()
+	if _, files := projectInstructions(); len(files) > 0 {
+		fmt.Printf("%s📋 Project instructions: %s%s\n", colorGray, strings.Join(files, ", "), colorReset)
+	}
+	if notice := binariesNotice(); notice != "" {
+		fmt.Println(notice)
+	}
 	fmt.Println()
 
 	scanner := bufio.NewScanner(os.Stdin)
@@ -1689,24 +3605,30 @@ func runChatWithHistory(history []ChatMessage) {
 	// Initialize cancel channel
 	streamCancel = make(chan struct{})
 	
-	// Handle Ctrl+C for cancel
+	// Ctrl+C cancels what is running, or exits between turns; SIGTERM
+	// always exits. Either way the session is saved on the way out.
+	signal.Stop(shutdownSignals)
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for range sigChan {
+		for sig := range sigChan {
 			streamMutex.Lock()
 			streaming := isStreaming
 			streamMutex.Unlock()
 			
+			if sig == os.Interrupt && interruptChild() {
+				fmt.Printf("\n%s⚡ Command killed%s\n", colorYellow, colorReset)
+				continue
+			}
 			if streaming {
 				close(streamCancel)
 				streamCancel = make(chan struct{})
 				fmt.Printf("\n%s⚡ Cancelled%s\n", colorYellow, colorReset)
-			} else {
-				saveMemory()
-				fmt.Printf("\n%s👋 Bye!%s\n", colorCyan, colorReset)
-				os.Exit(0)
+				if sig == os.Interrupt {
+					continue
+				}
 			}
+			shutdownChat()
 		}
 	}()
 
@@ -1718,27 +3640,70 @@ func runChatWithHistory(history []ChatMessage) {
 	}
 	hintIdx := 0
 
+	chatMu.Lock()
+	defer chatMu.Unlock()
 	for {
-		hint := hints[hintIdx%len(hints)]
-		// Input box
-		fmt.Printf("\n%s╭─ You ─────────────────────────────────────────────────────────╮%s\n", colorGray, colorReset)
-		fmt.Printf("%s│%s %s%s%s", colorGray, colorReset, colorGray, hint, colorReset)
-		fmt.Printf("\r%s│%s ", colorGray, colorReset)
-		
-		input := readMultiLine(scanner)
-		fmt.Printf("%s╰───────────────────────────────────────────────────────────────╯%s\n", colorGray, colorReset)
-		input = strings.TrimSpace(input)
-		if input == "" {
-			continue
+		// A fix round goes out without waiting for input, as is
+		auto := autoPending != ""
+		var input string
+		if auto {
+			input, autoPending = autoPending, ""
+			fmt.Printf("\n%s🔁 %s%s\n", colorCyan, autoLabel, colorReset)
+		} else {
+			hint := hints[hintIdx%len(hints)]
+			// Input box
+			fmt.Printf("\n%s╭─ You ─────────────────────────────────────────────────────────╮%s\n", colorGray, colorReset)
+			fmt.Printf("%s│%s %s%s%s", colorGray, colorReset, colorGray, hint, colorReset)
+			fmt.Printf("\r%s│%s ", colorGray, colorReset)
+
+			// While waiting for input the signal handler may take over
+			chatHistory = history
+			chatMu.Unlock()
+			input = readMultiLine(scanner)
+			chatMu.Lock()
+			fmt.Printf("%s╰───────────────────────────────────────────────────────────────╯%s\n", colorGray, colorReset)
+			input = strings.TrimSpace(input)
+			if input == "" {
+				continue
+			}
+			hintIdx++
+		}
+
+		// Quick-select a follow-up suggestion
+		if len(input) == 1 && input[0] >= '1' && int(input[0]-'0') <= len(followUps) {
+			input = followUps[input[0]-'1']
+			fmt.Printf("%s→ %s%s\n", colorGray, input, colorReset)
 		}
-		hintIdx++
 		
-		appendToExport("User", input)
 
 		// Commands
 		switch {
+		case auto:
+		case input == "/test" || strings.HasPrefix(input, "/test "):
+			report, prompt := cmdTest(strings.TrimSpace(strings.TrimPrefix(input, "/test")))
+			fmt.Println(report)
+			if prompt == "" {
+				fmt.Println()
+				continue
+			}
+			fmt.Printf("\n%s🔁 Fix round 1/%d: sending the failing tests%s\n", colorCyan, testFix.Max, colorReset)
+			input, auto = prompt, true
+		case input == "/review" || strings.HasPrefix(input, "/review "):
+			report, request := cmdReview(strings.TrimSpace(strings.TrimPrefix(input, "/review")))
+			fmt.Println(report)
+			fmt.Println()
+			if request != "" {
+				lastResponse = stripANSI(report)
+				history = append(history, ChatMessage{Role: "user", Content: request}, ChatMessage{Role: "assistant", Content: lastResponse})
+			}
+			continue
 		case input == "exit" || input == "quit":
+			stopAllJobs()
+			closeBrowser()
 			saveMemory()
+			closeSession(history)
+			printSessionSummary()
+			worktreeReminder()
 			fmt.Printf("%s👋 Bye!%s\n", colorCyan, colorReset)
 			return
 		case input == "/mode":
@@ -1746,127 +3711,295 @@ func runChatWithHistory(history []ChatMessage) {
 			history[0] = ChatMessage{Role: "system", Content: getSystemPrompt()}
 			fmt.Printf("Mode: %s\n\n", getModeDisplay())
 			continue
-		case input == "/undo":
-			fmt.Println(doUndo())
+		case input == "/model" || strings.HasPrefix(input, "/model "):
+			fmt.Println(cmdModel(strings.TrimSpace(strings.TrimPrefix(input, "/model"))))
+			history[0] = ChatMessage{Role: "system", Content: getSystemPrompt()}
+			fmt.Println()
+			continue
+		case input == "/undo" || strings.HasPrefix(input, "/undo "):
+			fmt.Println(cmdUndo(strings.TrimSpace(strings.TrimPrefix(input, "/undo"))))
+			fmt.Println()
+			continue
+		case input == "/redo":
+			fmt.Println(doRedo())
 			fmt.Println()
 			continue
 		case input == "/save":
 			saveSession(history)
 			continue
+		case input == "/fork" || strings.HasPrefix(input, "/fork "):
+			var msg string
+			history, msg = forkSession(strings.TrimSpace(strings.TrimPrefix(input, "/fork")), history)
+			fmt.Println(msg)
+			fmt.Println()
+			continue
+		case input == "/checkpoint" || strings.HasPrefix(input, "/checkpoint "):
+			fmt.Println(createCheckpoint(strings.TrimSpace(strings.TrimPrefix(input, "/checkpoint")), history))
+			fmt.Println()
+			continue
+		case input == "/rollback" || strings.HasPrefix(input, "/rollback "):
+			var msg string
+			history, msg = rollbackCheckpoint(strings.TrimSpace(strings.TrimPrefix(input, "/rollback")), history)
+			fmt.Println(msg)
+			fmt.Println()
+			continue
+		case input == "/revert-turn" || strings.HasPrefix(input, "/revert-turn "):
+			fmt.Println(cmdRevertTurn(strings.TrimSpace(strings.TrimPrefix(input, "/revert-turn"))))
+			fmt.Println()
+			continue
+		case input == "/revert-session":
+			fmt.Println(cmdRevertSession())
+			fmt.Println()
+			continue
 		case input == "/copy":
-			fmt.Println(copyToClipboard(lastResponse))
+			fmt.Println(cmdCopy(lastResponse))
+			continue
+		case input == "/pr" || strings.HasPrefix(input, "/pr "):
+			fmt.Println(cmdPR(strings.TrimSpace(strings.TrimPrefix(input, "/pr")), history))
+			fmt.Println()
+			continue
+		case input == "/cost" || strings.HasPrefix(input, "/cost "):
+			fmt.Println(cmdCost(strings.TrimSpace(strings.TrimPrefix(input, "/cost"))))
+			fmt.Println()
+			continue
+		case input == "/compact":
+			var msg string
+			history, msg = compactHistory(apiKey, history)
+			fmt.Println(msg)
+			fmt.Println()
+			continue
+		case input == "/pin-file" || strings.HasPrefix(input, "/pin-file "):
+			fmt.Println(cmdPinFile(strings.TrimSpace(strings.TrimPrefix(input, "/pin-file"))))
+			fmt.Println()
+			continue
+		case strings.HasPrefix(input, "/unpin-file"):
+			fmt.Println(cmdUnpinFile(strings.TrimSpace(strings.TrimPrefix(input, "/unpin-file"))))
+			fmt.Println()
+			continue
+		case input == "/pin" || strings.HasPrefix(input, "/pin "):
+			fmt.Println(cmdPin(strings.TrimSpace(strings.TrimPrefix(input, "/pin")), history))
+			fmt.Println()
+			continue
+		case strings.HasPrefix(input, "/unpin"):
+			fmt.Println(cmdUnpin(strings.TrimSpace(strings.TrimPrefix(input, "/unpin")), history))
+			fmt.Println()
 			continue
-		case input == "/cost":
-			fmt.Printf("Tokens: %d | Cost: $%.4f\n\n", totalTokens, totalCost)
+		case input == "/index" || strings.HasPrefix(input, "/index "):
+			runIndexCmd(strings.Fields(strings.TrimPrefix(input, "/index")))
+			fmt.Println()
 			continue
 		case input == "/context":
-			pct := float64(totalTokens) / float64(maxContextTokens) * 100
-			fmt.Printf("Context: %d/%d (%.1f%%)\n\n", totalTokens, maxContextTokens, pct)
+			fmt.Println(cmdContext(history))
+			fmt.Println()
+			continue
+		case strings.HasPrefix(input, "/context preview"):
+			fmt.Println(cmdContextPreview(strings.TrimSpace(strings.TrimPrefix(input, "/context preview")), history))
+			fmt.Println()
 			continue
 		case input == "/memory":
 			showMemory()
 			fmt.Println()
 			continue
-		case input == "/sessions":
-			listSessions()
+		case input == "/memory review":
+			fmt.Println(reviewStaleFacts(scanner, true))
 			fmt.Println()
 			continue
-		case strings.HasPrefix(input, "/export"):
-			parts := strings.SplitN(input, " ", 2)
-			f := ""
-			if len(parts) > 1 {
-				f = parts[1]
-			}
-			exportChat(f)
+		case input == "/memory edit" || strings.HasPrefix(input, "/memory edit "):
+			fmt.Println(editMemory(strings.TrimSpace(strings.TrimPrefix(input, "/memory edit")), scanner))
+			fmt.Println()
+			continue
+		case input == "/sessions" || strings.HasPrefix(input, "/sessions "):
+			listSessions(strings.Fields(strings.TrimPrefix(input, "/sessions")))
+			fmt.Println()
+			continue
+		case input == "/export" || strings.HasPrefix(input, "/export "):
+			exportChat(history, strings.Fields(strings.TrimPrefix(input, "/export")))
 			continue
 		case strings.HasPrefix(input, "/forget "):
-			key := strings.TrimPrefix(input, "/forget ")
-			forgetFact(key)
-			fmt.Printf("Forgot: %s\n\n", key)
+			scope, key := parseScopeFlag(strings.TrimPrefix(input, "/forget "))
+			if from := forgetFact(scope, key); from != "" {
+				fmt.Printf("Forgot (%s): %s\n\n", from, key)
+			} else {
+				fmt.Printf("No such memory: %s\n\n", key)
+			}
 			continue
 		case strings.HasPrefix(input, "/remember "):
-			parts := strings.SplitN(strings.TrimPrefix(input, "/remember "), "=", 2)
-			if len(parts) == 2 {
-				rememberFact(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
-				fmt.Printf("Remembered: %s\n\n", parts[0])
+			scope, arg := scopeGlobal, strings.TrimSpace(strings.TrimPrefix(input, "/remember "))
+			var ttl time.Duration
+			var err error
+			for strings.HasPrefix(arg, "--") {
+				if rest, ok := strings.CutPrefix(arg, "--ttl "); ok {
+					fields := strings.SplitN(strings.TrimSpace(rest), " ", 2)
+					if ttl, err = parseAge(fields[0]); err != nil || len(fields) < 2 {
+						break
+					}
+					arg = strings.TrimSpace(fields[1])
+					continue
+				}
+				sc, rest := parseScopeFlag(arg)
+				if sc == "" {
+					break
+				}
+				scope, arg = sc, rest
+			}
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) == 2 && err == nil {
+				fmt.Println(rememberChecked(scope, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), ttl, scanner))
+				fmt.Println()
+			} else {
+				fmt.Printf("Usage: /remember [--project|--session] [--ttl 30d] key=value\n\n")
 			}
 			continue
 		case strings.HasPrefix(input, "/python "):
 			code := strings.TrimPrefix(input, "/python ")
-			fmt.Println(runPython(code))
+			fmt.Println(shownResult(executeTool("python", code)))
 			continue
 		case strings.HasPrefix(input, "/node "):
 			code := strings.TrimPrefix(input, "/node ")
-			fmt.Println(runNode(code))
+			fmt.Println(shownResult(executeTool("node", code)))
 			continue
 		case strings.HasPrefix(input, "/search "):
 			query := strings.TrimPrefix(input, "/search ")
-			fmt.Println(webSearch(query))
+			fmt.Println(executeTool("search", query))
 			continue
 		case strings.HasPrefix(input, "/img "):
 			path := strings.TrimPrefix(input, "/img ")
-			fmt.Println(analyzeImage(path))
+			fmt.Println(executeTool("image", path))
 			continue
 		case strings.HasPrefix(input, "/"):
 			result := handleCommand(input, scanner)
+			history[0] = ChatMessage{Role: "system", Content: getSystemPrompt()}
 			fmt.Println(result)
 			fmt.Println()
 			continue
 		}
 
-		// Process mentions
-		input = processAtMentions(input)
+		// Expand {{vars}}, then mentions; test output is sent as it is
+		var unknown []string
+		if !auto {
+			input, unknown = expandVars(input)
+		}
+		if len(unknown) > 0 {
+			fmt.Printf("%sUndefined: {{%s}} (sent as is, see /set)%s\n", colorYellow, strings.Join(unknown, "}}, {{"), colorReset)
+		}
+		if settings.LintPrompts && !auto {
+			var send bool
+			if input, send = reviewPromptRefs(input, scanner); !send {
+				fmt.Println()
+				continue
+			}
+		}
+		question := input
+		if !auto {
+			input = processAtMentions(input)
+		}
+		if snippets, display := indexSnippets(question, input); snippets != "" {
+			fmt.Println(display)
+			input += "\n\n" + snippets
+		}
+		followUps = nil
+
+		if verifierPending != "" {
+			input = verifierPending + "\n\n" + input
+			verifierPending = ""
+		}
+		if notice := staleNotice(); notice != "" {
+			input = notice + "\n\n" + input
+		}
+		if notice := jobsNotice(); notice != "" {
+			input = notice + "\n\n" + input
+		}
+
+		// Large memories are filtered per message, so the prompt follows it;
+		// it is also rebuilt when files changed under the repo map
+		if memoryQuery = input; memoryFiltered() || repoMapStale() {
+			history[0] = ChatMessage{Role: "system", Content: getSystemPrompt()}
+		}
+
+		if needsCompaction(history) {
+			var msg string
+			history, msg = compactHistory(apiKey, history)
+			fmt.Println(msg)
+		}
+		// Compaction off, failed or blocked by pins: drop old exchanges instead
+		if float64(contextTokens(history)) > compactAt*float64(maxContextTokens) {
+			var msg string
+			if history, msg = trimHistory(history); msg != "" {
+				fmt.Println(msg)
+			}
+		}
 
 		// Send to AI with cancellation support
-		history = append(history, ChatMessage{Role: "user", Content: input})
+		history = append(history, ChatMessage{Role: "user", Content: stripANSI(input)})
+		turnCount++
+		history = enforceBudgets(history)
+		outgoing := withPinnedFiles(history)
 		
 		streamMutex.Lock()
 		isStreaming = true
 		currentCancel := streamCancel
 		streamMutex.Unlock()
 		
+		turnStart := time.Now()
 		showThinking()
-		response, cancelled := sendStreamWithCancel(apiKey, history, currentCancel)
+		response, cancelled := sendStreamWithCancel(apiKey, outgoing, currentCancel)
 		stopThinking()
+		recordUsage("message")
+		modelTime := time.Since(turnStart)
 		
 		streamMutex.Lock()
 		isStreaming = false
 		streamMutex.Unlock()
 		
 		if cancelled {
+			testFix.Max = 0
+			recordTurn(turnStart, modelTime, 0, 0, true)
 			history = history[:len(history)-1]
 			fmt.Println()
 			continue
 		}
 		
 		lastResponse = response
-		appendToExport("Assistant", response)
-		totalCost = float64(totalTokens) / 1000 * costPer1KTokens
 
 		// Parse tools
-		_, results := parseAndExecuteTools(response)
+		toolStart := time.Now()
+		text, results := parseAndExecuteTools(response)
+		toolTime := time.Since(toolStart)
 		
 		if len(results) > 0 {
 			fmt.Printf("\n\n%s─── Executing ───%s\n", colorCyan, colorReset)
 			for _, r := range results {
-				fmt.Println(r)
+				fmt.Println(r.Display())
 			}
 			fmt.Printf("%s─────────────────%s\n", colorCyan, colorReset)
 			
 			history = append(history, ChatMessage{Role: "assistant", Content: response})
+			resultText := "Results:\n" + resultsForModel(results)
+			if notice := staleNotice(); notice != "" {
+				resultText += "\n\n" + notice
+			}
+			if notice := jobsNotice(); notice != "" {
+				resultText += "\n\n" + notice
+			}
 			history = append(history, ChatMessage{
 				Role:    "user",
-				Content: "Results:\n" + strings.Join(results, "\n") + "\n\nJelaskan singkat.",
+				Content: resultText + "\n\nJelaskan singkat.",
 			})
 			
+			history = enforceBudgets(history)
+			outgoing = withPinnedFiles(history) // tools may have just changed them
+			
 			streamMutex.Lock()
 			isStreaming = true
 			currentCancel = streamCancel
 			streamMutex.Unlock()
 			
 			fmt.Printf("\n%s", colorGreen)
-			followUp, _ := sendStreamWithCancel(apiKey, history, currentCancel)
+			followStart := time.Now()
+			followUp, _ := sendStreamWithCancel(apiKey, outgoing, currentCancel)
 			fmt.Printf("%s", colorReset)
+			recordUsage("tool_followup")
+			modelTime += time.Since(followStart)
 			
 			streamMutex.Lock()
 			isStreaming = false
@@ -1876,13 +4009,38 @@ func runChatWithHistory(history []ChatMessage) {
 			
 			if followUp != "" {
 				history = append(history, ChatMessage{Role: "assistant", Content: followUp})
-				appendToExport("Assistant", followUp)
 			}
+			text += "\n" + followUp
 		} else {
 			history = append(history, ChatMessage{Role: "assistant", Content: response})
 		}
 		
 		fmt.Println()
+
+		if issues := verifyClaims(text, extractToolCalls(response, results)); len(issues) > 0 {
+			fmt.Printf("\n%s⚠ Unverified claims:%s\n", colorYellow, colorReset)
+			for _, issue := range issues {
+				fmt.Printf("  %s• %s%s\n", colorYellow, issue, colorReset)
+			}
+			verifierPending = verifierNote(issues)
+		}
+		if len(results) > 0 {
+			shadowCommit(question)
+		}
+		continueTestFix(len(results) > 0)
+		checkAfterEdits(toolStart, auto)
+
+		recordTurn(turnStart, modelTime, toolTime, len(results), false)
+		touchMentionedFacts(lastResponse)
+		autoSaveSession(history)
+		if len(results) > 0 {
+			printStatusBar() // the tools may have changed or committed files
+		}
+
+		if settings.FollowUps {
+			followUps = suggestFollowUps(apiKey, history)
+			printFollowUps(followUps)
+		}
 	}
 }
 
@@ -1903,11 +4061,11 @@ func sendStreamWithCancel(apiKey string, messages []ChatMessage, cancel chan str
 	}()
 	
 	reqBody := ChatRequest{
-		Model:       modelName,
+		Model:       activeModel(),
 		MaxTokens:   4096,
 		Temperature: 0.7,
 		Stream:      true,
-		Messages:    messages,
+		Messages:    apiMessages(messages),
 	}
 
 	body, _ := json.Marshal(reqBody)
@@ -1969,6 +4127,7 @@ func sendStreamWithCancel(apiKey string, messages []ChatMessage, cancel chan str
 
 		if sr.Usage.TotalTokens > 0 {
 			totalTokens = sr.Usage.TotalTokens
+			lastUsage = sr.Usage
 		}
 	}
 
@@ -1999,19 +4158,51 @@ func handleCommand(input string, scanner *bufio.Scanner) string {
 /cd <d>     Change directory
 /python <c> Run Python
 /node <c>   Run JavaScript
+/sandbox [on|off] Container for run/python/node
+/jobs [log|stop] Background jobs (/run cmd &)
+/policy     Command allow/deny lists
+/confine    Keep cd/run in the project
+/env        Scrub commands' environment
+/limits     Resource limits for commands
+/test       Run tests (--fix: until green)
+/check      Build/lint after edits
+/commit     Commit with a written message
+/shadow     Journal of AI edits in git
+/pr         Open a pull request, or its CI status
+/review     Review pending changes
+/resolve    Resolve merge conflicts
+/worktree   Work on a separate branch
 /search <q> Web search
 /img <f>    Analyze image
 /settings   Open settings menu
 /mcp        Manage MCP servers
 /mode       Toggle mode
-/undo       Undo change
+/model [m]  Show/switch model
+/undo [n]   List changes, undo n (1 = last)
+/redo       Redo last undo
 /save       Save session
-/export [f] Export chat
+/rename <n> Name this session
+/fork [n]   Fork session at message n
+/checkpoint <n> Mark history + files
+/rollback <n>   Rewind to checkpoint
+/revert-turn [n] Tree before last n turns (git)
+/revert-session Tree before this session (git)
+/changes    Files changed this session
+/tag <t>    Tag session (/untag to remove)
+/export [f] Export chat (--profile p, --raw)
+/set n=v    Define {{n}} for this session
+/cache [list|clear] Web cache
+/sync [push|pull] Cloud sync now
 /copy       Copy last response
-/cost       Show API cost
-/context    Context usage
+/extract [n] [f] Write code block n to a file
+/cost       API cost (--detail: per turn/model)
+/context [preview [f]] Context breakdown / next request
+/compact    Summarize old messages
+/pin [n|list] Keep an exchange in context (/unpin)
+/pin-file [f] Re-attach a file every turn (/unpin-file f|all)
+/index [status|--rebuild] Update the code index
 /memory     Show memory
-/remember   Remember fact
+/remember k=v Remember fact (--project, --session, --ttl 30d)
 /forget <k> Forget fact
 /clear      Clear history
 exit        Quit`
@@ -2022,25 +4213,60 @@ exit        Quit`
 		showMCPServers(scanner)
 		return ""
 	case "/read", "/cat":
-		return cmdRead(arg)
+		return executeTool("read", arg)
 	case "/ls", "/dir":
-		return cmdList(arg)
+		return executeTool("ls", arg)
 	case "/run", "/exec", "/$":
-		return cmdRun(arg)
+		return shownResult(executeTool("run", arg))
 	case "/find":
-		return cmdFind(arg)
+		return executeTool("find", arg)
 	case "/grep":
-		return cmdGrep(arg)
+		return executeTool("grep", arg)
 	case "/tree":
-		return cmdTree(arg)
+		return executeTool("tree", arg)
 	case "/git":
-		return cmdGit(arg)
+		return executeTool("git", arg)
 	case "/cd":
-		return cmdCd(arg)
+		return executeTool("cd", arg)
 	case "/pwd":
 		return currentDir
 	case "/edit":
 		return cmdEdit(arg, scanner)
+	case "/rename", "/tag", "/untag":
+		return cmdSessionMeta(cmd, arg)
+	case "/cache":
+		return cmdCache(arg)
+	case "/sandbox":
+		return cmdSandbox(arg)
+	case "/jobs":
+		return cmdJobs(arg)
+	case "/policy":
+		return cmdPolicy(arg)
+	case "/confine":
+		return cmdConfine(arg)
+	case "/env":
+		return cmdEnv(arg)
+	case "/limits":
+		return cmdLimits(arg)
+	case "/check":
+		return cmdCheck(arg)
+	case "/commit":
+		return cmdCommit(arg)
+	case "/shadow":
+		return cmdShadow(arg)
+	case "/resolve":
+		return cmdResolve(arg)
+	case "/worktree":
+		return cmdWorktree(arg)
+	case "/changes":
+		return cmdChanges(arg)
+	case "/set":
+		return cmdSet(arg)
+	case "/extract":
+		return cmdExtract(arg, lastResponse)
+	case "/sync":
+		runSyncCmd(strings.Fields(arg), scanner)
+		return ""
 	case "/clear":
 		return "Cleared"
 	default:
@@ -2048,11 +4274,12 @@ exit        Quit`
 	}
 }
 
-func sendStream(apiKey string, messages []ChatMessage) (string, error) {
+// tap, when not nil, also gets the answer as it arrives
+func sendStream(apiKey string, messages []ChatMessage, tap io.Writer) (string, error) {
 	reqBody := ChatRequest{
-		Model:       modelName,
+		Model:       activeModel(),
 		MaxTokens:   4096,
-		Messages:    messages,
+		Messages:    apiMessages(messages),
 		Stream:      true,
 		Temperature: 0.7,
 	}
@@ -2098,10 +4325,14 @@ func sendStream(apiKey string, messages []ChatMessage) (string, error) {
 					if content != "" {
 						fmt.Print(content)
 						full.WriteString(content)
+						if tap != nil {
+							tap.Write([]byte(content))
+						}
 					}
 				}
 				if sr.Usage.TotalTokens > 0 {
 					totalTokens = sr.Usage.TotalTokens
+					lastUsage = sr.Usage
 				}
 			}
 		}
@@ -2110,3 +4341,132 @@ func sendStream(apiKey string, messages []ChatMessage) (string, error) {
 	fmt.Printf("%s", colorReset)
 	return full.String(), nil
 }
+
+// ==================== FOLLOW-UPS ====================
+
+// Non-streaming request for short side tasks (suggestions, titles, ...)
+// kind labels the call in the usage ledger
+func sendComplete(apiKey string, messages []ChatMessage, maxTokens int, kind string) (string, error) {
+	reqBody := ChatRequest{
+		Model:       activeModel(),
+		MaxTokens:   maxTokens,
+		Messages:    apiMessages(messages),
+		Temperature: 0.3,
+	}
+
+	jsonBody, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", minimaxAPIURL, bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+	}
+
+	var cr CompletionResponse
+	if err := json.Unmarshal(body, &cr); err != nil {
+		return "", err
+	}
+	lastUsage = cr.Usage
+	recordUsage(kind)
+	if len(cr.Choices) == 0 {
+		return "", fmt.Errorf("empty response")
+	}
+	return strings.TrimSpace(cr.Choices[0].Message.Content), nil
+}
+
+// Asks the model for a title and abstract once the conversation has moved
+// on since the last time; without force only untitled sessions get one.
+func refreshSessionTitle(history []ChatMessage, force bool) {
+	if (!force && sessionTitle != "") || len(history) == summarizedLen {
+		return
+	}
+	apiKey := getAPIKey()
+	if apiKey == "" {
+		return
+	}
+	transcript := renderTranscript(history, RedactionProfile{MaxToolOutputLines: 5})
+	if strings.TrimSpace(transcript) == "" {
+		return
+	}
+	if len(transcript) > 8000 {
+		transcript = transcript[:2000] + "\n...\n" + transcript[len(transcript)-6000:]
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: "Summarize this coding session. Line 1: a title of at most 8 words, no quotes. " +
+			"Line 2: a one or two sentence abstract of what was done. Same language as the user."},
+		{Role: "user", Content: transcript},
+	}
+	out, err := sendComplete(apiKey, messages, 150, "title")
+	if err != nil {
+		return
+	}
+	label := regexp.MustCompile(`(?i)^(?:#+\s*)?(?:title|judul|abstract|summary|ringkasan)?\s*:?\s*`)
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = label.ReplaceAllString(strings.TrimSpace(line), ""); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	sessionTitle = truncate(strings.Trim(lines[0], "\"*"), 80)
+	sessionSummary = strings.Join(lines[1:], " ")
+	summarizedLen = len(history)
+}
+
+func suggestFollowUps(apiKey string, history []ChatMessage) []string {
+	start := len(history) - 4
+	if start < 1 {
+		start = 1
+	}
+	var convo strings.Builder
+	for _, m := range history[start:] {
+		convo.WriteString(fmt.Sprintf("%s: %s\n\n", m.Role, truncate(m.Content, 1500)))
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: "Suggest 3 short follow-up requests the user is likely to send next " +
+			"(e.g. \"run the tests\", \"show the diff\", \"explain this function\"). " +
+			"One per line, max 8 words each, no numbering, same language as the user."},
+		{Role: "user", Content: convo.String()},
+	}
+	out, err := sendComplete(apiKey, messages, 120, "follow_ups")
+	if err != nil {
+		return nil
+	}
+
+	bullet := regexp.MustCompile(`^(?:[-*•]|\d+[.)])\s*`)
+	var suggestions []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.Trim(bullet.ReplaceAllString(strings.TrimSpace(line), ""), "\"")
+		if line == "" {
+			continue
+		}
+		suggestions = append(suggestions, line)
+		if len(suggestions) == 3 {
+			break
+		}
+	}
+	return suggestions
+}
+
+func printFollowUps(suggestions []string) {
+	if len(suggestions) == 0 {
+		return
+	}
+	fmt.Printf("%sSuggested (press number to send):%s\n", colorGray, colorReset)
+	for i, s := range suggestions {
+		fmt.Printf("  %s%d%s %s\n", colorYellow, i+1, colorReset, s)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== MCP CLIENT ====================
+
+// Minimal MCP client: streamable HTTP for URL servers, newline-delimited
+// JSON-RPC over stdio for command servers. Tools are exposed to the model
+// namespaced as server.tool. A server can do anything, so its tools are
+// gated by the mode like write and run are, except those the server marks
+// read-only.
+
+// How long a stdio server gets to answer before it is stopped
+const mcpCallTimeout = 2 * time.Minute
+
+type mcpClient struct {
+	server    MCPServer
+	sessionID string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    *bufio.Reader
+	nextID    int
+	mu        sync.Mutex
+	closed    bool
+}
+
+type mcpRemoteTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema struct {
+		Required []string `json:"required"`
+	} `json:"inputSchema"`
+	Annotations struct {
+		ReadOnlyHint bool `json:"readOnlyHint"`
+	} `json:"annotations"`
+}
+
+var (
+	mcpClients  = map[string]*mcpClient{}
+	mcpToolInfo = map[string]mcpRemoteTool{} // keyed by server.tool
+)
+
+func isBuiltinTool(name string) bool {
+	for _, t := range toolDocs {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validMCPServerName(name string) error {
+	if strings.ContainsAny(name, ".: ") {
+		return fmt.Errorf("server name cannot contain '.', ':' or spaces")
+	}
+	if isBuiltinTool(name) {
+		return fmt.Errorf("server name %q collides with a built-in tool", name)
+	}
+	return nil
+}
+
+func mcpServerURL(u string) string {
+	if strings.HasPrefix(u, "http") {
+		return u
+	}
+	if strings.HasPrefix(u, "localhost") || strings.HasPrefix(u, "127.0.0.1") {
+		return "http://" + u
+	}
+	return "https://" + u
+}
+
+// Connects, performs the initialize handshake and lists tools
+func connectMCP(server MCPServer) (*mcpClient, []mcpRemoteTool, error) {
+	c := &mcpClient{server: server}
+	if server.Command != "" {
+		c.cmd = exec.Command("sh", "-c", server.Command)
+		c.cmd.Dir = currentDir
+		c.stdin, _ = c.cmd.StdinPipe()
+		out, _ := c.cmd.StdoutPipe()
+		c.stdout = bufio.NewReaderSize(out, 1024*1024)
+		if err := c.cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	_, err := c.call("initialize", map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "mytool", "version": version},
+	})
+	if err != nil {
+		c.close()
+		return nil, nil, err
+	}
+	c.notify("notifications/initialized")
+
+	raw, err := c.call("tools/list", map[string]interface{}{})
+	if err != nil {
+		c.close()
+		return nil, nil, err
+	}
+	var list struct {
+		Tools []mcpRemoteTool `json:"tools"`
+	}
+	json.Unmarshal(raw, &list)
+	return c, list.Tools, nil
+}
+
+func (c *mcpClient) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if c.cmd != nil && c.cmd.Process != nil {
+		c.stdin.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	}
+}
+
+func (c *mcpClient) notify(method string) {
+	msg, _ := json.Marshal(map[string]string{"jsonrpc": "2.0", "method": method})
+	if c.cmd != nil {
+		c.stdin.Write(append(msg, '\n'))
+		return
+	}
+	if resp, err := c.post(msg); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (c *mcpClient) call(method string, params interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := c.nextID
+	msg, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+
+	var reply rpcMessage
+	if c.cmd != nil {
+		if _, err := c.stdin.Write(append(msg, '\n')); err != nil {
+			return nil, err
+		}
+		done := make(chan error, 1)
+		go func() {
+			for {
+				line, err := c.stdout.ReadBytes('\n')
+				if err != nil {
+					done <- fmt.Errorf("server closed: %s", err)
+					return
+				}
+				if json.Unmarshal(line, &reply) == nil && string(reply.ID) == fmt.Sprint(id) {
+					done <- nil
+					return
+				}
+			}
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+		case <-time.After(mcpCallTimeout):
+			c.close() // also ends the read above
+			return nil, fmt.Errorf("no reply from %s within %s; it was stopped and restarts on the next call", c.server.Name, mcpCallTimeout)
+		}
+	} else {
+		resp, err := c.post(msg)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == 401 {
+			return nil, fmt.Errorf("unauthorized, authenticate via /mcp")
+		}
+		if resp.StatusCode >= 400 {
+			body, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncate(string(body), 200))
+		}
+		if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" {
+			c.sessionID = sid
+		}
+		if err := readRPCReply(resp, id, &reply); err != nil {
+			return nil, err
+		}
+	}
+
+	if reply.Error != nil {
+		return nil, fmt.Errorf("%s (%d)", reply.Error.Message, reply.Error.Code)
+	}
+	data, _ := json.Marshal(reply.Result)
+	return data, nil
+}
+
+func (c *mcpClient) post(body []byte) (*http.Response, error) {
+	req, _ := http.NewRequest("POST", mcpServerURL(c.server.URL), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if c.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", c.sessionID)
+	}
+	if c.server.Auth == "oauth" {
+		token, err := mcpAccessToken(c.server)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	return client.Do(req)
+}
+
+// Reply is either a JSON body or an SSE stream carrying it
+func readRPCReply(resp *http.Response, id int, reply *rpcMessage) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return json.NewDecoder(resp.Body).Decode(reply)
+	}
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("stream ended without reply")
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		if json.Unmarshal([]byte(strings.TrimSpace(line[5:])), reply) == nil && string(reply.ID) == fmt.Sprint(id) {
+			return nil
+		}
+	}
+}
+
+// Connects a server and records its tools; returns collision warnings
+func mcpConnect(idx int) ([]string, error) {
+	server := mcpServers[idx]
+	c, tools, err := connectMCP(server)
+	if err != nil {
+		return nil, err
+	}
+	if old, ok := mcpClients[server.Name]; ok {
+		old.close()
+	}
+	mcpClients[server.Name] = c
+
+	var names []string
+	for _, t := range tools {
+		names = append(names, t.Name)
+		mcpToolInfo[server.Name+"."+t.Name] = t
+	}
+	sort.Strings(names)
+	mcpServers[idx].Tools = names
+	mcpServers[idx].Connected = true
+	return mcpCollisions(server.Name, names), nil
+}
+
+func mcpDisconnect(idx int) {
+	name := mcpServers[idx].Name
+	if c, ok := mcpClients[name]; ok {
+		c.close()
+		delete(mcpClients, name)
+	}
+	mcpServers[idx].Connected = false
+}
+
+func mcpCollisions(serverName string, tools []string) []string {
+	var warnings []string
+	for _, t := range tools {
+		if isBuiltinTool(t) {
+			warnings = append(warnings, fmt.Sprintf("%s.%s has the same name as built-in %q — call it as %s.%s", serverName, t, t, serverName, t))
+		}
+		for _, other := range mcpServers {
+			if other.Name == serverName || !other.Connected {
+				continue
+			}
+			for _, ot := range other.Tools {
+				if ot == t {
+					warnings = append(warnings, fmt.Sprintf("%q is provided by both %s and %s — use %s.%s or %s.%s", t, serverName, other.Name, serverName, t, other.Name, t))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+func isMCPToolDisabled(server MCPServer, tool string) bool {
+	for _, d := range server.DisabledTools {
+		if d == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// Executes server.tool; arg is a JSON object or a plain string that is
+// passed as the tool's first required parameter.
+func callMCPTool(name, arg string) string {
+	parts := strings.SplitN(name, ".", 2)
+	serverName, tool := parts[0], parts[1]
+
+	idx := -1
+	for i, s := range mcpServers {
+		if s.Name == serverName {
+			idx = i
+		}
+	}
+	if idx == -1 || !mcpServers[idx].Connected {
+		return "Unknown tool: " + name
+	}
+	if isMCPToolDisabled(mcpServers[idx], tool) {
+		return fmt.Sprintf("Error: %s is disabled in /mcp", name)
+	}
+	if c, ok := mcpClients[serverName]; !ok || c.closed {
+		if _, err := mcpConnect(idx); err != nil {
+			return fmt.Sprintf("Error: connect %s: %s", serverName, err)
+		}
+	}
+
+	args := map[string]interface{}{}
+	if arg != "" && json.Unmarshal([]byte(arg), &args) != nil {
+		key := "input"
+		if info, ok := mcpToolInfo[name]; ok && len(info.InputSchema.Required) > 0 {
+			key = info.InputSchema.Required[0]
+		}
+		args = map[string]interface{}{key: arg}
+	}
+	if !mcpToolInfo[name].Annotations.ReadOnlyHint {
+		if currentMode == ModeManual {
+			return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
+		}
+		if currentMode == ModeAsk && !confirmAction(fmt.Sprintf("%sCall %s %s?%s", colorYellow, name, truncate(arg, 200), colorReset)) {
+			return "Cancelled"
+		}
+	}
+
+	raw, err := mcpClients[serverName].call("tools/call", map[string]interface{}{"name": tool, "arguments": args})
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	var res struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	json.Unmarshal(raw, &res)
+	var texts []string
+	for _, c := range res.Content {
+		if c.Type == "text" {
+			texts = append(texts, c.Text)
+		} else {
+			texts = append(texts, fmt.Sprintf("[%s content]", c.Type))
+		}
+	}
+	out := strings.Join(texts, "\n")
+	if res.IsError {
+		return "Error: " + out
+	}
+	return out
+}
+
+// Tool picker for one server: toggles individual tools on/off
+func manageMCPTools(idx int) {
+	for {
+		server := mcpServers[idx]
+		if len(server.Tools) == 0 {
+			selectMenu(server.Name+": no tools (connect first)", []string{"← Back"}, 0)
+			return
+		}
+		var options []string
+		for _, t := range server.Tools {
+			mark := "✓"
+			if isMCPToolDisabled(server, t) {
+				mark = "✗"
+			}
+			options = append(options, fmt.Sprintf("%s %s.%s", mark, server.Name, t))
+		}
+		options = append(options, "← Back")
+
+		choice := selectMenu("🔧 Tools for "+server.Name, options, 0)
+		if choice == -1 || choice == len(options)-1 {
+			return
+		}
+		tool := server.Tools[choice]
+		if isMCPToolDisabled(server, tool) {
+			var kept []string
+			for _, d := range server.DisabledTools {
+				if d != tool {
+					kept = append(kept, d)
+				}
+			}
+			mcpServers[idx].DisabledTools = kept
+		} else {
+			mcpServers[idx].DisabledTools = append(mcpServers[idx].DisabledTools, tool)
+		}
+		saveMCPServers()
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ==================== MCP SERVER (STDIO) ====================
+
+// `mytool mcp-serve` exposes the built-in tools over MCP (JSON-RPC 2.0,
+// newline-delimited on stdin/stdout). The current mode still applies:
+// manual blocks writes and commands, ask prompts on the controlling tty
+// (the console on Windows) and denies when there is none.
+
+const mcpProtocolVersion = "2024-11-05"
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpToolDef struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	args        []string               // joined with "|||" into the tool argument
+}
+
+func schema(required []string, props ...string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, p := range props {
+		properties[p] = map[string]string{"type": "string"}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+}
+
+// The tools with arguments of their own; every other built-in tool is
+// served with a single "arg" in its usual syntax (see servedToolDefs)
+var curatedTools = []mcpToolDef{
+	{Name: "read", Description: "Read a file with line numbers", InputSchema: schema([]string{"path"}, "path"), args: []string{"path"}},
+	{Name: "ls", Description: "List a directory", InputSchema: schema(nil, "path"), args: []string{"path"}},
+	{Name: "tree", Description: "Show directory structure", InputSchema: schema(nil, "path"), args: []string{"path"}},
+	{Name: "find", Description: "Find files by name pattern", InputSchema: schema([]string{"pattern"}, "pattern"), args: []string{"pattern"}},
+	{Name: "grep", Description: "Search text in files", InputSchema: schema([]string{"pattern"}, "pattern", "path"), args: []string{"pattern", "path"}},
+	{Name: "write", Description: "Create or overwrite a file", InputSchema: schema([]string{"path", "content"}, "path", "content"), args: []string{"path", "content"}},
+	{Name: "replace", Description: "Replace exact text in a file", InputSchema: schema([]string{"path", "old", "new"}, "path", "old", "new"), args: []string{"path", "old", "new"}},
+	{Name: "append", Description: "Append content to a file", InputSchema: schema([]string{"path", "content"}, "path", "content"), args: []string{"path", "content"}},
+	{Name: "run", Description: "Run a shell command", InputSchema: schema([]string{"command"}, "command"), args: []string{"command"}},
+	{Name: "git", Description: "Run a git command", InputSchema: schema(nil, "args"), args: []string{"args"}},
+	{Name: "python", Description: "Run Python code", InputSchema: schema([]string{"code"}, "code"), args: []string{"code"}},
+	{Name: "node", Description: "Run JavaScript code", InputSchema: schema([]string{"code"}, "code"), args: []string{"code"}},
+	{Name: "fetch", Description: "Fetch a URL", InputSchema: schema([]string{"url"}, "url"), args: []string{"url"}},
+	{Name: "search", Description: "Web search", InputSchema: schema([]string{"query"}, "query"), args: []string{"query"}},
+}
+
+var servedTools = servedToolDefs()
+
+var toolTagRe = regexp.MustCompile(`</?tool>`)
+
+// curatedTools plus the rest of toolDocs, so clients see every tool
+func servedToolDefs() []mcpToolDef {
+	defs := append([]mcpToolDef{}, curatedTools...)
+	for _, t := range toolDocs {
+		if slices.ContainsFunc(curatedTools, func(d mcpToolDef) bool { return d.Name == t.Name }) {
+			continue
+		}
+		syntax := toolSyntax[t.Name]
+		if syntax == "" {
+			syntax = t.Name + ":<arg>"
+		}
+		s := schema([]string{"arg"}, "arg")
+		s["properties"].(map[string]interface{})["arg"] = map[string]string{
+			"type":        "string",
+			"description": "Everything after \"" + t.Name + ":\" in " + syntax,
+		}
+		defs = append(defs, mcpToolDef{Name: t.Name, Description: toolTagRe.ReplaceAllString(t.Doc, ""), InputSchema: s, args: []string{"arg"}})
+	}
+	return defs
+}
+
+func runMCPServe(args []string) {
+	currentMode = ModeAsk
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--mode" && i+1 < len(args) {
+			switch args[i+1] {
+			case ModeAuto, ModeAsk, ModeManual:
+				currentMode = args[i+1]
+			}
+			i++
+		}
+	}
+
+	// Everything the tools print goes to stderr; stdout carries the protocol
+	serveMode = true
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	fmt.Fprintf(os.Stderr, "mytool MCP server v%s (mode: %s, dir: %s)\n", version, currentMode, currentDir)
+
+	enc := json.NewEncoder(out)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 4*1024*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req rpcMessage
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			enc.Encode(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: -32700, Message: "parse error"}})
+			continue
+		}
+		if len(req.ID) == 0 {
+			continue // notification
+		}
+
+		resp := rpcMessage{JSONRPC: "2.0", ID: req.ID}
+		switch req.Method {
+		case "initialize":
+			resp.Result = map[string]interface{}{
+				"protocolVersion": mcpProtocolVersion,
+				"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+				"serverInfo":      map[string]string{"name": "mytool", "version": version},
+			}
+		case "ping":
+			resp.Result = map[string]interface{}{}
+		case "tools/list":
+			var tools []mcpToolDef
+			for _, t := range servedTools {
+				if !isToolDisabled(t.Name) && !isToolUnavailable(t.Name) {
+					tools = append(tools, t)
+				}
+			}
+			resp.Result = map[string]interface{}{"tools": tools}
+		case "tools/call":
+			resp.Result = callServedTool(req.Params)
+		default:
+			resp.Error = &rpcError{Code: -32601, Message: "method not found: " + req.Method}
+		}
+		enc.Encode(resp)
+	}
+}
+
+func callServedTool(params json.RawMessage) map[string]interface{} {
+	var p struct {
+		Name      string            `json:"name"`
+		Arguments map[string]string `json:"arguments"`
+	}
+	json.Unmarshal(params, &p)
+
+	var def *mcpToolDef
+	for i := range servedTools {
+		if servedTools[i].Name == p.Name {
+			def = &servedTools[i]
+		}
+	}
+	if def == nil {
+		return toolContent("Unknown tool: "+p.Name, true)
+	}
+
+	var vals []string
+	for _, a := range def.args {
+		vals = append(vals, p.Arguments[a])
+	}
+	arg := strings.Join(vals, "|||")
+	if p.Name == "grep" {
+		arg = strings.TrimSpace(strings.Join(vals, " "))
+	}
+
+	out := executeTool(p.Name, arg)
+	r := ToolResult{Tool: p.Name, Output: out, Err: classifyToolResult(p.Name, out)}
+	if r.Err != nil {
+		data, _ := json.Marshal(r.Err)
+		return toolContent(string(data), true)
+	}
+	return toolContent(stripANSI(out), false)
+}
+
+func toolContent(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
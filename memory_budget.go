@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ==================== MEMORY BUDGET ====================
+
+// The MEMORY block is resent with every message, so it is capped at
+// settings.MemoryBudget tokens. When the facts don't fit, the ones least
+// recently sent to the model are left out of the prompt (not forgotten)
+// and a warning names them. Session facts are short-lived by design and
+// count as the most recent.
+
+var memoryBudgetWarned string // dropped keys last warned about
+
+// Rough token count: about four characters per token for English and code
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+func memoryTokens(lines []string) int {
+	n := 0
+	for _, l := range lines {
+		n += estimateTokens(l) + 1
+	}
+	return n
+}
+
+func budgetLabel(tokens int) string {
+	if tokens <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d tokens", tokens)
+}
+
+// Drops the least recently used keys until the lines fit the budget; keys
+// keeps its order
+func fitMemoryBudget(facts map[string]memoryFact, keys []string) (kept, dropped []string) {
+	budget := settings.MemoryBudget
+	total := 0
+	for _, k := range keys {
+		total += estimateTokens(memoryLine(k, facts[k].Value)) + 1
+	}
+	if budget <= 0 || total <= budget {
+		return keys, nil
+	}
+
+	lastUsed := func(k string) time.Time {
+		if facts[k].Scope == scopeSession {
+			return time.Now()
+		}
+		return memoryMeta.Facts[metaKey(facts[k].Scope, k)].Used
+	}
+	out := map[string]bool{}
+	for total > budget {
+		victim := ""
+		for _, k := range keys {
+			if !out[k] && (victim == "" || lastUsed(k).Before(lastUsed(victim))) {
+				victim = k
+			}
+		}
+		if victim == "" {
+			break
+		}
+		out[victim] = true
+		total -= estimateTokens(memoryLine(victim, facts[victim].Value)) + 1
+	}
+	for _, k := range keys {
+		if out[k] {
+			dropped = append(dropped, k)
+		} else {
+			kept = append(kept, k)
+		}
+	}
+	return kept, dropped
+}
+
+// Warns once per distinct set of dropped facts
+func warnMemoryBudget(dropped []string) {
+	names := strings.Join(dropped, ", ")
+	if names == memoryBudgetWarned {
+		return
+	}
+	memoryBudgetWarned = names
+	if len(dropped) == 0 {
+		return
+	}
+	fmt.Printf("%s⚠ Memory is over its %s budget; left out of the prompt: %s%s %s(/memory edit to trim, /settings to raise)%s\n",
+		colorYellow, budgetLabel(settings.MemoryBudget), truncate(names, 120), colorReset, colorGray, colorReset)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ==================== MEMORY CONFLICTS ====================
+
+// /remember checks a new fact against what is stored before writing it.
+// The same key (compared loosely: "DB_Name" matches "dbname") with another
+// value is a contradiction; the same value under another key in any scope
+// is a duplicate. Either way the user picks what happens instead of the
+// old fact being silently overwritten.
+
+type memoryConflict struct {
+	Scope, Key, Value string
+	SameKey           bool
+}
+
+// Lowercase letters and digits only
+func normalizeFact(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+func findMemoryConflicts(scope, key, value string) []memoryConflict {
+	var conflicts []memoryConflict
+	nk, nv := normalizeFact(key), normalizeFact(value)
+	for _, sc := range memoryScopes {
+		m := scopeMemory(sc)
+		for _, k := range sortedKeys(m) {
+			switch {
+			case sc == scope && normalizeFact(k) == nk:
+				if m[k] != value {
+					conflicts = append(conflicts, memoryConflict{sc, k, m[k], true})
+				}
+			case nv != "" && normalizeFact(m[k]) == nv:
+				conflicts = append(conflicts, memoryConflict{sc, k, m[k], false})
+			}
+		}
+	}
+	return conflicts
+}
+
+// When a fact was stored, for "keep both"
+func factDate(scope, key string) string {
+	if m, ok := memoryMeta.Facts[metaKey(scope, key)]; ok && scope != scopeSession {
+		return m.Created.Format("2006-01-02")
+	}
+	return time.Now().Format("2006-01-02")
+}
+
+// Stores key=value after resolving conflicts with the user; returns what
+// happened
+func rememberChecked(scope, key, value string, ttl time.Duration, scanner *bufio.Scanner) string {
+	for _, c := range findMemoryConflicts(scope, key, value) {
+		if c.SameKey {
+			fmt.Printf("%s⚠ %s is already remembered%s %s[%s]%s\n", colorYellow, c.Key, colorReset, colorGray, scopeLabel(c.Scope), colorReset)
+			fmt.Printf("  %s%s%s\n  %s%s%s\n", colorRed, memoryLine(c.Key, c.Value), colorReset, colorGreen, memoryLine(key, value), colorReset)
+			answer := askChoice(scanner, "[r]eplace / [m]erge / keep [b]oth with dates / [c]ancel?", "r", "m", "b", "c")
+			switch answer {
+			case "r", "m":
+				if c.Key != key {
+					forgetFact(c.Scope, c.Key)
+				}
+				if answer == "m" {
+					value = c.Value + "; " + value
+				}
+			case "b":
+				oldKey, newKey := c.Key+"@"+factDate(c.Scope, c.Key), key+"@"+time.Now().Format("2006-01-02")
+				if normalizeFact(oldKey) == normalizeFact(newKey) {
+					newKey = key + "@" + time.Now().Format("2006-01-02 15:04")
+				}
+				forgetFact(c.Scope, c.Key)
+				rememberFact(c.Scope, oldKey, c.Value, 0)
+				key = newKey
+			default:
+				return "Cancelled"
+			}
+			continue
+		}
+		fmt.Printf("%s≈ The same fact is stored as %s%s %s[%s]%s: %s\n", colorYellow, c.Key, colorReset, colorGray, scopeLabel(c.Scope), colorReset, c.Value)
+		switch askChoice(scanner, fmt.Sprintf("[r]eplace %s / keep [b]oth / [c]ancel?", c.Key), "r", "b", "c") {
+		case "r":
+			forgetFact(c.Scope, c.Key)
+		case "b":
+		default:
+			return "Cancelled"
+		}
+	}
+	rememberFact(scope, key, value, ttl)
+	return fmt.Sprintf("Remembered (%s): %s", scopeLabel(scope), key)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ==================== MEMORY EDITOR ====================
+
+// /memory edit: browse every scope's facts with selectMenu, see a value in
+// full, change it, delete it, or narrow the list with a search.
+
+type memoryEntry struct {
+	Scope, Key string
+}
+
+func memoryEntries(query string) []memoryEntry {
+	query = strings.ToLower(query)
+	var entries []memoryEntry
+	for _, scope := range memoryScopes {
+		m := scopeMemory(scope)
+		for _, k := range sortedKeys(m) {
+			if query == "" || strings.Contains(strings.ToLower(k), query) || strings.Contains(strings.ToLower(m[k]), query) {
+				entries = append(entries, memoryEntry{scope, k})
+			}
+		}
+	}
+	return entries
+}
+
+func editMemory(query string, scanner *bufio.Scanner) string {
+	cursor, changes := 0, 0
+	for {
+		entries := memoryEntries(query)
+		search := "🔍 Search"
+		if query != "" {
+			search = fmt.Sprintf("🔍 Search: %s %s(%d match)%s", query, colorGray, len(entries), colorReset)
+		}
+		options := []string{search}
+		for _, e := range entries {
+			options = append(options, fmt.Sprintf("%s%-8s%s %s%s%s: %s", colorGray, e.Scope, colorReset,
+				colorYellow, e.Key, colorReset, truncate(strings.ReplaceAll(scopeMemory(e.Scope)[e.Key], "\n", " "), 60)))
+		}
+		options = append(options, "← Back")
+
+		cursor = selectMenu(fmt.Sprintf("🧠 Memory (%d items)", len(entries)), options, cursor)
+		switch {
+		case cursor == -1 || cursor == len(options)-1:
+			return fmt.Sprintf("Memory editor closed (%d change(s))", changes)
+		case cursor == 0:
+			fmt.Print("\033[H\033[2J")
+			fmt.Print("Search keys and values (Enter to show all): ")
+			if scanner.Scan() {
+				query = strings.TrimSpace(scanner.Text())
+			}
+			continue
+		}
+		if editMemoryEntry(entries[cursor-1], scanner) {
+			changes++
+		}
+	}
+}
+
+// Shows one fact in full with its actions; true when it was changed
+func editMemoryEntry(e memoryEntry, scanner *bufio.Scanner) bool {
+	value := scopeMemory(e.Scope)[e.Key]
+	title := fmt.Sprintf("🧠 %s %s[%s]%s\n\n%s", e.Key, colorGray, scopeLabel(e.Scope), colorReset, value)
+	if note := factNote(e.Scope, e.Key); note != "" {
+		title += fmt.Sprintf("\n\n%s%s%s", colorGray, note, colorReset)
+	}
+	switch selectMenu(title, []string{"Edit value", "Delete", "← Back"}, 0) {
+	case 0:
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%s%s%s: %s\n\nNew value (Enter to keep): ", colorYellow, e.Key, colorReset, value)
+		if !scanner.Scan() {
+			return false
+		}
+		v := strings.TrimSpace(scanner.Text())
+		if v == "" || v == value {
+			return false
+		}
+		// Editing keeps whatever expiry the fact had
+		var ttl time.Duration
+		if m, ok := memoryMeta.Facts[metaKey(e.Scope, e.Key)]; ok && !m.Expires.IsZero() {
+			ttl = max(time.Until(m.Expires), time.Second)
+		}
+		rememberFact(e.Scope, e.Key, v, ttl)
+		return true
+	case 1:
+		if !confirmAction(fmt.Sprintf("Delete %s?", memoryLine(e.Key, value))) {
+			return false
+		}
+		forgetFact(e.Scope, e.Key)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ==================== MEMORY IMPORT/EXPORT ====================
+
+// `mytool memory export` writes one scope's facts to a portable file;
+// `import` merges such a file (or a bare memory.json) back in. Keys only
+// present on one side are simply kept; for keys with different values the
+// strategy decides: ours keeps the local value, theirs takes the file's,
+// interactive asks each time.
+
+const memoryFileSchema = "mytool-memory"
+
+type memoryFile struct {
+	Schema   string            `json:"schema"`
+	Version  int               `json:"version"`
+	Exported time.Time         `json:"exported"`
+	Scope    string            `json:"scope"`
+	Facts    map[string]string `json:"facts"`
+}
+
+// mytool memory [edit [query] | export [--scope s] [--out f] | import <f> [--strategy s] [--scope s]]
+func runMemoryCmd(args []string) {
+	if len(args) == 0 {
+		showMemory()
+		return
+	}
+	scope, out, strategy := scopeGlobal, "", "interactive"
+	var files []string
+	for i := 1; i < len(args); i++ {
+		switch {
+		case args[i] == "--scope" && i+1 < len(args):
+			scope = args[i+1]
+			i++
+		case args[i] == "--out" && i+1 < len(args):
+			out = args[i+1]
+			i++
+		case args[i] == "--strategy" && i+1 < len(args):
+			strategy = args[i+1]
+			i++
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if scope != scopeGlobal && scope != scopeProject {
+		fmt.Println("--scope must be global or project")
+		return
+	}
+
+	switch args[0] {
+	case "edit":
+		fmt.Println(editMemory(strings.Join(files, " "), bufio.NewScanner(os.Stdin)))
+	case "export":
+		data, _ := json.MarshalIndent(memoryFile{
+			Schema: memoryFileSchema, Version: 1, Exported: time.Now(), Scope: scope, Facts: scopeMemory(scope),
+		}, "", "  ")
+		if out == "" {
+			fmt.Println(string(data))
+			return
+		}
+		if err := os.WriteFile(out, append(data, '\n'), 0644); err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		fmt.Printf("%s✓ Exported %d %s facts to %s%s\n", colorGreen, len(scopeMemory(scope)), scope, out, colorReset)
+	case "import":
+		if len(files) == 0 {
+			fmt.Println("Usage: mytool memory import <file> [--strategy ours|theirs|interactive] [--scope global|project]")
+			return
+		}
+		if strategy != "ours" && strategy != "theirs" && strategy != "interactive" {
+			fmt.Println("--strategy must be ours, theirs or interactive")
+			return
+		}
+		scanner := bufio.NewScanner(os.Stdin)
+		for _, f := range files {
+			added, replaced, kept, err := importMemory(f, scope, strategy, scanner)
+			if err != nil {
+				fmt.Printf("%sError: %s: %s%s\n", colorRed, f, err, colorReset)
+				continue
+			}
+			fmt.Printf("%s✓ %s: %d added, %d replaced, %d kept%s\n", colorGreen, f, added, replaced, kept, colorReset)
+		}
+	default:
+		fmt.Println("Usage: mytool memory [edit|export|import]")
+	}
+}
+
+func importMemory(path, scope, strategy string, scanner *bufio.Scanner) (added, replaced, kept int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var facts map[string]string
+	var mf memoryFile
+	if json.Unmarshal(data, &mf) == nil && mf.Schema == memoryFileSchema {
+		facts = mf.Facts
+	} else if err := json.Unmarshal(data, &facts); err != nil {
+		return 0, 0, 0, fmt.Errorf("not a memory export or memory.json")
+	}
+
+	local := scopeMemory(scope)
+	for _, k := range sortedKeys(facts) {
+		theirs := strings.TrimSpace(facts[k])
+		ours, exists := local[k]
+		switch {
+		case !exists:
+			local[k] = theirs
+			trackFact(scope, k, 0)
+			added++
+		case ours == theirs:
+			kept++
+		case strategy == "theirs" || strategy == "interactive" && preferTheirs(k, ours, theirs, scanner):
+			local[k] = theirs
+			trackFact(scope, k, 0)
+			replaced++
+		default:
+			kept++
+		}
+	}
+	saveScope(scope)
+	return added, replaced, kept, nil
+}
+
+func preferTheirs(key, ours, theirs string, scanner *bufio.Scanner) bool {
+	fmt.Printf("\n%sConflict on %s%s\n", colorCyan, key, colorReset)
+	fmt.Printf("  %so) %s%s\n", colorRed, memoryLine(key, ours), colorReset)
+	fmt.Printf("  %st) %s%s\n", colorGreen, memoryLine(key, theirs), colorReset)
+	fmt.Print("Keep [o]urs or take [t]heirs? ")
+	return scanner.Scan() && strings.HasPrefix(strings.ToLower(strings.TrimSpace(scanner.Text())), "t")
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== MEMORY EXPIRY ====================
+
+// Bookkeeping for global and project facts in ~/.mytool/memory_meta.json:
+// when each was stored, last used and, optionally, when it expires. A
+// fact is used when recall picks it as relevant to a message or a reply
+// mentions it; being sent along with every prompt doesn't count, or
+// small memories would never look unused. Expired facts are dropped at
+// startup; facts unused for settings.MemoryReviewDays are offered for
+// review (keep or forget). Session facts end with their session and are
+// not tracked.
+
+type factMeta struct {
+	Created time.Time `json:"created"`
+	Used    time.Time `json:"used"`
+	Expires time.Time `json:"expires,omitzero"`
+	Updated time.Time `json:"updated,omitzero"` // value last stored; cloud sync merges on it
+}
+
+type memoryMetaFile struct {
+	LastReview time.Time           `json:"last_review,omitzero"`
+	Facts      map[string]factMeta `json:"facts"` // see metaKey
+	// When facts were forgotten, so cloud sync doesn't bring them back
+	Forgotten map[string]time.Time `json:"forgotten,omitempty"`
+}
+
+const memoryReviewBatch = 5
+
+var (
+	memoryMeta    = memoryMetaFile{Facts: map[string]factMeta{}, Forgotten: map[string]time.Time{}}
+	metaDirty     = map[string]bool{} // keys changed since the last save
+	metaForgotten = map[string]bool{}
+	metaReviewed  bool
+)
+
+func metaKey(scope, key string) string {
+	if scope == scopeProject {
+		return scopeProject + ":" + memoryProject + ":" + key
+	}
+	return scopeGlobal + ":" + key
+}
+
+func memoryMetaPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "memory_meta.json")
+}
+
+func loadMemoryMeta() {
+	memoryMeta = memoryMetaFile{Facts: map[string]factMeta{}}
+	if data, err := readPrivateFile(memoryMetaPath()); err == nil {
+		json.Unmarshal(data, &memoryMeta)
+	}
+	if memoryMeta.Facts == nil {
+		memoryMeta.Facts = map[string]factMeta{}
+	}
+	if memoryMeta.Forgotten == nil {
+		memoryMeta.Forgotten = map[string]time.Time{}
+	}
+	// Facts stored before tracking count as fresh
+	now := time.Now()
+	for _, scope := range []string{scopeGlobal, scopeProject} {
+		for k := range scopeMemory(scope) {
+			mk := metaKey(scope, k)
+			if _, ok := memoryMeta.Facts[mk]; !ok {
+				memoryMeta.Facts[mk] = factMeta{Created: now, Used: now}
+				metaDirty[mk] = true
+			}
+		}
+	}
+}
+
+// Merges our changes into the file: the newer Used wins, forgotten keys go
+func saveMemoryMeta() {
+	if len(metaDirty) == 0 && len(metaForgotten) == 0 && !metaReviewed {
+		return
+	}
+	updatePrivateFile(memoryMetaPath(), 0644, func(old []byte) []byte {
+		disk := memoryMetaFile{Facts: map[string]factMeta{}}
+		json.Unmarshal(old, &disk)
+		if disk.Facts == nil {
+			disk.Facts = map[string]factMeta{}
+		}
+		if disk.Forgotten == nil {
+			disk.Forgotten = map[string]time.Time{}
+		}
+		for mk := range metaDirty {
+			ours, theirs := memoryMeta.Facts[mk], disk.Facts[mk]
+			if theirs.Used.After(ours.Used) {
+				ours.Used = theirs.Used
+			}
+			disk.Facts[mk] = ours
+			if ours.Updated.After(disk.Forgotten[mk]) {
+				delete(disk.Forgotten, mk)
+			}
+		}
+		for mk := range metaForgotten {
+			delete(disk.Facts, mk)
+			if t, ok := memoryMeta.Forgotten[mk]; ok {
+				disk.Forgotten[mk] = t
+			}
+		}
+		if memoryMeta.LastReview.After(disk.LastReview) {
+			disk.LastReview = memoryMeta.LastReview
+		}
+		memoryMeta = disk
+		data, _ := json.MarshalIndent(disk, "", "  ")
+		return data
+	})
+	metaDirty, metaForgotten, metaReviewed = map[string]bool{}, map[string]bool{}, false
+}
+
+// Records a stored fact; ttl 0 means it never expires
+func trackFact(scope, key string, ttl time.Duration) {
+	if scope == scopeSession {
+		return
+	}
+	mk := metaKey(scope, key)
+	m, ok := memoryMeta.Facts[mk]
+	now := time.Now()
+	if !ok {
+		m.Created = now
+	}
+	m.Used = now
+	m.Updated = now
+	m.Expires = time.Time{}
+	if ttl > 0 {
+		m.Expires = now.Add(ttl)
+	}
+	memoryMeta.Facts[mk] = m
+	metaDirty[mk] = true
+	saveMemoryMeta()
+}
+
+func untrackFact(scope, key string) {
+	if scope == scopeSession {
+		return
+	}
+	mk := metaKey(scope, key)
+	delete(memoryMeta.Facts, mk)
+	memoryMeta.Forgotten[mk] = time.Now()
+	metaForgotten[mk] = true
+	saveMemoryMeta()
+}
+
+// Marks facts as used; at most one write per fact a day
+func touchFacts(facts map[string]memoryFact, keys []string) {
+	now := time.Now()
+	for _, k := range keys {
+		f := facts[k]
+		if f.Scope == scopeSession {
+			continue
+		}
+		mk := metaKey(f.Scope, k)
+		if m, ok := memoryMeta.Facts[mk]; ok && now.Sub(m.Used) > 24*time.Hour {
+			m.Used = now
+			memoryMeta.Facts[mk] = m
+			metaDirty[mk] = true
+		}
+	}
+	saveMemoryMeta()
+}
+
+// Marks the facts reply refers to, by key or by value, as used
+func touchMentionedFacts(reply string) {
+	reply = strings.ToLower(reply)
+	facts := effectiveMemory()
+	var keys []string
+	for k, f := range facts {
+		key := strings.ToLower(k)
+		value := strings.ToLower(strings.TrimSpace(f.Value))
+		if len(key) >= 3 && (strings.Contains(reply, key) || strings.Contains(reply, strings.NewReplacer("_", " ", "-", " ").Replace(key))) ||
+			len(value) >= 6 && strings.Contains(reply, value) {
+			keys = append(keys, k)
+		}
+	}
+	touchFacts(facts, keys)
+}
+
+// Drops expired facts and returns their keys
+func expireFacts() []string {
+	var expired []string
+	now := time.Now()
+	for _, scope := range []string{scopeGlobal, scopeProject} {
+		for _, k := range sortedKeys(scopeMemory(scope)) {
+			if m := memoryMeta.Facts[metaKey(scope, k)]; !m.Expires.IsZero() && now.After(m.Expires) {
+				forgetFact(scope, k)
+				expired = append(expired, k)
+			}
+		}
+	}
+	return expired
+}
+
+type staleFact struct {
+	Scope, Key string
+	Used       time.Time
+}
+
+// Facts not used for settings.MemoryReviewDays, oldest first
+func staleFacts() []staleFact {
+	if settings.MemoryReviewDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -settings.MemoryReviewDays)
+	var stale []staleFact
+	for _, scope := range []string{scopeGlobal, scopeProject} {
+		for k := range scopeMemory(scope) {
+			if m, ok := memoryMeta.Facts[metaKey(scope, k)]; ok && m.Used.Before(cutoff) {
+				stale = append(stale, staleFact{scope, k, m.Used})
+			}
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Used.Before(stale[j].Used) })
+	return stale
+}
+
+// Offers stale facts for review at most once a week, unless forced
+// (/memory review)
+func reviewStaleFacts(scanner *bufio.Scanner, force bool) string {
+	if !force && time.Since(memoryMeta.LastReview) < 7*24*time.Hour {
+		return ""
+	}
+	stale := staleFacts()
+	if len(stale) == 0 {
+		if force {
+			return fmt.Sprintf("No memories unused for %d days", settings.MemoryReviewDays)
+		}
+		return ""
+	}
+	if len(stale) > memoryReviewBatch {
+		stale = stale[:memoryReviewBatch]
+	}
+	memoryMeta.LastReview = time.Now()
+	metaReviewed = true
+
+	fmt.Printf("\n%s🧠 These %d memories haven't been used in %d+ days:%s\n", colorCyan, len(stale), settings.MemoryReviewDays, colorReset)
+	for i, f := range stale {
+		fmt.Printf("  %s%d%s %s %s[%s, last used %s]%s\n", colorYellow, i+1, colorReset,
+			memoryLine(f.Key, scopeMemory(f.Scope)[f.Key]), colorGray, scopeLabel(f.Scope), f.Used.Format("2006-01-02"), colorReset)
+	}
+	fmt.Printf("Forget which? (numbers like 1 3, %sall%s, or Enter to keep them): ", colorYellow, colorReset)
+	if !scanner.Scan() {
+		saveMemoryMeta()
+		return ""
+	}
+	answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	var forgotten []string
+	for i, f := range stale {
+		if answer == "all" || containsField(answer, fmt.Sprint(i+1)) {
+			forgetFact(f.Scope, f.Key)
+			forgotten = append(forgotten, f.Key)
+			continue
+		}
+		// Kept facts count as used, so they aren't offered again right away
+		mk := metaKey(f.Scope, f.Key)
+		m := memoryMeta.Facts[mk]
+		m.Used = time.Now()
+		memoryMeta.Facts[mk] = m
+		metaDirty[mk] = true
+	}
+	saveMemoryMeta()
+	if len(forgotten) == 0 {
+		return fmt.Sprintf("%s✓ Kept all %d%s", colorGreen, len(stale), colorReset)
+	}
+	return fmt.Sprintf("%s✓ Forgot %s%s", colorGreen, strings.Join(forgotten, ", "), colorReset)
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// "expires in 3d" / "unused 70d" for /memory, "" when unremarkable
+func factNote(scope, key string) string {
+	if scope == scopeSession {
+		return ""
+	}
+	m, ok := memoryMeta.Facts[metaKey(scope, key)]
+	if !ok {
+		return ""
+	}
+	var notes []string
+	if left := time.Until(m.Expires); !m.Expires.IsZero() && left > 0 {
+		notes = append(notes, "expires in "+formatDays(left))
+	} else if !m.Expires.IsZero() {
+		notes = append(notes, "expired")
+	}
+	if settings.MemoryReviewDays > 0 && time.Since(m.Used) > time.Duration(settings.MemoryReviewDays)*24*time.Hour {
+		notes = append(notes, "unused "+formatDays(time.Since(m.Used)))
+	}
+	return strings.Join(notes, ", ")
+}
+
+func formatDays(d time.Duration) string {
+	if d < 24*time.Hour {
+		return d.Round(time.Hour).String()
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== MEMORY RECALL ====================
+
+// Small memories go into the system prompt whole. Once there are more than
+// twice settings.MemoryRecall facts, only the ones closest to the current
+// message are sent, ranked by embedding similarity. Fact vectors are cached
+// in ~/.mytool/memory_vectors.json and recomputed when a fact changes; if
+// the embeddings API is unavailable, ranking falls back to shared words.
+
+const (
+	embeddingsURL   = "https://api.minimax.io/v1/embeddings"
+	embeddingsModel = "embo-01"
+)
+
+type memoryVector struct {
+	Text   string    `json:"text"` // the memoryLine the vector was computed from
+	Vector []float64 `json:"vector"`
+}
+
+var memoryQuery string // the message the next system prompt is built for
+
+// The prompt is rebuilt for the same message more than once (every tool
+// follow-up, /context), so the query's vector and the scores it gave are
+// kept until the message or the facts change
+var recallCache struct {
+	query    string
+	vector   []float64
+	revision string // memoryRevision of the facts scored
+	scores   map[string]float64
+}
+
+func memoryFiltered() bool {
+	return settings.MemoryRecall > 0 && len(effectiveMemory()) > 2*settings.MemoryRecall
+}
+
+func recallLabel(k int) string {
+	if k <= 0 {
+		return "all facts"
+	}
+	return fmt.Sprintf("top %d relevant", k)
+}
+
+// Lines for the MEMORY section of the system prompt
+func promptMemory() []string {
+	facts := effectiveMemory()
+	values := make(map[string]string, len(facts))
+	for k, f := range facts {
+		values[k] = f.Value
+	}
+	keys := sortedKeys(values)
+	if memoryFiltered() && strings.TrimSpace(memoryQuery) != "" {
+		var relevant []string
+		keys, relevant = rankMemory(memoryQuery, values, keys, settings.MemoryRecall)
+		touchFacts(facts, relevant)
+	}
+	keys, dropped := fitMemoryBudget(facts, keys)
+	warnMemoryBudget(dropped)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = memoryLine(k, values[k])
+	}
+	return lines
+}
+
+// The k keys most relevant to query, best first, and those of them that
+// are related to it at all; the rest only fill up the k
+func rankMemory(query string, values map[string]string, keys []string, k int) (ranked, relevant []string) {
+	scores, err := embeddingScores(query, values, keys)
+	if err != nil {
+		scores = wordScores(query, values, keys)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return scores[keys[i]] > scores[keys[j]] })
+	if len(keys) > k {
+		keys = keys[:k]
+	}
+	for _, key := range keys {
+		if scores[key] > 0 {
+			relevant = append(relevant, key)
+		}
+	}
+	return keys, relevant
+}
+
+func embeddingScores(query string, values map[string]string, keys []string) (map[string]float64, error) {
+	apiKey := getAPIKey()
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key")
+	}
+	revision := memoryRevision(values, keys)
+	if recallCache.scores != nil && recallCache.query == query && recallCache.revision == revision {
+		return recallCache.scores, nil
+	}
+	vectors := loadMemoryVectors()
+
+	var missing, texts []string
+	for _, k := range keys {
+		line := memoryLine(k, values[k])
+		if v, ok := vectors[k]; !ok || v.Text != line {
+			missing = append(missing, k)
+			texts = append(texts, line)
+		}
+	}
+	if len(missing) > 0 {
+		embedded, err := embedTexts(apiKey, texts, "db")
+		if err != nil {
+			return nil, err
+		}
+		for i, k := range missing {
+			vectors[k] = memoryVector{Text: texts[i], Vector: embedded[i]}
+		}
+		saveMemoryVectors(vectors, values)
+	}
+
+	if recallCache.vector == nil || recallCache.query != query {
+		q, err := embedTexts(apiKey, []string{query}, "query")
+		if err != nil {
+			return nil, err
+		}
+		recallCache.query, recallCache.vector = query, q[0]
+	}
+	scores := make(map[string]float64, len(keys))
+	for _, k := range keys {
+		scores[k] = cosine(recallCache.vector, vectors[k].Vector)
+	}
+	recallCache.revision, recallCache.scores = revision, scores
+	return scores, nil
+}
+
+// Identifies the facts as scored: any added, removed or edited one changes it
+func memoryRevision(values map[string]string, keys []string) string {
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00", memoryLine(k, values[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Fraction of the query's words that appear in each fact
+func wordScores(query string, values map[string]string, keys []string) map[string]float64 {
+	facts := make(map[string]string, len(keys))
+	for _, k := range keys {
+		facts[k] = memoryLine(k, values[k])
+	}
+	return wordOverlap(query, facts, keys)
+}
+
+// Fraction of the query's words that appear in texts[k], for each key
+func wordOverlap(query string, texts map[string]string, keys []string) map[string]float64 {
+	words := strings.Fields(strings.ToLower(query))
+	scores := make(map[string]float64, len(keys))
+	for _, k := range keys {
+		text := strings.ToLower(texts[k])
+		hits := 0
+		for _, w := range words {
+			if len(w) > 2 && strings.Contains(text, w) {
+				hits++
+			}
+		}
+		if len(words) > 0 {
+			scores[k] = float64(hits) / float64(len(words))
+		}
+	}
+	return scores
+}
+
+// kind is "db" for stored facts and "query" for the message searched with
+func embedTexts(apiKey string, texts []string, kind string) ([][]float64, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": embeddingsModel, "texts": texts, "type": kind})
+	req, _ := http.NewRequest("POST", embeddingsURL, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{Timeout: 20 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("embeddings API error (%d): %s", resp.StatusCode, truncate(string(data), 200))
+	}
+
+	var er struct {
+		Vectors     [][]float64 `json:"vectors"`
+		TotalTokens int         `json:"total_tokens"`
+		BaseResp    struct {
+			StatusCode int    `json:"status_code"`
+			StatusMsg  string `json:"status_msg"`
+		} `json:"base_resp"`
+	}
+	if err := json.Unmarshal(data, &er); err != nil {
+		return nil, err
+	}
+	if er.BaseResp.StatusCode != 0 {
+		return nil, fmt.Errorf("embeddings API error: %s", er.BaseResp.StatusMsg)
+	}
+	if len(er.Vectors) != len(texts) {
+		return nil, fmt.Errorf("embeddings API returned %d vectors for %d texts", len(er.Vectors), len(texts))
+	}
+	lastUsage = TokenUsage{TotalTokens: er.TotalTokens, PromptTokens: er.TotalTokens}
+	recordModelUsage("embedding", embeddingsModel)
+	return er.Vectors, nil
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func memoryVectorsPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "memory_vectors.json")
+}
+
+func loadMemoryVectors() map[string]memoryVector {
+	vectors := map[string]memoryVector{}
+	if data, err := readPrivateFile(memoryVectorsPath()); err == nil {
+		json.Unmarshal(data, &vectors)
+	}
+	return vectors
+}
+
+// Writes vectors merged over the file, dropping those whose fact text is
+// no longer stored in any scope
+func saveMemoryVectors(vectors map[string]memoryVector, values map[string]string) {
+	updatePrivateFile(memoryVectorsPath(), 0644, func(old []byte) []byte {
+		disk := map[string]memoryVector{}
+		json.Unmarshal(old, &disk)
+		for k, v := range vectors {
+			disk[k] = v
+		}
+		for k := range disk {
+			if _, ok := values[k]; !ok && factScope(k) == "" {
+				delete(disk, k)
+			}
+		}
+		data, _ := json.Marshal(disk)
+		return data
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ==================== MEMORY SCOPES ====================
+
+// Facts live in one of three scopes. Global facts (memory.json) apply
+// everywhere, project facts only inside one project (the git root, see
+// sessionProject) and session facts only in the session that stored them.
+// When a key exists in several scopes the narrowest one wins.
+
+const (
+	scopeGlobal  = "global"
+	scopeProject = "project"
+	scopeSession = "session"
+)
+
+// Narrowest first
+var memoryScopes = []string{scopeSession, scopeProject, scopeGlobal}
+
+var (
+	projectMemory     = map[string]string{} // facts of memoryProject
+	projectMemoryBase = map[string]string{}
+	memoryProject     string
+	sessionMemory     = map[string]string{} // saved with the session
+)
+
+type memoryFact struct {
+	Value string
+	Scope string
+}
+
+func projectMemoryPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "project_memory.json")
+}
+
+// Loads the facts of the current project (again after /cd)
+func loadProjectMemory() {
+	memoryProject = sessionProject(currentDir)
+	all := map[string]map[string]string{}
+	if data, err := readPrivateFile(projectMemoryPath()); err == nil {
+		json.Unmarshal(data, &all)
+	}
+	projectMemory = all[memoryProject]
+	if projectMemory == nil {
+		projectMemory = map[string]string{}
+	}
+	projectMemoryBase = copyMemory(projectMemory)
+}
+
+// Same merge as saveMemory, within this project's entry
+func saveProjectMemory() {
+	err := updatePrivateFile(projectMemoryPath(), 0644, func(old []byte) []byte {
+		all := map[string]map[string]string{}
+		json.Unmarshal(old, &all)
+		disk := all[memoryProject]
+		if disk == nil {
+			disk = map[string]string{}
+		}
+		for k, v := range projectMemory {
+			if base, ok := projectMemoryBase[k]; !ok || base != v {
+				disk[k] = v
+			}
+		}
+		for k := range projectMemoryBase {
+			if _, ok := projectMemory[k]; !ok {
+				delete(disk, k)
+			}
+		}
+		if len(disk) == 0 {
+			delete(all, memoryProject)
+		} else {
+			all[memoryProject] = disk
+		}
+		projectMemory, projectMemoryBase = copyMemory(disk), copyMemory(disk)
+
+		data, _ := json.MarshalIndent(all, "", "  ")
+		if bytes.Equal(old, data) {
+			return nil
+		}
+		return data
+	})
+	if err != nil {
+		fmt.Printf("%sProject memory not saved: %s%s\n", colorYellow, err, colorReset)
+	}
+}
+
+func scopeMemory(scope string) map[string]string {
+	switch scope {
+	case scopeProject:
+		return projectMemory
+	case scopeSession:
+		return sessionMemory
+	}
+	return memory
+}
+
+func saveScope(scope string) {
+	switch scope {
+	case scopeProject:
+		saveProjectMemory()
+	case scopeSession:
+		// stored with the session on the next autosave
+	default:
+		saveMemory()
+	}
+}
+
+// Every fact in effect here, each key resolved to its narrowest scope
+func effectiveMemory() map[string]memoryFact {
+	facts := map[string]memoryFact{}
+	for i := len(memoryScopes) - 1; i >= 0; i-- {
+		for k, v := range scopeMemory(memoryScopes[i]) {
+			facts[k] = memoryFact{Value: v, Scope: memoryScopes[i]}
+		}
+	}
+	return facts
+}
+
+// Strips a leading --global/--project/--session; the scope is "" without one
+func parseScopeFlag(arg string) (string, string) {
+	arg = strings.TrimSpace(arg)
+	for _, scope := range memoryScopes {
+		if rest, ok := strings.CutPrefix(arg, "--"+scope); ok && (rest == "" || rest[0] == ' ') {
+			return scope, strings.TrimSpace(rest)
+		}
+	}
+	return "", arg
+}
+
+// The narrowest scope that has key, "" if none
+func factScope(key string) string {
+	for _, scope := range memoryScopes {
+		if _, ok := scopeMemory(scope)[key]; ok {
+			return scope
+		}
+	}
+	return ""
+}
+
+func scopeLabel(scope string) string {
+	if scope == scopeProject {
+		return fmt.Sprintf("project %s", filepath.Base(memoryProject))
+	}
+	return scope
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ==================== DIRECTORY AND GLOB MENTIONS ====================
+
+// @src/ attaches the files below a directory and @**/*.test.ts the files
+// matching a glob (expandGlob rules). Each file is capped at
+// mentionFileTokens and the whole mention at mentionTotalTokens; files
+// that don't fit or aren't text are listed as skipped, both to the user
+// and to the model.
+
+const (
+	mentionFileTokens  = 4000
+	mentionTotalTokens = 24000
+	mentionMaxFiles    = 60
+)
+
+// s cut to about tokens tokens, at the last line break when there is one
+// and never inside a UTF-8 sequence
+func cutToTokens(s string, tokens int) string {
+	n := tokens * 4
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	s = s[:n]
+	if i := strings.LastIndex(s, "\n"); i > 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func isGlobMention(ref string) bool {
+	return strings.ContainsAny(ref, "*?{")
+}
+
+// Files for a directory or glob mention, sorted
+func mentionFiles(ref string) ([]string, error) {
+	if isGlobMention(ref) {
+		files, err := expandGlob(ref)
+		files = filterIgnored(files, func(path string) string { return path })
+		sort.Strings(files)
+		return files, err
+	}
+	root := resolvePath(ref)
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		hidden := strings.HasPrefix(d.Name(), ".") // .env and friends too
+		if d.IsDir() {
+			if path != root && (projectSkipDirs[d.Name()] || hidden || isIgnored(path, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !hidden && !isIgnored(path, false) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Attachment text for a multi-file mention and the line shown to the user
+func expandMultiMention(ref string) (string, string) {
+	files, err := mentionFiles(ref)
+	if err != nil || len(files) == 0 {
+		return "", fmt.Sprintf("%s  ✗ @%s: no files%s", colorYellow, ref, colorReset)
+	}
+
+	var parts, skipped []string
+	used, included := 0, 0
+	for _, path := range files {
+		rel := relPath(path)
+		if included >= mentionMaxFiles {
+			skipped = append(skipped, rel+" (file limit)")
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			skipped = append(skipped, rel+" (unreadable)")
+			continue
+		}
+		if reason := unreadableReason(data, guardMaxBytes); reason != "" {
+			skipped = append(skipped, rel+" ("+reason+")")
+			continue
+		}
+		content, note := string(data), ""
+		if estimateTokens(content) > mentionFileTokens {
+			content = cutToTokens(content, mentionFileTokens)
+			note = " (truncated)"
+		}
+		if used+estimateTokens(content) > mentionTotalTokens {
+			skipped = append(skipped, rel+" (total budget)")
+			continue
+		}
+		used += estimateTokens(content)
+		included++
+		parts = append(parts, fmt.Sprintf("=== %s%s ===\n%s", path, note, content))
+		noteSeen(path, data)
+	}
+
+	display := fmt.Sprintf("%s  ✓ @%s: %d files, ~%d tokens%s", colorGray, ref, included, used, colorReset)
+	if len(skipped) > 0 {
+		display += fmt.Sprintf("\n%s    skipped %d: %s%s", colorYellow, len(skipped), truncate(strings.Join(skipped, ", "), 300), colorReset)
+		parts = append(parts, fmt.Sprintf("=== @%s: not attached ===\n%s", ref, strings.Join(skipped, "\n")))
+	}
+	return strings.Join(parts, "\n\n"), display
+}
+
+// ==================== URL MENTIONS ====================
+
+// @https://... fetches the page (fetchReadable: readable text, cached)
+// and attaches it like a file, within mentionFileTokens.
+
+var mentionURLRe = regexp.MustCompile(`@(https?://[^\s<>"']+)`)
+
+func expandURLMention(url string) (string, string) {
+	url = strings.TrimRight(url, ".,;:!?)]}")
+	text := stripANSI(fetchReadable(url))
+	cached := ""
+	if strings.HasPrefix(text, "[cached ") {
+		if i := strings.Index(text, "\n"); i > 0 {
+			cached, text = ", "+strings.TrimSuffix(text[1:i], "]"), text[i+1:]
+		}
+	}
+	if strings.HasPrefix(text, "Error:") {
+		return "", fmt.Sprintf("%s  ✗ @%s: %s%s", colorYellow, url, strings.TrimPrefix(text, "Error: "), colorReset)
+	}
+	note := ""
+	if estimateTokens(text) > mentionFileTokens {
+		text = cutToTokens(text, mentionFileTokens)
+		note = " (truncated)"
+	}
+	display := fmt.Sprintf("%s  ✓ @%s: ~%d tokens%s%s%s", colorGray, url, estimateTokens(text), note, cached, colorReset)
+	return fmt.Sprintf("=== %s%s ===\n%s", url, note, text), display
+}
+
+// ==================== ISSUE MENTIONS ====================
+
+// gh#123 (with or without the @; gl#123 and bb#123 work the same)
+// attaches issue 123 of the origin repository, and gl!123 or bb!123 its
+// merge or pull request 123. @ and the URL of an issue or pull request on
+// GitHub, GitLab or Bitbucket attaches one of any repository. Either way
+// it's the title, state, body and latest comments, read through the
+// forge's API.
+
+const forgeMentionComments = 10
+
+var (
+	forgeMentionRe  = regexp.MustCompile(`(?:^|[^\w/#@!])@?(?:gh|gl|bb)([#!])(\d+)\b`)
+	forgeIssueURLRe = regexp.MustCompile(`^https?://([^/]+)/(.+?)/([^/]+?)(/-)?/(issues|pull|merge_requests|pull-requests)/(\d+)(?:[/#?].*)?$`)
+)
+
+// The repository and number of an issue or pull request URL on one of the
+// public forges or on the origin's host; pull is true for pull requests
+func forgeIssueURL(url string) (repo forgeRepo, number string, pull, ok bool) {
+	m := forgeIssueURLRe.FindStringSubmatch(url)
+	if m == nil {
+		return forgeRepo{}, "", false, false
+	}
+	repo = forgeRepo{Host: m[1], Owner: m[2], Name: m[3]}
+	switch m[1] {
+	case "github.com":
+		repo.Kind = forgeGitHub
+	case "gitlab.com":
+		repo.Kind = forgeGitLab
+	case "bitbucket.org":
+		repo.Kind = forgeBitbucket
+	default:
+		origin, err := forgeRemote(findProjectRoot(), "origin")
+		if err != nil || origin.Host != m[1] {
+			return forgeRepo{}, "", false, false
+		}
+		repo.Kind = origin.Kind
+	}
+	if (m[4] != "") != (repo.Kind == forgeGitLab) {
+		return forgeRepo{}, "", false, false // GitLab's paths have /-/, the others' don't
+	}
+	return repo, m[6], m[5] != "issues", true
+}
+
+func expandForgeMention(ref string, repo forgeRepo, number string, pull bool) (string, string) {
+	issue, err := forgeGetIssue(repo, number, pull)
+	if err != nil {
+		return "", fmt.Sprintf("%s  ✗ %s: %s%s", colorYellow, ref, err, colorReset)
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("=== %s %s %s#%s: %s ===\n", repo.Label(), issue.Kind, repo, number, issue.Title))
+	b.WriteString(fmt.Sprintf("State: %s · opened by %s", issue.State, issue.Author))
+	if len(issue.Labels) > 0 {
+		b.WriteString(" · labels: " + strings.Join(issue.Labels, ", "))
+	}
+	b.WriteString("\n" + issue.URL + "\n\n" + strings.TrimSpace(issue.Body))
+	if skipped := issue.Total - len(issue.Comments); skipped > 0 {
+		b.WriteString(fmt.Sprintf("\n\n(%d earlier comments left out)", skipped))
+	}
+	for _, c := range issue.Comments {
+		b.WriteString(fmt.Sprintf("\n\n--- %s, %s ---\n%s", c.Author, strings.SplitN(c.Date, "T", 2)[0], strings.TrimSpace(c.Body)))
+	}
+
+	text, note := b.String(), ""
+	if estimateTokens(text) > mentionFileTokens {
+		text = cutToTokens(text, mentionFileTokens) + "\n... (truncated)"
+		note = ", truncated"
+	}
+	display := fmt.Sprintf("%s  ✓ %s: %s (%s, %d comments%s)%s", colorGray, ref, truncate(issue.Title, 60), issue.Kind, issue.Total, note, colorReset)
+	return text, display
+}
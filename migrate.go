@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== MIGRATE ====================
+
+// `mytool migrate "Express 4 to 5"` has the model plan the upgrade and pick
+// the files involved, then rewrites them a batch at a time. Each batch is
+// approved, tested and kept or reverted. Progress lives in
+// <project>/.mytool/migration.json, so an interrupted run picks up at the
+// next unfinished batch.
+
+type Migration struct {
+	Goal    string            `json:"goal"`
+	Plan    string            `json:"plan"`
+	TestCmd string            `json:"test_cmd,omitempty"`
+	Batches [][]string        `json:"batches"`
+	Next    int               `json:"next"`   // first batch not yet handled
+	Status  map[string]string `json:"status"` // file → migrated, unchanged, skipped, reverted, failed
+	Started time.Time         `json:"started"`
+	Updated time.Time         `json:"updated"`
+}
+
+var projectSkipDirs = map[string]bool{".git": true, "node_modules": true, "vendor": true, "dist": true,
+	"build": true, "target": true, ".mytool": true, "__pycache__": true, ".venv": true, ".next": true}
+
+const migrateMaxFileSize = 100 * 1024
+
+func migrationRoot() string {
+	if root := findProjectRoot(); root != "" {
+		return root
+	}
+	return currentDir
+}
+
+func migrationPath() string {
+	return filepath.Join(migrationRoot(), ".mytool", "migration.json")
+}
+
+func loadMigration() (*Migration, error) {
+	data, err := os.ReadFile(migrationPath())
+	if err != nil {
+		return nil, err
+	}
+	var m Migration
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("bad %s: %s", migrationPath(), err)
+	}
+	if m.Status == nil {
+		m.Status = map[string]string{}
+	}
+	return &m, nil
+}
+
+func (m *Migration) save() error {
+	m.Updated = time.Now()
+	os.MkdirAll(filepath.Dir(migrationPath()), 0755)
+	ignoreProjectCaches(migrationRoot())
+	data, _ := json.MarshalIndent(m, "", "  ")
+	return writeFileAtomic(migrationPath(), data, 0644)
+}
+
+func (m *Migration) files() int {
+	n := 0
+	for _, b := range m.Batches {
+		n += len(b)
+	}
+	return n
+}
+
+// mytool migrate "<from> to <to>" [--batch n] [--test cmd] | --status | --abandon
+func runMigrate(args []string) {
+	batchSize, testCmd, goal := 5, "", ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--batch" && i+1 < len(args):
+			if n := parseInt(args[i+1]); n > 0 {
+				batchSize = n
+			}
+			i++
+		case args[i] == "--test" && i+1 < len(args):
+			testCmd = args[i+1]
+			i++
+		case args[i] == "--status":
+			if m, err := loadMigration(); err == nil {
+				printMigrationStatus(m)
+			} else {
+				fmt.Println("No migration in progress")
+			}
+			return
+		case args[i] == "--abandon":
+			os.Remove(migrationPath())
+			fmt.Printf("%s✓ Migration state removed (file changes are kept)%s\n", colorGreen, colorReset)
+			return
+		default:
+			goal = strings.TrimSpace(goal + " " + args[i])
+		}
+	}
+
+	m, err := loadMigration()
+	if err != nil && goal == "" {
+		fmt.Println("Usage: mytool migrate \"<from> to <to>\" [--batch 5] [--test \"go test ./...\"]")
+		return
+	}
+	apiKey := getAPIKey()
+	if apiKey == "" {
+		fmt.Println("API key required, run mytool once to set it up")
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+
+	switch {
+	case err == nil && goal != "" && goal != m.Goal:
+		fmt.Printf("%sA migration (%q) is already in progress here.%s\n", colorYellow, m.Goal, colorReset)
+		fmt.Println("Run `mytool migrate` to resume it or `mytool migrate --abandon` first.")
+		return
+	case err == nil:
+		fmt.Printf("%s↻ Resuming migration: %s%s\n", colorCyan, m.Goal, colorReset)
+		printMigrationStatus(m)
+	default:
+		if m, err = planMigration(apiKey, goal, batchSize, testCmd); err != nil {
+			fmt.Printf("%sPlanning failed: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		fmt.Printf("\n%sPlan%s\n%s\n\n", colorCyan, colorReset, m.Plan)
+		fmt.Printf("%d files in %d batches", m.files(), len(m.Batches))
+		if m.TestCmd != "" {
+			fmt.Printf(", tests: %s", m.TestCmd)
+		}
+		fmt.Println()
+		if !askYes(scanner, "Start the migration?") {
+			return
+		}
+		m.save()
+	}
+	if testCmd != "" {
+		m.TestCmd = testCmd
+	}
+
+	for m.Next < len(m.Batches) {
+		if !runMigrationBatch(apiKey, m, scanner) {
+			m.save()
+			fmt.Printf("%sPaused. Run `mytool migrate` to continue.%s\n", colorGray, colorReset)
+			return
+		}
+		m.Next++
+		m.save()
+	}
+	fmt.Printf("\n%s✓ Migration complete%s\n", colorGreen, colorReset)
+	printMigrationStatus(m)
+	notifyWebhook(webhookPayload{Event: "migrate.completed", Status: "ok", Summary: m.Goal + "\n\n" + m.Plan}, m.Started)
+}
+
+// Asks the model for a plan, the files to touch and a test command
+func planMigration(apiKey, goal string, batchSize int, testCmd string) (*Migration, error) {
+	root := migrationRoot()
+	var files []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if projectSkipDirs[d.Name()] && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Size() <= migrateMaxFileSize {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if len(files) > 1500 {
+		files = files[:1500]
+	}
+
+	var manifests strings.Builder
+	for _, name := range []string{"go.mod", "package.json", "requirements.txt", "pyproject.toml", "Cargo.toml", "pom.xml", "Gemfile", "composer.json"} {
+		if data, err := os.ReadFile(filepath.Join(root, name)); err == nil {
+			manifests.WriteString(fmt.Sprintf("\n--- %s ---\n%s\n", name, truncate(string(data), 3000)))
+		}
+	}
+
+	fmt.Printf("%sPlanning %q over %d files...%s\n", colorGray, goal, len(files), colorReset)
+	messages := []ChatMessage{
+		{Role: "system", Content: "You plan codebase migrations. Reply with only a JSON object: " +
+			`{"plan": "numbered steps, including dependency/manifest changes", ` +
+			`"files": ["relative paths that need changes, most foundational first"], ` +
+			`"test": "shell command that verifies the project, or empty"}`},
+		{Role: "user", Content: fmt.Sprintf("Migration: %s\n\nManifests:%s\n\nFiles:\n%s", goal, manifests.String(), strings.Join(files, "\n"))},
+	}
+	out, err := sendComplete(apiKey, messages, 4000, "migrate")
+	if err != nil {
+		return nil, err
+	}
+	start, end := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no plan in reply: %s", truncate(out, 200))
+	}
+	var reply struct {
+		Plan  string   `json:"plan"`
+		Files []string `json:"files"`
+		Test  string   `json:"test"`
+	}
+	if err := json.Unmarshal([]byte(out[start:end+1]), &reply); err != nil {
+		return nil, fmt.Errorf("unreadable plan: %s", err)
+	}
+
+	m := &Migration{Goal: goal, Plan: reply.Plan, TestCmd: reply.Test, Status: map[string]string{}, Started: time.Now()}
+	if testCmd != "" {
+		m.TestCmd = testCmd
+	}
+	seen := map[string]bool{}
+	var batch []string
+	for _, f := range reply.Files {
+		f = filepath.ToSlash(filepath.Clean(f))
+		if seen[f] || strings.HasPrefix(f, "..") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, f)); err != nil {
+			continue
+		}
+		seen[f] = true
+		batch = append(batch, f)
+		if len(batch) == batchSize {
+			m.Batches = append(m.Batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		m.Batches = append(m.Batches, batch)
+	}
+	if len(m.Batches) == 0 {
+		return nil, fmt.Errorf("the model found no files to change")
+	}
+	return m, nil
+}
+
+// Handles batch m.Next; false means the user stopped
+func runMigrationBatch(apiKey string, m *Migration, scanner *bufio.Scanner) bool {
+	root := migrationRoot()
+	batch := m.Batches[m.Next]
+	fmt.Printf("\n%s─── Batch %d/%d ───%s\n", colorCyan, m.Next+1, len(m.Batches), colorReset)
+	for _, f := range batch {
+		fmt.Printf("  %s\n", f)
+	}
+	switch askChoice(scanner, "Migrate this batch? [y]es / [s]kip / [q]uit", "y", "s", "q") {
+	case "q":
+		return false
+	case "s":
+		for _, f := range batch {
+			m.Status[f] = "skipped"
+		}
+		return true
+	}
+
+	// Each file's status is saved as soon as it is written, so resuming
+	// after a crash picks up where the batch stopped instead of migrating
+	// finished files a second time
+	type original struct {
+		text   string
+		format fileFormat
+	}
+	originals := map[string]original{}
+	for _, f := range batch {
+		if st := m.Status[f]; st == "migrated" || st == "unchanged" {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(f))
+		content, format, err := readText(path)
+		if err != nil {
+			m.Status[f] = "failed"
+			m.save()
+			continue
+		}
+		fmt.Printf("%s  ⟳ %s%s\n", colorGray, f, colorReset)
+		updated, err := migrateFile(apiKey, m, f, content)
+		switch {
+		case err != nil:
+			fmt.Printf("  %s✗ %s: %s%s\n", colorRed, f, err, colorReset)
+			m.Status[f] = "failed"
+		case updated == content:
+			m.Status[f] = "unchanged"
+		default:
+			action := undoSnapshot(path)
+			action.Op = "migrate"
+			if err := writeText(path, updated, format); err != nil {
+				fmt.Printf("  %s✗ %s: %s%s\n", colorRed, f, err, colorReset)
+				m.Status[f] = "failed"
+				break
+			}
+			pushUndo(action)
+			originals[f] = original{content, format}
+			m.Status[f] = "migrated"
+			added, removed := diffStat(content, updated)
+			fmt.Printf("  %s✓%s %s %s+%d%s %s-%d%s\n", colorGreen, colorReset, f, colorGreen, added, colorReset, colorRed, removed, colorReset)
+		}
+		m.save()
+	}
+	if len(originals) == 0 {
+		return true
+	}
+
+	if m.TestCmd != "" {
+		// Through the run tool, so policy, confinement, the sandbox, the
+		// environment scrub and the resource limits apply as for any command
+		saved := currentDir
+		currentDir, outputShown, toolFailure = root, false, nil
+		out := cmdRun(m.TestCmd)
+		currentDir = saved
+		if !outputShown {
+			fmt.Println(out)
+		}
+		switch {
+		case toolFailure != nil:
+			fmt.Printf("%s✗ Tests failed: %s%s\n", colorRed, toolFailure.Message, colorReset)
+		case strings.HasPrefix(out, "Error:") || strings.Contains(out, "[blocked]") || out == "Cancelled":
+			fmt.Printf("%s✗ Tests not run%s\n", colorRed, colorReset)
+		default:
+			fmt.Printf("%s✓ Tests passed%s\n", colorGreen, colorReset)
+		}
+	}
+
+	if !askYes(scanner, "Keep this batch?") {
+		for f, o := range originals {
+			path := filepath.Join(root, filepath.FromSlash(f))
+			saveForUndo(path, "migrate revert")
+			if err := writeText(path, o.text, o.format); err != nil {
+				fmt.Printf("  %s✗ %s: %s%s\n", colorRed, f, err, colorReset)
+				continue
+			}
+			m.Status[f] = "reverted"
+		}
+		fmt.Printf("%s↩ Batch reverted%s\n", colorYellow, colorReset)
+	}
+	return true
+}
+
+// Returns the migrated content; the original if nothing needs to change
+func migrateFile(apiKey string, m *Migration, name, content string) (string, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: fmt.Sprintf("You are migrating a codebase: %s.\n\nPlan:\n%s\n\n"+
+			"Rewrite the file you are given for this migration. Reply with only the complete new file in one "+
+			"fenced code block, or exactly NO_CHANGES if the file needs no change.", m.Goal, m.Plan)},
+		{Role: "user", Content: fmt.Sprintf("File: %s\n\n```\n%s\n```", name, content)},
+	}
+	out, err := sendComplete(apiKey, messages, 16000, "migrate")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(out) == "NO_CHANGES" {
+		return content, nil
+	}
+	open := strings.Index(out, "```")
+	close := strings.LastIndex(out, "```")
+	if open < 0 || close <= open {
+		return "", fmt.Errorf("reply has no code block")
+	}
+	body := out[open+3 : close]
+	if nl := strings.Index(body, "\n"); nl >= 0 {
+		body = body[nl+1:] // drop the language tag line
+	}
+	if strings.HasSuffix(content, "\n") && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	return body, nil
+}
+
+func printMigrationStatus(m *Migration) {
+	counts := map[string]int{}
+	for _, s := range m.Status {
+		counts[s]++
+	}
+	var parts []string
+	for s, n := range counts {
+		parts = append(parts, fmt.Sprintf("%d %s", n, s))
+	}
+	sort.Strings(parts)
+	fmt.Printf("%s%s%s: batch %d/%d, %d files", colorBold, m.Goal, colorReset, m.Next, len(m.Batches), m.files())
+	if len(parts) > 0 {
+		fmt.Printf(" (%s)", strings.Join(parts, ", "))
+	}
+	fmt.Printf("\n%sstarted %s, updated %s ago%s\n", colorGray, m.Started.Format("2006-01-02 15:04"),
+		time.Since(m.Updated).Round(time.Minute), colorReset)
+}
+
+func askYes(scanner *bufio.Scanner, prompt string) bool {
+	return askChoice(scanner, prompt+" [y/N]", "y", "n") == "y"
+}
+
+// Repeats until the answer starts with one of choices; EOF picks the last
+func askChoice(scanner *bufio.Scanner, prompt string, choices ...string) string {
+	for {
+		fmt.Printf("%s ", prompt)
+		if !scanner.Scan() {
+			fmt.Println()
+			return choices[len(choices)-1]
+		}
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer == "" && len(choices) == 2 {
+			return choices[1]
+		}
+		for _, c := range choices {
+			if answer != "" && strings.HasPrefix(answer, c) {
+				return c
+			}
+		}
+	}
+}
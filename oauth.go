@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ==================== MCP OAUTH ====================
+
+// OAuth token stored per MCP server in ~/.mytool/mcp_tokens.json
+type OAuthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	TokenURL     string    `json:"token_url"`
+	ClientID     string    `json:"client_id"`
+}
+
+type oauthMetadata struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	RegistrationEndpoint  string `json:"registration_endpoint"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	ErrorDesc    string `json:"error_description"`
+}
+
+func tokensPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "mcp_tokens.json")
+}
+
+func loadOAuthTokens() map[string]OAuthToken {
+	tokens := make(map[string]OAuthToken)
+	if data, err := os.ReadFile(tokensPath()); err == nil {
+		json.Unmarshal(data, &tokens)
+	}
+	return tokens
+}
+
+func saveOAuthToken(server string, tok *OAuthToken) {
+	updateFile(tokensPath(), 0600, func(old []byte) []byte {
+		tokens := make(map[string]OAuthToken)
+		json.Unmarshal(old, &tokens)
+		if tok == nil {
+			delete(tokens, server)
+		} else {
+			tokens[server] = *tok
+		}
+		data, _ := json.MarshalIndent(tokens, "", "  ")
+		return data
+	})
+}
+
+// Returns a valid access token for the server, refreshing it if expired
+func mcpAccessToken(server MCPServer) (string, error) {
+	tok, ok := loadOAuthTokens()[server.Name]
+	if !ok {
+		return "", fmt.Errorf("not authenticated, use /mcp → %s → Authenticate", server.Name)
+	}
+	if tok.Expiry.IsZero() || time.Now().Add(30*time.Second).Before(tok.Expiry) {
+		return tok.AccessToken, nil
+	}
+	if tok.RefreshToken == "" {
+		return "", fmt.Errorf("token expired, re-authenticate %s", server.Name)
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tok.RefreshToken},
+		"client_id":     {tok.ClientID},
+	}
+	tr, err := requestToken(tok.TokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("refresh failed: %s", err)
+	}
+	tok.AccessToken = tr.AccessToken
+	if tr.RefreshToken != "" {
+		tok.RefreshToken = tr.RefreshToken
+	}
+	tok.Expiry = tokenExpiry(tr.ExpiresIn)
+	saveOAuthToken(server.Name, &tok)
+	return tok.AccessToken, nil
+}
+
+// Runs the authorization-code flow (with PKCE) against the server's
+// authorization server and stores the resulting token.
+func authenticateMCPServer(server MCPServer) error {
+	meta, err := discoverOAuth(server.URL)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+
+	clientID := server.ClientID
+	if clientID == "" {
+		if meta.RegistrationEndpoint == "" {
+			return fmt.Errorf("server has no client registration, set a client ID")
+		}
+		if clientID, err = registerOAuthClient(meta.RegistrationEndpoint, redirectURI); err != nil {
+			return err
+		}
+	}
+
+	verifier := randomString(32)
+	challenge := sha256.Sum256([]byte(verifier))
+	state := randomString(16)
+
+	authURL, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return err
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if server.Scopes != "" {
+		q.Set("scope", server.Scopes)
+	}
+	authURL.RawQuery = q.Encode()
+
+	// Only the first callback counts; later ones (a reload, a second tab)
+	// must not block their handler, or Shutdown would wait on it forever
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		if e := r.URL.Query().Get("error"); e != "" {
+			fmt.Fprintf(w, "Authorization failed: %s. You can close this window.", e)
+			fail(fmt.Errorf("authorization denied: %s", e))
+			return
+		}
+		if r.URL.Query().Get("state") != state {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			fail(fmt.Errorf("state mismatch"))
+			return
+		}
+		fmt.Fprint(w, "mytool: authorization complete. You can close this window.")
+		select {
+		case codeCh <- r.URL.Query().Get("code"):
+		default:
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	fmt.Printf("%sOpening browser for %s...%s\n", colorCyan, server.Name, colorReset)
+	fmt.Printf("%sIf it doesn't open, visit:%s\n%s\n", colorGray, colorReset, authURL.String())
+	openBrowser(authURL.String())
+
+	var code string
+	select {
+	case code = <-codeCh:
+	case err := <-errCh:
+		return err
+	case <-time.After(3 * time.Minute):
+		return fmt.Errorf("timed out waiting for authorization")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	}
+	tr, err := requestToken(meta.TokenEndpoint, form)
+	if err != nil {
+		return err
+	}
+	saveOAuthToken(server.Name, &OAuthToken{
+		AccessToken:  tr.AccessToken,
+		RefreshToken: tr.RefreshToken,
+		Expiry:       tokenExpiry(tr.ExpiresIn),
+		TokenURL:     meta.TokenEndpoint,
+		ClientID:     clientID,
+	})
+	return nil
+}
+
+// Authorization server metadata (RFC 8414) from the server's origin
+func discoverOAuth(serverURL string) (*oauthMetadata, error) {
+	if !strings.HasPrefix(serverURL, "http") {
+		serverURL = "https://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	wellKnown := fmt.Sprintf("%s://%s/.well-known/oauth-authorization-server", u.Scheme, u.Host)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(wellKnown)
+	if err == nil && resp.StatusCode == 200 {
+		defer resp.Body.Close()
+		var meta oauthMetadata
+		if json.NewDecoder(resp.Body).Decode(&meta) == nil && meta.AuthorizationEndpoint != "" {
+			return &meta, nil
+		}
+	} else if err == nil {
+		resp.Body.Close()
+	}
+
+	// Fallback to default endpoints as described in the MCP spec
+	base := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+	return &oauthMetadata{
+		AuthorizationEndpoint: base + "/authorize",
+		TokenEndpoint:         base + "/token",
+		RegistrationEndpoint:  base + "/register",
+	}, nil
+}
+
+// Dynamic client registration (RFC 7591) as a public client
+func registerOAuthClient(endpoint, redirectURI string) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"client_name":                "mytool",
+		"redirect_uris":              []string{redirectURI},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": "none",
+	})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var reg struct {
+		ClientID string `json:"client_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reg)
+	if reg.ClientID == "" {
+		return "", fmt.Errorf("client registration failed (%d)", resp.StatusCode)
+	}
+	return reg.ClientID, nil
+}
+
+func requestToken(endpoint string, form url.Values) (*oauthTokenResponse, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	req, _ := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tr oauthTokenResponse
+	json.NewDecoder(resp.Body).Decode(&tr)
+	if tr.Error != "" {
+		return nil, fmt.Errorf("%s: %s", tr.Error, tr.ErrorDesc)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in response (%d)", resp.StatusCode)
+	}
+	return &tr, nil
+}
+
+func tokenExpiry(expiresIn int) time.Time {
+	if expiresIn <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expiresIn) * time.Second)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	cmd.Start()
+}
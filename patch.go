@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== APPLY PATCH ====================
+
+// apply_patch takes a unified diff, possibly touching several files, and
+// applies it the way patch(1) does: each hunk is looked for near the line
+// its header names, then with whitespace ignored, then with up to
+// patchMaxFuzz context lines dropped at either end. Hunks that apply are
+// written, the others are reported so the model can redo just those.
+
+const patchMaxFuzz = 2
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+type filePatch struct {
+	OldPath, NewPath string // "" for /dev/null
+	Hunks            []patchHunk
+}
+
+type patchHunk struct {
+	Header  string
+	OldLine int      // 1-based; 0 when the header has no line numbers
+	Lines   []string // prefixed with ' ', '-' or '+'
+}
+
+// Hunk lines without trim context lines at each end
+func (h patchHunk) trimmed(trim int) []string {
+	lines := h.Lines
+	for i := 0; i < trim && len(lines) > 0 && lines[0][0] == ' '; i++ {
+		lines = lines[1:]
+	}
+	for i := 0; i < trim && len(lines) > 0 && lines[len(lines)-1][0] == ' '; i++ {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines the hunk expects to find
+func oldSide(lines []string) []string {
+	var old []string
+	for _, l := range lines {
+		if l[0] != '+' {
+			old = append(old, l[1:])
+		}
+	}
+	return old
+}
+
+// What replaces the matched lines at pos; context keeps the file's text,
+// which may differ in whitespace
+func newSide(lines, file []string, pos int) []string {
+	var new []string
+	for _, l := range lines {
+		switch l[0] {
+		case ' ':
+			new = append(new, file[pos])
+			pos++
+		case '-':
+			pos++
+		case '+':
+			new = append(new, l[1:])
+		}
+	}
+	return new
+}
+
+func parsePatchPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i] // timestamp
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	for _, prefix := range []string{"a/", "b/"} {
+		if rest, ok := strings.CutPrefix(s, prefix); ok {
+			return rest
+		}
+	}
+	return s
+}
+
+func parsePatch(text string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var files []filePatch
+	var hunk *patchHunk
+	closeHunk := func() {
+		if hunk != nil && len(files) > 0 {
+			// A blank line ends the patch rather than the hunk
+			for len(hunk.Lines) > 0 && hunk.Lines[len(hunk.Lines)-1] == " " {
+				hunk.Lines = hunk.Lines[:len(hunk.Lines)-1]
+			}
+			files[len(files)-1].Hunks = append(files[len(files)-1].Hunks, *hunk)
+		}
+		hunk = nil
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			closeHunk()
+			files = append(files, filePatch{OldPath: parsePatchPath(line[4:]), NewPath: parsePatchPath(lines[i+1][4:])})
+			i++
+		case strings.HasPrefix(line, "@@"):
+			closeHunk()
+			if len(files) == 0 {
+				return nil, fmt.Errorf("hunk before any file header (--- a/path, +++ b/path)")
+			}
+			hunk = &patchHunk{Header: line}
+			if m := hunkHeaderRe.FindStringSubmatch(line); m != nil {
+				hunk.OldLine, _ = strconv.Atoi(m[1])
+			}
+		case strings.HasPrefix(line, "diff "):
+			closeHunk()
+		case hunk == nil, strings.HasPrefix(line, `\`): // "\ No newline at end of file"
+		case line == "":
+			hunk.Lines = append(hunk.Lines, " ")
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.Lines = append(hunk.Lines, line)
+		default:
+			closeHunk()
+		}
+	}
+	closeHunk()
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file headers (--- a/path, +++ b/path)")
+	}
+	return files, nil
+}
+
+func sameLine(a, b string, loose bool) bool {
+	if loose {
+		return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+	}
+	return strings.TrimRight(a, "\r") == strings.TrimRight(b, "\r")
+}
+
+// Position of old in lines closest to want, or -1
+func findLines(lines, old []string, want int, loose bool) int {
+	if len(old) == 0 {
+		return max(0, min(want, len(lines)))
+	}
+	matches := func(pos int) bool {
+		if pos < 0 || pos+len(old) > len(lines) {
+			return false
+		}
+		for i, l := range old {
+			if !sameLine(lines[pos+i], l, loose) {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; d <= len(lines); d++ {
+		if matches(want - d) {
+			return want - d
+		}
+		if d > 0 && matches(want+d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+// Applies the hunks in order; returns the new content and a line per hunk
+func applyHunks(content string, hunks []patchHunk) (string, []string, int) {
+	lines := splitLines(content)
+	var report []string
+	applied, delta := 0, 0
+	for n, h := range hunks {
+		want := delta
+		if h.OldLine > 0 {
+			want += h.OldLine - 1
+		}
+		pos, fuzz, loose := -1, 0, false
+		var hl, old []string
+		for ; fuzz <= patchMaxFuzz; fuzz++ {
+			prev := len(old)
+			hl = h.trimmed(fuzz)
+			old = oldSide(hl)
+			if fuzz > 0 && len(old) == prev {
+				break // no context left to drop
+			}
+			for _, loose = range []bool{false, true} {
+				if pos = findLines(lines, old, want+fuzz, loose); pos >= 0 {
+					break
+				}
+			}
+			if pos >= 0 {
+				break
+			}
+		}
+		if pos < 0 {
+			report = append(report, fmt.Sprintf("hunk %d %s: FAILED, context not found", n+1, h.Header))
+			continue
+		}
+		new := newSide(hl, lines, pos)
+		lines = append(lines[:pos], append(new, lines[pos+len(old):]...)...)
+		delta += len(new) - len(old)
+		applied++
+
+		var notes []string
+		if h.OldLine > 0 && pos != want+fuzz {
+			notes = append(notes, fmt.Sprintf("offset %+d", pos-want-fuzz))
+		}
+		if fuzz > 0 {
+			notes = append(notes, fmt.Sprintf("fuzz %d", fuzz))
+		}
+		if loose {
+			notes = append(notes, "whitespace ignored")
+		}
+		status := "ok"
+		if len(notes) > 0 {
+			status += " (" + strings.Join(notes, ", ") + ")"
+		}
+		report = append(report, fmt.Sprintf("hunk %d %s: %s at line %d", n+1, h.Header, status, pos+1))
+	}
+	out := strings.Join(lines, "\n")
+	if len(lines) > 0 && (content == "" || strings.HasSuffix(content, "\n")) {
+		out += "\n"
+	}
+	return out, report, applied
+}
+
+func cmdApplyPatch(args string) string {
+	if strings.TrimSpace(args) == "" {
+		return "Usage: apply_patch:<unified diff>"
+	}
+	if currentMode == ModeManual {
+		return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
+	}
+	files, err := parsePatch(args)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+
+	type change struct {
+		path, from    string // from: renamed from, or ""
+		before, after string
+		format        fileFormat
+		remove        bool
+	}
+	var changes []change
+	var report strings.Builder
+	applied, total := 0, 0
+	for _, fp := range files {
+		path := fp.NewPath
+		if path == "" {
+			path = fp.OldPath
+		}
+		if path == "" {
+			continue
+		}
+		fullPath := resolvePath(path)
+		before, format := "", fileFormat{}
+		if fp.OldPath != "" {
+			var err error
+			if before, format, err = readText(resolvePath(fp.OldPath)); err != nil {
+				report.WriteString(fmt.Sprintf("\n  ✗ %s: %s", fp.OldPath, err))
+				total += len(fp.Hunks)
+				continue
+			}
+		} else if _, err := os.Stat(fullPath); err == nil {
+			report.WriteString(fmt.Sprintf("\n  ✗ %s: patch creates it but it already exists", path))
+			total += len(fp.Hunks)
+			continue
+		}
+		after, lines, n := applyHunks(before, fp.Hunks)
+		applied += n
+		total += len(fp.Hunks)
+		report.WriteString(fmt.Sprintf("\n  %s:", path))
+		if fp.OldPath != "" {
+			if stale, _ := staleFile(resolvePath(fp.OldPath)); stale {
+				report.WriteString(" (changed on disk since you read it; hunks were matched against the current version)")
+			}
+		}
+		for _, l := range lines {
+			report.WriteString("\n    " + l)
+		}
+		if n == 0 {
+			continue
+		}
+		c := change{path: fullPath, before: before, after: after, format: format, remove: fp.NewPath == ""}
+		if fp.OldPath != "" && fp.NewPath != "" && fp.OldPath != fp.NewPath {
+			c.from = resolvePath(fp.OldPath)
+		}
+		changes = append(changes, c)
+	}
+	if len(changes) == 0 {
+		return fmt.Sprintf("Patch failed: no hunk applied (0/%d)%s", total, report.String())
+	}
+
+	// In ask mode every file is confirmed on its own, edits hunk by hunk
+	var kept []change
+	for _, c := range changes {
+		ask := currentMode == ModeAsk
+		switch {
+		case c.remove:
+			fmt.Printf("%s%s: deleted%s\n", colorRed, relPath(c.path), colorReset)
+			if ask && !confirmAction(fmt.Sprintf("%sDelete %s?%s", colorYellow, relPath(c.path), colorReset)) {
+				report.WriteString(fmt.Sprintf("\n  %s: the user declined the deletion", relPath(c.path)))
+				continue
+			}
+		default:
+			if c.from != "" {
+				fmt.Printf("%s%s → %s%s\n", colorCyan, relPath(c.from), relPath(c.path), colorReset)
+			}
+			if ask && c.before != "" {
+				after, note, ok := reviewHunks(relPath(c.path), c.before, c.after)
+				if !ok {
+					report.WriteString(fmt.Sprintf("\n  %s: the user declined all hunks", relPath(c.path)))
+					continue
+				}
+				if note != "" {
+					report.WriteString(fmt.Sprintf("\n  %s: %s", relPath(c.path), note))
+				}
+				c.after = after
+				break
+			}
+			fmt.Println(renderDiff(relPath(c.path), c.before, c.after))
+			if ask && !confirmAction(fmt.Sprintf("%sCreate %s?%s", colorYellow, relPath(c.path), colorReset)) {
+				report.WriteString(fmt.Sprintf("\n  %s: the user declined it", relPath(c.path)))
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	if len(kept) == 0 {
+		return "Cancelled"
+	}
+	changes = kept
+
+	// Stops at the first file that can't be written; undo covers only the
+	// files that were
+	batch := UndoAction{Type: "batch", Path: "patch", Time: time.Now(), Op: "patch"}
+	written := 0
+	var failed error
+	for i, c := range changes {
+		snaps := []UndoAction{undoSnapshot(c.path)}
+		if c.from != "" {
+			snaps = append(snaps, undoSnapshot(c.from))
+		}
+		var err error
+		changed := false
+		if c.remove {
+			err = os.Remove(c.path)
+			changed = err == nil
+		} else if err = writeText(c.path, c.after, c.format); err == nil {
+			changed = true
+			if c.from != "" {
+				err = os.Remove(c.from)
+			}
+		}
+		if changed {
+			batch.Files = append(batch.Files, snaps...)
+			written++
+			refreshSeen(c.path)
+			if c.from != "" {
+				refreshSeen(c.from)
+			}
+		}
+		if err != nil {
+			failed = err
+			report.WriteString(fmt.Sprintf("\n  ✗ %s: %s", relPath(c.path), err))
+			for _, rest := range changes[i+1:] {
+				report.WriteString(fmt.Sprintf("\n  %s: not written", relPath(rest.path)))
+			}
+			break
+		}
+	}
+	if len(batch.Files) > 0 {
+		pushUndo(batch)
+	}
+	status := colorGreen + "✓ Patch applied"
+	switch {
+	case failed != nil:
+		status = colorYellow + "⚠ Patch stopped at a file it couldn't write"
+	case applied < total:
+		status = colorYellow + "⚠ Patch partly applied (redo the failed hunks against the current file)"
+	}
+	return fmt.Sprintf("%s: %d/%d hunks in %d files%s%s", status, applied, total, written, colorReset, report.String())
+}
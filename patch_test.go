@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const patchBase = "package main\n\nfunc a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n"
+
+func TestApplyHunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		patch   string
+		want    string // "" when the hunk must fail
+		note    string // in the hunk's report line
+	}{
+		{
+			name:    "exact",
+			content: patchBase,
+			patch:   "@@ -7,3 +7,3 @@\n func b() {\n-\treturn 2\n+\treturn 3\n }\n",
+			want:    strings.Replace(patchBase, "return 2", "return 3", 1),
+			note:    "ok at line 7",
+		},
+		{
+			name:    "offset",
+			content: "// header\n// more\n" + patchBase,
+			patch:   "@@ -7,3 +7,3 @@\n func b() {\n-\treturn 2\n+\treturn 3\n }\n",
+			want:    "// header\n// more\n" + strings.Replace(patchBase, "return 2", "return 3", 1),
+			note:    "offset +2",
+		},
+		{
+			name:    "whitespace drift",
+			content: strings.ReplaceAll(patchBase, "\t", "    "),
+			patch:   "@@ -7,3 +7,3 @@\n func b() {\n-\treturn 2\n+\treturn 3\n }\n",
+			want:    strings.Replace(strings.ReplaceAll(patchBase, "\t", "    "), "    return 2", "\treturn 3", 1),
+			note:    "whitespace ignored",
+		},
+		{
+			name:    "stale context",
+			content: strings.Replace(patchBase, "func b() {", "func b() { // renamed", 1),
+			patch:   "@@ -6,4 +6,4 @@\n \n func b() {\n-\treturn 2\n+\treturn 3\n }\n",
+			want:    strings.Replace(strings.Replace(patchBase, "func b() {", "func b() { // renamed", 1), "return 2", "return 3", 1),
+			note:    "fuzz",
+		},
+		{
+			name:    "no match",
+			content: patchBase,
+			patch:   "@@ -7,3 +7,3 @@\n func c() {\n-\treturn 9\n+\treturn 3\n }\n",
+			note:    "FAILED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := parsePatch("--- a/x.go\n+++ b/x.go\n" + tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, report, applied := applyHunks(tt.content, files[0].Hunks)
+			if len(report) != 1 || !strings.Contains(report[0], tt.note) {
+				t.Errorf("report = %q, want it to mention %q", report, tt.note)
+			}
+			if tt.want == "" {
+				if applied != 0 || got != tt.content {
+					t.Errorf("applied %d, content changed to %q; want the hunk to fail", applied, got)
+				}
+				return
+			}
+			if applied != 1 || got != tt.want {
+				t.Errorf("applied %d, got\n%s\nwant\n%s", applied, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePatchCreateDelete(t *testing.T) {
+	files, err := parsePatch("--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1,2 @@\n+one\n+two\n" +
+		"diff --git a/old.txt b/old.txt\n--- a/old.txt\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-one\n-two\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2", len(files))
+	}
+	create, del := files[0], files[1]
+	if create.OldPath != "" || create.NewPath != "new.txt" {
+		t.Errorf("create paths = %q, %q", create.OldPath, create.NewPath)
+	}
+	if got, _, n := applyHunks("", create.Hunks); n != 1 || got != "one\ntwo\n" {
+		t.Errorf("create = %q (%d applied), want %q", got, n, "one\ntwo\n")
+	}
+	if del.OldPath != "old.txt" || del.NewPath != "" {
+		t.Errorf("delete paths = %q, %q", del.OldPath, del.NewPath)
+	}
+	if got, _, n := applyHunks("one\ntwo\n", del.Hunks); n != 1 || strings.TrimSpace(got) != "" {
+		t.Errorf("delete = %q (%d applied), want nothing left", got, n)
+	}
+}
+
+// A file that can't be written stops the patch, and undo only covers the
+// files written before it
+func TestApplyPatchStopsAtWriteError(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "blocker"), []byte("a file, not a directory\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "c.txt"), []byte("three\n"), 0644)
+	savedDir, savedUndo := currentDir, undoStack
+	currentDir = dir
+	defer func() { currentDir, undoStack = savedDir, savedUndo }()
+
+	out := cmdApplyPatch("--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-one\n+ONE\n" +
+		"--- /dev/null\n+++ b/blocker/b.txt\n@@ -0,0 +1 @@\n+two\n" +
+		"--- a/c.txt\n+++ b/c.txt\n@@ -1 +1 @@\n-three\n+THREE\n")
+	if !strings.Contains(out, "stopped") {
+		t.Errorf("result doesn't say the patch stopped:\n%s", out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "ONE\n" {
+		t.Errorf("a.txt = %q, want it patched", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "c.txt")); string(data) != "three\n" {
+		t.Errorf("c.txt = %q, want it left alone after the failed write", data)
+	}
+	last := undoStack[len(undoStack)-1]
+	if len(last.Files) != 1 || last.Files[0].Path != filepath.Join(dir, "a.txt") {
+		t.Errorf("undo record covers %v, want only a.txt", last.Files)
+	}
+}
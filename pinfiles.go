@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== PINNED FILES ====================
+
+// /pin-file keeps files attached for the whole session: every request
+// carries their current contents after the latest user message, without
+// storing a copy per turn in the history. A file whose mtime moved since
+// the previous turn is flagged, both to the user and to the model.
+
+var (
+	pinnedFiles   []string                 // absolute paths, in pin order
+	pinnedModTime = map[string]time.Time{} // as of the last request
+)
+
+func cmdPinFile(args string) string {
+	if args == "" {
+		if len(pinnedFiles) == 0 {
+			return "No pinned files (/pin-file <path>...)"
+		}
+		var b strings.Builder
+		b.WriteString("Pinned files:\n")
+		for _, path := range pinnedFiles {
+			info, err := os.Stat(path)
+			if err != nil {
+				b.WriteString(fmt.Sprintf("  %s%s (missing)%s\n", colorRed, relPath(path), colorReset))
+				continue
+			}
+			b.WriteString(fmt.Sprintf("  📎 %s %s%s, modified %s%s\n", relPath(path), colorGray, formatSize(info.Size()), info.ModTime().Format("15:04:05"), colorReset))
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	var added []string
+	for _, arg := range strings.Fields(args) {
+		path := resolvePath(arg)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			return fmt.Sprintf("%sNot a file: %s%s", colorRed, arg, colorReset)
+		}
+		if isPinnedFile(path) {
+			continue
+		}
+		pinnedFiles = append(pinnedFiles, path)
+		pinnedModTime[path] = info.ModTime()
+		added = append(added, relPath(path))
+	}
+	if len(added) == 0 {
+		return "Already pinned"
+	}
+	return fmt.Sprintf("%s📎 Pinned %s (attached to every request)%s", colorGreen, strings.Join(added, ", "), colorReset)
+}
+
+func cmdUnpinFile(args string) string {
+	if args == "all" {
+		n := len(pinnedFiles)
+		pinnedFiles, pinnedModTime = nil, map[string]time.Time{}
+		return fmt.Sprintf("Unpinned %d files", n)
+	}
+	var kept, removed []string
+	for _, path := range pinnedFiles {
+		if args == relPath(path) || resolvePath(args) == path {
+			removed = append(removed, relPath(path))
+			delete(pinnedModTime, path)
+			continue
+		}
+		kept = append(kept, path)
+	}
+	if len(removed) == 0 {
+		return "Usage: /unpin-file <path|all> (see /pin-file)"
+	}
+	pinnedFiles = kept
+	return "Unpinned " + strings.Join(removed, ", ")
+}
+
+func isPinnedFile(path string) bool {
+	for _, p := range pinnedFiles {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Current contents of the pinned files as one attachment; changed lists
+// those modified since the previous request. query picks the chunks of
+// files too big to attach whole.
+func pinnedFilesBlock(query string) (block string, changed []string) {
+	var parts []string
+	for _, path := range pinnedFiles {
+		info, err := os.Stat(path)
+		if err != nil {
+			parts = append(parts, fmt.Sprintf("=== %s (pinned; deleted or unreadable) ===", path))
+			continue
+		}
+		note := "pinned"
+		if last, ok := pinnedModTime[path]; ok && !info.ModTime().Equal(last) {
+			note = "pinned; changed since the previous turn"
+			changed = append(changed, relPath(path))
+		}
+		pinnedModTime[path] = info.ModTime()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		content := string(data)
+		if reason := unreadableReason(data, guardMaxBytes); reason != "" {
+			content = fileSummary(path, data, reason)
+		} else if estimateTokens(content) > mentionFileTokens {
+			var chunks string
+			content, chunks = chunkedMention(path, content, query)
+			note += ", " + chunks
+		}
+		parts = append(parts, fmt.Sprintf("=== %s (%s, modified %s) ===\n%s", path, note, info.ModTime().Format(time.RFC3339), content))
+	}
+	sort.Strings(changed)
+	return strings.Join(parts, "\n\n"), changed
+}
+
+// history with the pinned files appended to its last user message, for
+// sending; history itself is left as it is
+func withPinnedFiles(history []ChatMessage) []ChatMessage {
+	if len(pinnedFiles) == 0 {
+		return history
+	}
+	last := -1
+	for i := len(history) - 1; i > 0; i-- {
+		if history[i].Role == "user" {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return history
+	}
+	block, changed := pinnedFilesBlock(history[last].Content)
+	if len(changed) > 0 {
+		fmt.Printf("%s  ↻ changed since last turn: %s%s\n", colorYellow, strings.Join(changed, ", "), colorReset)
+	}
+	out := make([]ChatMessage, len(history))
+	copy(out, history)
+	out[last].Content += "\n\n" + block
+	return out
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ==================== PINNED MESSAGES ====================
+
+// /pin marks an exchange (the user's message, tool results and the
+// replies to them) as always retained: compaction keeps it verbatim and
+// the sliding window that trims the middle of the history when the context
+// gets tight skips it. Pins are stored on the messages, so they survive a
+// saved session.
+
+const trimTo = 0.6 // of maxContextTokens, once compaction didn't get below compactAt
+
+// Messages as sent to the API, without local fields
+func apiMessages(history []ChatMessage) []ChatMessage {
+	for i, m := range history {
+		if m.Pinned {
+			out := make([]ChatMessage, len(history))
+			copy(out, history)
+			for j := i; j < len(out); j++ {
+				out[j].Pinned = false
+			}
+			return out
+		}
+	}
+	return history
+}
+
+// Start and end (exclusive) of each exchange after the system prompt. An
+// exchange starts at a user message; tool results belong to the one
+// they answer.
+func historyUnits(history []ChatMessage) [][2]int {
+	var units [][2]int
+	for i := 1; i < len(history); i++ {
+		m := history[i]
+		if len(units) == 0 || m.Role == "user" && !strings.HasPrefix(m.Content, "Results:\n") {
+			units = append(units, [2]int{i, i + 1})
+			continue
+		}
+		units[len(units)-1][1] = i + 1
+	}
+	return units
+}
+
+func unitPinned(history []ChatMessage, u [2]int) bool {
+	for _, m := range history[u[0]:u[1]] {
+		if m.Pinned {
+			return true
+		}
+	}
+	return false
+}
+
+func setPinned(history []ChatMessage, u [2]int, pinned bool) {
+	for i := u[0]; i < u[1]; i++ {
+		history[i].Pinned = pinned
+	}
+}
+
+func isCompactSummary(m ChatMessage) bool {
+	return m.Role == "user" && strings.HasPrefix(m.Content, compactMarker)
+}
+
+// /pin [n|list]: the last exchange, exchange n, or the list
+func cmdPin(arg string, history []ChatMessage) string {
+	units := historyUnits(history)
+	if len(units) == 0 {
+		return "Nothing to pin yet"
+	}
+	if arg == "list" {
+		var b strings.Builder
+		for i, u := range units {
+			mark := "  "
+			if unitPinned(history, u) {
+				mark = colorYellow + "📌" + colorReset
+			}
+			preview := strings.Join(strings.Fields(stripANSI(history[u[0]].Content)), " ")
+			b.WriteString(fmt.Sprintf("%s %s%3d%s %s\n", mark, colorGray, i+1, colorReset, truncate(preview, 70)))
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	n := len(units)
+	if arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil || n < 1 || n > len(units) {
+			return fmt.Sprintf("Usage: /pin [1-%d|list]", len(units))
+		}
+	}
+	u := units[n-1]
+	setPinned(history, u, true)
+	return fmt.Sprintf("%s📌 Pinned #%d: %s%s", colorGreen, n, truncate(strings.Join(strings.Fields(history[u[0]].Content), " "), 60), colorReset)
+}
+
+// /unpin [n|all]
+func cmdUnpin(arg string, history []ChatMessage) string {
+	units := historyUnits(history)
+	if arg == "all" {
+		for _, u := range units {
+			setPinned(history, u, false)
+		}
+		return "Unpinned all"
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(units) {
+		return "Usage: /unpin <n|all> (numbers from /pin list)"
+	}
+	setPinned(history, units[n-1], false)
+	return fmt.Sprintf("Unpinned #%d", n)
+}
+
+// Drops the oldest unpinned exchanges until the history fits trimTo. The
+// last compactKeep messages and the compaction summary always stay.
+func trimHistory(history []ChatMessage) ([]ChatMessage, string) {
+	target := int(trimTo * float64(maxContextTokens))
+	used := historyTokens(history)
+	drop := map[int]bool{}
+	dropped := 0
+	for _, u := range historyUnits(history) {
+		if used <= target || u[1] > len(history)-compactKeep {
+			break
+		}
+		if unitPinned(history, u) || isCompactSummary(history[u[0]]) {
+			continue
+		}
+		for i := u[0]; i < u[1]; i++ {
+			drop[i] = true
+			used -= estimateTokens(history[i].Content) + 4
+		}
+		dropped += u[1] - u[0]
+	}
+	if dropped == 0 {
+		return history, ""
+	}
+	trimmed := make([]ChatMessage, 0, len(history)-dropped)
+	for i, m := range history {
+		if !drop[i] {
+			trimmed = append(trimmed, m)
+		}
+	}
+	for i := range checkpoints {
+		checkpoints[i].HistoryLen = max(2, checkpoints[i].HistoryLen-dropped)
+	}
+	totalTokens = historyTokens(trimmed)
+	return trimmed, fmt.Sprintf("%s✂ Dropped %d older messages to stay within context (/pin keeps one)%s", colorYellow, dropped, colorReset)
+}
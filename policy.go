@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ==================== COMMAND POLICY ====================
+
+// <root>/.mytool/policy.yaml limits what run: and bash: may execute in
+// this project, in every mode:
+//
+//	allow:
+//	  - go test
+//	  - npm run *
+//	deny:
+//	  - curl * | sh
+//	  - /terraform\s+(apply|destroy)/
+//
+// A pattern is a glob (* is anything) unless written as /regex/. Deny
+// patterns match anywhere in the command. With an allow list, every part
+// of the command (split on ;, &, &&, || and |) must start with an allowed
+// pattern, and command substitution ($(...), backticks, <(...)) and
+// redirections to files are refused, since they'd run or write things
+// the list never sees; 2>&1 and the like are fine. Only that subset of
+// YAML is read: two lists, one item per line, full-line # comments.
+// python:, node: and the other runtimes are not covered; disable them in
+// /settings when that matters.
+
+type commandPolicy struct {
+	Allow, Deny []policyPattern
+}
+
+type policyPattern struct {
+	Text string
+	re   *regexp.Regexp
+}
+
+var (
+	commandSplitRe = regexp.MustCompile(`&&|\|\||[;|&\n]`)
+	fdDupRe        = regexp.MustCompile(`[0-9]*[<>]&[0-9-]+`)
+	redirectRe     = regexp.MustCompile("\\$\\(|`|[<>]")
+)
+
+func policyPath() string {
+	return filepath.Join(findProjectRoot(), ".mytool", "policy.yaml")
+}
+
+// The project's policy; nil without a policy file
+func loadPolicy() (*commandPolicy, error) {
+	f, err := os.Open(policyPath())
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	p := &commandPolicy{}
+	var list *[]policyPattern
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line == "allow:":
+			list = &p.Allow
+		case line == "deny:":
+			list = &p.Deny
+		case strings.HasPrefix(line, "- ") && list != nil:
+			text := unquoteYAML(strings.TrimSpace(line[2:]))
+			re, err := compilePolicyPattern(text, list == &p.Allow)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %s", relPath(policyPath()), n, err)
+			}
+			*list = append(*list, policyPattern{Text: text, re: re})
+		default:
+			return nil, fmt.Errorf("%s line %d: expected allow:, deny: or a \"- pattern\" item", relPath(policyPath()), n)
+		}
+	}
+	return p, sc.Err()
+}
+
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Allow patterns must match from the start of a command part, up to its
+// end or a space; deny patterns anywhere
+func compilePolicyPattern(text string, anchored bool) (*regexp.Regexp, error) {
+	var expr string
+	if len(text) > 2 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+		expr = text[1 : len(text)-1]
+	} else {
+		var b strings.Builder
+		for i, part := range strings.Split(text, "*") {
+			if i > 0 {
+				b.WriteString(".*")
+			}
+			// Runs of spaces in the pattern match any run of spaces
+			for j, word := range strings.Fields(part) {
+				if j > 0 || strings.HasPrefix(part, " ") {
+					b.WriteString(`\s+`)
+				}
+				b.WriteString(regexp.QuoteMeta(word))
+			}
+			if strings.HasSuffix(part, " ") && strings.TrimSpace(part) != "" {
+				b.WriteString(`\s+`)
+			}
+		}
+		expr = b.String()
+		if !anchored {
+			expr = `(^|[^\w-])` + expr
+		}
+	}
+	if anchored {
+		expr = `^\s*(?:` + expr + `)(\s|$)`
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %s", text, err)
+	}
+	return re, nil
+}
+
+// Why command may not run under the project's policy, or ""
+func policyViolation(command string) string {
+	p, err := loadPolicy()
+	if err != nil {
+		return err.Error() // fail closed
+	}
+	if p == nil {
+		return ""
+	}
+	for _, d := range p.Deny {
+		if d.re.MatchString(command) {
+			return fmt.Sprintf("matches deny pattern %q", d.Text)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return ""
+	}
+	command = fdDupRe.ReplaceAllString(command, "")
+	if m := redirectRe.FindString(command); m != "" {
+		return fmt.Sprintf("%q: command substitution and redirections aren't allowed with an allow list", m)
+	}
+	for _, part := range commandSplitRe.Split(command, -1) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		allowed := false
+		for _, a := range p.Allow {
+			if a.re.MatchString(part) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("%q is not on the allow list", strings.TrimSpace(part))
+		}
+	}
+	return ""
+}
+
+// Result for a command the policy refuses, or ""
+func policyBlock(command string) string {
+	why := policyViolation(command)
+	if why == "" {
+		return ""
+	}
+	return fmt.Sprintf("Error: blocked by policy (%s): %s", relPath(policyPath()), why)
+}
+
+// /policy shows the project's policy, /policy check <cmd> tests a command
+func cmdPolicy(arg string) string {
+	if cmd, ok := strings.CutPrefix(arg, "check "); ok {
+		if why := policyViolation(cmd); why != "" {
+			return fmt.Sprintf("%s✗ blocked: %s%s", colorRed, why, colorReset)
+		}
+		return fmt.Sprintf("%s✓ allowed%s", colorGreen, colorReset)
+	}
+	p, err := loadPolicy()
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if p == nil {
+		return fmt.Sprintf("No command policy (create %s with allow: and deny: lists)", relPath(policyPath()))
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%s%s%s\n", colorCyan, relPath(policyPath()), colorReset))
+	for _, list := range []struct {
+		name string
+		pats []policyPattern
+	}{{"allow", p.Allow}, {"deny", p.Deny}} {
+		if len(list.pats) == 0 {
+			continue
+		}
+		b.WriteString(list.name + ":\n")
+		for _, pat := range list.pats {
+			b.WriteString("  - " + pat.Text + "\n")
+		}
+	}
+	if len(p.Allow) == 0 {
+		b.WriteString(colorGray + "no allow list: everything not denied may run\n" + colorReset)
+	}
+	return b.String() + colorGray + "/policy check <command> tests a command" + colorReset
+}
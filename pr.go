@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ==================== PULL REQUESTS ====================
+
+// /pr pushes the current branch and opens a pull request for it (a merge
+// request on GitLab). The model drafts the title and description from the
+// branch's commits, its diff and what was asked in this session; the user
+// can edit the draft or have it redone before anything leaves the
+// machine. /pr status shows the branch's pull request and its CI.
+
+const prDiffMax = 10000
+
+// /pr [--draft] [--base <branch>] [--remote <name>] | /pr status | /pr login <token>
+func cmdPR(arg string, history []ChatMessage) string {
+	fields := strings.Fields(arg)
+	draft, base, remote := false, "", "origin"
+	for i := 0; i < len(fields); i++ {
+		switch {
+		case i == 0 && (fields[0] == "login" || fields[0] == "status"):
+			i = len(fields)
+		case fields[i] == "--draft":
+			draft = true
+		case fields[i] == "--base" && i+1 < len(fields):
+			i++
+			base = fields[i]
+		case fields[i] == "--remote" && i+1 < len(fields):
+			i++
+			remote = fields[i]
+		default:
+			return "Usage: /pr [--draft] [--base <branch>] [--remote <name>], /pr status, /pr login <token>"
+		}
+	}
+
+	if !haveBinary("git") {
+		return toolUnavailable("git", false)
+	}
+	root := findProjectRoot()
+	if root == "" {
+		return "Error: not in a git repository"
+	}
+	repo, err := forgeRemote(root, remote)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if len(fields) > 0 && fields[0] == "login" {
+		if len(fields) != 2 {
+			return "Usage: /pr login <token>"
+		}
+		if err := keychainSet(forgeKeychain(repo.Kind), "mytool "+repo.Label()+" token", fields[1]); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		return fmt.Sprintf("%s✓ %s token saved in the keychain%s", colorGreen, repo.Label(), colorReset)
+	}
+	if _, err := forgeToken(repo); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	branch, _ := gitIn(root, "branch", "--show-current")
+	if branch == "" {
+		return "Error: not on a branch (detached HEAD)"
+	}
+	if len(fields) > 0 && fields[0] == "status" {
+		return prStatus(root, repo, branch)
+	}
+	if base == "" {
+		if base, err = forgeDefaultBranch(repo); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+	}
+	if branch == base {
+		return fmt.Sprintf("Error: you are on %s itself; create a branch for the change first (git switch -c <name>)", base)
+	}
+
+	// Pushing is enough when the branch already has a pull request
+	open, err := forgeOpenRequest(repo, branch)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if open != nil {
+		if err := gitPush(root, remote, branch); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		return fmt.Sprintf("%s✓ Pushed %s; %s #%d is already open: %s%s", colorGreen, branch, repo.RequestName(), open.Number, open.URL, colorReset)
+	}
+
+	if status, _ := gitIn(root, "status", "--porcelain"); status != "" {
+		fmt.Printf("%s⚠ Uncommitted changes are not part of the %s (/commit first)%s\n", colorYellow, repo.RequestName(), colorReset)
+	}
+	baseRef := remote + "/" + base
+	if _, err := gitIn(root, "rev-parse", "-q", "--verify", baseRef); err != nil {
+		baseRef = base
+	}
+	commits, _ := gitIn(root, "log", "--reverse", "--format=- %s%n%b", baseRef+"..HEAD")
+	if strings.TrimSpace(commits) == "" {
+		return fmt.Sprintf("Error: %s has no commits that %s doesn't have", branch, baseRef)
+	}
+	stat, _ := gitIn(root, "diff", "--stat", baseRef+"...HEAD")
+	diff, _ := gitIn(root, "diff", "--no-color", baseRef+"...HEAD")
+	if len(diff) > prDiffMax {
+		diff = diff[:prDiffMax] + "\n... (diff truncated)"
+	}
+	fmt.Printf("%s%s → %s, %s %s%s\n", colorGray, branch, base, repo.Label(), repo, colorReset)
+
+	for {
+		fmt.Printf("%sDrafting the description...%s\n", colorGray, colorReset)
+		title, body, err := draftPR(repo, commits, stat, diff, sessionRequests(history))
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		for {
+			fmt.Printf("\n%s%s%s\n\n%s\n\n", colorCyan, title, colorReset, body)
+			switch strings.ToLower(readAnswer(fmt.Sprintf("%s[Enter] push and open · e edit · r redraft · n cancel:%s ", colorYellow, colorReset))) {
+			case "":
+				return openPR(root, remote, repo, branch, base, title, body, draft)
+			case "e":
+				if edited, ok := editCommitMessage(title + "\n\n" + body); ok && edited != "" {
+					title, body, _ = strings.Cut(edited, "\n")
+					body = strings.TrimSpace(body)
+				}
+				continue
+			case "r":
+			default:
+				return "Cancelled"
+			}
+			break
+		}
+	}
+}
+
+// What the user asked for in this session, for the description
+func sessionRequests(history []ChatMessage) string {
+	var asks []string
+	for _, m := range history {
+		if m.Role != "user" || strings.HasPrefix(m.Content, "Results:") || strings.HasPrefix(m.Content, "[") {
+			continue
+		}
+		asks = append(asks, "- "+truncate(strings.ReplaceAll(m.Content, "\n", " "), 300))
+	}
+	if len(asks) > 10 {
+		asks = asks[len(asks)-10:]
+	}
+	return strings.Join(asks, "\n")
+}
+
+func draftPR(repo forgeRepo, commits, stat, diff, asks string) (string, string, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "Write a " + repo.Label() + " " + repo.RequestName() + " title and description for this branch. " +
+			"First line: the title, under 70 characters, no prefix like \"PR:\". Then a blank line and a Markdown body: " +
+			"a short paragraph on what the change does and why, a \"## Changes\" list, and \"## Testing\" only if the " +
+			"commits or diff show how it was tested. Don't invent issue numbers or results. Reply with the title and body only."},
+		{Role: "user", Content: fmt.Sprintf("Commits:\n%s\n\nFiles:\n%s\n\nWhat the user asked for in the session:\n%s\n\nDiff:\n%s",
+			commits, stat, asks, diff)},
+	}
+	out, err := sendComplete(getAPIKey(), messages, 800, "pr_description")
+	if err != nil {
+		return "", "", err
+	}
+	out = strings.TrimSpace(out)
+	if m := codeFenceRe.FindStringSubmatch(out); m != nil {
+		out = strings.TrimSpace(m[1])
+	}
+	title, body, _ := strings.Cut(out, "\n")
+	title = strings.TrimSpace(strings.TrimLeft(title, "# "))
+	if title == "" {
+		return "", "", fmt.Errorf("the model returned an empty draft")
+	}
+	return title, strings.TrimSpace(body), nil
+}
+
+// git push -u, shown as it runs (it may ask for credentials)
+func gitPush(root, remote, branch string) error {
+	fmt.Printf("%s$ git push -u %s %s%s\n", colorGray, remote, branch, colorReset)
+	cmd := exec.Command("git", "push", "-u", remote, branch)
+	cmd.Dir = root
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git push: %s", err)
+	}
+	return nil
+}
+
+func openPR(root, remote string, repo forgeRepo, branch, base, title, body string, draft bool) string {
+	if err := gitPush(root, remote, branch); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	pr, err := forgeCreateRequest(repo, branch, base, title, body, draft)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return fmt.Sprintf("%s✓ Opened %s #%d: %s%s", colorGreen, repo.RequestName(), pr.Number, pr.URL, colorReset)
+}
+
+// The branch's open pull request and the CI results for what was pushed
+func prStatus(root string, repo forgeRepo, branch string) string {
+	open, err := forgeOpenRequest(repo, branch)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	var b strings.Builder
+	sha := ""
+	if open != nil {
+		b.WriteString(fmt.Sprintf("%s%s %s #%d%s %s\n", colorCyan, repo.Label(), repo.RequestName(), open.Number, colorReset, open.URL))
+		sha = open.SHA
+	} else {
+		b.WriteString(fmt.Sprintf("No open %s for %s\n", repo.RequestName(), branch))
+	}
+	if sha == "" {
+		if sha, err = gitIn(root, "rev-parse", "@{upstream}"); err != nil {
+			return b.String() + fmt.Sprintf("%s%s isn't pushed yet%s", colorGray, branch, colorReset)
+		}
+	}
+	if local, _ := gitIn(root, "rev-parse", "HEAD"); local != sha {
+		b.WriteString(fmt.Sprintf("%sLocal commits aren't pushed; CI below is for %s%s\n", colorYellow, sha[:min(len(sha), 8)], colorReset))
+	}
+	checks, err := forgeChecks(repo, sha)
+	if err != nil {
+		return b.String() + fmt.Sprintf("Error: %s", err)
+	}
+	if len(checks) == 0 {
+		return b.String() + "No CI results for " + sha[:min(len(sha), 8)]
+	}
+	counts := map[string]int{}
+	for _, c := range checks {
+		counts[c.State]++
+		icon, color := "…", colorYellow
+		switch c.State {
+		case "success":
+			icon, color = "✓", colorGreen
+		case "failure":
+			icon, color = "✗", colorRed
+		case "skipped":
+			icon, color = "–", colorGray
+		}
+		b.WriteString(fmt.Sprintf("  %s%s %s%s", color, icon, c.Name, colorReset))
+		if c.State == "failure" && c.URL != "" {
+			b.WriteString(fmt.Sprintf(" %s%s%s", colorGray, c.URL, colorReset))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(fmt.Sprintf("CI: %d passed, %d failed, %d running", counts["success"], counts["failure"], counts["pending"]))
+	if counts["skipped"] > 0 {
+		b.WriteString(fmt.Sprintf(", %d skipped", counts["skipped"]))
+	}
+	return b.String()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== PROMPT LINT ====================
+
+// Before a message is sent, file paths and `symbols` it mentions are looked
+// up in the workspace. Missing ones that are close to an existing name are
+// reported with the candidates, saving a round trip where the model greps
+// for a file that isn't there. Names with no near match are left alone:
+// they are usually files the user wants created.
+
+var lintExtensions = map[string]bool{
+	"go": true, "mod": true, "js": true, "jsx": true, "ts": true, "tsx": true, "mjs": true, "py": true, "rs": true,
+	"java": true, "kt": true, "rb": true, "php": true, "c": true, "h": true, "cc": true, "cpp": true, "hpp": true,
+	"cs": true, "swift": true, "md": true, "json": true, "yaml": true, "yml": true, "toml": true, "sh": true,
+	"sql": true, "html": true, "css": true, "scss": true, "vue": true, "svelte": true, "txt": true, "xml": true,
+	"ini": true, "cfg": true, "env": true, "lock": true, "gradle": true, "proto": true, "tf": true,
+}
+
+var (
+	lintPathRe   = regexp.MustCompile(`@?[\w./~\-]+`)
+	lintSymbolRe = regexp.MustCompile("`([A-Za-z_][A-Za-z0-9_]*(?:\\.[A-Za-z_][A-Za-z0-9_]*)?)(?:\\(\\))?`")
+	lintWordRe   = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]{3,}`)
+)
+
+const lintMaxFiles = 5000
+
+// Workspace listing, reused until a directory in it changes; words can lag
+// behind edits to existing files, which only costs a suggestion
+var lintCache struct {
+	dir   string
+	stamp walkStamp
+	files []string // relative to dir, slash-separated
+	words map[string]bool
+}
+
+// Modification times of what a directory walk went through. Adding,
+// removing or renaming a file updates its directory's mtime, so while
+// every time still matches the walk would find the same entries: checking
+// that takes one stat per entry instead of a new walk.
+type walkStamp map[string]time.Time
+
+func (s walkStamp) add(path string, d fs.DirEntry) {
+	if info, err := d.Info(); err == nil {
+		s[path] = info.ModTime()
+	}
+}
+
+func (s walkStamp) current() bool {
+	if len(s) == 0 {
+		return false
+	}
+	for path, t := range s {
+		if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(t) {
+			return false
+		}
+	}
+	return true
+}
+
+type badRef struct {
+	Ref         string
+	Symbol      bool
+	Suggestions []string
+}
+
+func lintWorkspace() ([]string, map[string]bool) {
+	if lintCache.dir == currentDir && lintCache.stamp.current() {
+		return lintCache.files, lintCache.words
+	}
+	var files []string
+	words := map[string]bool{}
+	stamp := walkStamp{}
+	filepath.WalkDir(currentDir, func(path string, d fs.DirEntry, err error) error {
+		if len(files) >= lintMaxFiles {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != currentDir && (projectSkipDirs[d.Name()] || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			stamp.add(path, d)
+			return nil
+		}
+		rel, _ := filepath.Rel(currentDir, path)
+		files = append(files, filepath.ToSlash(rel))
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		if info, err := d.Info(); err == nil && info.Size() < 256*1024 && lintExtensions[ext] {
+			if data, err := os.ReadFile(path); err == nil {
+				for _, w := range lintWordRe.FindAllString(string(data), -1) {
+					words[w] = true
+				}
+			}
+		}
+		return nil
+	})
+	lintCache.dir, lintCache.stamp, lintCache.files, lintCache.words = currentDir, stamp, files, words
+	return files, words
+}
+
+// Path-like tokens and backticked symbols in input that don't exist here
+// but have near matches
+func lintPromptRefs(input string) []badRef {
+	if findProjectRoot() == "" && projectType == "" {
+		return nil // not in a workspace, e.g. the home directory
+	}
+	var bad []badRef
+	seen := map[string]bool{}
+	files, words := lintWorkspace()
+
+	for _, tok := range lintPathRe.FindAllString(input, -1) {
+		tok = strings.TrimRight(strings.TrimPrefix(tok, "@"), ".")
+		if seen[tok] || !looksLikePath(tok) {
+			continue
+		}
+		seen[tok] = true
+		if _, err := os.Stat(resolvePath(tok)); err == nil {
+			continue
+		}
+		if s := closestPaths(tok, files); len(s) > 0 {
+			bad = append(bad, badRef{Ref: tok, Suggestions: s})
+		}
+	}
+
+	for _, m := range lintSymbolRe.FindAllStringSubmatch(input, -1) {
+		sym := m[1]
+		if i := strings.LastIndex(sym, "."); i >= 0 {
+			sym = sym[i+1:] // pkg.Func: check Func
+		}
+		if seen[sym] || len(sym) < 4 || words[sym] || looksLikePath(m[1]) {
+			continue
+		}
+		seen[sym] = true
+		if s := closestWords(sym, words); len(s) > 0 {
+			bad = append(bad, badRef{Ref: sym, Symbol: true, Suggestions: s})
+		}
+	}
+	return bad
+}
+
+// A token with a known file extension, or a relative path whose first
+// directory exists
+func looksLikePath(tok string) bool {
+	if strings.Contains(tok, "://") || strings.HasPrefix(tok, "//") || strings.Count(tok, ".") > 3 {
+		return false
+	}
+	base := filepath.Base(tok)
+	if i := strings.LastIndex(base, "."); i > 0 && lintExtensions[strings.ToLower(base[i+1:])] {
+		return true
+	}
+	if first, _, ok := strings.Cut(tok, "/"); ok && first != "" && first != "." && first != ".." && !strings.HasPrefix(tok, "~") {
+		if info, err := os.Stat(filepath.Join(currentDir, first)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func closestPaths(ref string, files []string) []string {
+	ref = filepath.ToSlash(strings.TrimPrefix(ref, "./"))
+	base := filepath.Base(ref)
+	type cand struct {
+		path string
+		dist int
+	}
+	var cands []cand
+	for _, f := range files {
+		d := levenshtein(ref, f)
+		if fb := filepath.Base(f); fb == base {
+			d = 0 // right name, wrong directory
+		} else if bd := levenshtein(base, fb); bd+1 < d {
+			d = bd + 1
+		}
+		if d <= max(2, len(base)/4) {
+			cands = append(cands, cand{f, d})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].dist < cands[j].dist })
+	var out []string
+	for i := 0; i < len(cands) && i < 3; i++ {
+		out = append(out, cands[i].path)
+	}
+	return out
+}
+
+func closestWords(sym string, words map[string]bool) []string {
+	type cand struct {
+		word string
+		dist int
+	}
+	var cands []cand
+	limit := max(1, len(sym)/4)
+	lower := strings.ToLower(sym)
+	for w := range words {
+		if abs(len(w)-len(sym)) > limit {
+			continue
+		}
+		if d := levenshtein(lower, strings.ToLower(w)); d <= limit {
+			cands = append(cands, cand{w, d})
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].dist != cands[j].dist {
+			return cands[i].dist < cands[j].dist
+		}
+		return cands[i].word < cands[j].word
+	})
+	var out []string
+	for i := 0; i < len(cands) && i < 3; i++ {
+		out = append(out, cands[i].word)
+	}
+	return out
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Reports unknown references and asks what to do. Returns the message to
+// send (with suggestions swapped in on "fix") and false to drop it.
+func reviewPromptRefs(input string, scanner *bufio.Scanner) (string, bool) {
+	bad := lintPromptRefs(input)
+	if len(bad) == 0 {
+		return input, true
+	}
+	for _, b := range bad {
+		kind := "file"
+		if b.Symbol {
+			kind = "symbol"
+		}
+		fmt.Printf("%s⚠ %s %s not found in %s%s %s— did you mean %s?%s\n", colorYellow, kind, b.Ref, filepath.Base(currentDir),
+			colorReset, colorGray, strings.Join(b.Suggestions, ", "), colorReset)
+	}
+	fmt.Print("[f]ix with first suggestion / [s]end anyway / [c]ancel: ")
+	if !scanner.Scan() {
+		return input, true
+	}
+	switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+	case "c", "cancel", "n":
+		return "", false
+	case "f", "fix":
+		for _, b := range bad {
+			if b.Symbol {
+				input = replaceWord(input, b.Ref, b.Suggestions[0])
+			} else {
+				input = strings.ReplaceAll(input, b.Ref, b.Suggestions[0])
+			}
+		}
+		fmt.Printf("%s→ %s%s\n", colorGray, truncate(input, 200), colorReset)
+	}
+	return input, true
+}
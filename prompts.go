@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== PROMPT VARIANTS ====================
+
+// Per-model overrides from ~/.mytool/prompts.json, keyed by model name or
+// glob ("gpt-4*"). Template replaces the whole prompt and may use
+// {{default}}, {{tools}}, {{memory}}, {{mcp}}, {{instructions}},
+// {{repo_map}}, {{dir}}, ...; Append adds model-specific rules to the
+// default prompt.
+type PromptVariant struct {
+	Template string `json:"template,omitempty"`
+	Append   string `json:"append,omitempty"`
+}
+
+func loadPromptVariants() map[string]PromptVariant {
+	variants := map[string]PromptVariant{}
+	home, _ := os.UserHomeDir()
+	if data, err := os.ReadFile(filepath.Join(home, ".mytool", "prompts.json")); err == nil {
+		if err := json.Unmarshal(data, &variants); err != nil {
+			fmt.Printf("%sInvalid prompts.json: %s%s\n", colorYellow, err, colorReset)
+		}
+	}
+	return variants
+}
+
+// Exact match wins, then the longest matching glob
+func promptVariantFor(model string) (PromptVariant, string, bool) {
+	variants := loadPromptVariants()
+	if v, ok := variants[model]; ok {
+		return v, model, true
+	}
+	var patterns []string
+	for p := range variants {
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, model); ok {
+			return variants[p], p, true
+		}
+	}
+	return PromptVariant{}, "", false
+}
+
+func applyPromptVariant(vars map[string]string) string {
+	v, _, ok := promptVariantFor(activeModel())
+	if !ok {
+		return vars["default"]
+	}
+	if v.Template != "" {
+		out := v.Template
+		for k, val := range vars {
+			out = strings.ReplaceAll(out, "{{"+k+"}}", val)
+		}
+		out, _ = expandVars(out)
+		return out
+	}
+	if v.Append != "" {
+		appended, _ := expandVars(v.Append)
+		return vars["default"] + "\n\n" + appended
+	}
+	return vars["default"]
+}
+
+func activeModel() string {
+	if settings.Model != "" {
+		return settings.Model
+	}
+	return modelName
+}
+
+// /model [name]
+func cmdModel(arg string) string {
+	if arg == "" {
+		msg := fmt.Sprintf("Model: %s%s%s", colorCyan, activeModel(), colorReset)
+		if _, pattern, ok := promptVariantFor(activeModel()); ok {
+			msg += fmt.Sprintf(" %s(prompt variant: %s)%s", colorGray, pattern, colorReset)
+		}
+		return msg
+	}
+	settings.Model = arg
+	saveSettings()
+	msg := fmt.Sprintf("%s✓ Model: %s%s", colorGreen, arg, colorReset)
+	if _, pattern, ok := promptVariantFor(arg); ok {
+		msg += fmt.Sprintf(" %s(prompt variant: %s)%s", colorGray, pattern, colorReset)
+	}
+	return msg
+}
+
+// ==================== SESSION VARIABLES ====================
+
+// /set name=value defines {{name}} for the rest of the session. Variables
+// are expanded in user messages before sending and in prompt variants.
+
+var (
+	sessionVars = map[string]string{}
+	varNameRe   = regexp.MustCompile(`^[A-Za-z_][\w.\-]*$`)
+	varRefRe    = regexp.MustCompile(`\{\{\s*([A-Za-z_][\w.\-]*)\s*\}\}`)
+)
+
+// Replaces known {{name}} references; unknown ones are left in place and
+// returned so the caller can warn
+func expandVars(s string) (string, []string) {
+	var unknown []string
+	out := varRefRe.ReplaceAllStringFunc(s, func(m string) string {
+		name := varRefRe.FindStringSubmatch(m)[1]
+		if v, ok := sessionVars[name]; ok {
+			return v
+		}
+		unknown = append(unknown, name)
+		return m
+	})
+	return out, unknown
+}
+
+// /set [name=value | name= ]
+func cmdSet(arg string) string {
+	if arg == "" {
+		if len(sessionVars) == 0 {
+			return "No variables. Usage: /set name=value, then use {{name}}"
+		}
+		var names []string
+		for k := range sessionVars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("%sVariables:%s\n", colorCyan, colorReset))
+		for _, k := range names {
+			b.WriteString(fmt.Sprintf("  %s{{%s}}%s = %s\n", colorYellow, k, colorReset, sessionVars[k]))
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+
+	parts := strings.SplitN(arg, "=", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts) != 2 || !varNameRe.MatchString(name) {
+		return "Usage: /set name=value (letters, digits, _ . -); /set name= removes it"
+	}
+	value := strings.TrimSpace(parts[1])
+	if value == "" {
+		delete(sessionVars, name)
+		return fmt.Sprintf("Unset {{%s}}", name)
+	}
+	sessionVars[name] = value
+	return fmt.Sprintf("%s✓ {{%s}} = %s%s", colorGreen, name, value, colorReset)
+}
+
+// ==================== PROJECT INSTRUCTIONS ====================
+
+// MYTOOL.md and .mytool/instructions.md in the project root hold
+// per-repository rules (conventions, build commands, directories to leave
+// alone). Both are appended to the system prompt when present.
+
+var projectInstructionFiles = []string{"MYTOOL.md", filepath.Join(".mytool", "instructions.md")}
+
+const maxInstructionBytes = 16 * 1024
+
+// Contents of the instruction files found and their paths
+func projectInstructions() (string, []string) {
+	root := findProjectRoot()
+	if root == "" {
+		root = currentDir
+	}
+	var parts, found []string
+	for _, name := range projectInstructionFiles {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		text := string(data)
+		if len(text) > maxInstructionBytes {
+			text = text[:maxInstructionBytes] + "\n[truncated]"
+		}
+		parts = append(parts, fmt.Sprintf("From %s:\n%s", name, strings.TrimSpace(text)))
+		found = append(found, name)
+	}
+	return strings.Join(parts, "\n\n"), found
+}
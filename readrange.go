@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ==================== RANGE READS ====================
+
+// read:path:120-180 and read:path#Name return part of a file with its
+// real line numbers, so a 3000-line file can be read where it matters
+// and the numbers stay valid for the edits that follow. Symbols are found
+// with definitionLines, the same as for chunked mentions.
+
+const (
+	readMaxLines      = 200 // whole-file reads
+	readRangeMaxLines = 500 // explicit ranges and symbols
+)
+
+var readRangeRe = regexp.MustCompile(`^(.+?):(\d+)(?:-(\d*))?$`)
+
+// Splits path:from-to or path#symbol; a path that exists as written is
+// taken literally
+func parseReadSpec(spec string) (path string, from, to int, symbol string) {
+	if _, err := os.Stat(resolvePath(spec)); err == nil {
+		return spec, 0, 0, ""
+	}
+	if m := readRangeRe.FindStringSubmatch(spec); m != nil {
+		from, _ = strconv.Atoi(m[2])
+		to = from + readMaxLines - 1
+		if m[3] != "" {
+			to, _ = strconv.Atoi(m[3])
+		} else if strings.HasSuffix(spec, "-") {
+			to = 0 // to the end
+		}
+		return m[1], max(from, 1), to, ""
+	}
+	if i := strings.LastIndex(spec, "#"); i > 0 && i < len(spec)-1 {
+		return spec[:i], 0, 0, spec[i+1:]
+	}
+	return spec, 0, 0, ""
+}
+
+// Lines of the definition named symbol: from its start (doc comment
+// included) to its end for Go, elsewhere to the line before the next
+// definition without trailing blank lines
+func symbolRange(path, content, symbol string) (int, int, error) {
+	defs := definitionLines(path, content)
+	starts := make([]int, 0, len(defs))
+	for line := range defs {
+		starts = append(starts, line)
+	}
+	sort.Ints(starts)
+	lines := strings.Split(content, "\n")
+	for i, start := range starts {
+		if !symbolMatches(defs[start], symbol) {
+			continue
+		}
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1] - 1
+		}
+		for end > start && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		if goEnd := goDeclEnd(path, content, start); goEnd > 0 {
+			end = goEnd
+		}
+		return start, end, nil
+	}
+	var names []string
+	for _, start := range starts {
+		if len(names) == 30 {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, defs[start])
+	}
+	if len(names) == 0 {
+		return 0, 0, fmt.Errorf("no definitions found in %s; read a line range instead", relPath(path))
+	}
+	return 0, 0, fmt.Errorf("%s not found in %s; definitions: %s", symbol, relPath(path), strings.Join(names, ", "))
+}
+
+// "(*Server).Start" matches Start, Server.Start and (*Server).Start;
+// "type Config" matches Config
+func symbolMatches(def, symbol string) bool {
+	if def == symbol {
+		return true
+	}
+	for _, kw := range []string{"type ", "var ", "const "} {
+		def = strings.TrimPrefix(def, kw)
+	}
+	if def == symbol {
+		return true
+	}
+	if recv, name, ok := strings.Cut(def, ")."); ok {
+		recv = strings.TrimLeft(recv, "(*")
+		return name == symbol || recv+"."+name == symbol
+	}
+	return false
+}
+
+// Last line of the Go declaration starting (doc included) at line start, or 0
+func goDeclEnd(path, content string, start int) int {
+	if strings.ToLower(filepath.Ext(path)) != ".go" {
+		return 0
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, content, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return 0
+	}
+	for _, decl := range f.Decls {
+		pos := decl.Pos()
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				pos = d.Doc.Pos()
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				pos = d.Doc.Pos()
+			}
+		}
+		if fset.Position(pos).Line == start {
+			return fset.Position(decl.End()).Line
+		}
+	}
+	return 0
+}
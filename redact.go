@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== EXPORT & REDACTION ====================
+
+// Exports are rendered from the history and passed through a redaction
+// profile, "public" by default, so a transcript can go into an issue as is.
+
+type RedactionProfile struct {
+	StripPaths         bool `json:"strip_paths"`           // absolute paths → ./ ~/ or …/base
+	StripHosts         bool `json:"strip_hosts"`           // this machine's hostname → <host>
+	MaskUsers          bool `json:"mask_users"`            // the login name → <user>
+	MaxToolOutputLines int  `json:"max_tool_output_lines"` // longer tool outputs are dropped, 0 = keep all
+}
+
+var builtinRedactionProfiles = map[string]RedactionProfile{
+	"public": {StripPaths: true, StripHosts: true, MaskUsers: true, MaxToolOutputLines: 20},
+	"none":   {},
+}
+
+var (
+	unixPathRe    = regexp.MustCompile(`(^|[\s"'` + "`" + `(=:,\[])(/[\w.\-@+~]+(?:/[\w.\-@+~]*)+)`)
+	windowsPathRe = regexp.MustCompile(`\b[A-Za-z]:\\[^\s"'` + "`" + `]+`)
+)
+
+// Custom profiles in settings override built-ins of the same name
+func redactionProfile(name string) (RedactionProfile, bool) {
+	if p, ok := settings.RedactionProfiles[name]; ok {
+		return p, true
+	}
+	p, ok := builtinRedactionProfiles[name]
+	return p, ok
+}
+
+func redactionProfileNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for name := range builtinRedactionProfiles {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range settings.RedactionProfiles {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func redactText(s string, p RedactionProfile) string {
+	if p.StripPaths {
+		home, _ := os.UserHomeDir()
+		for _, prefix := range []struct{ dir, repl string }{{findProjectRoot(), "."}, {currentDir, "."}, {home, "~"}} {
+			if prefix.dir != "" && prefix.dir != string(filepath.Separator) {
+				s = strings.ReplaceAll(s, prefix.dir, prefix.repl)
+			}
+		}
+		s = unixPathRe.ReplaceAllStringFunc(s, func(m string) string {
+			sub := unixPathRe.FindStringSubmatch(m)
+			return sub[1] + "…/" + filepath.Base(sub[2])
+		})
+		s = windowsPathRe.ReplaceAllStringFunc(s, func(m string) string {
+			return `…\` + m[strings.LastIndex(m, `\`)+1:]
+		})
+	}
+	if p.StripHosts {
+		if host, err := os.Hostname(); err == nil && host != "" {
+			s = replaceWord(s, host, "<host>")
+			if short := strings.SplitN(host, ".", 2)[0]; short != host {
+				s = replaceWord(s, short, "<host>")
+			}
+		}
+	}
+	if p.MaskUsers {
+		for _, name := range []string{os.Getenv("USER"), os.Getenv("USERNAME")} {
+			if len(name) > 1 {
+				s = replaceWord(s, name, "<user>")
+			}
+		}
+	}
+	return s
+}
+
+func replaceWord(s, word, repl string) string {
+	return regexp.MustCompile(`\b`+regexp.QuoteMeta(word)+`\b`).ReplaceAllString(s, repl)
+}
+
+// Markdown transcript of the conversation; the system prompt is left out
+func renderTranscript(history []ChatMessage, p RedactionProfile) string {
+	var b strings.Builder
+	for _, m := range history {
+		content := strings.TrimSpace(stripANSI(m.Content))
+		switch {
+		case m.Role == "system":
+			continue
+		case m.Role == "user" && strings.HasPrefix(content, "Results:\n"):
+			content = strings.TrimSuffix(strings.TrimPrefix(content, "Results:\n"), "\n\nJelaskan singkat.")
+			if n := strings.Count(content, "\n") + 1; p.MaxToolOutputLines > 0 && n > p.MaxToolOutputLines {
+				content = fmt.Sprintf("_[%d lines of tool output removed]_", n)
+			} else {
+				content = "```\n" + content + "\n```"
+			}
+			b.WriteString("\n## Tool results\n")
+		case m.Role == "user":
+			b.WriteString("\n## User\n")
+		default:
+			b.WriteString("\n## Assistant\n")
+		}
+		b.WriteString(redactText(content, p))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// /export [file] [--profile name | --raw]
+func exportChat(history []ChatMessage, args []string) {
+	profileName := settings.ExportRedaction
+	filename := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--raw":
+			profileName = "none"
+		case args[i] == "--profile" && i+1 < len(args):
+			profileName = args[i+1]
+			i++
+		default:
+			filename = args[i]
+		}
+	}
+	profile, ok := redactionProfile(profileName)
+	if !ok {
+		fmt.Printf("%sUnknown redaction profile %q (have: %s)%s\n", colorRed, profileName, strings.Join(redactionProfileNames(), ", "), colorReset)
+		return
+	}
+	if filename == "" {
+		filename = fmt.Sprintf("chat_%s_%s.md", sessionID, time.Now().Format("20060102_150405"))
+	}
+
+	transcript := renderTranscript(history, profile)
+	if strings.TrimSpace(transcript) == "" {
+		fmt.Printf("%sNo chat to export%s\n", colorYellow, colorReset)
+		return
+	}
+	os.WriteFile(filename, []byte(transcript), 0644)
+	fmt.Printf("%s✓ Exported: %s (redaction: %s)%s\n", colorGreen, filename, profileName, colorReset)
+}
+
+// mytool export [file] [--session ref] [--profile name | --raw]; defaults
+// to the latest session in this directory
+func exportSessionCmd(args []string) {
+	var rest []string
+	ref := ""
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--session" && i+1 < len(args) {
+			ref = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+
+	var s *Session
+	var err error
+	if ref != "" {
+		var id string
+		if id, err = resolveSessionID(ref); err == nil {
+			s, err = loadSession(id)
+		}
+	} else {
+		s, err = latestProjectSession(currentDir)
+	}
+	if err != nil {
+		fmt.Printf("%sNo session to export: %s%s\n", colorYellow, err, colorReset)
+		return
+	}
+	sessionID = s.ID
+	exportChat(s.History, rest)
+}
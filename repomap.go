@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== REPO MAP ====================
+
+// A compact map of the project goes into the system prompt: top-level
+// directories with file counts, then each source file with the symbols
+// it defines (go/parser for Go, patterns for other languages). It is
+// cached against the mtimes of every directory and file it covers and
+// rebuilt when one changes; settings.RepoMapTokens caps its size. Paths
+// matched by .mytoolignore are left out.
+
+const repoMapMaxFiles = 3000
+
+var repoMapCache struct {
+	root  string
+	stamp walkStamp
+	text  string
+}
+
+// Top-level definitions for languages without a parser here
+var symbolPatterns = map[string]*regexp.Regexp{
+	".py":   regexp.MustCompile(`(?m)^(?:async\s+)?(?:def|class)\s+([A-Za-z_]\w*)`),
+	".js":   regexp.MustCompile(`(?m)^export\s+(?:default\s+)?(?:async\s+)?(?:function\*?|class|const|let|var)\s+([A-Za-z_$][\w$]*)`),
+	".ts":   regexp.MustCompile(`(?m)^export\s+(?:default\s+)?(?:async\s+)?(?:function\*?|class|const|let|interface|type|enum)\s+([A-Za-z_$][\w$]*)`),
+	".rs":   regexp.MustCompile(`(?m)^pub\s+(?:async\s+)?(?:fn|struct|enum|trait|type|const|mod)\s+([A-Za-z_]\w*)`),
+	".rb":   regexp.MustCompile(`(?m)^\s*(?:def|class|module)\s+([A-Za-z_][\w.]*[?!]?)`),
+	".php":  regexp.MustCompile(`(?m)^\s*(?:(?:abstract|final)\s+)?(?:function|class|interface|trait)\s+([A-Za-z_]\w*)`),
+	".java": regexp.MustCompile(`(?m)^\s*public\s+(?:(?:static|final|abstract)\s+)*(?:class|interface|enum|record|[\w<>\[\]]+)\s+([A-Za-z_]\w*)\s*[({<]?`),
+}
+
+func init() {
+	symbolPatterns[".jsx"] = symbolPatterns[".js"]
+	symbolPatterns[".mjs"] = symbolPatterns[".js"]
+	symbolPatterns[".tsx"] = symbolPatterns[".ts"]
+	symbolPatterns[".kt"] = regexp.MustCompile(`(?m)^(?:(?:data|sealed|open|abstract)\s+)?(?:fun|class|object|interface)\s+([A-Za-z_]\w*)`)
+}
+
+func repoMapRoot() string {
+	if root := findProjectRoot(); root != "" {
+		return root
+	}
+	if projectType != "" {
+		return currentDir
+	}
+	return "" // not in a project, e.g. the home directory
+}
+
+// Source files below root, relative and slash-separated, plus the mtimes
+// of them and their directories: any file added, removed or modified
+// changes one
+func repoFiles(root string) ([]string, walkStamp) {
+	var files []string
+	stamp := walkStamp{}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if len(files) >= repoMapMaxFiles {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (projectSkipDirs[d.Name()] || strings.HasPrefix(d.Name(), ".") || isIgnored(path, true)) {
+				return filepath.SkipDir
+			}
+			stamp.add(path, d)
+			return nil
+		}
+		if isIgnored(path, false) {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, filepath.ToSlash(rel))
+		stamp.add(path, d)
+		return nil
+	})
+	return files, stamp
+}
+
+// Symbols defined in a file, in source order
+func fileSymbols(path string) []string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".go" {
+		return goSymbols(path)
+	}
+	re, ok := symbolPatterns[ext]
+	if !ok {
+		return nil
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 512*1024 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var syms []string
+	seen := map[string]bool{}
+	for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			syms = append(syms, m[1])
+		}
+	}
+	return syms
+}
+
+// Exported declarations; everything top-level in package main, where
+// nothing is exported
+func goSymbols(path string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+	if err != nil || strings.HasSuffix(path, "_test.go") {
+		return nil
+	}
+	all := f.Name.Name == "main"
+	keep := func(name string) bool { return name != "_" && name != "init" && (all || ast.IsExported(name)) }
+	var syms []string
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv := d.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if idx, ok := recv.(*ast.IndexExpr); ok {
+					recv = idx.X
+				}
+				if id, ok := recv.(*ast.Ident); ok {
+					if !keep(id.Name) {
+						continue
+					}
+					name = id.Name + "." + name
+				}
+			}
+			if keep(d.Name.Name) {
+				syms = append(syms, name)
+			}
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue // vars and consts would crowd out the functions
+			}
+			for _, spec := range d.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok && keep(ts.Name.Name) {
+					syms = append(syms, ts.Name.Name)
+				}
+			}
+		}
+	}
+	return syms
+}
+
+func buildRepoMap(root string, files []string) string {
+	budget := settings.RepoMapTokens
+	dirs := map[string]int{}
+	var lines []string
+	for _, f := range files {
+		if i := strings.Index(f, "/"); i >= 0 {
+			dirs[f[:i]]++
+		}
+	}
+	if len(dirs) > 0 {
+		var names, parts []string
+		for d := range dirs {
+			names = append(names, d)
+		}
+		sort.Strings(names)
+		for _, d := range names {
+			parts = append(parts, fmt.Sprintf("%s/ (%d)", d, dirs[d]))
+		}
+		lines = append(lines, "dirs: "+strings.Join(parts, ", "))
+	}
+
+	// Shallow files first: they are usually the entry points
+	sorted := append([]string(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := strings.Count(sorted[i], "/"), strings.Count(sorted[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return sorted[i] < sorted[j]
+	})
+	used := estimateTokens(strings.Join(lines, "\n"))
+	for _, f := range sorted {
+		syms := fileSymbols(filepath.Join(root, f))
+		if len(syms) == 0 {
+			continue
+		}
+		if len(syms) > 15 {
+			syms = append(syms[:15], fmt.Sprintf("+%d", len(syms)-15))
+		}
+		line := f + ": " + strings.Join(syms, ", ")
+		if used+estimateTokens(line) > budget {
+			lines = append(lines, fmt.Sprintf("... (truncated; %d files in total)", len(files)))
+			break
+		}
+		lines = append(lines, line)
+		used += estimateTokens(line) + 1
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The map for the current project, rebuilt when the tree changed
+func repoMap() string {
+	root := repoMapRoot()
+	if settings.RepoMapTokens <= 0 || root == "" {
+		return ""
+	}
+	if repoMapCache.root != root || !repoMapCache.stamp.current() {
+		files, stamp := repoFiles(root)
+		repoMapCache.root, repoMapCache.stamp, repoMapCache.text = root, stamp, buildRepoMap(root, files)
+	}
+	return repoMapCache.text
+}
+
+// True when the tree changed since the map in the prompt was built
+func repoMapStale() bool {
+	root := repoMapRoot()
+	if settings.RepoMapTokens <= 0 || root == "" {
+		return false
+	}
+	return repoMapCache.root != root || !repoMapCache.stamp.current()
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ==================== CONFLICT RESOLUTION ====================
+
+// /resolve walks the conflicts in the files git reports as unmerged (or
+// the files given), shows each with both sides and the lines around it,
+// and asks the model for a resolution the user can accept, edit or swap
+// for one side. Each file is written once, after its last conflict, and
+// saved for undo first, so /undo brings the conflict markers back. Files
+// are not staged: git add marks them resolved once they look right.
+
+const (
+	resolveContext      = 5  // lines shown around a conflict
+	resolveModelContext = 40 // lines around it sent to the model
+)
+
+var resolveFenceRe = regexp.MustCompile("(?s)```[^\\n]*\\n(.*?)\\n?```")
+
+type conflict struct {
+	Start, End             int // lines of the <<<<<<< and >>>>>>> markers
+	Ours, Base, Theirs     []string
+	OursLabel, TheirsLabel string
+	HasBase                bool
+}
+
+func conflictMarker(line, marker string) (string, bool) {
+	line = strings.TrimRight(line, "\r")
+	if line == marker {
+		return "", true
+	}
+	label, ok := strings.CutPrefix(line, marker+" ")
+	return label, ok && marker != "======="
+}
+
+// The conflicts in lines; an unterminated one is left out
+func parseConflicts(lines []string) []conflict {
+	var conflicts []conflict
+	for i := 0; i < len(lines); i++ {
+		label, ok := conflictMarker(lines[i], "<<<<<<<")
+		if !ok {
+			continue
+		}
+		c := conflict{Start: i, OursLabel: label}
+		side := &c.Ours
+		for j := i + 1; j < len(lines); j++ {
+			if _, ok := conflictMarker(lines[j], "|||||||"); ok && side == &c.Ours {
+				side, c.HasBase = &c.Base, true
+			} else if _, ok := conflictMarker(lines[j], "======="); ok && side != &c.Theirs {
+				side = &c.Theirs
+			} else if label, ok := conflictMarker(lines[j], ">>>>>>>"); ok && side == &c.Theirs {
+				c.End, c.TheirsLabel = j, label
+				conflicts = append(conflicts, c)
+				i = j
+				break
+			} else if _, ok := conflictMarker(lines[j], "<<<<<<<"); ok {
+				i = j - 1 // a new conflict before this one ended
+				break
+			} else {
+				*side = append(*side, lines[j])
+			}
+		}
+	}
+	return conflicts
+}
+
+// Files git reports as unmerged
+func unmergedFiles(root string) []string {
+	out, err := gitIn(root, "diff", "--name-only", "--diff-filter=U")
+	if err != nil || out == "" {
+		return nil
+	}
+	var files []string
+	for _, f := range strings.Split(out, "\n") {
+		files = append(files, filepath.Join(root, f))
+	}
+	return files
+}
+
+// /resolve [file...]
+func cmdResolve(arg string) string {
+	var files []string
+	for _, f := range strings.Fields(arg) {
+		files = append(files, resolvePath(f))
+	}
+	if len(files) == 0 {
+		if root := findProjectRoot(); root != "" && haveBinary("git") {
+			files = unmergedFiles(root)
+		}
+		if len(files) == 0 {
+			return "No conflicted files (give the files to check: /resolve <file>...)"
+		}
+	}
+
+	resolved, left := 0, 0
+	var written []string
+	for _, path := range files {
+		r, l, changed, quit := resolveFile(path)
+		resolved, left = resolved+r, left+l
+		if changed {
+			written = append(written, relPath(path))
+		}
+		if quit {
+			break
+		}
+	}
+	if len(written) == 0 {
+		return fmt.Sprintf("Nothing changed (%d conflict(s) left)", left)
+	}
+	msg := fmt.Sprintf("%s✓ Resolved %d conflict(s) in %s%s", colorGreen, resolved, strings.Join(written, ", "), colorReset)
+	if left > 0 {
+		msg += fmt.Sprintf("\n%s%d conflict(s) left as they were%s", colorYellow, left, colorReset)
+	}
+	return msg + fmt.Sprintf("\n%s/undo puts the conflict markers back; git add the files once they look right%s", colorGray, colorReset)
+}
+
+// Resolves the conflicts of one file: how many were resolved and left,
+// whether the file was written, and whether the user quit
+func resolveFile(path string) (resolved, left int, changed, quit bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("%s✗ %s%s\n", colorYellow, err, colorReset)
+		return 0, 0, false, false
+	}
+	lines := strings.Split(string(data), "\n")
+	conflicts := parseConflicts(lines)
+	if len(conflicts) == 0 {
+		fmt.Printf("%s%s: no conflict markers%s\n", colorGray, relPath(path), colorReset)
+		return 0, 0, false, false
+	}
+
+	resolutions := make([][]string, len(conflicts))
+	done := make([]bool, len(conflicts))
+	for i, c := range conflicts {
+		if quit {
+			break
+		}
+		fmt.Printf("\n%s─── %s: conflict %d/%d (line %d) ───%s\n", colorCyan, relPath(path), i+1, len(conflicts), c.Start+1, colorReset)
+		showConflict(lines, c)
+		resolutions[i], done[i], quit = chooseResolution(path, lines, c)
+	}
+
+	var out []string
+	next := 0
+	for i, c := range conflicts {
+		if !done[i] {
+			left++
+			continue
+		}
+		resolved++
+		out = append(out, lines[next:c.Start]...)
+		for _, l := range resolutions[i] {
+			if strings.HasSuffix(lines[c.Start], "\r") && !strings.HasSuffix(l, "\r") {
+				l += "\r" // keep CRLF files CRLF
+			}
+			out = append(out, l)
+		}
+		next = c.End + 1
+	}
+	if resolved == 0 {
+		return 0, left, false, quit
+	}
+	out = append(out, lines[next:]...)
+	saveForUndo(path, "resolve conflicts")
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := writeFileAtomic(path, []byte(strings.Join(out, "\n")), mode); err != nil {
+		fmt.Printf("%s✗ %s%s\n", colorYellow, err, colorReset)
+		return 0, len(conflicts), false, quit
+	}
+	return resolved, left, true, quit
+}
+
+func showConflict(lines []string, c conflict) {
+	number := func(from, to int) {
+		for n := max(from, 0); n < min(to, len(lines)); n++ {
+			fmt.Printf("%s%5d  %s%s\n", colorGray, n+1, strings.TrimRight(lines[n], "\r"), colorReset)
+		}
+	}
+	side := func(label, color string, body []string) {
+		fmt.Printf("%s  ── %s%s\n", color, label, colorReset)
+		for _, l := range body {
+			fmt.Printf("%s       %s%s\n", color, strings.TrimRight(l, "\r"), colorReset)
+		}
+	}
+	number(c.Start-resolveContext, c.Start)
+	side("ours: "+c.OursLabel, colorGreen, c.Ours)
+	if c.HasBase {
+		side("base", colorGray, c.Base)
+	}
+	side("theirs: "+c.TheirsLabel, colorBlue, c.Theirs)
+	number(c.End+1, c.End+1+resolveContext)
+}
+
+// Asks the model, then the user; the accepted lines, whether one was
+// accepted, and whether the user quit
+func chooseResolution(path string, lines []string, c conflict) ([]string, bool, bool) {
+	fmt.Printf("%sAsking for a resolution...%s\n", colorGray, colorReset)
+	proposal, why, err := proposeResolution(path, lines, c)
+	if err != nil {
+		fmt.Printf("%s✗ %s%s\n", colorYellow, err, colorReset)
+	}
+	for {
+		if proposal != nil {
+			fmt.Printf("%s  ── proposed%s\n", colorCyan, colorReset)
+			for _, l := range proposal {
+				fmt.Printf("%s       %s%s\n", colorCyan, strings.TrimRight(l, "\r"), colorReset)
+			}
+			if why != "" {
+				fmt.Printf("%s  %s%s\n", colorGray, why, colorReset)
+			}
+		}
+		answer, ok := readInputLine(fmt.Sprintf("%s[Enter] accept · o ours · t theirs · b both · e edit · s skip · q quit:%s ", colorYellow, colorReset))
+		if !ok {
+			return nil, false, true
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "":
+			if proposal != nil {
+				return proposal, true, false
+			}
+		case "o":
+			return c.Ours, true, false
+		case "t":
+			return c.Theirs, true, false
+		case "b":
+			return append(append([]string{}, c.Ours...), c.Theirs...), true, false
+		case "e":
+			start := proposal
+			if start == nil {
+				start = append(append([]string{}, c.Ours...), c.Theirs...)
+			}
+			if edited, ok := editResolution(path, start); ok {
+				proposal, why = edited, "(edited)"
+			}
+		case "s":
+			return nil, false, false
+		case "q":
+			return nil, false, true
+		}
+	}
+}
+
+func proposeResolution(path string, lines []string, c conflict) ([]string, string, error) {
+	from, to := max(c.Start-resolveModelContext, 0), min(c.End+1+resolveModelContext, len(lines))
+	var base string
+	if c.HasBase {
+		base = fmt.Sprintf("Common ancestor:\n%s\n\n", strings.Join(c.Base, "\n"))
+	}
+	messages := []ChatMessage{
+		{Role: "system", Content: "Resolve a git merge conflict. Keep what each side meant to change; when they truly " +
+			"contradict, prefer the one that fits the surrounding code. Reply with the lines that replace the whole " +
+			"conflict, from <<<<<<< to >>>>>>>, without any markers, in a single code block, then one short sentence saying what you kept."},
+		{Role: "user", Content: fmt.Sprintf("File: %s\n\nAround the conflict (lines %d-%d):\n%s\n\nOurs (%s):\n%s\n\n%sTheirs (%s):\n%s",
+			relPath(path), from+1, to, strings.Join(lines[from:to], "\n"),
+			c.OursLabel, strings.Join(c.Ours, "\n"), base, c.TheirsLabel, strings.Join(c.Theirs, "\n"))},
+	}
+	out, err := sendComplete(getAPIKey(), messages, 2000, "resolve_conflict")
+	if err != nil {
+		return nil, "", err
+	}
+	m := resolveFenceRe.FindStringSubmatchIndex(out)
+	if m == nil {
+		return nil, "", fmt.Errorf("the model didn't return a resolution")
+	}
+	code := out[m[2]:m[3]]
+	why := strings.TrimSpace(out[m[1]:])
+	if why == "" {
+		why = strings.TrimSpace(out[:m[0]])
+	}
+	resolution := []string{} // not nil: dropping both sides is a resolution too
+	if code != "" {
+		resolution = strings.Split(code, "\n")
+	}
+	if len(parseConflicts(resolution)) > 0 {
+		return nil, "", fmt.Errorf("the proposed resolution still has conflict markers")
+	}
+	return resolution, truncate(strings.SplitN(why, "\n", 2)[0], 200), nil
+}
+
+func editResolution(path string, start []string) ([]string, bool) {
+	if userEditor() != "" {
+		text, ok := editInEditor(strings.Join(start, "\n")+"\n", "mytool-resolve-*"+filepath.Ext(path))
+		if !ok {
+			return nil, false
+		}
+		return strings.Split(strings.TrimSuffix(text, "\n"), "\n"), true
+	}
+	fmt.Printf("%sType the resolution (/save or /cancel; set $EDITOR to use an editor):%s\n", colorYellow, colorReset)
+	return typeLines()
+}
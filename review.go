@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ==================== REVIEW ====================
+
+// /review has the model review the pending changes: the working tree
+// against HEAD, or against where it branched from ref. The diff is split
+// by file, and large files by hunk, into parts reviewed one at a time;
+// each line carries its number in the new file so findings come back as
+// path:line references. The review is kept in the conversation, so "fix
+// the bugs" works as the next message.
+
+const (
+	reviewChunkChars = 24000
+	reviewMaxChunks  = 8
+)
+
+// Lock files are changed by tools, not people
+var reviewSkipFiles = map[string]bool{
+	"go.sum": true, "package-lock.json": true, "yarn.lock": true, "pnpm-lock.yaml": true,
+	"Cargo.lock": true, "poetry.lock": true, "composer.lock": true, "Gemfile.lock": true,
+}
+
+var (
+	reviewHunkRe    = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+	reviewFindingRe = regexp.MustCompile(`^\s*(?:[-*]\s*)?\[(bug|security|style)\]\s+(\S+?):(\d+)(?:-\d+)?:?\s+(.+)$`)
+)
+
+type reviewFinding struct {
+	Kind, Path string
+	Line       int
+	Text       string
+}
+
+// /review [ref]: the review, and the request to record it under in the
+// conversation ("" when there is nothing to record)
+func cmdReview(ref string) (string, string) {
+	if !haveBinary("git") {
+		return toolUnavailable("git", false), ""
+	}
+	root := findProjectRoot()
+	if root == "" {
+		return "Error: not in a git repository", ""
+	}
+	base, what := "HEAD", "uncommitted changes"
+	if ref != "" {
+		mergeBase, err := gitIn(root, "merge-base", ref, "HEAD")
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err), ""
+		}
+		base, what = mergeBase, "changes since "+ref
+	}
+	tree, err := worktreeTree(root)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err), ""
+	}
+	diff, err := gitIn(root, "diff", "--no-color", "--no-ext-diff", base, tree)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err), ""
+	}
+	if diff == "" {
+		return "Nothing to review: no " + what, ""
+	}
+
+	files, skipped := splitDiffFiles(diff)
+	chunks := packReviewChunks(files)
+	note := ""
+	if len(chunks) > reviewMaxChunks {
+		note = fmt.Sprintf("only the first %d of %d parts were reviewed; /review a narrower ref for the rest", reviewMaxChunks, len(chunks))
+		chunks = chunks[:reviewMaxChunks]
+	}
+	stat, _ := gitIn(root, "diff", "--shortstat", base, tree)
+	fmt.Printf("%sReviewing %s:%s%s\n", colorGray, what, stat, colorReset)
+
+	var findings []reviewFinding
+	seen := map[string]bool{}
+	for i, chunk := range chunks {
+		if len(chunks) > 1 {
+			fmt.Printf("%s  part %d/%d...%s\n", colorGray, i+1, len(chunks), colorReset)
+		}
+		found, err := reviewChunk(chunk)
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err), ""
+		}
+		for _, f := range found {
+			key := fmt.Sprintf("%s:%d:%s", f.Path, f.Line, f.Text)
+			if !seen[key] {
+				seen[key] = true
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range []struct{ kind, title, color string }{
+		{"bug", "Bugs", colorRed},
+		{"security", "Security", colorPurple},
+		{"style", "Style", colorYellow},
+	} {
+		var lines []string
+		for _, f := range findings {
+			if f.Kind == section.kind {
+				loc := fmt.Sprintf("%s:%d", relPath(filepath.Join(root, f.Path)), f.Line)
+				lines = append(lines, fmt.Sprintf("  %s%s%s  %s", colorCyan, loc, colorReset, f.Text))
+			}
+		}
+		if len(lines) > 0 {
+			b.WriteString(fmt.Sprintf("%s%s (%d)%s\n%s\n\n", section.color, section.title, len(lines), colorReset, strings.Join(lines, "\n")))
+		}
+	}
+	if len(findings) == 0 {
+		b.WriteString(fmt.Sprintf("%s✓ No problems found in the %s%s\n", colorGreen, what, colorReset))
+	}
+	if len(skipped) > 0 {
+		b.WriteString(fmt.Sprintf("%sNot reviewed: %s%s\n", colorGray, strings.Join(skipped, ", "), colorReset))
+	}
+	if note != "" {
+		b.WriteString(fmt.Sprintf("%s⚠ %s%s\n", colorYellow, note, colorReset))
+	}
+	report := strings.TrimRight(b.String(), "\n")
+	return report, "[review] Review the " + what
+}
+
+// The diff of each file, with each new-side line numbered; lock files
+// and binary files are left out and named in skipped
+func splitDiffFiles(diff string) (files []string, skipped []string) {
+	var cur strings.Builder
+	path, line, skip := "", 0, false
+	flush := func() {
+		if cur.Len() > 0 && !skip {
+			files = append(files, cur.String())
+		}
+		cur.Reset()
+	}
+	for _, l := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			flush()
+			path, line, skip = "", 0, false
+			if i := strings.LastIndex(l, " b/"); i > 0 {
+				path = l[i+3:]
+			}
+			if reviewSkipFiles[filepath.Base(path)] {
+				skip = true
+				skipped = append(skipped, path)
+			}
+		case strings.HasPrefix(l, "Binary files "):
+			if !skip {
+				skip = true
+				skipped = append(skipped, path)
+			}
+		case reviewHunkRe.MatchString(l):
+			line, _ = strconv.Atoi(reviewHunkRe.FindStringSubmatch(l)[1])
+		case line > 0 && (strings.HasPrefix(l, "+") || strings.HasPrefix(l, " ")):
+			l = fmt.Sprintf("%5d %s", line, l)
+			line++
+		case line > 0 && strings.HasPrefix(l, "-"):
+			l = "      " + l
+		}
+		cur.WriteString(l + "\n")
+	}
+	flush()
+	return files, skipped
+}
+
+// Packs file diffs into parts of about reviewChunkChars, splitting a file
+// that is larger than that at its hunks
+func packReviewChunks(files []string) []string {
+	var pieces []string
+	for _, f := range files {
+		if len(f) <= reviewChunkChars {
+			pieces = append(pieces, f)
+			continue
+		}
+		lines := strings.Split(f, "\n")
+		header := 0
+		for header < len(lines) && !strings.HasPrefix(lines[header], "@@") {
+			header++
+		}
+		head := strings.Join(lines[:header], "\n") + "\n"
+		var piece strings.Builder
+		for _, l := range lines[header:] {
+			if strings.HasPrefix(l, "@@") && piece.Len() > 0 && piece.Len()+len(head) > reviewChunkChars/2 {
+				pieces = append(pieces, head+piece.String())
+				piece.Reset()
+			}
+			piece.WriteString(l + "\n")
+		}
+		if piece.Len() > 0 {
+			pieces = append(pieces, head+piece.String())
+		}
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	for _, p := range pieces {
+		if cur.Len() > 0 && cur.Len()+len(p) > reviewChunkChars {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+		}
+		cur.WriteString(p)
+	}
+	if cur.Len() > 0 {
+		chunks = append(chunks, cur.String())
+	}
+	return chunks
+}
+
+func reviewChunk(chunk string) ([]reviewFinding, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "Review this code change. Each line of the diff starts with its line number in the new file. " +
+			"Report only real problems in the added or changed lines, one per line, exactly as:\n" +
+			"[bug] path:line: what is wrong and how to fix it\n[security] path:line: ...\n[style] path:line: ...\n" +
+			"bug: wrong behaviour, crashes, races, leaks, unhandled errors or edge cases. security: injection, secrets, " +
+			"unsafe input, permissions. style: naming, duplication or readability that is worth changing. " +
+			"Use the path from the diff header. No praise, no summary, no other text. If there are no problems, reply: none"},
+		{Role: "user", Content: chunk},
+	}
+	out, err := sendComplete(getAPIKey(), messages, 2000, "review")
+	if err != nil {
+		return nil, err
+	}
+	var findings []reviewFinding
+	for _, l := range strings.Split(out, "\n") {
+		m := reviewFindingRe.FindStringSubmatch(strings.Trim(l, "`"))
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[3])
+		findings = append(findings, reviewFinding{Kind: m[1], Path: strings.Trim(m[2], "`"), Line: n, Text: strings.TrimSpace(m[4])})
+	}
+	return findings, nil
+}
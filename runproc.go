@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== CHILD PROCESSES ====================
+
+// run, python and node stream their output to the terminal as it comes
+// and hand the same text to the model afterwards. Each child has a
+// timeout (settings, or run:--timeout 30m cmd), is killed when it prints
+// more than the output limit (/limits) and Ctrl+C while one runs kills it
+// and its children rather than quitting mytool.
+
+const runCaptureMax = 1 << 20 // output kept for the model
+
+var (
+	childMu     sync.Mutex
+	childKill   func()     // kills the running child; nil when there is none
+	outputShown bool       // the last tool streamed its output to the terminal
+	toolFailure *ToolError // how the last tool failed, when it knows; see classifyToolResult
+)
+
+// Keeps the first max bytes written to it
+type capWriter struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := max(w.max-w.buf.Len(), 0); room < n {
+		w.dropped += n - room
+		p = p[:room]
+	}
+	w.buf.Write(p)
+	return n, nil
+}
+
+// Kills the command once it has written max bytes
+type outputLimit struct {
+	n, max int64
+	over   func()
+}
+
+func (o *outputLimit) Write(p []byte) (int, error) {
+	o.n += int64(len(p))
+	if o.n > o.max && o.over != nil {
+		o.over()
+		o.over = nil
+	}
+	return len(p), nil
+}
+
+// Default limit for a child process; 0 means none
+func runTimeout() time.Duration {
+	return time.Duration(settings.RunTimeout) * time.Second
+}
+
+// Splits a leading "--timeout 5m" (or seconds) off a command
+func parseRunTimeout(command string) (time.Duration, string, error) {
+	rest, ok := strings.CutPrefix(command, "--timeout ")
+	if !ok {
+		return runTimeout(), command, nil
+	}
+	value, rest, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	if n, err := strconv.Atoi(value); err == nil {
+		return time.Duration(n) * time.Second, strings.TrimSpace(rest), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, command, fmt.Errorf("bad timeout %q (use 90, 30s or 5m)", value)
+	}
+	return d, strings.TrimSpace(rest), nil
+}
+
+// Runs cmd with its output streamed and captured. onKill does extra
+// cleanup when the child is killed (the sandbox stops its container).
+func runStreamed(cmd *exec.Cmd, timeout time.Duration, onKill func()) string {
+	var once sync.Once
+	reason := ""
+	kill := func(why string) {
+		once.Do(func() {
+			reason = why
+			killProcessGroup(cmd)
+			if onKill != nil {
+				onKill()
+			}
+		})
+	}
+	out := &capWriter{max: runCaptureMax}
+	w := io.MultiWriter(os.Stdout, out)
+	if mb := settings.Limits.OutputMB; mb > 0 {
+		w = io.MultiWriter(w, &outputLimit{max: int64(mb) << 20, over: func() { kill(fmt.Sprintf("output over %dMB", mb)) }})
+	}
+	cmd.Stdout, cmd.Stderr = w, w
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		toolFailure = &ToolError{Code: "exit_status", Message: err.Error()}
+		return fmt.Sprintf("%sExit: %s%s", colorRed, err, colorReset)
+	}
+	outputShown = true
+
+	childMu.Lock()
+	childKill = func() { kill("interrupted with Ctrl+C") }
+	childMu.Unlock()
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { kill(fmt.Sprintf("timed out after %s", timeout)) })
+	}
+	err := cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+	childMu.Lock()
+	childKill = nil
+	childMu.Unlock()
+
+	result := out.buf.String()
+	if out.dropped > 0 {
+		result += fmt.Sprintf("\n... (%s more output not kept)", formatSize(int64(out.dropped)))
+	}
+	once.Do(func() {}) // no kill after this point
+	switch {
+	case reason != "":
+		toolFailure = killedFailure(reason, result)
+		result += fmt.Sprintf("\n%sKilled: %s%s", colorRed, reason, colorReset)
+	case err != nil:
+		toolFailure = &ToolError{Code: "exit_status", Message: err.Error(), Output: result}
+		result += fmt.Sprintf("\n%sExit: %s%s", colorRed, err, colorReset)
+	}
+	return result
+}
+
+// Why a child was killed, as a tool error
+func killedFailure(reason, out string) *ToolError {
+	switch {
+	case strings.HasPrefix(reason, "interrupted"):
+		return &ToolError{Code: "cancelled", Message: "the user stopped the command (" + reason + ")", Hint: "ask the user how to proceed", Output: out}
+	case strings.HasPrefix(reason, "output over"):
+		return &ToolError{Code: "resource_limit", Message: reason, Hint: "make the command quieter (filter with grep, head or tail) or write its output to a file", Output: out}
+	}
+	return &ToolError{Code: "timeout", Message: reason, Hint: "if it needs longer, rerun with run:--timeout 30m <cmd>", Output: out}
+}
+
+// Kills the running child, if any; for the Ctrl+C handler
+func interruptChild() bool {
+	childMu.Lock()
+	kill := childKill
+	childMu.Unlock()
+	if kill == nil {
+		return false
+	}
+	kill()
+	return true
+}
+
+// What is left to print of a tool result whose output was streamed: the
+// exit status, if any
+func streamedTail(out string) string {
+	if i := strings.LastIndex(out, "\n"+colorRed); i >= 0 {
+		return out[i+1:]
+	}
+	if strings.HasPrefix(out, colorRed) {
+		return out
+	}
+	if strings.TrimSpace(out) == "" {
+		return colorGray + "(no output)" + colorReset
+	}
+	return fmt.Sprintf("%s(%d lines of output above)%s", colorGray, strings.Count(strings.TrimRight(out, "\n"), "\n")+1, colorReset)
+}
+
+// out, or only its exit status when it was already streamed
+func shownResult(out string) string {
+	if outputShown {
+		return streamedTail(out)
+	}
+	return out
+}
+
+func secondsOrOff(s int) string {
+	switch {
+	case s <= 0:
+		return "off"
+	case s%60 == 0:
+		return fmt.Sprintf("%dm", s/60)
+	}
+	return fmt.Sprintf("%ds", s)
+}
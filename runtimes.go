@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// ==================== RUNTIMES ====================
+
+// Besides python and node the model can run Go (gorun:, a throwaway
+// module), multi-line bash scripts (bash:), Ruby (ruby:) and SQL against a
+// SQLite file (sql:, built in, no sqlite3 needed). A runtime whose program
+// is missing is left out of the system prompt, and only reported at
+// startup when the project type calls for it (go for a Go project).
+
+const sqlMaxRows = 200
+
+// Checks shared by the execution tools; a message when it may not run
+func execAllowed(what, code string) string {
+	if currentMode == ModeManual {
+		return fmt.Sprintf("%s[blocked] Manual mode%s", colorRed, colorReset)
+	}
+	if currentMode == ModeAsk {
+		preview := truncate(strings.TrimSpace(code), 300)
+		if !confirmAction(fmt.Sprintf("%sRun %s:%s\n%s\n", colorYellow, what, colorReset, preview)) {
+			return "Cancelled"
+		}
+	}
+	return ""
+}
+
+// Writes code to a temp file with ext and runs bin on it in the current
+// directory; in the sandbox the code goes to sandboxArgs on stdin
+func runScript(bin, ext, code string, sandboxArgs ...string) string {
+	if msg := execAllowed(bin, code); msg != "" {
+		return msg
+	}
+	if sandboxConfig().Enabled {
+		return sandboxRun(code, runTimeout(), sandboxArgs...)
+	}
+	if !haveBinary(bin) {
+		return toolUnavailable(bin, false)
+	}
+	f, err := os.CreateTemp("", "mytool-*"+ext)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(code)
+	f.Close()
+
+	cmd := exec.Command(bin, f.Name())
+	cmd.Dir = currentDir
+	cmd.Env = childEnv()
+	applyLimits(cmd, true)
+	return runStreamed(cmd, runTimeout(), nil)
+}
+
+// Every line of a bash script is held to the command policy and the
+// confinement to the project root
+func runBash(code string) string {
+	for _, line := range strings.Split(code, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			if msg := policyBlock(line); msg != "" {
+				return msg
+			}
+			if msg := confineBlock(line); msg != "" {
+				return msg
+			}
+		}
+	}
+	return runScript("bash", ".sh", code, "bash", "-s")
+}
+
+func runRuby(code string) string {
+	return runScript("ruby", ".rb", code, "ruby", "-")
+}
+
+// gorun:code runs a Go program from a throwaway module; "package main"
+// may be left out. The working directory stays the current one.
+func runGo(code string) string {
+	if !strings.Contains(code, "package ") {
+		code = "package main\n\n" + code
+	}
+	if msg := execAllowed("go", code); msg != "" {
+		return msg
+	}
+	if sandboxConfig().Enabled {
+		script := `d=$(mktemp -d) && cat > "$d/main.go" && printf 'module scratch\n' > "$d/go.mod" && go run -C "$d" .`
+		return sandboxRun(code, runTimeout(), "sh", "-c", script)
+	}
+	if !haveBinary("go") {
+		return toolUnavailable("go", false)
+	}
+	dir, err := os.MkdirTemp("", "mytool-go-*")
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte(code), 0644)
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module scratch\n"), 0644)
+
+	// Built in the module, run from the current directory so relative
+	// paths mean the same as for run:
+	bin := filepath.Join(dir, "scratch")
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Dir = dir
+	build.Env = childEnv()
+	if out, err := build.CombinedOutput(); err != nil {
+		toolFailure = &ToolError{Code: "exit_status", Message: "build failed: " + err.Error(), Output: string(out)}
+		return fmt.Sprintf("%s\n%sExit: %s%s", out, colorRed, err, colorReset)
+	}
+	cmd := exec.Command(bin)
+	cmd.Dir = currentDir
+	cmd.Env = childEnv()
+	applyLimits(cmd, true)
+	return runStreamed(cmd, runTimeout(), nil)
+}
+
+// sql:db.sqlite|||query runs SQL against a SQLite file; the query may be
+// a .sql file. With the sandbox on the database is opened read-only.
+func cmdSQL(args string) string {
+	parts := strings.SplitN(args, "|||", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		return "Error: format db|||query"
+	}
+	path, query := resolvePath(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+	if strings.HasSuffix(query, ".sql") && !strings.ContainsAny(query, " \n") {
+		data, err := os.ReadFile(resolvePath(query))
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		query = string(data)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if msg := execAllowed("SQL on "+relPath(path), query); msg != "" {
+		return msg
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)"
+	if sandboxConfig().Enabled {
+		dsn += "&mode=ro"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	defer db.Close()
+
+	// Statements before the last are executed; the last one may return rows
+	stmts := splitSQL(query)
+	for _, s := range stmts[:len(stmts)-1] {
+		if _, err := db.Exec(s); err != nil {
+			return fmt.Sprintf("Error: %s\nin: %s", err, truncate(s, 200))
+		}
+	}
+	last := stmts[len(stmts)-1]
+	rows, err := db.Query(last)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	if len(cols) == 0 {
+		return fmt.Sprintf("%s✓ %d statement(s) executed%s", colorGreen, len(stmts), colorReset)
+	}
+
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	n := 0
+	for rows.Next() {
+		if n == sqlMaxRows {
+			n++
+			break
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		cells := make([]string, len(cols))
+		for i, v := range values {
+			switch v := v.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = truncate(string(v), 80)
+			default:
+				cells[i] = truncate(strings.ReplaceAll(fmt.Sprint(v), "\n", " "), 80)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	tw.Flush()
+	if n > sqlMaxRows {
+		return b.String() + fmt.Sprintf("%s(first %d rows; add LIMIT/OFFSET for more)%s", colorGray, sqlMaxRows, colorReset)
+	}
+	return b.String() + fmt.Sprintf("%s(%d rows)%s", colorGray, n, colorReset)
+}
+
+// Splits on semicolons outside quotes; never returns an empty list
+func splitSQL(query string) []string {
+	var stmts []string
+	var quote rune
+	start := 0
+	for i, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';':
+			if s := strings.TrimSpace(query[start:i]); s != "" {
+				stmts = append(stmts, s)
+			}
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(query[start:]); s != "" {
+		stmts = append(stmts, s)
+	}
+	if len(stmts) == 0 {
+		stmts = []string{query}
+	}
+	return stmts
+}
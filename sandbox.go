@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ==================== CONTAINER SANDBOX ====================
+
+// With the sandbox on, run, python and node execute in a throwaway
+// Docker/Podman container instead of on the host: the project root is
+// mounted at its own path (read-write or read-only), the network is off
+// unless allowed and all capabilities are dropped. That makes auto mode
+// reasonable on prompts that can't be trusted. A project picks its image
+// in <root>/.mytool/sandbox.json and may tighten the rest there, never
+// loosen it: the file comes with the repository.
+
+type SandboxSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Runtime  string `json:"runtime,omitempty"` // docker or podman; the first installed when empty
+	Image    string `json:"image,omitempty"`   // default sandboxImage
+	Network  bool   `json:"network"`
+	ReadOnly bool   `json:"read_only"` // mount the project read-only
+}
+
+// Per-project overrides; unset fields keep the global setting, and only
+// the image may be anything
+type projectSandbox struct {
+	Enabled  *bool   `json:"enabled,omitempty"`
+	Image    *string `json:"image,omitempty"`
+	Network  *bool   `json:"network,omitempty"`
+	ReadOnly *bool   `json:"read_only,omitempty"`
+}
+
+// Has sh, python3, node and git
+const sandboxImage = "docker.io/library/node:lts"
+
+func projectSandboxPath() string {
+	return filepath.Join(findProjectRoot(), ".mytool", "sandbox.json")
+}
+
+func loadProjectSandbox() projectSandbox {
+	var p projectSandbox
+	if data, err := os.ReadFile(projectSandboxPath()); err == nil {
+		if err := json.Unmarshal(data, &p); err != nil {
+			fmt.Printf("%sInvalid .mytool/sandbox.json: %s%s\n", colorYellow, err, colorReset)
+		}
+	}
+	return p
+}
+
+// Global settings with the project's overrides applied
+func sandboxConfig() SandboxSettings {
+	cfg := settings.Sandbox
+	p := loadProjectSandbox()
+	if p.Enabled != nil && *p.Enabled {
+		cfg.Enabled = true
+	}
+	if p.Image != nil {
+		cfg.Image = *p.Image
+	}
+	if p.Network != nil && !*p.Network {
+		cfg.Network = false
+	}
+	if p.ReadOnly != nil && *p.ReadOnly {
+		cfg.ReadOnly = true
+	}
+	if cfg.Image == "" {
+		cfg.Image = sandboxImage
+	}
+	return cfg
+}
+
+func sandboxRuntime(cfg SandboxSettings) string {
+	if cfg.Runtime != "" {
+		return cfg.Runtime
+	}
+	for _, rt := range []string{"docker", "podman"} {
+		if haveBinary(rt) {
+			return rt
+		}
+	}
+	return ""
+}
+
+// Output of args run in the container, formatted like the host tools;
+// stdin is fed to the process when not empty
+func sandboxRun(stdin string, timeout time.Duration, args ...string) string {
+	cmd, stop, err := sandboxCommand(stdin, args...)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return runStreamed(cmd, timeout, stop)
+}
+
+// The container command for args, and how to stop the container
+func sandboxCommand(stdin string, args ...string) (*exec.Cmd, func(), error) {
+	cfg := sandboxConfig()
+	rt := sandboxRuntime(cfg)
+	if rt == "" || !haveBinary(rt) {
+		return nil, nil, fmt.Errorf("tool unavailable: the sandbox needs docker or podman (turn it off with /sandbox off)")
+	}
+	// The project is mounted at its own path; outside one, the directory
+	root := findProjectRoot()
+	if root == "" {
+		root = currentDir
+	}
+	if rel, err := filepath.Rel(root, currentDir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, nil, fmt.Errorf("the working directory %s is outside the project %s the sandbox mounts; cd back into it", currentDir, root)
+	}
+	mount := root + ":" + root
+	if cfg.ReadOnly {
+		mount += ":ro"
+	}
+	// Named, so a killed run stops its container and not just the client
+	name := fmt.Sprintf("mytool-%d-%d", os.Getpid(), time.Now().UnixNano())
+	run := []string{"run", "--rm", "--name", name, "-v", mount, "-w", currentDir,
+		"--cap-drop", "ALL", "--security-opt", "no-new-privileges", "-e", "HOME=/tmp"}
+	if !cfg.Network {
+		run = append(run, "--network", "none")
+	}
+	if stdin != "" {
+		run = append(run, "-i")
+	}
+	run = append(run, sandboxLimitArgs(settings.Limits)...)
+	// Files written into the project belong to the user, not root
+	if rt == "podman" {
+		run = append(run, "--userns", "keep-id")
+	} else if uid := os.Getuid(); uid >= 0 {
+		run = append(run, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+	}
+	run = append(append(run, cfg.Image), args...)
+
+	fmt.Printf("%s[sandbox %s, %s]%s\n", colorGray, cfg.Image, sandboxLabel(cfg), colorReset)
+	cmd := exec.Command(rt, run...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	return cmd, func() { exec.Command(rt, "kill", name).Run() }, nil
+}
+
+func sandboxLabel(cfg SandboxSettings) string {
+	mode, network := "read-write", "no network"
+	if cfg.ReadOnly {
+		mode = "read-only"
+	}
+	if cfg.Network {
+		network = "network"
+	}
+	return mode + ", " + network
+}
+
+// /sandbox [on|off|network on|off|ro|rw|image <name>] [--project]
+func cmdSandbox(arg string) string {
+	arg, project := strings.CutSuffix(strings.TrimSpace(arg), "--project")
+	arg = strings.TrimSpace(arg)
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		cfg := sandboxConfig()
+		state := "off (run, python and node use the host)"
+		if cfg.Enabled {
+			rt := sandboxRuntime(cfg)
+			if rt == "" {
+				rt = "no docker or podman found"
+			}
+			state = fmt.Sprintf("on: %s, %s, %s", rt, cfg.Image, sandboxLabel(cfg))
+		}
+		return fmt.Sprintf("Sandbox %s\n%sUsage: /sandbox on|off, network on|off, ro|rw, image <name>; add --project to set it for this project only (%s)%s",
+			state, colorGray, relPath(projectSandboxPath()), colorReset)
+	}
+
+	if project && (arg == "off" || arg == "rw" || arg == "network on") {
+		return "Error: a project can only tighten the sandbox; use /sandbox " + arg + " without --project"
+	}
+	p := loadProjectSandbox()
+	set := func(global *bool, local **bool, v bool) {
+		if project {
+			*local = &v
+		} else {
+			*global = v
+		}
+	}
+	switch {
+	case arg == "on" || arg == "off":
+		set(&settings.Sandbox.Enabled, &p.Enabled, arg == "on")
+	case fields[0] == "network" && len(fields) == 2 && (fields[1] == "on" || fields[1] == "off"):
+		set(&settings.Sandbox.Network, &p.Network, fields[1] == "on")
+	case arg == "ro" || arg == "rw":
+		set(&settings.Sandbox.ReadOnly, &p.ReadOnly, arg == "ro")
+	case fields[0] == "image" && len(fields) == 2:
+		if project {
+			p.Image = &fields[1]
+		} else {
+			settings.Sandbox.Image = fields[1]
+		}
+	default:
+		return "Usage: /sandbox [on|off|network on|off|ro|rw|image <name>] [--project]"
+	}
+
+	if project {
+		data, _ := json.MarshalIndent(p, "", "  ")
+		os.MkdirAll(filepath.Dir(projectSandboxPath()), 0755)
+		if err := os.WriteFile(projectSandboxPath(), data, 0644); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+	} else {
+		saveSettings()
+	}
+	status, _, _ := strings.Cut(cmdSandbox(""), "\n")
+	return fmt.Sprintf("%s✓ %s%s", colorGreen, status, colorReset)
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ==================== SESSION STORE ====================
+
+// Sessions live in ~/.mytool/sessions.db. The full session is kept as a
+// JSON blob; the columns and the FTS table exist for listing and search.
+// Each session belongs to a project (the enclosing git root, else its own
+// directory); listing and resume go through the project index.
+
+var sessionDB *sql.DB
+
+// Session states. A session is "active" while an instance is autosaving it
+// and back to "" on a clean exit; one left active by a dead process is
+// marked "recovered" on the next start.
+const (
+	sessionActive    = "active"
+	sessionRecovered = "recovered"
+)
+
+type sessionRow struct {
+	ID       string
+	Name     string
+	Title    string
+	Summary  string
+	Tags     []string
+	Parent   string
+	State    string
+	Dir      string
+	Project  string
+	Messages int
+	Updated  time.Time
+	Snippet  string
+}
+
+type sessionFilter struct {
+	Tag     string
+	Dir     string // matches the directory and everything below it
+	Project string
+	Limit   int
+}
+
+const sessionSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id       TEXT PRIMARY KEY,
+	dir      TEXT NOT NULL,
+	messages INTEGER NOT NULL DEFAULT 0,
+	created  INTEGER NOT NULL,
+	updated  INTEGER NOT NULL,
+	data     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_dir ON sessions(dir, updated);
+CREATE VIRTUAL TABLE IF NOT EXISTS sessions_fts USING fts5(id UNINDEXED, content);
+`
+
+func openSessionDB() (*sql.DB, error) {
+	if sessionDB != nil {
+		return sessionDB, nil
+	}
+	home, _ := os.UserHomeDir()
+	dir := filepath.Join(home, ".mytool")
+	os.MkdirAll(dir, 0755)
+
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(dir, "sessions.db")+
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sessionSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	ensureColumn(db, "sessions", "name", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "sessions", "tags", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "sessions", "parent", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "sessions", "state", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "sessions", "pid", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "sessions", "title", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "sessions", "summary", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "sessions", "project", "TEXT NOT NULL DEFAULT ''")
+	db.Exec(`CREATE INDEX IF NOT EXISTS sessions_project ON sessions(project, updated)`)
+	backfillSessionProjects(db)
+	sessionDB = db
+	migrateJSONSessions()
+	return db, nil
+}
+
+// Sessions saved before projects existed get theirs from their directory
+func backfillSessionProjects(db *sql.DB) {
+	rows, err := db.Query(`SELECT DISTINCT dir FROM sessions WHERE project = ''`)
+	if err != nil {
+		return
+	}
+	var dirs []string
+	for rows.Next() {
+		var dir string
+		rows.Scan(&dir)
+		dirs = append(dirs, dir)
+	}
+	rows.Close()
+	for _, dir := range dirs {
+		db.Exec(`UPDATE sessions SET project = ? WHERE dir = ? AND project = ''`, sessionProject(dir), dir)
+	}
+}
+
+// The git root enclosing dir, or dir itself outside a repository
+func sessionProject(dir string) string {
+	if root := projectRootFor(dir); root != "" {
+		return worktreeProject(root)
+	}
+	return dir
+}
+
+// Adds a column to an existing table (schema upgrades)
+func ensureColumn(db *sql.DB, table, column, def string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return
+	}
+	found := false
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk)
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if !found {
+		db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	}
+}
+
+// Tags are stored as ",a,b," so a single LIKE finds one
+func encodeTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "," + strings.Join(tags, ",") + ","
+}
+
+func decodeTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// One-time import of the old ~/.mytool/sessions/*.json files
+func migrateJSONSessions() {
+	home, _ := os.UserHomeDir()
+	oldDir := filepath.Join(home, ".mytool", "sessions")
+	entries, err := os.ReadDir(oldDir)
+	if err != nil {
+		return
+	}
+	n := 0
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(oldDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var s Session
+		if json.Unmarshal(data, &s) != nil || s.ID == "" {
+			continue
+		}
+		if storeSession(&s) == nil {
+			n++
+		}
+	}
+	os.Rename(oldDir, oldDir+".migrated")
+	if n > 0 {
+		fmt.Printf("%s✓ Migrated %d sessions to sessions.db%s\n", colorGreen, n, colorReset)
+	}
+}
+
+func sessionSearchText(history []ChatMessage) string {
+	var b strings.Builder
+	for _, m := range history {
+		if m.Role == "system" {
+			continue
+		}
+		b.WriteString(m.Content)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Upserts the session in one transaction
+func storeSession(s *Session) error {
+	db, err := openSessionDB()
+	if err != nil {
+		return err
+	}
+	if s.Created.IsZero() {
+		s.Created = s.Updated
+	}
+	data, _ := json.Marshal(s)
+	pid := 0
+	if s.State == sessionActive {
+		pid = os.Getpid()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO sessions (id, name, title, summary, tags, parent, state, pid, dir, project, messages, created, updated, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET name = excluded.name, title = excluded.title, summary = excluded.summary, tags = excluded.tags,
+		parent = excluded.parent, state = excluded.state, pid = excluded.pid, dir = excluded.dir, project = excluded.project,
+		messages = excluded.messages, updated = excluded.updated, data = excluded.data`,
+		s.ID, s.Name, sealString(s.Title), sealString(s.Summary), encodeTags(s.Tags), s.Parent, s.State, pid, s.Dir, sessionProject(s.Dir), len(s.History),
+		s.Created.Unix(), s.Updated.Unix(), sealString(string(data)))
+	if err != nil {
+		return err
+	}
+	tx.Exec(`DELETE FROM sessions_fts WHERE id = ?`, s.ID)
+	content := sessionSearchText(s.History)
+	if vaultKey != nil {
+		content = "" // an index would leak what the vault protects
+	}
+	if _, err := tx.Exec(`INSERT INTO sessions_fts (id, content) VALUES (?, ?)`, s.ID, content); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func loadSession(id string) (*Session, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return nil, err
+	}
+	var data, state string
+	if err := db.QueryRow(`SELECT data, state FROM sessions WHERE id = ?`, id).Scan(&data, &state); err != nil {
+		return nil, fmt.Errorf("session %s not found", id)
+	}
+	plain, err := openAtRest([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(plain, &session); err != nil {
+		return nil, err
+	}
+	session.State = state
+	return &session, nil
+}
+
+// Most recent session of dir's project, preferring one started in dir itself
+func latestProjectSession(dir string) (*Session, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	if err := db.QueryRow(`SELECT id FROM sessions WHERE project = ? ORDER BY dir = ? DESC, updated DESC LIMIT 1`,
+		sessionProject(dir), dir).Scan(&id); err != nil {
+		return nil, err
+	}
+	return loadSession(id)
+}
+
+func (f sessionFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Tag != "" {
+		conds = append(conds, "s.tags LIKE ?")
+		args = append(args, "%,"+f.Tag+",%")
+	}
+	if f.Project != "" {
+		conds = append(conds, "s.project = ?")
+		args = append(args, f.Project)
+	}
+	if f.Dir != "" {
+		conds = append(conds, "(s.dir = ? OR s.dir LIKE ?)")
+		args = append(args, f.Dir, strings.TrimSuffix(f.Dir, string(filepath.Separator))+string(filepath.Separator)+"%")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(conds, " AND "), args
+}
+
+func scanSessionRows(rows *sql.Rows, withSnippet bool) []sessionRow {
+	defer rows.Close()
+	var out []sessionRow
+	for rows.Next() {
+		var r sessionRow
+		var tags string
+		var updated int64
+		if withSnippet {
+			rows.Scan(&r.ID, &r.Name, &r.Title, &r.Summary, &tags, &r.Parent, &r.State, &r.Dir, &r.Project, &r.Messages, &updated, &r.Snippet)
+		} else {
+			rows.Scan(&r.ID, &r.Name, &r.Title, &r.Summary, &tags, &r.Parent, &r.State, &r.Dir, &r.Project, &r.Messages, &updated)
+		}
+		r.Title, r.Summary = openString(r.Title), openString(r.Summary)
+		r.Tags = decodeTags(tags)
+		r.Updated = time.Unix(updated, 0)
+		out = append(out, r)
+	}
+	return out
+}
+
+func listSessionRows(f sessionFilter) ([]sessionRow, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return nil, err
+	}
+	where, args := f.where()
+	rows, err := db.Query(`SELECT s.id, s.name, s.title, s.summary, s.tags, s.parent, s.state, s.dir, s.project, s.messages, s.updated FROM sessions s
+		WHERE 1=1`+where+` ORDER BY s.updated DESC LIMIT ?`, append(args, f.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessionRows(rows, false), nil
+}
+
+func searchSessions(query string, f sessionFilter) ([]sessionRow, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return nil, err
+	}
+	where, args := f.where()
+	args = append([]interface{}{ftsQuery(query)}, args...)
+	rows, err := db.Query(`SELECT s.id, s.name, s.title, s.summary, s.tags, s.parent, s.state, s.dir, s.project, s.messages, s.updated,
+			snippet(sessions_fts, 1, '[', ']', '…', 12)
+		FROM sessions_fts f JOIN sessions s ON s.id = f.id
+		WHERE sessions_fts MATCH ?`+where+` ORDER BY rank LIMIT ?`, append(args, f.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessionRows(rows, true), nil
+}
+
+// Updates name/tags of a stored session; false if it isn't saved yet
+func updateSessionMeta(id, name string, tags []string) bool {
+	s, err := loadSession(id)
+	if err != nil {
+		return false
+	}
+	s.Name, s.Tags = name, tags
+	return storeSession(s) == nil
+}
+
+// Marks sessions left active by a process that is gone as recovered and
+// returns them
+func recoverSessions() ([]sessionRow, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, pid FROM sessions WHERE state = ?`, sessionActive)
+	if err != nil {
+		return nil, err
+	}
+	var dead []string
+	for rows.Next() {
+		var id string
+		var pid int
+		rows.Scan(&id, &pid)
+		if !processAlive(pid) {
+			dead = append(dead, id)
+		}
+	}
+	rows.Close()
+
+	var recovered []sessionRow
+	for _, id := range dead {
+		db.Exec(`UPDATE sessions SET state = ?, pid = 0 WHERE id = ?`, sessionRecovered, id)
+		if s, err := loadSession(id); err == nil {
+			recovered = append(recovered, sessionRow{ID: s.ID, Name: s.Name, Dir: s.Dir, Messages: len(s.History), Updated: s.Updated})
+		}
+	}
+	return recovered, nil
+}
+
+// PID of another live instance that has the session open, or 0
+func sessionOwner(id string) int {
+	db, err := openSessionDB()
+	if err != nil {
+		return 0
+	}
+	var state string
+	var pid int
+	if db.QueryRow(`SELECT state, pid FROM sessions WHERE id = ?`, id).Scan(&state, &pid) != nil {
+		return 0
+	}
+	if state != sessionActive || pid == os.Getpid() || !processAlive(pid) {
+		return 0
+	}
+	return pid
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true // FindProcess already failed if it was gone
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// Quotes each word so user input can't trip FTS5 query syntax
+func ftsQuery(q string) string {
+	var terms []string
+	for _, w := range strings.Fields(q) {
+		terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Resolves an exact ID, a unique ID prefix, or a session name
+func resolveSessionID(ref string) (string, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return "", err
+	}
+	rows, err := db.Query(`SELECT id FROM sessions WHERE id = ? OR id LIKE ? OR name = ? ORDER BY id = ? DESC, updated DESC`,
+		ref, ref+"%", ref, ref)
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	switch {
+	case len(ids) == 0:
+		return "", fmt.Errorf("session %s not found", ref)
+	case len(ids) > 1 && ids[0] != ref:
+		return "", fmt.Errorf("%s is ambiguous (%d sessions)", ref, len(ids))
+	}
+	return ids[0], nil
+}
+
+// Deletes by ID, prefix or name; returns the deleted ID
+func deleteSession(ref string) (string, error) {
+	id, err := resolveSessionID(ref)
+	if err != nil {
+		return "", err
+	}
+	sessionDB.Exec(`DELETE FROM sessions_fts WHERE id = ?`, id)
+	_, err = sessionDB.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return id, err
+}
+
+// Removes sessions not updated within olderThan (0 = no age limit), then
+// the oldest ones until the store is under maxBytes (0 = no size limit).
+func pruneSessions(olderThan time.Duration, maxBytes int64) (int, error) {
+	db, err := openSessionDB()
+	if err != nil {
+		return 0, err
+	}
+	var victims []string
+	if olderThan > 0 {
+		rows, err := db.Query(`SELECT id FROM sessions WHERE updated < ?`, time.Now().Add(-olderThan).Unix())
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			victims = append(victims, id)
+		}
+		rows.Close()
+	}
+	if maxBytes > 0 {
+		rows, err := db.Query(`SELECT id, length(data) FROM sessions ORDER BY updated DESC`)
+		if err != nil {
+			return 0, err
+		}
+		var total int64
+		for rows.Next() {
+			var id string
+			var size int64
+			rows.Scan(&id, &size)
+			total += size
+			if total > maxBytes {
+				victims = append(victims, id)
+			}
+		}
+		rows.Close()
+	}
+
+	seen := map[string]bool{}
+	for _, id := range victims {
+		if seen[id] || id == sessionID {
+			continue
+		}
+		seen[id] = true
+		db.Exec(`DELETE FROM sessions_fts WHERE id = ?`, id)
+		db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	}
+	if len(seen) > 0 {
+		db.Exec(`VACUUM`)
+	}
+	return len(seen), nil
+}
+
+// ==================== SESSION BUNDLES ====================
+
+// Portable single-file form of a session for `mytool sessions export` and
+// `import`. Bump sessionBundleVersion when the layout changes.
+
+const sessionBundleVersion = 1
+
+type sessionBundle struct {
+	Schema   int            `json:"schema"`
+	Exported time.Time      `json:"exported"`
+	Version  string         `json:"mytool_version"`
+	Settings bundleSettings `json:"settings"`
+	Session  Session        `json:"session"`
+}
+
+// The settings the conversation was held with
+type bundleSettings struct {
+	Model          string `json:"model"`
+	ReasoningLevel string `json:"reasoning_level"`
+	Mode           string `json:"mode"`
+}
+
+// File snapshots and checkpoints only make sense on the machine that took
+// them, so they stay behind
+func exportSessionBundle(ref, out string, redact *RedactionProfile) (string, error) {
+	id, err := resolveSessionID(ref)
+	if err != nil {
+		return "", err
+	}
+	s, err := loadSession(id)
+	if err != nil {
+		return "", err
+	}
+	s.Checkpoints, s.Originals, s.State = nil, nil, ""
+	if redact != nil {
+		for i := range s.History {
+			s.History[i].Content = redactText(s.History[i].Content, *redact)
+		}
+		s.Dir = redactText(s.Dir, *redact)
+	}
+
+	bundle := sessionBundle{
+		Schema:   sessionBundleVersion,
+		Exported: time.Now(),
+		Version:  version,
+		Settings: bundleSettings{Model: activeModel(), ReasoningLevel: settings.ReasoningLevel, Mode: s.Mode},
+		Session:  *s,
+	}
+	if out == "" {
+		out = fmt.Sprintf("session_%s.json", s.ID)
+	}
+	data, _ := json.MarshalIndent(bundle, "", "  ")
+	return out, os.WriteFile(out, data, 0644)
+}
+
+// Returns the ID the session was stored under; a new one if it collides
+func importSessionBundle(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var bundle sessionBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return "", fmt.Errorf("%s is not a session bundle: %s", path, err)
+	}
+	switch {
+	case bundle.Schema == 0 || bundle.Session.ID == "":
+		return "", fmt.Errorf("%s is not a session bundle", path)
+	case bundle.Schema > sessionBundleVersion:
+		return "", fmt.Errorf("bundle schema %d is newer than this mytool supports (%d), upgrade first", bundle.Schema, sessionBundleVersion)
+	}
+
+	s := bundle.Session
+	if _, err := loadSession(s.ID); err == nil {
+		s.ID = generateSessionID()
+	}
+	if info, err := os.Stat(s.Dir); err != nil || !info.IsDir() {
+		s.Dir = currentDir
+	}
+	s.State = ""
+	if s.Updated.IsZero() {
+		s.Updated = time.Now()
+	}
+	return s.ID, storeSession(&s)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==================== SHADOW BRANCH ====================
+
+// With shadow commits on, every turn that changed files is committed to
+// the mytool/shadow branch with the prompt that led to it, so what the
+// assistant did can be recovered from git even when the session was never
+// saved. Changes made in between by someone else go in as a commit of
+// their own first, so each assistant commit holds only its turn. HEAD,
+// the index and the working tree are never touched.
+
+const shadowRef = "refs/heads/mytool/shadow"
+
+// Commits the working tree to the shadow branch after a turn; prompt is
+// the message that started it
+func shadowCommit(prompt string) {
+	if !settings.ShadowCommits || !haveBinary("git") {
+		return
+	}
+	root := findProjectRoot()
+	if root == "" {
+		return
+	}
+	tree, err := worktreeTree(root)
+	if err != nil {
+		fmt.Printf("%s⚠ shadow commit skipped: %s%s\n", colorGray, err, colorReset)
+		return
+	}
+	parent, err := snapshotGit(root, "", "rev-parse", "-q", "--verify", shadowRef)
+	if err != nil {
+		parent, _ = snapshotGit(root, "", "rev-parse", "-q", "--verify", "HEAD")
+	}
+	parentTree := ""
+	if parent != "" {
+		parentTree, _ = snapshotGit(root, "", "rev-parse", parent+"^{tree}")
+	}
+	if tree == parentTree {
+		return
+	}
+	commit := func(tree, parent, message string) (string, error) {
+		args := []string{"commit-tree", tree, "-m", message}
+		if parent != "" {
+			args = append(args, "-p", parent)
+		}
+		return snapshotGit(root, "", args...)
+	}
+
+	// The tree before this turn, when files changed since the last commit
+	if n := len(turnSnapshots); n > 0 && turnSnapshots[n-1].Turn == turnCount {
+		before, _ := snapshotGit(root, "", "rev-parse", turnSnapshots[n-1].Commit+"^{tree}")
+		if before != "" && before != parentTree && before != tree {
+			if c, err := commit(before, parent, "mytool: changes made outside the session"); err == nil {
+				parent = c
+			}
+		}
+	}
+
+	prompt = strings.TrimSpace(stripANSI(prompt))
+	subject := strings.SplitN(prompt, "\n", 2)[0]
+	message := "mytool: " + truncate(subject, 72) + "\n\n"
+	if prompt != subject || len(subject) > 72 {
+		message += truncate(prompt, 2000) + "\n\n"
+	}
+	message += fmt.Sprintf("Mytool-Session: %s\nMytool-Turn: %d", sessionID, turnCount)
+	c, err := commit(tree, parent, message)
+	if err != nil {
+		fmt.Printf("%s⚠ shadow commit skipped: %s%s\n", colorGray, err, colorReset)
+		return
+	}
+	if _, err := snapshotGit(root, "", "update-ref", shadowRef, c); err != nil {
+		fmt.Printf("%s⚠ shadow commit skipped: %s%s\n", colorGray, err, colorReset)
+		return
+	}
+	fmt.Printf("%s↳ shadow commit %s on mytool/shadow%s\n", colorGray, c[:min(len(c), 8)], colorReset)
+}
+
+// /shadow [on|off|log [n]]
+func cmdShadow(arg string) string {
+	fields := strings.Fields(arg)
+	switch {
+	case len(fields) == 0:
+	case arg == "on" || arg == "off":
+		settings.ShadowCommits = arg == "on"
+		saveSettings()
+	case fields[0] == "log":
+		root := findProjectRoot()
+		if root == "" {
+			return "Error: not in a git repository"
+		}
+		n := "20"
+		if len(fields) > 1 {
+			n = fields[1]
+		}
+		out, err := gitIn(root, "log", "-n", n, "--format=%h %ad %s", "--date=format:%m-%d %H:%M", "--stat=80", "mytool/shadow")
+		if err != nil {
+			return "No shadow commits yet"
+		}
+		return out
+	default:
+		return "Usage: /shadow [on|off|log [n]]"
+	}
+	state := "off"
+	if settings.ShadowCommits {
+		state = "on: each turn that changes files is committed to mytool/shadow"
+	}
+	return fmt.Sprintf("Shadow commits %s\n%s/shadow log lists them; git checkout mytool/shadow -- <file> gets a file back%s", state, colorGray, colorReset)
+}
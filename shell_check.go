@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ==================== SHELL CHECK ====================
+
+// Before /copy puts a response on the clipboard, its last code block is
+// checked if it is a shell snippet: a handful of static patterns that are
+// worth a second look before pasting into a terminal.
+
+var shellLangs = map[string]bool{"sh": true, "bash": true, "shell": true, "zsh": true, "console": true, "terminal": true, "fish": true}
+
+var shellHazards = []struct {
+	re   *regexp.Regexp
+	warn string
+}{
+	{regexp.MustCompile(`\bsudo\b`), "runs with sudo"},
+	{regexp.MustCompile(`\brm\s+(-[a-zA-Z]*[rR][a-zA-Z]*\s+)*-[a-zA-Z]*[fF]|\brm\s+(-[a-zA-Z]*[fF][a-zA-Z]*\s+)*-[a-zA-Z]*[rR]`), "recursive forced delete (rm -rf)"},
+	{regexp.MustCompile(`\brm\s+(-\S+\s+)*(/|~|\$HOME|/\*)(\s|$)`), "deletes / or the home directory"},
+	{regexp.MustCompile(`\b(curl|wget)\b[^|\n]*\|\s*(sudo\s+)?(ba|z)?sh\b`), "pipes a download straight into a shell"},
+	{regexp.MustCompile(`\bchmod\s+(-R\s+)?0?777\b`), "makes files world-writable (chmod 777)"},
+	{regexp.MustCompile(`\bdd\b[^\n]*\bof=/dev/`), "dd writes to a device"},
+	{regexp.MustCompile(`\bmkfs(\.\w+)?\b`), "formats a filesystem"},
+	{regexp.MustCompile(`>\s*/dev/(sd|nvme|disk)`), "redirects into a disk device"},
+	{regexp.MustCompile(`:\(\)\s*\{\s*:\|:&\s*\};:`), "fork bomb"},
+	{regexp.MustCompile(`\bgit\s+push\b[^\n]*(--force\b|-f\b)`), "force-pushes"},
+	{regexp.MustCompile(`\bgit\s+(reset\s+--hard|clean\s+-[a-zA-Z]*f)`), "discards uncommitted work"},
+	{regexp.MustCompile(`\beval\b`), "uses eval"},
+	{regexp.MustCompile(`\bchown\s+-R\b`), "changes ownership recursively"},
+	{regexp.MustCompile(`\b(kill|pkill|killall)\s+-9\b`), "kills processes with SIGKILL"},
+}
+
+var shellVarRe = regexp.MustCompile(`\$\{?[A-Za-z_][A-Za-z0-9_]*\}?`)
+
+func isShellBlock(b codeBlock) bool {
+	if shellLangs[b.Lang] {
+		return true
+	}
+	// An untagged one-liner that starts with a prompt
+	return b.Lang == "" && strings.HasPrefix(strings.TrimSpace(b.Code), "$ ")
+}
+
+func shellWarnings(code string) []string {
+	var warnings []string
+	for _, h := range shellHazards {
+		if h.re.MatchString(code) {
+			warnings = append(warnings, h.warn)
+		}
+	}
+	if vars := unquotedVars(code); len(vars) > 0 {
+		warnings = append(warnings, "unquoted variables (word splitting, globbing): "+strings.Join(vars, " "))
+	}
+	return warnings
+}
+
+// $VARs outside double quotes; single-quoted text and comments are skipped
+func unquotedVars(code string) []string {
+	seen := map[string]bool{}
+	var vars []string
+	for _, line := range strings.Split(code, "\n") {
+		inSingle, inDouble := false, false
+		var bare strings.Builder
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '\\' && !inSingle:
+				i++
+				bare.WriteByte(' ')
+				continue
+			case c == '\'' && !inDouble:
+				inSingle = !inSingle
+			case c == '"' && !inSingle:
+				inDouble = !inDouble
+			case c == '#' && !inSingle && !inDouble && (i == 0 || line[i-1] == ' '):
+				i = len(line)
+				continue
+			}
+			if inSingle || inDouble {
+				bare.WriteByte(' ')
+			} else {
+				bare.WriteByte(c)
+			}
+		}
+		for _, v := range shellVarRe.FindAllString(bare.String(), -1) {
+			if !seen[v] {
+				seen[v] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars
+}
+
+// /copy: copies the last response, after a warning summary when its last
+// code block is a shell snippet with something risky in it
+func cmdCopy(response string) string {
+	if response == "" {
+		return "Nothing to copy yet"
+	}
+	if settings.CopyShellCheck {
+		if blocks := extractCodeBlocks(response); len(blocks) > 0 && isShellBlock(blocks[len(blocks)-1]) {
+			if warnings := shellWarnings(blocks[len(blocks)-1].Code); len(warnings) > 0 {
+				fmt.Printf("%s⚠ The shell snippet in this response:%s\n", colorYellow, colorReset)
+				for _, w := range warnings {
+					fmt.Printf("  %s• %s%s\n", colorYellow, w, colorReset)
+				}
+				if !confirmAction("Copy anyway?") {
+					return "Cancelled"
+				}
+			}
+		}
+	}
+	return copyToClipboard(response)
+}
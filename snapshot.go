@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== TURN SNAPSHOTS ====================
+
+// Before the first tool of an assistant turn that can change files, the
+// whole working tree (tracked and untracked, minus .gitignore) is written
+// to git as a commit on refs/mytool/snapshots/<session>, through a
+// temporary index so HEAD, the index and the stash stay untouched. This
+// catches what shell commands change too, which saveForUndo never sees.
+// /revert-turn puts the tree back to the state before the last turn (or
+// n turns back), /revert-session to before the first; both are recorded
+// as one /undo step.
+
+type turnSnapshot struct {
+	Turn   int       `json:"turn"`
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+}
+
+var (
+	turnSnapshots   []turnSnapshot // newest last; popped by /revert-turn
+	sessionSnapshot *turnSnapshot  // the first of the session
+)
+
+// Tools that never change files; any other tool triggers the snapshot
+var readOnlyTools = map[string]bool{
+	"read": true, "ls": true, "tree": true, "find": true, "grep": true, "image": true,
+	"fetch": true, "search": true, "remember": true, "cd": true, "job_output": true,
+	"blame": true, "log": true, "crawl": true,
+}
+
+func snapshotGit(root, index string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=mytool", "GIT_AUTHOR_EMAIL=mytool@localhost",
+		"GIT_COMMITTER_NAME=mytool", "GIT_COMMITTER_EMAIL=mytool@localhost")
+	if index != "" {
+		cmd.Env = append(cmd.Env, "GIT_INDEX_FILE="+index)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Temporary index seeded from the real one, so only changed files are hashed
+func tempIndex(root string) (string, error) {
+	f, err := os.CreateTemp("", "mytool-index-*")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if real, err := snapshotGit(root, "", "rev-parse", "--git-path", "index"); err == nil {
+		if !filepath.IsAbs(real) {
+			real = filepath.Join(root, real)
+		}
+		if data, err := os.ReadFile(real); err == nil {
+			os.WriteFile(f.Name(), data, 0600)
+		} else {
+			os.Remove(f.Name()) // git wants no file rather than an empty one
+		}
+	}
+	return f.Name(), nil
+}
+
+// Tree object of the working tree as it is now
+func worktreeTree(root string) (string, error) {
+	index, err := tempIndex(root)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(index)
+	if _, err := snapshotGit(root, index, "add", "-A", "."); err != nil {
+		return "", err
+	}
+	return snapshotGit(root, index, "write-tree")
+}
+
+// Called before each tool; snapshots once per assistant turn
+func snapshotBeforeTool(tool string, taken *bool) {
+	if *taken || readOnlyTools[tool] {
+		return
+	}
+	*taken = true
+	root := projectRootFor(currentDir)
+	if root == "" || !haveBinary("git") {
+		return
+	}
+	tree, err := worktreeTree(root)
+	if err != nil {
+		fmt.Printf("%s⚠ snapshot skipped: %s%s\n", colorGray, err, colorReset)
+		return
+	}
+	args := []string{"commit-tree", tree, "-m", fmt.Sprintf("mytool: before turn %d of %s", turnCount, sessionID)}
+	if len(turnSnapshots) > 0 {
+		args = append(args, "-p", turnSnapshots[len(turnSnapshots)-1].Commit)
+	}
+	commit, err := snapshotGit(root, "", args...)
+	if err != nil {
+		fmt.Printf("%s⚠ snapshot skipped: %s%s\n", colorGray, err, colorReset)
+		return
+	}
+	// The ref keeps the chain of snapshots safe from git gc
+	snapshotGit(root, "", "update-ref", "refs/mytool/snapshots/"+sessionID, commit)
+	snap := turnSnapshot{Turn: turnCount, Commit: commit, Time: time.Now()}
+	turnSnapshots = append(turnSnapshots, snap)
+	if sessionSnapshot == nil {
+		sessionSnapshot = &snap
+	}
+}
+
+// Puts the working tree back to commit; returns the paths it changed
+func restoreSnapshot(root, commit, op string) ([]string, error) {
+	tree, err := worktreeTree(root)
+	if err != nil {
+		return nil, err
+	}
+	out, err := snapshotGit(root, "", "diff-tree", "-r", "--no-renames", "--name-status", "-z", commit, tree)
+	if err != nil {
+		return nil, err
+	}
+	var restore, remove, changed []string
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		if status == "A" {
+			remove = append(remove, path) // created since the snapshot
+		} else {
+			restore = append(restore, path)
+		}
+		changed = append(changed, path)
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	fmt.Printf("%s%d files differ from the snapshot:%s\n", colorCyan, len(changed), colorReset)
+	for i, path := range changed {
+		if i == 20 {
+			fmt.Printf("  %s+%d more%s\n", colorGray, len(changed)-20, colorReset)
+			break
+		}
+		fmt.Printf("  %s\n", path)
+	}
+	if !confirmAction(fmt.Sprintf("%sRestore them?%s", colorYellow, colorReset)) {
+		return nil, fmt.Errorf("cancelled")
+	}
+
+	batch := UndoAction{Type: "batch", Path: op, Op: op, Time: time.Now()}
+	for _, path := range changed {
+		batch.Files = append(batch.Files, undoSnapshot(filepath.Join(root, path)))
+	}
+	for _, path := range remove {
+		os.Remove(filepath.Join(root, path))
+	}
+	if len(restore) > 0 {
+		index, err := tempIndex(root)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(index)
+		if _, err := snapshotGit(root, index, "read-tree", commit); err != nil {
+			return nil, err
+		}
+		if _, err := snapshotGit(root, index, append([]string{"checkout-index", "-f", "--"}, restore...)...); err != nil {
+			return nil, err
+		}
+	}
+	pushUndo(batch)
+	return changed, nil
+}
+
+// /revert-turn [n]: the tree as it was before the last n turns that wrote
+func cmdRevertTurn(arg string) string {
+	n := 1
+	if arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+			return "Usage: /revert-turn [n]"
+		}
+	}
+	if len(turnSnapshots) == 0 {
+		return "No turn snapshots yet (taken before the model's first write in a git repository)"
+	}
+	n = min(n, len(turnSnapshots))
+	snap := turnSnapshots[len(turnSnapshots)-n]
+	msg := revertTo(snap, "revert-turn")
+	if !strings.HasPrefix(msg, "Error") {
+		turnSnapshots = turnSnapshots[:len(turnSnapshots)-n]
+	}
+	return msg
+}
+
+func cmdRevertSession() string {
+	if sessionSnapshot == nil {
+		return "No session snapshot yet (taken before the model's first write in a git repository)"
+	}
+	msg := revertTo(*sessionSnapshot, "revert-session")
+	if !strings.HasPrefix(msg, "Error") {
+		turnSnapshots = nil
+	}
+	return msg
+}
+
+func revertTo(snap turnSnapshot, op string) string {
+	root := projectRootFor(currentDir)
+	if root == "" {
+		return "Error: not in a git repository"
+	}
+	changed, err := restoreSnapshot(root, snap.Commit, op)
+	if err != nil {
+		if err.Error() == "cancelled" {
+			return "Cancelled"
+		}
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if len(changed) == 0 {
+		return fmt.Sprintf("Nothing to revert: the tree matches the snapshot before turn %d", snap.Turn)
+	}
+	return fmt.Sprintf("%s✓ Restored %d files to before turn %d (%s ago); /undo 1 reverts this%s",
+		colorGreen, len(changed), snap.Turn, time.Since(snap.Time).Round(time.Second), colorReset)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== STALE FILE WATCH ====================
+
+// Files the model has read (read, @mentions) or written whole are
+// remembered by size, mtime and hash. When one changes on disk behind its
+// back (saved in an editor, reformatted by a shell command) the next
+// message to the model carries the diff, and edits made from the old copy
+// are caught: write refuses and replace with text that is gone returns
+// the file re-read, so the retry starts from the current version.
+
+type seenFile struct {
+	Size    int64
+	ModTime time.Time
+	Hash    string
+	Content string // up to staleKeepBytes, for the diff
+	Stale   bool   // changed, and the model hasn't seen the new content
+}
+
+const (
+	staleKeepBytes = 256 << 10
+	staleDiffLines = 40 // longer diffs only name the file
+)
+
+var seenFiles = map[string]seenFile{}
+
+// Records data as the model's copy of path
+func noteSeen(path string, data []byte) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	s := seenFile{Size: info.Size(), ModTime: info.ModTime(), Hash: contentHash(string(data))}
+	if len(data) <= staleKeepBytes {
+		s.Content = string(data)
+	}
+	seenFiles[path] = s
+}
+
+// After our own edit of a file the model has seen, the new content is
+// what it expects to find; a copy that was already stale stays so
+func refreshSeen(path string) {
+	old, ok := seenFiles[path]
+	if !ok {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		delete(seenFiles, path)
+		return
+	}
+	noteSeen(path, data)
+	if old.Stale {
+		s := seenFiles[path]
+		s.Stale = true
+		seenFiles[path] = s
+	}
+}
+
+// Whether path changed since the model saw it, and the current content
+// ("" when it was deleted). Cheap when size and mtime are unchanged.
+func staleFile(path string) (bool, string) {
+	s, ok := seenFiles[path]
+	if !ok {
+		return false, ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return true, ""
+	}
+	if info.Size() == s.Size && info.ModTime().Equal(s.ModTime) {
+		return s.Stale, ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return true, ""
+	}
+	if contentHash(string(data)) == s.Hash {
+		s.ModTime = info.ModTime() // touched, not changed
+		seenFiles[path] = s
+		return s.Stale, string(data)
+	}
+	s.Stale = true
+	seenFiles[path] = s
+	return true, string(data)
+}
+
+// Message for the model about files changed on disk since it read them,
+// or "". Short changes come with their diff and count as seen; for the
+// others write refuses until the file is read again.
+func staleNotice() string {
+	paths := make([]string, 0, len(seenFiles))
+	for path := range seenFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var parts, names []string
+	for _, path := range paths {
+		old := seenFiles[path]
+		if old.Stale {
+			continue // already reported
+		}
+		changed, content := staleFile(path)
+		if !changed {
+			continue
+		}
+		names = append(names, relPath(path))
+		if _, err := os.Stat(path); err != nil {
+			delete(seenFiles, path)
+			parts = append(parts, fmt.Sprintf("%s was deleted", relPath(path)))
+			continue
+		}
+		noteSeen(path, []byte(content))
+		diff := ""
+		if old.Content != "" || old.Size == 0 {
+			diff = plainDiff(old.Content, content)
+		}
+		if diff == "" || strings.Count(diff, "\n") > staleDiffLines {
+			s := seenFiles[path]
+			s.Stale = true
+			seenFiles[path] = s
+			parts = append(parts, fmt.Sprintf("%s changed (too much to show); read it again before editing it", relPath(path)))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("--- %s\n%s", relPath(path), diff))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	fmt.Printf("%s⚠ Changed on disk since the model read them: %s%s\n", colorYellow, strings.Join(names, ", "), colorReset)
+	return "Files changed outside mytool since you read them (your copy is out of date):\n" + strings.Join(parts, "\n")
+}
+
+// Refusal for an edit of path made from an out-of-date copy, with the file
+// read again; "" when the copy is current
+func staleEdit(path string) string {
+	changed, _ := staleFile(path)
+	if !changed {
+		return ""
+	}
+	fmt.Printf("%s⚠ %s changed on disk since the model read it; reading it again%s\n", colorYellow, relPath(path), colorReset)
+	if _, err := os.Stat(path); err != nil {
+		delete(seenFiles, path)
+		return fmt.Sprintf("Error: %s changed on disk since you last read it: it was deleted", relPath(path))
+	}
+	return fmt.Sprintf("Error: %s changed on disk since you last read it; current content:\n%s", relPath(path), cmdRead(path))
+}
+
+// Uncolored unified diff for the model
+func plainDiff(before, after string) string {
+	var b strings.Builder
+	for _, h := range diffHunks(lineDiff(splitLines(before), splitLines(after)), diffContext) {
+		b.WriteString(hunkHeader(h) + "\n")
+		for _, op := range h {
+			b.WriteString(string(op.Kind) + op.Text + "\n")
+		}
+	}
+	return b.String()
+}
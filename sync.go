@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ==================== CLOUD SYNC ====================
+
+// Sessions and memory are pushed to / pulled from a WebDAV folder
+// (Nextcloud, ownCloud, rclone serve webdav, ...). Everything is encrypted
+// with AES-256-GCM under a key derived from a passphrase that never leaves
+// the machine. Conflicts go to whichever side has the newer Updated time.
+//
+// Remote layout, below settings.Sync.URL:
+//
+//	mytool/mytool-sync.json    salt + passphrase check (plain)
+//	mytool/index.bin           id → updated for every session, plus memory;
+//	                           written with If-Match so machines syncing at
+//	                           once don't drop each other's entries
+//	mytool/sessions/<id>.bin   one encrypted Session each
+//	mytool/memory.bin          global facts, each with its Updated time,
+//	                           merged fact by fact
+
+type SyncSettings struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+}
+
+type syncCredentials struct {
+	Password   string `json:"password"`
+	Passphrase string `json:"passphrase"`
+}
+
+type syncMeta struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Check   []byte `json:"check"` // "mytool-sync" sealed with the key
+}
+
+type syncIndex struct {
+	Sessions map[string]int64 `json:"sessions"`
+	Memory   int64            `json:"memory"`
+}
+
+type syncClient struct {
+	base  string
+	user  string
+	pass  string
+	key   []byte
+	httpc *http.Client
+}
+
+const syncKDFIterations = 300000
+
+var (
+	errRemoteMissing = errors.New("not found on server")
+	errRemoteChanged = errors.New("changed on server since it was read")
+)
+
+// Attempts at writing index.bin when other machines keep changing it
+const syncIndexRetries = 5
+
+// Keychain entries for the WebDAV password and the encryption passphrase
+const (
+	syncPasswordKeychain   = "mytool-sync-password"
+	syncPassphraseKeychain = "mytool-sync-passphrase"
+)
+
+// Where earlier versions kept both secrets in plain text; moved to the
+// keychain on first use
+func syncCredentialsPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "sync_credentials.json")
+}
+
+// Environment first, then the keychain
+func loadSyncCredentials() syncCredentials {
+	var c syncCredentials
+	if data, err := os.ReadFile(syncCredentialsPath()); err == nil && json.Unmarshal(data, &c) == nil {
+		if err := saveSyncCredentials(c); err == nil {
+			os.Remove(syncCredentialsPath())
+		}
+	} else {
+		c.Password, _ = keychainGet(syncPasswordKeychain)
+		c.Passphrase, _ = keychainGet(syncPassphraseKeychain)
+	}
+	if p := os.Getenv("MYTOOL_SYNC_PASSWORD"); p != "" {
+		c.Password = p
+	}
+	if p := os.Getenv("MYTOOL_SYNC_PASSPHRASE"); p != "" {
+		c.Passphrase = p
+	}
+	return c
+}
+
+func saveSyncCredentials(c syncCredentials) error {
+	if c.Password == "" {
+		keychainDelete(syncPasswordKeychain)
+	} else if err := keychainSet(syncPasswordKeychain, "mytool sync password", c.Password); err != nil {
+		return err
+	}
+	return keychainSet(syncPassphraseKeychain, "mytool sync passphrase", c.Passphrase)
+}
+
+// Asks for the endpoint and secrets; secrets go to the keychain, not settings
+func setupSync(scanner *bufio.Scanner) bool {
+	ask := func(label, current string) string {
+		if current != "" {
+			fmt.Printf("%s [%s]: ", label, current)
+		} else {
+			fmt.Printf("%s: ", label)
+		}
+		if !scanner.Scan() {
+			return current
+		}
+		if v := strings.TrimSpace(scanner.Text()); v != "" {
+			return v
+		}
+		return current
+	}
+	secret := func(label string) string {
+		fmt.Printf("%s: ", label)
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			b, _ := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			return string(b)
+		}
+		scanner.Scan()
+		return strings.TrimSpace(scanner.Text())
+	}
+
+	fmt.Printf("\n%sCloud sync (WebDAV)%s\n", colorCyan, colorReset)
+	fmt.Printf("%sData is encrypted before upload; the passphrase is needed on every machine.%s\n\n", colorGray, colorReset)
+	settings.Sync.URL = strings.TrimSuffix(ask("WebDAV folder URL", settings.Sync.URL), "/")
+	settings.Sync.Username = ask("Username", settings.Sync.Username)
+	creds := syncCredentials{Password: secret("Password"), Passphrase: secret("Encryption passphrase")}
+	if settings.Sync.URL == "" || creds.Passphrase == "" {
+		fmt.Printf("%sURL and passphrase are required%s\n", colorRed, colorReset)
+		return false
+	}
+	// Without a keychain the secrets can still come from the environment
+	if err := saveSyncCredentials(creds); err != nil && os.Getenv("MYTOOL_SYNC_PASSPHRASE") == "" {
+		fmt.Printf("%sCredentials not saved: keychain: %s%s\n", colorRed, err, colorReset)
+		fmt.Printf("%sOr set MYTOOL_SYNC_PASSWORD and MYTOOL_SYNC_PASSPHRASE and run setup again%s\n", colorGray, colorReset)
+		return false
+	}
+
+	if _, err := newSyncClient(); err != nil {
+		fmt.Printf("%sSync check failed: %s%s\n", colorRed, err, colorReset)
+		return false
+	}
+	settings.CloudSync = true
+	saveSettings()
+	fmt.Printf("%s✓ Cloud sync enabled%s\n", colorGreen, colorReset)
+	return true
+}
+
+// Connects, creates the remote folders on first use and unlocks the key
+func newSyncClient() (*syncClient, error) {
+	if settings.Sync.URL == "" {
+		return nil, fmt.Errorf("sync is not configured, run: mytool sync setup")
+	}
+	creds := loadSyncCredentials()
+	if creds.Passphrase == "" {
+		return nil, fmt.Errorf("no encryption passphrase, run: mytool sync setup")
+	}
+	c := &syncClient{base: strings.TrimSuffix(settings.Sync.URL, "/"), user: settings.Sync.Username,
+		pass: creds.Password, httpc: &http.Client{Timeout: 60 * time.Second}}
+
+	for _, dir := range []string{"mytool", "mytool/sessions"} {
+		if err := c.mkcol(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	var meta syncMeta
+	data, err := c.get("mytool/mytool-sync.json")
+	switch {
+	case err == errRemoteMissing:
+		meta = syncMeta{Version: 1, Salt: make([]byte, 16)}
+		rand.Read(meta.Salt)
+		if c.key, err = syncKey(creds.Passphrase, meta.Salt); err != nil {
+			return nil, err
+		}
+		if meta.Check, err = c.seal([]byte("mytool-sync")); err != nil {
+			return nil, err
+		}
+		data, _ = json.MarshalIndent(meta, "", "  ")
+		if err := c.put("mytool/mytool-sync.json", data); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("bad mytool-sync.json: %s", err)
+		}
+		if c.key, err = syncKey(creds.Passphrase, meta.Salt); err != nil {
+			return nil, err
+		}
+		if check, err := c.open(meta.Check); err != nil || string(check) != "mytool-sync" {
+			return nil, fmt.Errorf("wrong encryption passphrase for this sync folder")
+		}
+	}
+	return c, nil
+}
+
+func syncKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, syncKDFIterations, 32)
+}
+
+func (c *syncClient) seal(plain []byte) ([]byte, error) {
+	return sealGCM(c.key, plain)
+}
+
+func (c *syncClient) open(sealed []byte) ([]byte, error) {
+	return openGCM(c.key, sealed)
+}
+
+// AES-256-GCM with a random nonce prepended; also used for at-rest
+// encryption (vault.go)
+func sealGCM(key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func openGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func (c *syncClient) do(method, path string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	resp, err := c.httpc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: access denied (HTTP %d)", method, path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// 405 means the collection already exists
+func (c *syncClient) mkcol(path string) error {
+	resp, err := c.do("MKCOL", path, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode != 405 {
+		return fmt.Errorf("MKCOL %s: HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *syncClient) get(path string) ([]byte, error) {
+	data, _, err := c.getIf(path)
+	return data, err
+}
+
+// Also returns the precondition for writing path back only if it is
+// still what was read: If-Match on its ETag, If-None-Match: * while it
+// doesn't exist, nothing when the server gives no ETag
+func (c *syncClient) getIf(path string) ([]byte, http.Header, error) {
+	resp, err := c.do("GET", path, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, http.Header{"If-None-Match": {"*"}}, errRemoteMissing
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("GET %s: HTTP %d", path, resp.StatusCode)
+	}
+	var cond http.Header
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		cond = http.Header{"If-Match": {etag}}
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, cond, err
+}
+
+func (c *syncClient) put(path string, data []byte) error {
+	return c.putIf(path, data, nil)
+}
+
+// errRemoteChanged when cond no longer holds
+func (c *syncClient) putIf(path string, data []byte, cond http.Header) error {
+	resp, err := c.do("PUT", path, data, cond)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 412 {
+		return errRemoteChanged
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("PUT %s: HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *syncClient) getSealed(path string, v interface{}) error {
+	_, err := c.getSealedIf(path, v)
+	return err
+}
+
+func (c *syncClient) getSealedIf(path string, v interface{}) (http.Header, error) {
+	data, cond, err := c.getIf(path)
+	if err != nil {
+		return cond, err
+	}
+	plain, err := c.open(data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %s", path, err)
+	}
+	return cond, json.Unmarshal(plain, v)
+}
+
+func (c *syncClient) putSealed(path string, v interface{}) error {
+	return c.putSealedIf(path, v, nil)
+}
+
+func (c *syncClient) putSealedIf(path string, v interface{}, cond http.Header) error {
+	plain, _ := json.Marshal(v)
+	sealed, err := c.seal(plain)
+	if err != nil {
+		return err
+	}
+	return c.putIf(path, sealed, cond)
+}
+
+// Reads index.bin with the condition for writing it back
+func (c *syncClient) getIndex() (syncIndex, http.Header, error) {
+	index := syncIndex{}
+	cond, err := c.getSealedIf("mytool/index.bin", &index)
+	if err == errRemoteMissing {
+		err = nil
+	}
+	if index.Sessions == nil {
+		index.Sessions = map[string]int64{}
+	}
+	return index, cond, err
+}
+
+// Writes index.bin unless another machine wrote it since it was read; then
+// its version is read again, update reapplied and the write retried
+func (c *syncClient) putIndex(index syncIndex, cond http.Header, update func(*syncIndex)) error {
+	for attempt := 1; ; attempt++ {
+		err := c.putSealedIf("mytool/index.bin", index, cond)
+		if err != errRemoteChanged {
+			return err
+		}
+		if attempt == syncIndexRetries {
+			return fmt.Errorf("mytool/index.bin keeps changing on the server, try again")
+		}
+		if index, cond, err = c.getIndex(); err != nil {
+			return err
+		}
+		update(&index)
+	}
+}
+
+// One global fact in memory.bin; Deleted keeps a forgotten one from
+// coming back from a machine that still has it
+type syncFact struct {
+	Value   string `json:"value,omitempty"`
+	Updated int64  `json:"updated"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Global memory as memory.bin holds it, forgotten facts included
+func localMemoryFacts() map[string]syncFact {
+	facts := map[string]syncFact{}
+	for k, v := range memory {
+		m := memoryMeta.Facts[metaKey(scopeGlobal, k)]
+		updated := m.Updated
+		if updated.IsZero() {
+			updated = m.Created
+		}
+		facts[k] = syncFact{Value: v, Updated: updated.Unix()}
+	}
+	for mk, t := range memoryMeta.Forgotten {
+		if k, ok := strings.CutPrefix(mk, scopeGlobal+":"); ok {
+			if _, stored := facts[k]; !stored {
+				facts[k] = syncFact{Updated: t.Unix(), Deleted: true}
+			}
+		}
+	}
+	return facts
+}
+
+// Reads memory.bin with the condition for writing it back; one written
+// before facts had times is a plain key → value map, dated stamp
+func (c *syncClient) getMemory(stamp int64) (map[string]syncFact, http.Header, error) {
+	var raw json.RawMessage
+	cond, err := c.getSealedIf("mytool/memory.bin", &raw)
+	if err == errRemoteMissing {
+		return map[string]syncFact{}, cond, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	facts := map[string]syncFact{}
+	if json.Unmarshal(raw, &facts) != nil {
+		var old map[string]string
+		if err := json.Unmarshal(raw, &old); err != nil {
+			return nil, nil, fmt.Errorf("bad mytool/memory.bin: %s", err)
+		}
+		facts = map[string]syncFact{}
+		for k, v := range old {
+			facts[k] = syncFact{Value: v, Updated: stamp}
+		}
+	}
+	return facts, cond, nil
+}
+
+// Merges global memory fact by fact, the newer Updated winning; reports
+// whether anything went up or came down. memory.bin is written with
+// If-Match like index.bin and the merge redone when another machine got
+// there first.
+func (c *syncClient) syncMemory(index *syncIndex, push, pull bool) (pushed, pulled bool, err error) {
+	for attempt := 1; ; attempt++ {
+		remote, cond, err := c.getMemory(index.Memory)
+		if err != nil {
+			return false, false, err
+		}
+		local := localMemoryFacts()
+		merged := map[string]syncFact{}
+		changed := false
+		var newest int64
+		for k, f := range remote {
+			merged[k] = f
+		}
+		for k, f := range local {
+			if r, ok := remote[k]; !ok && !f.Deleted || ok && f.Updated > r.Updated && (f.Value != r.Value || f.Deleted != r.Deleted) {
+				merged[k] = f
+				changed = true
+			}
+		}
+		var incoming []string
+		for k, r := range remote {
+			if l, ok := local[k]; !ok && !r.Deleted || ok && r.Updated > l.Updated && (r.Value != l.Value || r.Deleted != l.Deleted) {
+				incoming = append(incoming, k)
+			}
+		}
+		for _, f := range merged {
+			newest = max(newest, f.Updated)
+		}
+
+		if push && changed {
+			err := c.putSealedIf("mytool/memory.bin", merged, cond)
+			if err == errRemoteChanged {
+				if attempt == syncIndexRetries {
+					return false, false, fmt.Errorf("mytool/memory.bin keeps changing on the server, try again")
+				}
+				continue
+			}
+			if err != nil {
+				return false, false, err
+			}
+			index.Memory = newest
+			pushed = true
+		}
+		if pull && len(incoming) > 0 {
+			for _, k := range incoming {
+				applySyncedFact(k, remote[k])
+			}
+			saveMemory()
+			saveMemoryMeta()
+			pulled = true
+		}
+		return pushed, pulled, nil
+	}
+}
+
+// Stores or forgets a global fact as another machine last left it
+func applySyncedFact(key string, f syncFact) {
+	mk := metaKey(scopeGlobal, key)
+	t := time.Unix(f.Updated, 0)
+	if f.Deleted {
+		delete(memory, key)
+		delete(memoryMeta.Facts, mk)
+		memoryMeta.Forgotten[mk] = t
+		metaForgotten[mk] = true
+		return
+	}
+	memory[key] = f.Value
+	m, ok := memoryMeta.Facts[mk]
+	if !ok {
+		m.Created, m.Used = t, t
+	}
+	m.Updated = t
+	memoryMeta.Facts[mk] = m
+	metaDirty[mk] = true
+}
+
+// Pushes what is newer locally and pulls what is newer remotely;
+// direction limits it to "push" or "pull"
+func runSync(direction string) (pushed, pulled int, err error) {
+	c, err := newSyncClient()
+	if err != nil {
+		return 0, 0, err
+	}
+	index, cond, err := c.getIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	// What this run uploaded, to merge into a newer index.bin if needed
+	sent := syncIndex{Sessions: map[string]int64{}}
+	push, pull := direction != "pull", direction != "push"
+
+	rows, err := listSessionRows(sessionFilter{Limit: -1})
+	if err != nil {
+		return 0, 0, err
+	}
+	local := map[string]int64{}
+	for _, r := range rows {
+		local[r.ID] = r.Updated.Unix()
+	}
+
+	for id, updated := range local {
+		if remote, ok := index.Sessions[id]; push && (!ok || updated > remote) {
+			s, err := loadSession(id)
+			if err != nil {
+				continue
+			}
+			s.State = ""
+			if err := c.putSealed("mytool/sessions/"+id+".bin", s); err != nil {
+				return pushed, pulled, err
+			}
+			index.Sessions[id] = updated
+			sent.Sessions[id] = updated
+			pushed++
+		}
+	}
+	for id, remote := range index.Sessions {
+		if updated, ok := local[id]; pull && (!ok || remote > updated) && id != sessionID {
+			var s Session
+			if err := c.getSealed("mytool/sessions/"+id+".bin", &s); err != nil {
+				if err == errRemoteMissing {
+					continue
+				}
+				return pushed, pulled, err
+			}
+			if err := storeSession(&s); err != nil {
+				return pushed, pulled, err
+			}
+			pulled++
+		}
+	}
+
+	memPushed, memPulled, err := c.syncMemory(&index, push, pull)
+	if err != nil {
+		return pushed, pulled, err
+	}
+	if memPushed {
+		sent.Memory = index.Memory
+		pushed++
+	}
+	if memPulled {
+		pulled++
+	}
+
+	if pushed > 0 {
+		merge := func(index *syncIndex) {
+			for id, updated := range sent.Sessions {
+				if updated > index.Sessions[id] {
+					index.Sessions[id] = updated
+				}
+			}
+			if sent.Memory > index.Memory {
+				index.Memory = sent.Memory
+			}
+		}
+		if err := c.putIndex(index, cond, merge); err != nil {
+			return pushed, pulled, err
+		}
+	}
+	return pushed, pulled, nil
+}
+
+// mytool sync [push | pull | setup]; scanner is the caller's stdin
+func runSyncCmd(args []string, scanner *bufio.Scanner) {
+	direction := ""
+	if len(args) > 0 {
+		direction = args[0]
+	}
+	switch direction {
+	case "setup":
+		setupSync(scanner)
+		return
+	case "", "push", "pull":
+	default:
+		fmt.Println("Usage: mytool sync [push|pull|setup]")
+		return
+	}
+	fmt.Printf("%sSyncing with %s...%s\n", colorGray, settings.Sync.URL, colorReset)
+	pushed, pulled, err := runSync(direction)
+	if err != nil {
+		fmt.Printf("%sSync failed: %s%s\n", colorRed, err, colorReset)
+		return
+	}
+	fmt.Printf("%s✓ Synced: %d pushed, %d pulled%s\n", colorGreen, pushed, pulled, colorReset)
+}
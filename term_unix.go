@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// The controlling terminal, for prompts when stdin is taken (mcp-serve)
+func openTerminal() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
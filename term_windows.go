@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// The console input buffer; Windows has no /dev/tty
+func openTerminal() (*os.File, error) {
+	return os.OpenFile("CONIN$", os.O_RDWR, 0)
+}
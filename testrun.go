@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ==================== TEST RUNNER ====================
+
+// /test and the test tool run the project's tests with the command its
+// type calls for (go test, npm test, pytest, cargo test...) and reduce the
+// output to the failing tests: name, location and the first lines of the
+// message. /test --fix sends those to the model, runs the tests again
+// after its changes and repeats until they pass or the rounds run out.
+
+const (
+	testMaxFailures = 20
+	testExcerpt     = 8 // message lines kept per failure
+	testFixRounds   = 3
+)
+
+type testFailure struct {
+	Name     string
+	Location string // file:line when the output names one
+	Message  string
+}
+
+type testReport struct {
+	Command  string
+	Passed   bool
+	Killed   string // why it was killed, if it was
+	Failures []testFailure
+	Tail     string // last lines of the output when no failure could be parsed
+}
+
+// The /test --fix loop between turns
+var testFix struct {
+	Filter     string
+	Round, Max int
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// The test command for the project type, with filter selecting tests by
+// name; "" when there is no known way to test it
+func testCommand(filter string) string {
+	with := func(base, flag string) string {
+		if filter == "" {
+			return base
+		}
+		return base + " " + flag + shellQuote(filter)
+	}
+	switch projectType {
+	case "go":
+		return with("go test", "-run ") + " ./..."
+	case "nodejs":
+		var pkg struct {
+			Scripts map[string]string `json:"scripts"`
+		}
+		data, _ := os.ReadFile(filepath.Join(currentDir, "package.json"))
+		json.Unmarshal(data, &pkg)
+		script := pkg.Scripts["test"]
+		if script == "" || strings.Contains(script, "no test specified") {
+			return ""
+		}
+		switch {
+		case strings.Contains(script, "mocha"):
+			return with("npm test", "-- --grep ")
+		case strings.Contains(script, "jest"), strings.Contains(script, "vitest"):
+			return with("npm test", "-- -t ")
+		}
+		return with("npm test", "-- ")
+	case "python":
+		if haveBinary("pytest") {
+			return with("pytest -q -rfE", "-k ")
+		}
+		return with("python3 -m unittest", "-k ")
+	case "rust":
+		return with("cargo test", "")
+	case "java":
+		return with("mvn -q test", "-Dtest=")
+	case "php":
+		return with("vendor/bin/phpunit", "--filter ")
+	case "ruby":
+		if _, err := os.Stat(filepath.Join(currentDir, "spec")); err == nil {
+			return with("bundle exec rspec", "-e ")
+		}
+		return with("bundle exec rake test", "TESTOPTS=--name=")
+	case "flutter":
+		return with("flutter test", "--plain-name ")
+	case "cpp":
+		return with("ctest --test-dir build --output-on-failure", "-R ")
+	case "make":
+		return "make test"
+	}
+	return ""
+}
+
+// Runs the tests like run: does (policy, sandbox, limits, timeout) and
+// parses what failed
+func runTests(filter string) testReport {
+	command := testCommand(filter)
+	if command == "" {
+		return testReport{Killed: fmt.Sprintf("no test command known for this project (type %q); use run:", projectType)}
+	}
+	out := stripANSI(cmdRun(command))
+	r := testReport{Command: command}
+	body, status := out, ""
+	if i := strings.LastIndex(out, "\nExit: "); i >= 0 {
+		body, status = out[:i], out[i+1:]
+	} else if i := strings.LastIndex(out, "\nKilled: "); i >= 0 {
+		r.Killed = strings.TrimPrefix(out[i+1:], "Killed: ")
+		return r
+	} else if strings.HasPrefix(out, "Error:") || strings.HasPrefix(out, "[blocked]") || out == "Cancelled" {
+		r.Killed = out
+		return r
+	}
+	if status == "" {
+		r.Passed = true
+		return r
+	}
+	r.Failures = parseTestFailures(body)
+	if len(r.Failures) == 0 {
+		lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+		r.Tail = strings.Join(lines[max(len(lines)-60, 0):], "\n") + "\n" + status
+	}
+	return r
+}
+
+var (
+	goFailRe      = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+	goLocRe       = regexp.MustCompile(`^\s+(\S+\.go:\d+): ?(.*)`)
+	goBuildRe     = regexp.MustCompile(`^(\S+\.go:\d+(?::\d+)?): (.*)`)
+	pytestRe      = regexp.MustCompile(`^(?:FAILED|ERROR) (\S+?)(?: - (.*))?$`)
+	unittestRe    = regexp.MustCompile(`^(?:FAIL|ERROR): (\S+) \((\S+)\)`)
+	pyLocRe       = regexp.MustCompile(`File "([^"]+)", line (\d+)`)
+	jestRe        = regexp.MustCompile(`^\s*● (.+)$`)
+	vitestRe      = regexp.MustCompile(`^\s*(?:FAIL|×|✗)\s+(.+ > .+)$`)
+	mochaRe       = regexp.MustCompile(`^\s+\d+\) (.+)$`)
+	jsLocRe       = regexp.MustCompile(`\(?((?:/|\./|\w)[^\s():]*\.[cm]?[jt]sx?:\d+)(?::\d+)?\)?`)
+	cargoFailRe   = regexp.MustCompile(`^test (\S+) \.\.\. FAILED$`)
+	cargoPanicRe  = regexp.MustCompile(`panicked at (\S+?:\d+):\d+:?`)
+	surefireRe    = regexp.MustCompile(`^\[ERROR\]\s+(\w[\w.$]*\.\w+):(\d+)\s+(.*)$`)
+	blockEndRe    = regexp.MustCompile(`^(?:={3,}|-{3,}|_{3,}|=== RUN|--- (?:PASS|FAIL)|ok\s|FAIL\s|PASS$|FAIL$)`)
+	testSummaryRe = regexp.MustCompile(`^\s*(?:Tests?:|Test Suites:|Snapshots:|Time:|Ran \d+ tests?|FAILED \(|\d+ (?:passed|failed|passing|failing))`)
+)
+
+// Failing tests in the output of any of the supported runners
+func parseTestFailures(out string) []testFailure {
+	lines := strings.Split(out, "\n")
+	var failures []testFailure
+	seen := map[string]bool{}
+	add := func(f testFailure) {
+		if seen[f.Name] || len(failures) >= testMaxFailures {
+			return
+		}
+		seen[f.Name] = true
+		failures = append(failures, f)
+	}
+	// The lines after i up to the next block, as a message excerpt, and
+	// the first location matched in them
+	block := func(i int, loc *regexp.Regexp) (string, string) {
+		var msg []string
+		location := ""
+		for _, l := range lines[i+1:] {
+			if blockEndRe.MatchString(strings.TrimSpace(l)) || goFailRe.MatchString(l) || jestRe.MatchString(l) || testSummaryRe.MatchString(l) {
+				break
+			}
+			if location == "" && loc != nil {
+				if m := loc.FindStringSubmatch(l); m != nil {
+					location = m[1]
+					if len(m) > 2 && loc == pyLocRe {
+						location += ":" + m[2]
+					}
+				}
+			}
+			if strings.TrimSpace(l) != "" && len(msg) < testExcerpt {
+				msg = append(msg, strings.TrimRight(l, " "))
+			}
+		}
+		return strings.Join(msg, "\n"), location
+	}
+
+	for i, l := range lines {
+		switch {
+		case goFailRe.MatchString(l):
+			msg, loc := block(i, goLocRe)
+			add(testFailure{Name: goFailRe.FindStringSubmatch(l)[1], Location: loc, Message: msg})
+		case pytestRe.MatchString(l):
+			m := pytestRe.FindStringSubmatch(l)
+			loc, _, _ := strings.Cut(m[1], "::")
+			add(testFailure{Name: m[1], Location: loc, Message: m[2]})
+		case unittestRe.MatchString(l):
+			m := unittestRe.FindStringSubmatch(l)
+			name := m[2] // the full id since Python 3.11
+			if !strings.HasSuffix(name, "."+m[1]) {
+				name += "." + m[1]
+			}
+			msg, loc := block(i+1, pyLocRe) // past the ---- under the title
+			add(testFailure{Name: name, Location: loc, Message: msg})
+		case cargoFailRe.MatchString(l):
+			name := cargoFailRe.FindStringSubmatch(l)[1]
+			f := testFailure{Name: name}
+			for j, s := range lines {
+				if strings.TrimSpace(s) == "---- "+name+" stdout ----" {
+					f.Message, f.Location = block(j, cargoPanicRe)
+					break
+				}
+			}
+			add(f)
+		case surefireRe.MatchString(l):
+			m := surefireRe.FindStringSubmatch(l)
+			add(testFailure{Name: m[1], Location: m[1] + ":" + m[2], Message: m[3]})
+		case jestRe.MatchString(l):
+			name := strings.TrimSpace(jestRe.FindStringSubmatch(l)[1])
+			msg, loc := block(i, jsLocRe)
+			add(testFailure{Name: name, Location: loc, Message: msg})
+		case mochaRe.MatchString(l) && strings.Contains(out, "failing"):
+			name := strings.TrimSpace(mochaRe.FindStringSubmatch(l)[1])
+			msg, loc := block(i, jsLocRe)
+			add(testFailure{Name: name, Location: loc, Message: msg})
+		case vitestRe.MatchString(l) && len(failures) == 0:
+			add(testFailure{Name: strings.TrimSpace(vitestRe.FindStringSubmatch(l)[1])})
+		}
+	}
+	// A Go test that failed only through its subtests says nothing itself
+	kept := failures[:0]
+	for _, f := range failures {
+		f.Message = strings.TrimPrefix(f.Message, strings.TrimSpace(f.Location)+": ")
+		if f.Message == "" && slices.ContainsFunc(failures, func(g testFailure) bool { return strings.HasPrefix(g.Name, f.Name+"/") }) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	failures = kept
+	// Go code that doesn't compile fails before any test runs
+	if len(failures) == 0 {
+		for _, l := range lines {
+			if m := goBuildRe.FindStringSubmatch(l); m != nil {
+				add(testFailure{Name: "build: " + m[1], Location: m[1], Message: m[2]})
+			}
+		}
+	}
+	return failures
+}
+
+func (r testReport) String() string {
+	switch {
+	case r.Command == "":
+		return "Error: " + r.Killed
+	case r.Killed != "":
+		return fmt.Sprintf("%s✗ Tests did not finish (%s): %s%s", colorRed, r.Command, r.Killed, colorReset)
+	case r.Passed:
+		return fmt.Sprintf("%s✓ Tests pass (%s)%s", colorGreen, r.Command, colorReset)
+	case len(r.Failures) == 0:
+		return fmt.Sprintf("%s✗ Tests failed (%s); no failures recognised, last output:%s\n%s", colorRed, r.Command, colorReset, r.Tail)
+	}
+	var b strings.Builder
+	more := ""
+	if len(r.Failures) == testMaxFailures {
+		more = "+"
+	}
+	b.WriteString(fmt.Sprintf("%s✗ %d%s failing (%s)%s", colorRed, len(r.Failures), more, r.Command, colorReset))
+	for i, f := range r.Failures {
+		b.WriteString(fmt.Sprintf("\n%d. %s%s%s", i+1, colorYellow, f.Name, colorReset))
+		if f.Location != "" {
+			b.WriteString(fmt.Sprintf(" %s(%s)%s", colorGray, f.Location, colorReset))
+		}
+		for _, l := range strings.Split(strings.TrimSpace(f.Message), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				b.WriteString("\n   " + truncate(l, 300))
+			}
+		}
+	}
+	return b.String()
+}
+
+// The message for a --fix round
+func (r testReport) fixPrompt() string {
+	return fmt.Sprintf("[tests] Round %d/%d: these tests fail.\n%s\n\nFix the code so they pass (change a test only if it is wrong), using tool calls. The tests run again after your changes.",
+		testFix.Round, testFix.Max, stripANSI(r.String()))
+}
+
+// /test [--fix [rounds]] [filter]: runs the tests; with --fix and
+// failures it starts the loop and returns the first message to send
+func cmdTest(arg string) (string, string) {
+	rounds := 0
+	if rest, ok := strings.CutPrefix(arg, "--fix"); ok {
+		rounds = testFixRounds
+		arg = strings.TrimSpace(rest)
+		if n, tail, _ := strings.Cut(arg, " "); n != "" {
+			if v, err := strconv.Atoi(n); err == nil {
+				rounds, arg = v, strings.TrimSpace(tail)
+			}
+		}
+	}
+	filter := strings.TrimSpace(arg)
+	r := runTests(filter)
+	if rounds <= 0 || r.Passed || len(r.Failures) == 0 && r.Tail == "" {
+		return r.String(), ""
+	}
+	testFix.Filter, testFix.Round, testFix.Max = filter, 1, rounds
+	return r.String(), r.fixPrompt()
+}
+
+// After a --fix turn: runs the tests again and queues the next round as
+// autoPending, or ends the loop. madeChanges is false when the model
+// didn't call any tool.
+func continueTestFix(madeChanges bool) {
+	if testFix.Max == 0 {
+		return
+	}
+	stop := func(color, format string, args ...any) {
+		fmt.Printf(color+format+colorReset+"\n", args...)
+		testFix.Max = 0
+	}
+	if !madeChanges {
+		stop(colorYellow, "⚠ Test fixing stopped: the reply changed nothing")
+		return
+	}
+	fmt.Printf("\n%s─── Re-running tests ───%s\n", colorCyan, colorReset)
+	r := runTests(testFix.Filter)
+	fmt.Println(r.String())
+	switch {
+	case r.Passed:
+		stop(colorGreen, "✓ Green after %d round(s)", testFix.Round)
+	case testFix.Round >= testFix.Max:
+		stop(colorYellow, "⚠ Still failing after %d round(s); /test --fix to keep going", testFix.Round)
+	case len(r.Failures) == 0 && r.Tail == "":
+		stop(colorYellow, "⚠ Test fixing stopped")
+	default:
+		testFix.Round++
+		autoPending = r.fixPrompt()
+		autoLabel = fmt.Sprintf("Fix round %d/%d: sending the failing tests", testFix.Round, testFix.Max)
+	}
+}
+
+// test:filter tool
+func cmdTestTool(filter string) string {
+	r := runTests(strings.TrimSpace(filter))
+	outputShown = false // the summary is new, print it in full
+	toolFailure = nil   // the report says how it went, not the command's exit
+	out := r.String()
+	if r.Command != "" && !r.Passed {
+		first, rest, _ := strings.Cut(stripANSI(out), "\n")
+		toolFailure = &ToolError{Code: "test_failures", Message: strings.TrimPrefix(first, "✗ "), Hint: "fix the code (a test only if it is wrong), then run test again", Output: rest}
+	}
+	return out
+}
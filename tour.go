@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ==================== TOUR ====================
+
+// `mytool tour` walks a new user through the everyday commands against a
+// throwaway directory: nothing touches a real repository and no API key
+// is needed, since every step runs locally.
+
+var tourFiles = map[string]string{
+	"hello.py":  "def greet(name):\n    return f\"Hello, {name}!\"\n\nprint(greet(\"tour\"))\n",
+	"notes.md":  "# Notes\n\n- greet() lives in hello.py\n- run.sh prints the files in this directory\n",
+	"run.sh":    "#!/bin/sh\necho \"Files here:\"\nls\n",
+	"README.md": "Scratch project for `mytool tour`. Safe to delete.\n",
+}
+
+type tourStep struct {
+	Title   string
+	Explain string
+	Command string // what the user is asked to type
+	Setup   func()
+	Run     func(input string) string
+}
+
+func runTour() {
+	dir, err := os.MkdirTemp("", "mytool-tour-")
+	if err != nil {
+		fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+		return
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range tourFiles {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	// Everything runs in the scratch dir; put the real state back afterwards
+	oldDir, oldMode, oldUndo, oldRedo := currentDir, currentMode, undoStack, redoStack
+	currentDir, currentMode, undoStack, redoStack = dir, ModeAsk, nil, nil
+	defer func() { currentDir, currentMode, undoStack, redoStack = oldDir, oldMode, oldUndo, oldRedo }()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	steps := tourSteps(scanner)
+
+	fmt.Printf("%s👋 Welcome to mytool!%s\n\n", colorCyan, colorReset)
+	fmt.Printf("This tour runs in a scratch directory that is deleted at the end:\n  %s%s%s\n", colorGray, dir, colorReset)
+	fmt.Printf("Type the command shown at each step, %sskip%s to move on or %sq%s to quit.\n", colorYellow, colorReset, colorYellow, colorReset)
+
+	for i, s := range steps {
+		fmt.Printf("\n%s── %d/%d %s ──%s\n", colorCyan, i+1, len(steps), s.Title, colorReset)
+		if s.Setup != nil {
+			s.Setup()
+		}
+		fmt.Println(s.Explain)
+		for {
+			fmt.Printf("\n%stry:%s %s\n%s❯%s ", colorGray, colorReset, s.Command, colorGreen, colorReset)
+			if !scanner.Scan() {
+				return
+			}
+			input := strings.TrimSpace(scanner.Text())
+			if input == "q" || input == "quit" || input == "exit" {
+				fmt.Println("Tour ended — run `mytool tour` again any time.")
+				return
+			}
+			if input == "skip" {
+				break
+			}
+			if cmd := strings.Fields(s.Command)[0]; strings.HasPrefix(cmd, "/") && !strings.HasPrefix(input, cmd) {
+				fmt.Printf("%sNot quite — type %s (or skip)%s\n", colorYellow, s.Command, colorReset)
+				continue
+			}
+			fmt.Println(s.Run(input))
+			break
+		}
+	}
+
+	fmt.Printf("\n%s🎉 That's the tour!%s\n", colorGreen, colorReset)
+	fmt.Println("Next: cd into a project and run `mytool`. /help lists every command.")
+}
+
+func tourSteps(scanner *bufio.Scanner) []tourStep {
+	return []tourStep{
+		{
+			Title:   "Reading files",
+			Explain: "The model reads files with the same tools you have. /read shows a file with line numbers\nand syntax highlighting.",
+			Command: "/read hello.py",
+			Run:     func(input string) string { return handleCommand(input, scanner) },
+		},
+		{
+			Title: "Running commands",
+			Explain: "/run executes a shell command in the current directory. You are in Ask mode, so\n" +
+				"mytool asks before running anything — answer y.",
+			Command: "/run sh run.sh",
+			Run:     func(input string) string { return handleCommand(input, scanner) },
+		},
+		{
+			Title: "@mentions",
+			Explain: "Mention a file with @name in a message and its contents are attached, so the model\n" +
+				"doesn't need a tool call to see it. Here is what would be sent (nothing is sent during the tour).",
+			Command: "what does @notes.md say about greet?",
+			Run: func(input string) string {
+				if !strings.Contains(input, "@") {
+					input = "what does @notes.md say about greet?"
+				}
+				return fmt.Sprintf("%s%s%s", colorGray, processAtMentions(input), colorReset)
+			},
+		},
+		{
+			Title: "Modes",
+			Explain: "Modes decide how much the model may do on its own:\n" +
+				"  " + fmt.Sprintf("%s●Auto%s", colorGreen, colorReset) + "    runs tools without asking\n" +
+				"  " + fmt.Sprintf("%s●Ask%s", colorYellow, colorReset) + "     confirms writes and commands\n" +
+				"  " + fmt.Sprintf("%s●Manual%s", colorRed, colorReset) + "  blocks writes and commands\n" +
+				"/mode cycles Auto → Ask → Manual.",
+			Command: "/mode",
+			Run: func(string) string {
+				cycleMode()
+				out := fmt.Sprintf("Mode: %s", getModeDisplay())
+				if currentMode == ModeManual {
+					out += "\nA write attempted now: " + cmdWrite("notes.md|||overwritten")
+				}
+				return out
+			},
+		},
+		{
+			Title: "Undo",
+			Explain: "Every write the model makes can be undone. The tour just rewrote hello.py the way a model\n" +
+				"would — /undo lists the changes, /undo 1 puts back the latest and /redo applies it again.",
+			Command: "/undo 1",
+			Setup: func() {
+				currentMode = ModeAuto
+				fmt.Println(cmdWrite("hello.py|||print(\"rewritten by the model\")\n"))
+			},
+			Run: func(string) string {
+				return doUndo() + "\n" + cmdRead("hello.py")
+			},
+		},
+	}
+}
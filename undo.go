@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== UNDO / REDO ====================
+
+// Every change records the content its files had before it. /undo lists
+// them newest first; /undo 1 reverts the latest and /undo n puts the
+// files of an older change back to how they were before it (itself
+// recorded as a change). Undoing moves the current content onto the redo
+// stack, and any new change clears it.
+//
+// The undo stack is saved with the session: contents go to .mytool/undo/
+// in the project root of each file, named by their hash so an unchanged
+// file is stored once, and the session keeps only the hashes. Like the
+// other caches there, .mytool/.gitignore keeps them out of commits: they
+// can hold secrets the user has since removed.
+
+const undoLimit = 20
+
+func appendUndo(stack []UndoAction, action UndoAction) []UndoAction {
+	stack = append(stack, action)
+	if len(stack) > undoLimit {
+		stack = stack[1:]
+	}
+	return stack
+}
+
+// Files of an action: the batch members, or the action itself
+func undoFiles(action UndoAction) []UndoAction {
+	if action.Type == "batch" {
+		return action.Files
+	}
+	return []UndoAction{action}
+}
+
+// The same files as action, with the content they have now; for a move,
+// the move back
+func currentState(action UndoAction) UndoAction {
+	switch action.Type {
+	case "move":
+		return UndoAction{Type: "move", Path: action.From, From: action.Path, Op: action.Op, Time: time.Now()}
+	case "batch":
+		now := UndoAction{Type: "batch", Path: action.Path, Op: action.Op, Time: time.Now()}
+		for _, f := range action.Files {
+			now.Files = append(now.Files, currentState(f))
+		}
+		return now
+	}
+	now := undoSnapshot(action.Path)
+	now.Op = action.Op
+	return now
+}
+
+// Writes back the recorded contents, directories and modes; an empty file
+// content means nothing existed there. Removals go last and in reverse,
+// so files leave before their directories.
+func restoreAction(action UndoAction) {
+	files := undoFiles(action)
+	for _, f := range files {
+		switch {
+		case f.Type == "move":
+			os.MkdirAll(filepath.Dir(f.From), 0755)
+			os.Rename(f.Path, f.From)
+		case f.Type == "dir":
+			os.MkdirAll(f.Path, 0755)
+		case f.Content != "":
+			os.MkdirAll(filepath.Dir(f.Path), 0755)
+			os.WriteFile(f.Path, []byte(f.Content), 0644)
+		default:
+			continue
+		}
+		if f.Mode != 0 {
+			os.Chmod(f.Path, f.Mode)
+		}
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if f := files[i]; f.Type == "file" && f.Content == "" {
+			os.Remove(f.Path)
+		}
+	}
+}
+
+func doUndo() string {
+	if len(undoStack) == 0 {
+		return "Nothing to undo"
+	}
+	action := undoStack[len(undoStack)-1]
+	undoStack = undoStack[:len(undoStack)-1]
+	redoStack = appendUndo(redoStack, currentState(action))
+	restoreAction(action)
+
+	switch action.Type {
+	case "batch":
+		return fmt.Sprintf("%s✓ Undone: restored %d files%s", colorGreen, len(action.Files), colorReset)
+	case "move":
+		return fmt.Sprintf("%s✓ Undone: moved %s back to %s%s", colorGreen, relPath(action.Path), relPath(action.From), colorReset)
+	case "dir":
+		return fmt.Sprintf("%s✓ Undone: restored %s%s", colorGreen, action.Path, colorReset)
+	}
+	if action.Content == "" {
+		return fmt.Sprintf("%s✓ Undone: removed %s%s", colorGreen, action.Path, colorReset)
+	}
+	return fmt.Sprintf("%s✓ Undone: restored %s%s", colorGreen, action.Path, colorReset)
+}
+
+func doRedo() string {
+	if len(redoStack) == 0 {
+		return "Nothing to redo"
+	}
+	action := redoStack[len(redoStack)-1]
+	redoStack = redoStack[:len(redoStack)-1]
+	undoStack = appendUndo(undoStack, currentState(action))
+	restoreAction(action)
+	target := undoTarget(action)
+	if action.Type == "move" { // recorded the way back
+		target = relPath(action.Path) + " → " + relPath(action.From)
+	}
+	return fmt.Sprintf("%s✓ Redone: %s %s%s", colorGreen, undoOp(action), target, colorReset)
+}
+
+// /undo lists, /undo n reverts change n of the list
+func cmdUndo(arg string) string {
+	if arg == "" {
+		return listUndo()
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(undoStack) {
+		return fmt.Sprintf("Usage: /undo [n] (1-%d from the list, 1 = latest)", len(undoStack))
+	}
+	if n == 1 {
+		return doUndo()
+	}
+	action := undoStack[len(undoStack)-n]
+	restore := currentState(action)
+	restore.Op = fmt.Sprintf("undo %d", n)
+	pushUndo(restore)
+	restoreAction(action)
+	return fmt.Sprintf("%s✓ %s put back as before change %d (%s); /undo 1 reverts this%s",
+		colorGreen, undoTarget(action), n, undoOp(action), colorReset)
+}
+
+func undoOp(action UndoAction) string {
+	if action.Op != "" {
+		return action.Op
+	}
+	return action.Type
+}
+
+func undoTarget(action UndoAction) string {
+	switch action.Type {
+	case "batch":
+		return fmt.Sprintf("%s (%d files)", action.Path, len(action.Files))
+	case "move":
+		return relPath(action.From) + " → " + relPath(action.Path)
+	}
+	return relPath(action.Path)
+}
+
+// Size of path after the change at index i: the content recorded by the
+// next change to it, or the file as it is now; false once it was moved
+func sizeAfter(i int, path string) (int, bool) {
+	for _, later := range undoStack[i+1:] {
+		for _, f := range undoFiles(later) {
+			if f.Type == "move" && f.From == path {
+				return 0, false
+			}
+			if f.Path == path {
+				return len(f.Content), true
+			}
+		}
+	}
+	data, _ := os.ReadFile(path)
+	return len(data), true
+}
+
+func listUndo() string {
+	if len(undoStack) == 0 {
+		if len(redoStack) > 0 {
+			return fmt.Sprintf("Nothing to undo (%d undone, /redo)", len(redoStack))
+		}
+		return "Nothing to undo"
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%sChanges (newest first):%s\n", colorCyan, colorReset))
+	for n := 1; n <= len(undoStack); n++ {
+		i := len(undoStack) - n
+		action := undoStack[i]
+		delta := 0
+		for _, f := range undoFiles(action) {
+			if size, ok := sizeAfter(i, f.Path); ok && f.Type == "file" {
+				delta += size - len(f.Content)
+			}
+		}
+		sign, color := "+", colorGreen
+		if delta < 0 {
+			sign, color, delta = "-", colorRed, -delta
+		}
+		when := action.Time.Format("15:04:05")
+		if action.Time.Format(time.DateOnly) != time.Now().Format(time.DateOnly) {
+			when = action.Time.Format("Jan 02 15:04")
+		}
+		b.WriteString(fmt.Sprintf("  %s%2d%s  %-12s  %-10s %-32s %s%s%s%s\n", colorYellow, n, colorReset,
+			when, undoOp(action), undoTarget(action), color, sign, formatSize(int64(delta)), colorReset))
+	}
+	b.WriteString(fmt.Sprintf("%s/undo <n> reverts a change (1 = latest)", colorGray))
+	if len(redoStack) > 0 {
+		b.WriteString(fmt.Sprintf(", /redo re-applies %d undone", len(redoStack)))
+	}
+	return b.String() + colorReset
+}
+
+// ---- persistence ----
+
+type undoRecord struct {
+	Type  string       `json:"type"`
+	Path  string       `json:"path"`
+	Hash  string       `json:"hash,omitempty"` // "" = the file did not exist
+	Op    string       `json:"op,omitempty"`
+	From  string       `json:"from,omitempty"`
+	Mode  os.FileMode  `json:"mode,omitempty"`
+	Time  time.Time    `json:"time"`
+	Files []undoRecord `json:"files,omitempty"`
+}
+
+// .mytool/undo in the project root of path, else in ~/.mytool
+func undoBlobDir(path string) string {
+	if root := projectRootFor(filepath.Dir(path)); root != "" {
+		ignoreProjectCaches(root)
+		return filepath.Join(root, ".mytool", "undo")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "undo")
+}
+
+// Files in a project's .mytool that are caches, not settings to commit
+var projectCaches = []string{"undo/", "index.json", "migration.json"}
+
+// Makes sure .mytool/.gitignore lists the caches, so /commit -a and
+// worktree merges don't pick them up; instructions.md, policy.yaml and
+// the rest stay committable
+func ignoreProjectCaches(root string) {
+	path := filepath.Join(root, ".mytool", ".gitignore")
+	data, _ := os.ReadFile(path)
+	listed := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		listed[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, name := range projectCaches {
+		if !listed[name] && !listed["/"+name] {
+			missing = append(missing, "/"+name)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	content := string(data)
+	if content == "" {
+		content = "# mytool caches\n"
+	} else if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	writeFileAtomic(path, []byte(content+strings.Join(missing, "\n")+"\n"), 0644)
+}
+
+func undoRecords(stack []UndoAction) []undoRecord {
+	var records []undoRecord
+	for _, action := range stack {
+		r := undoRecord{Type: action.Type, Path: action.Path, Op: action.Op, From: action.From, Mode: action.Mode, Time: action.Time}
+		if action.Type == "batch" {
+			r.Files = undoRecords(action.Files)
+		} else if action.Content != "" {
+			r.Hash = contentHash(action.Content)
+			blob := filepath.Join(undoBlobDir(action.Path), r.Hash)
+			if _, err := os.Stat(blob); err != nil {
+				os.MkdirAll(filepath.Dir(blob), 0755)
+				if os.WriteFile(blob, []byte(action.Content), 0644) != nil {
+					continue
+				}
+			}
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// The stack saved with a session; changes whose contents are gone are dropped
+func restoreUndo(records []undoRecord) []UndoAction {
+	var stack []UndoAction
+	for _, r := range records {
+		action, ok := undoFromRecord(r)
+		if ok {
+			stack = appendUndo(stack, action)
+		}
+	}
+	return stack
+}
+
+func undoFromRecord(r undoRecord) (UndoAction, bool) {
+	action := UndoAction{Type: r.Type, Path: r.Path, Op: r.Op, From: r.From, Mode: r.Mode, Time: r.Time}
+	if r.Type == "batch" {
+		for _, f := range r.Files {
+			file, ok := undoFromRecord(f)
+			if !ok {
+				return action, false
+			}
+			action.Files = append(action.Files, file)
+		}
+		return action, len(action.Files) > 0
+	}
+	if r.Hash != "" {
+		data, err := os.ReadFile(filepath.Join(undoBlobDir(r.Path), r.Hash))
+		if err != nil {
+			return action, false
+		}
+		action.Content = string(data)
+	}
+	return action, true
+}
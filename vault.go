@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ==================== ENCRYPTION AT REST ====================
+
+// With the vault enabled, memory files, the API key file and session
+// contents are stored encrypted (AES-256-GCM, as for cloud sync). The key
+// comes from a passphrase (asked once per start, or MYTOOL_PASSPHRASE) or
+// is a random key kept in the OS keychain. Everything else reads and
+// writes through readPrivateFile / updatePrivateFile / sealString and so
+// doesn't notice. Files written before the vault was enabled are still
+// read as plain text.
+//
+// Encrypted sessions aren't full-text indexed; search matches names only.
+//
+//	~/.mytool/vault.json   source, salt, key check (plain)
+
+type vaultMeta struct {
+	Version int    `json:"version"`
+	Source  string `json:"source"` // passphrase or keychain
+	Salt    []byte `json:"salt,omitempty"`
+	Check   []byte `json:"check"` // "mytool-vault" sealed with the key
+}
+
+const (
+	atRestMagic     = "mytool-enc:1:"
+	vaultCheck      = "mytool-vault"
+	keychainService = "mytool-vault"
+)
+
+var (
+	vaultKey       []byte // nil while the vault is off
+	errVaultLocked = errors.New("encrypted, and the vault is locked")
+)
+
+func vaultPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "vault.json")
+}
+
+func loadVaultMeta() (vaultMeta, bool) {
+	var m vaultMeta
+	data, err := os.ReadFile(vaultPath())
+	if err != nil || json.Unmarshal(data, &m) != nil {
+		return m, false
+	}
+	return m, true
+}
+
+// Files holding memory or credentials
+func privateFiles() []string {
+	home, _ := os.UserHomeDir()
+	return []string{
+		filepath.Join(home, ".mytool", "memory.json"),
+		projectMemoryPath(),
+		memoryVectorsPath(),
+		memoryMetaPath(),
+		filepath.Join(home, ".mytool_key"),
+	}
+}
+
+func sealAtRest(key, plain []byte) []byte {
+	if key == nil {
+		return plain
+	}
+	sealed, err := sealGCM(key, plain)
+	if err != nil {
+		return plain
+	}
+	return []byte(atRestMagic + base64.StdEncoding.EncodeToString(sealed))
+}
+
+func openAtRest(data []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(string(data), atRestMagic)
+	if !ok {
+		return data, nil
+	}
+	if vaultKey == nil {
+		return nil, errVaultLocked
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest))
+	if err != nil {
+		return nil, err
+	}
+	return openGCM(vaultKey, sealed)
+}
+
+func readPrivateFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return openAtRest(data)
+}
+
+func writePrivateFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, sealAtRest(vaultKey, data), perm)
+}
+
+// updateFile for a private file: fn sees and returns plain text
+func updatePrivateFile(path string, perm os.FileMode, fn func(old []byte) []byte) error {
+	var openErr error
+	err := updateFile(path, perm, func(old []byte) []byte {
+		plain, err := openAtRest(old)
+		if err != nil {
+			openErr = err
+			return nil // never overwrite what we can't read
+		}
+		data := fn(plain)
+		if data == nil {
+			return nil
+		}
+		return sealAtRest(vaultKey, data)
+	})
+	if openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// For session columns
+func sealString(s string) string {
+	return string(sealAtRest(vaultKey, []byte(s)))
+}
+
+func openString(s string) string {
+	plain, err := openAtRest([]byte(s))
+	if err != nil {
+		return "(encrypted)"
+	}
+	return string(plain)
+}
+
+// Called before anything private is read; exits when the vault is on but
+// can't be opened, rather than run with memory and sessions missing
+func unlockVault() {
+	meta, ok := loadVaultMeta()
+	if !ok {
+		return
+	}
+	key, err := vaultKeyFor(meta, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s🔒 Can't unlock the vault: %s%s\n", colorRed, err, colorReset)
+		os.Exit(1)
+	}
+	vaultKey = key
+}
+
+func vaultKeyFor(meta vaultMeta, confirm bool) ([]byte, error) {
+	var key []byte
+	switch meta.Source {
+	case "keychain":
+		secret, err := keychainGet(keychainService)
+		if err != nil {
+			return nil, fmt.Errorf("keychain: %s", err)
+		}
+		if key, err = base64.StdEncoding.DecodeString(secret); err != nil {
+			return nil, fmt.Errorf("keychain entry is not a mytool key")
+		}
+	default:
+		pass, err := vaultPassphrase(confirm)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = syncKey(pass, meta.Salt); err != nil {
+			return nil, err
+		}
+	}
+	if meta.Check != nil {
+		if check, err := openGCM(key, meta.Check); err != nil || string(check) != vaultCheck {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+	}
+	return key, nil
+}
+
+// MYTOOL_PASSPHRASE, else asked on the terminal
+func vaultPassphrase(confirm bool) (string, error) {
+	if p := os.Getenv("MYTOOL_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	tty, err := openTerminal()
+	if err != nil {
+		return "", fmt.Errorf("no terminal to ask for the passphrase; set MYTOOL_PASSPHRASE")
+	}
+	defer tty.Close()
+	ask := func(label string) string {
+		fmt.Fprintf(tty, "%s: ", label)
+		b, _ := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		return string(b)
+	}
+	pass := ask("🔒 Vault passphrase")
+	if pass == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	if confirm && ask("Repeat passphrase") != pass {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return pass, nil
+}
+
+// mytool vault [status | enable [--keychain] | disable]
+func runVaultCmd(args []string) {
+	sub := "status"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	meta, enabled := loadVaultMeta()
+	switch sub {
+	case "status":
+		if !enabled {
+			fmt.Println("Vault: off (memory, sessions and the API key are stored as plain text)")
+			return
+		}
+		fmt.Printf("Vault: %son%s (key from %s)\n", colorGreen, colorReset, meta.Source)
+	case "enable":
+		if enabled {
+			fmt.Println("The vault is already enabled")
+			return
+		}
+		meta = vaultMeta{Version: 1, Source: "passphrase"}
+		var key []byte
+		if len(args) > 1 && args[1] == "--keychain" {
+			meta.Source = "keychain"
+			key = make([]byte, 32)
+			rand.Read(key)
+			if err := keychainSet(keychainService, "mytool vault key", base64.StdEncoding.EncodeToString(key)); err != nil {
+				fmt.Printf("%sError: keychain: %s%s\n", colorRed, err, colorReset)
+				return
+			}
+		} else {
+			meta.Salt = make([]byte, 16)
+			rand.Read(meta.Salt)
+			var err error
+			if key, err = vaultKeyFor(meta, true); err != nil {
+				fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+				return
+			}
+		}
+		meta.Check, _ = sealGCM(key, []byte(vaultCheck))
+		// vault.json first: a half-finished pass still leaves everything readable
+		data, _ := json.MarshalIndent(meta, "", "  ")
+		if err := os.WriteFile(vaultPath(), data, 0600); err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		if err := recryptAtRest(key); err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		fmt.Printf("%s✓ Vault enabled: memory, sessions and the API key are now encrypted%s\n", colorGreen, colorReset)
+		if meta.Source == "passphrase" {
+			fmt.Printf("%sThe passphrase is asked at each start (or set MYTOOL_PASSPHRASE). It can't be recovered.%s\n", colorGray, colorReset)
+		}
+	case "disable":
+		if !enabled {
+			fmt.Println("The vault is not enabled")
+			return
+		}
+		if !confirmAction("Decrypt memory, sessions and the API key back to plain text?") {
+			return
+		}
+		if err := recryptAtRest(nil); err != nil {
+			fmt.Printf("%sError: %s%s\n", colorRed, err, colorReset)
+			return
+		}
+		os.Remove(vaultPath())
+		if meta.Source == "keychain" {
+			keychainDelete(keychainService)
+		}
+		fmt.Printf("%s✓ Vault disabled%s\n", colorGreen, colorReset)
+	default:
+		fmt.Println("Usage: mytool vault [status | enable [--keychain] | disable]")
+	}
+}
+
+// Rewrites every private file and session under key (nil: plain text).
+// Sessions are reindexed for search when they go back to plain text.
+func recryptAtRest(key []byte) error {
+	for _, path := range privateFiles() {
+		err := updateFile(path, 0600, func(old []byte) []byte {
+			if old == nil {
+				return nil
+			}
+			plain, err := openAtRest(old)
+			if err != nil {
+				return nil
+			}
+			return sealAtRest(key, plain)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := openSessionDB()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT id, title, summary, data FROM sessions`)
+	if err != nil {
+		return err
+	}
+	type row struct{ id, title, summary, data string }
+	var all []row
+	for rows.Next() {
+		var r row
+		rows.Scan(&r.id, &r.title, &r.summary, &r.data)
+		all = append(all, r)
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tx.Exec(`DELETE FROM sessions_fts`)
+	for _, r := range all {
+		data := openString(r.data)
+		var s Session
+		if json.Unmarshal([]byte(data), &s) != nil {
+			continue // unreadable, leave it as it is
+		}
+		reseal := func(v string) string { return string(sealAtRest(key, []byte(openString(v)))) }
+		if _, err := tx.Exec(`UPDATE sessions SET title = ?, summary = ?, data = ? WHERE id = ?`,
+			reseal(r.title), reseal(r.summary), reseal(r.data), r.id); err != nil {
+			return err
+		}
+		if key == nil {
+			tx.Exec(`INSERT INTO sessions_fts (id, content) VALUES (?, ?)`, r.id, sessionSearchText(s.History))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Don't leave the old plain text behind in free pages or the WAL
+	db.Exec(`INSERT INTO sessions_fts(sessions_fts) VALUES ('optimize')`)
+	db.Exec(`VACUUM`)
+	db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	vaultKey = key
+	return nil
+}
+
+// ==================== KEYCHAIN ====================
+
+// Secrets are stored per service name: the vault key, forge tokens
+
+func keychainGet(service string) (string, error) {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-a", os.Getenv("USER"), "-s", service, "-w")
+	case haveBinary("secret-tool"):
+		cmd = exec.Command("secret-tool", "lookup", "service", service)
+	default:
+		return "", fmt.Errorf("no keychain available (install secret-tool)")
+	}
+	out, err := cmd.Output()
+	if err != nil || len(strings.TrimSpace(string(out))) == 0 {
+		return "", fmt.Errorf("no %s entry", service)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func keychainSet(service, label, secret string) error {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		// -w without a value prompts for the secret (and its repetition)
+		// on stdin, keeping it out of the argument list ps shows
+		cmd = exec.Command("security", "add-generic-password", "-U", "-a", os.Getenv("USER"), "-s", service, "-w")
+		cmd.Stdin = strings.NewReader(secret + "\n" + secret + "\n")
+	case haveBinary("secret-tool"):
+		cmd = exec.Command("secret-tool", "store", "--label="+label, "service", service)
+		cmd.Stdin = strings.NewReader(secret)
+	default:
+		return fmt.Errorf("no keychain available (install secret-tool)")
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func keychainDelete(service string) {
+	switch {
+	case runtime.GOOS == "darwin":
+		exec.Command("security", "delete-generic-password", "-a", os.Getenv("USER"), "-s", service).Run()
+	case haveBinary("secret-tool"):
+		exec.Command("secret-tool", "clear", "service", service).Run()
+	}
+}
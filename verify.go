@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ==================== CLAIM VERIFIER ====================
+
+// Cross-checks what the assistant says it did against the tool calls it
+// actually emitted, so "I've updated the file" without a write is caught.
+// A call only backs a claim if it succeeded: a write that returned an
+// error changed nothing.
+
+type toolCall struct {
+	Name   string
+	Arg    string
+	Failed bool // its result was a ToolError
+}
+
+var (
+	editClaimRe = regexp.MustCompile(`(?i)\b(?:i(?:'ve| have)?\s+(?:just\s+)?(?:updated|modified|changed|edited|created|written|wrote|fixed|added|removed|deleted|renamed|replaced)|(?:has|have) been (?:updated|modified|changed|edited|created|written|fixed|added|removed|deleted)|saya\s+(?:sudah\s+|telah\s+)?(?:mengubah|memperbarui|membuat|menulis|mengedit|menambahkan|menghapus|memperbaiki)|(?:sudah|telah)\s+(?:diubah|diperbarui|dibuat|ditulis|diedit|ditambahkan|dihapus|diperbaiki))\b`)
+	runClaimRe  = regexp.MustCompile(`(?i)\b(?:i(?:'ve| have)?\s+(?:just\s+)?(?:ran|run|executed|installed|committed)|saya\s+(?:sudah\s+|telah\s+)?(?:menjalankan|menginstal|meng-commit))\b`)
+	fileRefRe   = regexp.MustCompile("`?([\\w./\\-]+\\.[A-Za-z0-9]{1,6})`?")
+	toolTagsRe  = regexp.MustCompile(`(?s)<tool>(.*?)</tool>`)
+	sentenceRe  = regexp.MustCompile(`[.!?]\s+`)
+)
+
+// Tools that change files are the WRITE group of toolDocs, those that run
+// commands the EXECUTE group, so new tools count once they are documented
+func toolGroup(name string) string {
+	for _, t := range toolDocs {
+		if t.Name == name {
+			return t.Group
+		}
+	}
+	return ""
+}
+
+// The paths a write tool call names; globs for bulk
+func writtenPaths(c toolCall) []string {
+	parts := strings.Split(c.Arg, "|||")
+	switch c.Name {
+	case "apply_patch":
+		var paths []string
+		for _, line := range strings.Split(c.Arg, "\n") {
+			if rest, ok := strings.CutPrefix(line, "+++ "); ok {
+				path := strings.Fields(rest + " ")[0]
+				if path != "/dev/null" {
+					paths = append(paths, strings.TrimPrefix(path, "b/"))
+				}
+			} else if rest, ok := strings.CutPrefix(line, "--- "); ok {
+				path := strings.Fields(rest + " ")[0]
+				if path != "/dev/null" {
+					paths = append(paths, strings.TrimPrefix(path, "a/"))
+				}
+			}
+		}
+		return paths
+	case "rename", "move":
+		return []string{strings.TrimSpace(parts[0]), strings.TrimSpace(parts[len(parts)-1])}
+	case "chmod":
+		if fields := strings.Fields(c.Arg); len(fields) > 1 {
+			return []string{strings.Join(fields[1:], " ")}
+		}
+	}
+	return []string{strings.TrimSpace(parts[0])}
+}
+
+// Tool calls in response, marked with how they went; results are in call
+// order as parseAndExecuteTools returns them
+func extractToolCalls(response string, results []ToolResult) []toolCall {
+	var calls []toolCall
+	for i, m := range toolTagsRe.FindAllStringSubmatch(response, -1) {
+		parts := strings.SplitN(m[1], ":", 2)
+		call := toolCall{Name: strings.TrimSpace(parts[0])}
+		if len(parts) > 1 {
+			call.Arg = strings.TrimSpace(parts[1])
+		}
+		call.Failed = i < len(results) && results[i].Err != nil
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// Returns one line per claim that isn't backed by a tool call
+func verifyClaims(text string, calls []toolCall) []string {
+	var written []string
+	wrote, ran := false, false
+	for _, c := range calls {
+		if c.Failed {
+			continue
+		}
+		switch toolGroup(c.Name) {
+		case "WRITE":
+			wrote = true
+			written = append(written, writtenPaths(c)...)
+		case "EXECUTE":
+			ran = true
+		}
+	}
+
+	var issues []string
+	for _, sentence := range splitSentences(text) {
+		if editClaimRe.MatchString(sentence) {
+			if !wrote {
+				issues = append(issues, fmt.Sprintf("claims a file change but no file tool succeeded: %q", truncate(sentence, 100)))
+				continue
+			}
+			for _, m := range fileRefRe.FindAllStringSubmatch(sentence, -1) {
+				if !touchedFile(m[1], written) {
+					issues = append(issues, fmt.Sprintf("claims to have changed %s but no tool wrote to it successfully", m[1]))
+				}
+			}
+		}
+		if runClaimRe.MatchString(sentence) && !ran {
+			issues = append(issues, fmt.Sprintf("claims to have run a command but none succeeded: %q", truncate(sentence, 100)))
+		}
+	}
+	return issues
+}
+
+func touchedFile(ref string, written []string) bool {
+	for _, w := range written {
+		if filepath.Base(w) == filepath.Base(ref) || strings.HasSuffix(resolvePath(w), ref) {
+			return true
+		}
+		if ok, _ := filepath.Match(filepath.Base(w), filepath.Base(ref)); ok {
+			return true // a bulk glob
+		}
+	}
+	return false
+}
+
+func splitSentences(text string) []string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		for _, s := range sentenceRe.Split(line, -1) {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// Note prepended to the next user message so the model can correct itself
+func verifierNote(issues []string) string {
+	return "[verifier] In your previous reply you described actions that were not performed:\n- " +
+		strings.Join(issues, "\n- ") +
+		"\nIf they are still needed, perform them with tool calls; otherwise correct your statement."
+}
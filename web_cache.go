@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================== WEB CACHE ====================
+
+// fetch and search results are cached in ~/.mytool/cache/web, one JSON file
+// per URL/query. Entries expire after settings.WebCacheTTLMinutes and the
+// oldest are evicted once the folder grows past settings.WebCacheMaxMB.
+
+type webCacheEntry struct {
+	Kind   string    `json:"kind"` // "page" or "search"
+	Key    string    `json:"key"`
+	Time   time.Time `json:"time"`
+	Output string    `json:"output"`
+}
+
+func webCacheDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mytool", "cache", "web")
+}
+
+func webCachePath(kind, key string) string {
+	sum := sha256.Sum256([]byte(kind + "\x00" + key))
+	return filepath.Join(webCacheDir(), hex.EncodeToString(sum[:16])+".json")
+}
+
+// Returns the cached output when fresh, otherwise calls load and stores its
+// result if ok
+func cachedWeb(kind, key string, load func() (string, bool)) string {
+	ttl := time.Duration(settings.WebCacheTTLMinutes) * time.Minute
+	if ttl <= 0 {
+		out, _ := load()
+		return out
+	}
+
+	path := webCachePath(kind, key)
+	if data, err := os.ReadFile(path); err == nil {
+		var e webCacheEntry
+		if json.Unmarshal(data, &e) == nil && e.Key == key && time.Since(e.Time) < ttl {
+			return fmt.Sprintf("%s[cached %s ago]%s\n%s", colorGray, time.Since(e.Time).Round(time.Second), colorReset, e.Output)
+		}
+	}
+
+	out, ok := load()
+	if ok {
+		data, _ := json.Marshal(webCacheEntry{Kind: kind, Key: key, Time: time.Now(), Output: out})
+		os.MkdirAll(webCacheDir(), 0755)
+		tmp := path + ".tmp"
+		if os.WriteFile(tmp, data, 0644) == nil {
+			os.Rename(tmp, path)
+		}
+		trimWebCache(int64(settings.WebCacheMaxMB) << 20)
+	}
+	return out
+}
+
+type cacheFile struct {
+	path string
+	size int64
+	mod  time.Time
+}
+
+func webCacheFiles() []cacheFile {
+	entries, _ := os.ReadDir(webCacheDir())
+	var files []cacheFile
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, cacheFile{filepath.Join(webCacheDir(), e.Name()), info.Size(), info.ModTime()})
+		}
+	}
+	return files
+}
+
+// Drops expired entries, then the oldest until under maxBytes
+func trimWebCache(maxBytes int64) {
+	files := webCacheFiles()
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+	ttl := time.Duration(settings.WebCacheTTLMinutes) * time.Minute
+	var total int64
+	for _, f := range files {
+		total += f.size
+		if (maxBytes > 0 && total > maxBytes) || (ttl > 0 && time.Since(f.mod) > ttl) {
+			os.Remove(f.path)
+		}
+	}
+}
+
+// /cache [list|clear]
+func cmdCache(arg string) string {
+	switch arg {
+	case "list":
+		files := webCacheFiles()
+		if len(files) == 0 {
+			return "Web cache is empty"
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+		ttl := time.Duration(settings.WebCacheTTLMinutes) * time.Minute
+		var b strings.Builder
+		for i, f := range files {
+			if i == 30 {
+				b.WriteString(fmt.Sprintf("%s+%d more%s\n", colorGray, len(files)-30, colorReset))
+				break
+			}
+			var e webCacheEntry
+			data, _ := os.ReadFile(f.path)
+			if json.Unmarshal(data, &e) != nil {
+				continue
+			}
+			age := time.Since(e.Time)
+			color := colorReset
+			if ttl <= 0 || age > ttl {
+				color = colorGray // expired, goes at the next trim
+			}
+			b.WriteString(fmt.Sprintf("  %s%6s  %-6s %s%s\n", color, age.Round(time.Minute), e.Kind, truncate(e.Key, 80), colorReset))
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	case "clear":
+		n := len(webCacheFiles())
+		os.RemoveAll(webCacheDir())
+		return fmt.Sprintf("%s✓ Cleared %d cached results%s", colorGreen, n, colorReset)
+	case "":
+		var total int64
+		files := webCacheFiles()
+		for _, f := range files {
+			total += f.size
+		}
+		return fmt.Sprintf("Web cache: %d entries, %s / %dMB, TTL %s\n%sUsage: /cache list | clear%s",
+			len(files), formatSize(total), settings.WebCacheMaxMB, minutesOrOff(settings.WebCacheTTLMinutes), colorGray, colorReset)
+	}
+	return "Usage: /cache [list|clear]"
+}
+
+func minutesOrOff(m int) string {
+	switch {
+	case m <= 0:
+		return "off"
+	case m%60 == 0:
+		return fmt.Sprintf("%dh", m/60)
+	}
+	return fmt.Sprintf("%dm", m)
+}
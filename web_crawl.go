@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ==================== CRAWL ====================
+
+// crawl:url [depth=N] [pages=N] reads a page and the pages it links to,
+// breadth first, for a whole docs section at once. Only links on the
+// same host and under the start page's directory are followed, so
+// https://example.com/docs/api/intro stays within /docs/api/. Each page
+// goes through fetchReadable, so robots.txt, host spacing and the cache
+// all apply; the pages share one output budget.
+
+const (
+	crawlDefaultDepth = 2
+	crawlMaxDepth     = 4
+	crawlDefaultPages = 20
+	crawlMaxPages     = 60
+	crawlMaxChars     = 30000 // all pages together
+)
+
+var (
+	crawlLinkRe = regexp.MustCompile(`\]\((https?://[^)\s]+)\)|<(https?://[^>\s]+)>`)
+	crawlSkipRe = regexp.MustCompile(`(?i)\.(?:png|jpe?g|gif|svg|webp|ico|pdf|zip|tar|gz|tgz|whl|exe|dmg|mp4|mp3|woff2?|css|js)$`)
+)
+
+type crawledPage struct {
+	URL, Text string
+	Depth     int
+}
+
+// crawl:url [depth=N] [pages=N]
+func cmdCrawl(arg string) string {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return "Usage: crawl:url [depth=2] [pages=20]"
+	}
+	start, depth, limit := fields[0], crawlDefaultDepth, crawlDefaultPages
+	for _, f := range fields[1:] {
+		name, value, _ := strings.Cut(f, "=")
+		n, err := strconv.Atoi(value)
+		switch {
+		case err != nil || n < 0:
+			return "Usage: crawl:url [depth=2] [pages=20]"
+		case name == "depth":
+			depth = min(n, crawlMaxDepth)
+		case name == "pages" || name == "max":
+			limit = max(1, min(n, crawlMaxPages))
+		default:
+			return "Usage: crawl:url [depth=2] [pages=20]"
+		}
+	}
+	if !strings.HasPrefix(start, "http") {
+		start = "https://" + start
+	}
+	root, err := url.Parse(start)
+	if err != nil || root.Host == "" {
+		return fmt.Sprintf("Error: not a URL: %s", fields[0])
+	}
+	root.Fragment = ""
+	scope := root.Path
+	if scope == "" {
+		scope = "/"
+	} else if !strings.HasSuffix(scope, "/") {
+		scope = path.Dir(scope)
+		if !strings.HasSuffix(scope, "/") {
+			scope += "/"
+		}
+	}
+
+	var pages []crawledPage
+	var failed []string
+	seen := map[string]bool{root.String(): true}
+	queue := []crawledPage{{URL: root.String()}}
+	for len(queue) > 0 && len(pages) < limit {
+		page := queue[0]
+		queue = queue[1:]
+		showProgress(fmt.Sprintf("Crawling %s", truncate(page.URL, 50)), len(pages), limit)
+		text := stripANSI(fetchReadable(page.URL))
+		if strings.HasPrefix(text, "Error:") {
+			failed = append(failed, fmt.Sprintf("%s (%s)", page.URL, strings.TrimSpace(strings.TrimPrefix(text, "Error:"))))
+			if page.URL == root.String() {
+				fmt.Printf("\r%s\r", clearLine)
+				return text
+			}
+			continue
+		}
+		if strings.HasPrefix(text, "[cached ") {
+			_, text, _ = strings.Cut(text, "\n")
+		}
+		page.Text = text
+		pages = append(pages, page)
+		if page.Depth == depth {
+			continue
+		}
+		for _, m := range crawlLinkRe.FindAllStringSubmatch(text, -1) {
+			link := m[1] + m[2]
+			u, err := url.Parse(link)
+			if err != nil || u.Host != root.Host || !strings.HasPrefix(u.Path, scope) || crawlSkipRe.MatchString(u.Path) {
+				continue
+			}
+			u.Fragment = ""
+			if key := u.String(); !seen[key] {
+				seen[key] = true
+				queue = append(queue, crawledPage{URL: key, Depth: page.Depth + 1})
+			}
+		}
+	}
+	fmt.Printf("\r%s\r", clearLine)
+
+	// Every page gets an equal share of the budget; short pages leave
+	// theirs to the rest
+	share := crawlMaxChars / len(pages)
+	spare := 0
+	for _, p := range pages {
+		if len(p.Text) < share {
+			spare += share - len(p.Text)
+		}
+	}
+	long := 0
+	for _, p := range pages {
+		if len(p.Text) > share {
+			long++
+		}
+	}
+	if long > 0 {
+		share += spare / long
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%sCrawled %d pages under %s://%s%s (depth %d)%s\n", colorCyan, len(pages), root.Scheme, root.Host, scope, depth, colorReset))
+	for i, p := range pages {
+		b.WriteString(fmt.Sprintf("  %d. %s\n", i+1, p.URL))
+	}
+	if len(failed) > 0 {
+		b.WriteString(fmt.Sprintf("Failed: %s\n", strings.Join(failed, "; ")))
+	}
+	if left := len(queue); left > 0 {
+		b.WriteString(fmt.Sprintf("%d more links not followed (pages=%d)\n", left, limit))
+	}
+	for i, p := range pages {
+		text := p.Text
+		if len(text) > share {
+			text = text[:share] + "\n... (truncated)"
+		}
+		b.WriteString(fmt.Sprintf("\n===== %d. %s =====\n%s\n", i+1, p.URL, text))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ==================== DOWNLOAD ====================
+
+// download:url|||dest[|||sha256] saves a file as it arrives instead of
+// holding it in memory like fetch: release archives, datasets, binaries.
+// It goes through politeGet, so robots.txt and host spacing apply. The
+// file is written next to dest and renamed into place once it is complete
+// (and matches the checksum, when one is given), so a failed download
+// never leaves half a file behind.
+
+const (
+	downloadMaxBytes = 1 << 30 // 1 GB
+	downloadTimeout  = 30 * time.Minute
+)
+
+// Counts bytes as they pass and redraws the progress line now and then
+type downloadProgress struct {
+	name        string
+	done, total int64
+	drawn       time.Time
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if p.done > downloadMaxBytes {
+		return 0, fmt.Errorf("larger than the %s download limit", formatSize(downloadMaxBytes))
+	}
+	if time.Since(p.drawn) >= 100*time.Millisecond {
+		p.drawn = time.Now()
+		p.draw()
+	}
+	return len(b), nil
+}
+
+func (p *downloadProgress) draw() {
+	if p.total > 0 {
+		showProgress(fmt.Sprintf("Downloading %s %s/%s", p.name, formatSize(p.done), formatSize(p.total)), int(p.done*100/p.total), 100)
+	} else {
+		fmt.Printf("\r%sDownloading %s %s%s", colorGray, p.name, formatSize(p.done), colorReset)
+	}
+}
+
+// download:url|||dest[|||sha256]
+func cmdDownload(arg string) string {
+	parts := strings.Split(arg, "|||")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "Error: format url|||dest or url|||dest|||sha256"
+	}
+	rawURL, dest := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	want := ""
+	if len(parts) == 3 {
+		want = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(parts[2]), "sha256:"))
+		if _, err := hex.DecodeString(want); err != nil || len(want) != 64 {
+			return fmt.Sprintf("Error: %q is not a SHA-256 checksum (64 hex digits)", parts[2])
+		}
+	}
+	if !strings.HasPrefix(rawURL, "http") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Sprintf("Error: not a URL: %s", parts[0])
+	}
+	if dest == "" {
+		return "Error: format url|||dest or url|||dest|||sha256"
+	}
+
+	// A directory (or a path ending in /) gets the file's name from the URL
+	fullPath := resolvePath(dest)
+	if info, err := os.Stat(fullPath); (err == nil && info.IsDir()) || strings.HasSuffix(dest, "/") {
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			name = "download"
+		}
+		fullPath = filepath.Join(fullPath, name)
+	}
+
+	if currentMode == ModeManual {
+		return fmt.Sprintf("%s[blocked]%s", colorRed, colorReset)
+	}
+	if currentMode == ModeAsk && !confirmAction(fmt.Sprintf("%sDownload %s to %s?%s", colorYellow, rawURL, fullPath, colorReset)) {
+		return "Cancelled"
+	}
+
+	resp, err := politeGet(rawURL, downloadTimeout, true)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Sprintf("Error: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > downloadMaxBytes {
+		return fmt.Sprintf("Error: %s is larger than the %s download limit", formatSize(resp.ContentLength), formatSize(downloadMaxBytes))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".part-*")
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	hash := sha256.New()
+	progress := &downloadProgress{name: filepath.Base(fullPath), total: resp.ContentLength}
+	progress.draw()
+	_, err = io.Copy(io.MultiWriter(tmp, hash, progress), resp.Body)
+	fmt.Printf("\r%s\r", clearLine)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Sprintf("Error: download failed after %s: %s", formatSize(progress.done), err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if want != "" && sum != want {
+		return fmt.Sprintf("Error: SHA-256 mismatch, file discarded\n  expected %s\n  got      %s", want, sum)
+	}
+
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(fullPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	os.Chmod(tmp.Name(), perm)
+	saveForUndo(fullPath, "download")
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		undoStack = undoStack[:len(undoStack)-1]
+		return fmt.Sprintf("Error: %s", err)
+	}
+	verified := ""
+	if want != "" {
+		verified = ", checksum verified"
+	}
+	return fmt.Sprintf("%s✓ Downloaded %s (%s%s)%s\n  sha256 %s", colorGreen, fullPath, formatSize(progress.done), verified, colorReset, sum)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== POLITE FETCHING ====================
+
+// Every web tool request goes through politeGet: it identifies itself with
+// a real User-Agent, waits between requests to the same host, keeps at
+// most hostConcurrency of them open at once, backs off when the host
+// answers 429 or 503 with Retry-After, and (for fetch) checks the site's
+// robots.txt first.
+
+const (
+	hostConcurrency = 2                // open requests per host
+	maxRetryAfter   = 60 * time.Second // longer waits are reported, not slept
+)
+
+type robotsRules struct {
+	allow    []string
+	disallow []string
+	delay    time.Duration // Crawl-delay
+}
+
+var (
+	politeMu     sync.Mutex
+	robotsCache  = map[string]*robotsRules{} // keyed by scheme://host
+	nextHostSlot = map[string]time.Time{}
+	hostSlots    = map[string]chan struct{}{}
+	hostBackoff  = map[string]time.Time{} // Retry-After the host last sent
+)
+
+func webUserAgent() string {
+	if settings.WebUserAgent != "" {
+		return settings.WebUserAgent
+	}
+	return fmt.Sprintf("mytool/%s (+https://github.com/zesbe/mytool)", version)
+}
+
+// GET with User-Agent, per-host spacing and, when checkRobots is set,
+// robots.txt enforcement. A 429 or 503 with a short Retry-After is retried
+// once after waiting. The response must be closed to free its host slot.
+func politeGet(rawURL string, timeout time.Duration, checkRobots bool) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	checkRobots = checkRobots && settings.RespectRobots
+	var rules *robotsRules
+	if checkRobots {
+		if rules, err = robotsCheck(u); err != nil {
+			return nil, err
+		}
+	}
+	delay := time.Duration(settings.WebHostDelayMs) * time.Millisecond
+	if rules != nil && rules.delay > delay {
+		delay = rules.delay
+	}
+	politeMu.Lock()
+	until := hostBackoff[u.Host]
+	politeMu.Unlock()
+	if time.Until(until) > maxRetryAfter {
+		return nil, fmt.Errorf("%s asked for no requests until %s (Retry-After)", u.Host, until.Format("15:04:05"))
+	}
+	release := acquireHost(u.Host)
+	client := &http.Client{Timeout: timeout, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		if checkRobots {
+			_, err := robotsCheck(req.URL) // where it redirects to has its own rules
+			return err
+		}
+		return nil
+	}}
+	for retried := false; ; retried = true {
+		waitForHost(u.Host, delay)
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		req.Header.Set("User-Agent", webUserAgent())
+		resp, err := client.Do(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			wait := retryAfter(resp.Header.Get("Retry-After"))
+			if wait > 0 {
+				backOffHost(u.Host, wait)
+			}
+			if wait > 0 && wait <= maxRetryAfter && !retried {
+				resp.Body.Close()
+				continue
+			}
+		}
+		resp.Body = &hostBody{ReadCloser: resp.Body, release: release}
+		return resp, nil
+	}
+}
+
+// Holds one of host's request slots until the body is closed
+type hostBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *hostBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// Waits for one of host's hostConcurrency slots; call release when done
+func acquireHost(host string) (release func()) {
+	politeMu.Lock()
+	slots, ok := hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, hostConcurrency)
+		hostSlots[host] = slots
+	}
+	politeMu.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// Retry-After as seconds or an HTTP date; 0 when missing or past
+func retryAfter(value string) time.Duration {
+	if secs, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// Keeps every request to host waiting until the server's Retry-After passes
+func backOffHost(host string, wait time.Duration) {
+	politeMu.Lock()
+	until := time.Now().Add(wait)
+	hostBackoff[host] = until
+	if nextHostSlot[host].Before(until) {
+		nextHostSlot[host] = until
+	}
+	politeMu.Unlock()
+}
+
+// Reserves the next slot for host and sleeps until it arrives
+func waitForHost(host string, delay time.Duration) {
+	politeMu.Lock()
+	now := time.Now()
+	slot := nextHostSlot[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	nextHostSlot[host] = slot.Add(delay)
+	politeMu.Unlock()
+	time.Sleep(time.Until(slot))
+}
+
+// The rules for u's host, or why u may not be fetched
+func robotsCheck(u *url.URL) (*robotsRules, error) {
+	rules, err := robotsFor(u)
+	if err != nil {
+		return nil, fmt.Errorf("%s://%s/robots.txt could not be fetched, so neither is %s: %s (respect_robots can be turned off in /settings)", u.Scheme, u.Host, u, err)
+	}
+	if !rules.allowed(robotsPath(u)) {
+		return nil, fmt.Errorf("%s is disallowed by %s://%s/robots.txt (respect_robots can be turned off in /settings)", u.RequestURI(), u.Scheme, u.Host)
+	}
+	return rules, nil
+}
+
+// What robots.txt rules are matched against: the path with its query
+func robotsPath(u *url.URL) string {
+	if u.RawQuery != "" {
+		return u.EscapedPath() + "?" + u.RawQuery
+	}
+	return u.EscapedPath()
+}
+
+// Fetched once per host per run. Per RFC 9309 a missing robots.txt allows
+// everything and a server error disallows everything; when it can't be
+// reached at all the error is returned and it is tried again next time.
+func robotsFor(u *url.URL) (*robotsRules, error) {
+	origin := u.Scheme + "://" + u.Host
+	politeMu.Lock()
+	rules, ok := robotsCache[origin]
+	politeMu.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	rules = &robotsRules{}
+	req, _ := http.NewRequest("GET", origin+"/robots.txt", nil)
+	req.Header.Set("User-Agent", webUserAgent())
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		rules.disallow = []string{"/"}
+	case resp.StatusCode < 300:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512*1024))
+		rules = parseRobots(string(body), "mytool")
+	}
+	resp.Body.Close()
+
+	politeMu.Lock()
+	robotsCache[origin] = rules
+	politeMu.Unlock()
+	return rules, nil
+}
+
+// Picks the group naming our agent (compared case-insensitively, as a
+// whole), else the "*" group
+func parseRobots(body, agent string) *robotsRules {
+	type group struct {
+		agents []string
+		rules  robotsRules
+	}
+	var groups []*group
+	var cur *group
+	inRules := false
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		switch field {
+		case "user-agent":
+			if cur == nil || inRules {
+				cur = &group{}
+				groups = append(groups, cur)
+				inRules = false
+			}
+			cur.agents = append(cur.agents, strings.ToLower(value))
+		case "allow", "disallow", "crawl-delay":
+			if cur == nil {
+				continue
+			}
+			inRules = true
+			switch {
+			case field == "allow" && value != "":
+				cur.rules.allow = append(cur.rules.allow, value)
+			case field == "disallow" && value != "":
+				cur.rules.disallow = append(cur.rules.disallow, value)
+			case field == "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil {
+					cur.rules.delay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	var star *robotsRules
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if strings.EqualFold(a, agent) {
+				return &g.rules
+			}
+			if a == "*" && star == nil {
+				star = &g.rules
+			}
+		}
+	}
+	if star != nil {
+		return star
+	}
+	return &robotsRules{}
+}
+
+// Longest matching rule wins; Allow wins ties
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	best, allow := -1, true
+	for _, p := range r.allow {
+		if n := robotsMatch(p, path); n >= best && n >= 0 {
+			best, allow = n, true
+		}
+	}
+	for _, p := range r.disallow {
+		if n := robotsMatch(p, path); n > best {
+			best, allow = n, false
+		}
+	}
+	return allow
+}
+
+// Length of the pattern if it matches path, -1 otherwise; supports * and $
+func robotsMatch(pattern, path string) int {
+	if !strings.ContainsAny(pattern, "*$") {
+		if strings.HasPrefix(path, pattern) {
+			return len(pattern)
+		}
+		return -1
+	}
+	expr := regexp.QuoteMeta(strings.TrimSuffix(pattern, "$"))
+	expr = "^" + strings.ReplaceAll(expr, `\*`, ".*")
+	if strings.HasSuffix(pattern, "$") {
+		expr += "$"
+	}
+	if re, err := regexp.Compile(expr); err == nil && re.MatchString(path) {
+		return len(pattern)
+	}
+	return -1
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== READABLE PAGES ====================
+
+// Pulls the main content out of an HTML page and writes it as markdown:
+// scripts, styles and page chrome (nav, header, footer, aside, forms, and
+// blocks whose class or id says sidebar, menu, cookie banner and the like)
+// are dropped, <article> or <main> is preferred over the whole body, and
+// headings, lists, links, emphasis, quotes, tables and code blocks (with
+// their language) come out as markdown. Regexp based, so it is a
+// heuristic, not a parser.
+
+var (
+	htmlDropRes   []*regexp.Regexp
+	htmlChrome    []*regexp.Regexp
+	htmlTitleRe   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlPreRe     = regexp.MustCompile(`(?is)<pre([^>]*)>(.*?)</pre>`)
+	htmlLangRe    = regexp.MustCompile(`(?i)\b(?:language|lang|highlight-source)-([\w+#-]+)`)
+	htmlQuoteRe   = regexp.MustCompile(`(?is)<blockquote[^>]*>(.*?)</blockquote>`)
+	htmlTableRe   = regexp.MustCompile(`(?is)<table[^>]*>(.*?)</table>`)
+	htmlRowRe     = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	htmlCellRe    = regexp.MustCompile(`(?is)<t([hd])[^>]*>(.*?)</t[hd]>`)
+	htmlOlRe      = regexp.MustCompile(`(?is)<ol[^>]*>(.*?)</ol>`)
+	htmlHeadRe    = regexp.MustCompile(`(?is)<h([1-6])[^>]*>(.*?)</h[1-6]>`)
+	htmlLinkRe    = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	htmlStrongRe  = regexp.MustCompile(`(?is)<(?:strong|b)\b[^>]*>(.*?)</(?:strong|b)>`)
+	htmlEmRe      = regexp.MustCompile(`(?is)<(?:em|i)\b[^>]*>(.*?)</(?:em|i)>`)
+	htmlCodeRe    = regexp.MustCompile(`(?is)<code[^>]*>(.*?)</code>`)
+	htmlLiRe      = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlHrRe      = regexp.MustCompile(`(?i)<hr\b[^>]*>`)
+	htmlBlockRe   = regexp.MustCompile(`(?i)</?(?:p|div|section|br|tr|table|ul|ol|dl|dt|dd|blockquote|figure|figcaption|details|summary)\b[^>]*>`)
+	htmlTagRe     = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlSpaceRe   = regexp.MustCompile(`[ \t\r\f\v]+`)
+	htmlBlankRe   = regexp.MustCompile(`\n{3,}`)
+	htmlMarkRe    = regexp.MustCompile("\x00(\\d+)\x00")
+	htmlOpenRe    = regexp.MustCompile(`(?i)<(div|section|ul|aside|span|table)\b([^>]*)>`)
+	htmlUnlikely  = regexp.MustCompile(`(?i)\b(?:class|id|role)\s*=\s*["'][^"']*(?:sidebar|menu|navbar|breadcrumb|cookie|consent|banner|share|social|related|comment|advert|\bads?\b|popup|modal|newsletter|subscribe|pagination|skip-link)[^"']*["']`)
+	htmlMaybe     = regexp.MustCompile(`(?i)\b(?:class|id|role)\s*=\s*["'][^"']*(?:article|content|main|body|post|entry)[^"']*["']`)
+	htmlMainAttrs = regexp.MustCompile(`(?i)<(div|section)\b[^>]*\b(?:role\s*=\s*["']main["']|id\s*=\s*["'](?:content|main-content|main)["'])[^>]*>`)
+)
+
+func init() {
+	for _, tag := range []string{"script", "style", "noscript", "svg", "template", "iframe", "button", "select"} {
+		htmlDropRes = append(htmlDropRes, regexp.MustCompile(`(?is)<`+tag+`\b.*?</`+tag+`>`))
+	}
+	htmlDropRes = append(htmlDropRes, regexp.MustCompile(`(?s)<!--.*?-->`))
+	for _, tag := range []string{"nav", "header", "footer", "aside", "form"} {
+		htmlChrome = append(htmlChrome, regexp.MustCompile(`(?is)<`+tag+`\b.*?</`+tag+`>`))
+	}
+}
+
+// Content of the outermost <tag>, or "" when the page has none
+func htmlSection(page, tag string) string {
+	lower := asciiLower(page)
+	start := strings.Index(lower, "<"+tag)
+	end := strings.LastIndex(lower, "</"+tag+">")
+	if start < 0 || end < start {
+		return ""
+	}
+	if gt := strings.Index(page[start:end], ">"); gt >= 0 {
+		return page[start+gt+1 : end]
+	}
+	return ""
+}
+
+// Lower case for tag names, keeping byte offsets the same as in s
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// End of the element whose start tag ends at from, matching nested tags
+// of the same name; len(page) when it isn't closed
+func htmlElementEnd(page, lower, name string, from int) int {
+	depth := 1
+	for i := from; ; {
+		j := strings.Index(lower[i:], name)
+		if j < 0 {
+			return len(page)
+		}
+		i += j
+		if k := i + len(name); k < len(lower) && (lower[k] >= 'a' && lower[k] <= 'z' || lower[k] == '-') {
+			i = k // a longer name
+			continue
+		}
+		switch {
+		case i > 0 && lower[i-1] == '<':
+			depth++
+		case i > 1 && lower[i-2:i] == "</":
+			if depth--; depth == 0 {
+				if gt := strings.IndexByte(lower[i:], '>'); gt >= 0 {
+					return i + gt + 1
+				}
+				return len(page)
+			}
+		}
+		i += len(name)
+	}
+}
+
+// The page without the blocks that look like chrome by class or id
+func dropUnlikely(page string) string {
+	var b strings.Builder
+	lower := asciiLower(page)
+	last := 0
+	for _, m := range htmlOpenRe.FindAllStringSubmatchIndex(page, -1) {
+		if m[0] < last {
+			continue // inside a block already dropped
+		}
+		attrs := page[m[4]:m[5]]
+		if !htmlUnlikely.MatchString(attrs) || htmlMaybe.MatchString(attrs) {
+			continue
+		}
+		b.WriteString(page[last:m[0]])
+		last = htmlElementEnd(page, lower, lower[m[2]:m[3]], m[1])
+	}
+	b.WriteString(page[last:])
+	return b.String()
+}
+
+// The content of a <div role="main"> or id="content" block, if any
+func htmlMainBlock(page string) string {
+	m := htmlMainAttrs.FindStringSubmatchIndex(page)
+	if m == nil {
+		return ""
+	}
+	lower := asciiLower(page)
+	end := htmlElementEnd(page, lower, lower[m[2]:m[3]], m[1])
+	return page[m[1]:end]
+}
+
+func htmlText(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(s, "")))
+}
+
+// Title and readable markdown of an HTML page; base, when set, is the
+// page's URL, for making links absolute
+func readablePage(page, base string) (string, string) {
+	for _, re := range htmlDropRes {
+		page = re.ReplaceAllString(page, "")
+	}
+	title := ""
+	if m := htmlTitleRe.FindStringSubmatch(page); m != nil {
+		title = htmlSpaceRe.ReplaceAllString(htmlText(m[1]), " ")
+	}
+
+	body := ""
+	for _, tag := range []string{"article", "main", "body"} {
+		if body = htmlSection(page, tag); strings.TrimSpace(body) != "" {
+			break
+		}
+	}
+	if body == "" {
+		body = page
+	}
+	if main := htmlMainBlock(body); strings.TrimSpace(htmlText(main)) != "" {
+		body = main
+	}
+	for _, re := range htmlChrome {
+		body = re.ReplaceAllString(body, "")
+	}
+	body = dropUnlikely(body)
+	baseURL, _ := url.Parse(base)
+	return title, htmlToMarkdown(body, baseURL)
+}
+
+// Markdown for an HTML fragment
+func htmlToMarkdown(body string, base *url.URL) string {
+	// Blocks that must keep their lines are set aside so whitespace
+	// folding leaves them alone, and put back at the end. Quotes and
+	// table cells are converted on their own, so they go first.
+	var blocks []string
+	setAside := func(block string) string {
+		blocks = append(blocks, block)
+		return fmt.Sprintf("\n\n\x00%d\x00\n\n", len(blocks)-1)
+	}
+	body = htmlQuoteRe.ReplaceAllStringFunc(body, func(m string) string {
+		lines := strings.Split(htmlToMarkdown(htmlQuoteRe.FindStringSubmatch(m)[1], base), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace("> " + line)
+		}
+		return setAside(strings.Join(lines, "\n"))
+	})
+	body = htmlTableRe.ReplaceAllStringFunc(body, func(m string) string {
+		if table := htmlTable(htmlTableRe.FindStringSubmatch(m)[1], base); table != "" {
+			return setAside(table)
+		}
+		return ""
+	})
+	body = htmlPreRe.ReplaceAllStringFunc(body, func(m string) string {
+		sub := htmlPreRe.FindStringSubmatch(m)
+		lang := ""
+		if l := htmlLangRe.FindStringSubmatch(sub[1] + sub[2][:min(len(sub[2]), 200)]); l != nil {
+			lang = strings.ToLower(l[1])
+		}
+		code := html.UnescapeString(htmlTagRe.ReplaceAllString(sub[2], ""))
+		return setAside("```" + lang + "\n" + strings.Trim(code, "\n") + "\n```")
+	})
+	body = htmlOlRe.ReplaceAllStringFunc(body, func(m string) string {
+		n := 0
+		return htmlLiRe.ReplaceAllStringFunc(htmlOlRe.FindStringSubmatch(m)[1], func(string) string {
+			n++
+			return fmt.Sprintf("\n%d. ", n)
+		})
+	})
+	body = htmlHeadRe.ReplaceAllStringFunc(body, func(m string) string {
+		sub := htmlHeadRe.FindStringSubmatch(m)
+		return "\n\n" + strings.Repeat("#", int(sub[1][0]-'0')) + " " + htmlSpaceRe.ReplaceAllString(htmlText(sub[2]), " ") + "\n\n"
+	})
+	body = htmlLinkRe.ReplaceAllStringFunc(body, func(m string) string {
+		sub := htmlLinkRe.FindStringSubmatch(m)
+		return htmlLink(sub[1], sub[2], base)
+	})
+	body = htmlCodeRe.ReplaceAllString(body, "`$1`")
+	body = htmlStrongRe.ReplaceAllString(body, "**$1**")
+	body = htmlEmRe.ReplaceAllString(body, "_${1}_")
+	body = htmlLiRe.ReplaceAllString(body, "\n- ")
+	body = htmlHrRe.ReplaceAllString(body, "\n\n---\n\n")
+	body = htmlBlockRe.ReplaceAllString(body, "\n")
+	body = html.UnescapeString(htmlTagRe.ReplaceAllString(body, ""))
+
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(htmlSpaceRe.ReplaceAllString(line, " "))
+		if line == "-" || line == "**" || line == "__" {
+			line = "" // a list item or emphasis that only held dropped chrome
+		}
+		lines = append(lines, line)
+	}
+	text := htmlBlankRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	text = htmlMarkRe.ReplaceAllStringFunc(text, func(m string) string {
+		i, _ := strconv.Atoi(htmlMarkRe.FindStringSubmatch(m)[1])
+		if i >= len(blocks) {
+			return ""
+		}
+		return blocks[i]
+	})
+	return strings.TrimSpace(text)
+}
+
+// [text](href), with href made absolute; just the text for links that
+// go nowhere useful (anchors, javascript:)
+func htmlLink(href, inner string, base *url.URL) string {
+	text := htmlSpaceRe.ReplaceAllString(strings.TrimSpace(htmlTagRe.ReplaceAllString(inner, "")), " ")
+	href = html.UnescapeString(strings.TrimSpace(href))
+	if text == "" || href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return inner
+	}
+	if u, err := url.Parse(href); err == nil && base != nil {
+		href = base.ResolveReference(u).String()
+	}
+	if text == href {
+		return "<" + href + ">"
+	}
+	return "[" + text + "](" + href + ")"
+}
+
+// A markdown table; the first row is the header. "" when the table is
+// only there for layout (a single column)
+func htmlTable(table string, base *url.URL) string {
+	var rows [][]string
+	cols := 0
+	for _, row := range htmlRowRe.FindAllStringSubmatch(table, -1) {
+		var cells []string
+		for _, cell := range htmlCellRe.FindAllStringSubmatch(row[1], -1) {
+			text := htmlToMarkdown(cell[2], base)
+			text = strings.ReplaceAll(strings.ReplaceAll(text, "\n", " "), "|", `\|`)
+			cells = append(cells, text)
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+			cols = max(cols, len(cells))
+		}
+	}
+	if cols < 2 {
+		var lines []string
+		for _, r := range rows {
+			lines = append(lines, strings.Join(r, " "))
+		}
+		return strings.Join(lines, "\n\n")
+	}
+	var b strings.Builder
+	for i, r := range rows {
+		for len(r) < cols {
+			r = append(r, "")
+		}
+		b.WriteString("| " + strings.Join(r, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat(" --- |", cols) + "\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Fetches url and returns its readable markdown (HTML) or body (other
+// text types), cached like fetch
+func fetchReadable(url string) string {
+	return cachedWeb("page", url, func() (string, bool) {
+		resp, err := politeGet(url, 30*time.Second, true)
+		if err != nil {
+			return fmt.Sprintf("Error: %s", err), false
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Sprintf("Error: %s", resp.Status), false
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		ctype := strings.ToLower(resp.Header.Get("Content-Type"))
+		switch {
+		case strings.Contains(ctype, "html") || ctype == "" && strings.Contains(strings.ToLower(string(data[:min(len(data), 512)])), "<html"):
+			title, text := readablePage(string(data), resp.Request.URL.String())
+			if title != "" && !strings.HasPrefix(text, "# "+title+"\n") {
+				text = "# " + title + "\n\n" + text
+			}
+			return text, true
+		case strings.HasPrefix(ctype, "text/") || strings.Contains(ctype, "json") || strings.Contains(ctype, "xml") || strings.Contains(ctype, "javascript"):
+			return string(data), true
+		}
+		return fmt.Sprintf("Error: not a text page (%s)", ctype), false
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ==================== WEBHOOK ====================
+
+// When settings.WebhookURL (or MYTOOL_WEBHOOK_URL) is set, headless runs
+// POST a JSON summary there on completion so other automation can pick up
+// the result. With a secret the body is signed in X-Mytool-Signature
+// (sha256=<hex HMAC>), the same scheme GitHub uses.
+
+type webhookPayload struct {
+	Event      string  `json:"event"`  // run.completed, ...
+	Status     string  `json:"status"` // ok, error, cancelled
+	Summary    string  `json:"summary"`
+	Error      string  `json:"error,omitempty"`
+	Session    string  `json:"session"`
+	Dir        string  `json:"dir"`
+	Model      string  `json:"model"`
+	Tokens     int     `json:"tokens"`
+	Cost       float64 `json:"cost"`
+	DurationMs int64   `json:"duration_ms"`
+	Time       string  `json:"time"`
+}
+
+func webhookURL() string {
+	if u := os.Getenv("MYTOOL_WEBHOOK_URL"); u != "" {
+		return u
+	}
+	return settings.WebhookURL
+}
+
+// Fills in the common fields and delivers p; failures are reported, not fatal
+func notifyWebhook(p webhookPayload, started time.Time) {
+	url := webhookURL()
+	if url == "" {
+		return
+	}
+	p.Session = sessionID
+	p.Dir = currentDir
+	p.Model = activeModel()
+	p.Tokens = ledgerTokens()
+	p.Cost = totalCost
+	p.DurationMs = time.Since(started).Milliseconds()
+	p.Time = time.Now().UTC().Format(time.RFC3339)
+	p.Summary = truncate(p.Summary, 2000)
+	body, _ := json.Marshal(p)
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "webhook: %s\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webUserAgent())
+	req.Header.Set("X-Mytool-Event", p.Event)
+	secret := os.Getenv("MYTOOL_WEBHOOK_SECRET")
+	if secret == "" {
+		secret = settings.WebhookSecret
+	}
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Mytool-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "webhook: %s\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Fprintf(os.Stderr, "webhook: %s returned %s\n", url, resp.Status)
+	}
+}
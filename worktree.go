@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ==================== WORKTREE SESSIONS ====================
+
+// With worktree sessions on (or mytool --worktree), a new session works in
+// a git worktree of its own, on branch mytool/session-<id> from HEAD, kept
+// under the repository's git directory. The checkout the user started in
+// is not touched until /worktree merge merges the branch into it.
+// Project memory and sessions stay filed under the original checkout, and
+// untracked project settings (.mytool/, instruction files) are copied in
+// so the policy and checks still apply.
+
+var sessionWorktree struct {
+	Root   string // the checkout the session started in
+	Path   string // the worktree
+	Branch string
+	Base   string   // commit the branch started from
+	Copied []string // untracked files copied in, left out of commits
+}
+
+func worktreeActive() bool {
+	return sessionWorktree.Path != ""
+}
+
+// Moves the session into a new worktree; false when it stays where it is
+func startWorktree() bool {
+	if worktreeActive() {
+		return true
+	}
+	if !haveBinary("git") {
+		fmt.Printf("%s⚠ Worktree session needs git; working in place%s\n", colorYellow, colorReset)
+		return false
+	}
+	root := findProjectRoot()
+	if root == "" {
+		fmt.Printf("%s⚠ Not in a git repository; working in place%s\n", colorYellow, colorReset)
+		return false
+	}
+	base, err := gitIn(root, "rev-parse", "HEAD")
+	if err != nil {
+		fmt.Printf("%s⚠ The repository has no commits yet; working in place%s\n", colorYellow, colorReset)
+		return false
+	}
+	common, err := gitIn(root, "rev-parse", "--path-format=absolute", "--git-common-dir")
+	if err != nil {
+		fmt.Printf("%s⚠ Worktree session: %s; working in place%s\n", colorYellow, err, colorReset)
+		return false
+	}
+	path := filepath.Join(common, "mytool-worktrees", sessionID)
+	branch := "mytool/session-" + sessionID
+	if _, err := gitIn(root, "worktree", "add", "-q", "-b", branch, path, base); err != nil {
+		fmt.Printf("%s⚠ Worktree session: %s; working in place%s\n", colorYellow, err, colorReset)
+		return false
+	}
+	rel, _ := filepath.Rel(root, currentDir)
+	sessionWorktree.Root, sessionWorktree.Path, sessionWorktree.Branch, sessionWorktree.Base = root, path, branch, base
+	sessionWorktree.Copied = copyUntrackedSettings(root, path)
+	currentDir = filepath.Join(path, rel)
+	if _, err := os.Stat(currentDir); err != nil {
+		currentDir = path // an untracked directory
+	}
+	confinedRoot = ""
+	detectProject()
+	loadMCPServers()
+
+	fmt.Printf("%s🌿 Working in a worktree on branch %s; %s stays as it is (/worktree merge brings the changes over)%s\n",
+		colorGreen, branch, root, colorReset)
+	if status, _ := gitIn(root, "status", "--porcelain", "--untracked-files=no"); status != "" {
+		fmt.Printf("%s⚠ Uncommitted changes in %s are not in the worktree%s\n", colorYellow, root, colorReset)
+	}
+	return true
+}
+
+// Copies the project's untracked .mytool files and instruction files into
+// the worktree; the files copied
+func copyUntrackedSettings(root, path string) []string {
+	var copied []string
+	names := append([]string{}, projectInstructionFiles...)
+	if entries, err := os.ReadDir(filepath.Join(root, ".mytool")); err == nil {
+		for _, e := range entries {
+			if e.Type().IsRegular() && e.Name() != "index.json" {
+				names = append(names, filepath.Join(".mytool", e.Name()))
+			}
+		}
+	}
+	for _, name := range names {
+		dst := filepath.Join(path, name)
+		if _, err := os.Stat(dst); err == nil {
+			continue // tracked, so already checked out
+		}
+		src, err := os.Open(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		os.MkdirAll(filepath.Dir(dst), 0755)
+		if out, err := os.Create(dst); err == nil {
+			io.Copy(out, src)
+			out.Close()
+			copied = append(copied, filepath.ToSlash(name))
+		}
+		src.Close()
+	}
+	return copied
+}
+
+// Pathspec for everything in the worktree but the copied files
+func worktreePathspec() []string {
+	spec := []string{"--", "."}
+	for _, name := range sessionWorktree.Copied {
+		spec = append(spec, ":(exclude)"+name)
+	}
+	return spec
+}
+
+// Moves the session back to the original checkout
+func leaveWorktree() {
+	rel, err := filepath.Rel(sessionWorktree.Path, currentDir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = "."
+	}
+	currentDir = filepath.Join(sessionWorktree.Root, rel)
+	sessionWorktree.Root, sessionWorktree.Path, sessionWorktree.Branch, sessionWorktree.Base = "", "", "", ""
+	sessionWorktree.Copied = nil
+	confinedRoot = ""
+	detectProject()
+	loadMCPServers()
+}
+
+// The project a worktree session belongs to: the original checkout
+func worktreeProject(root string) string {
+	if worktreeActive() && root == sessionWorktree.Path {
+		return sessionWorktree.Root
+	}
+	return root
+}
+
+// /worktree [start|merge|leave|discard]
+func cmdWorktree(arg string) string {
+	if arg == "start" {
+		if !startWorktree() {
+			return "Worktree not started"
+		}
+		return ""
+	}
+	if !worktreeActive() {
+		if arg != "" {
+			return "Not in a worktree session (/worktree start)"
+		}
+		state := "off"
+		if settings.WorktreeSessions {
+			state = "on"
+		}
+		return fmt.Sprintf("Not in a worktree session; worktree sessions for new sessions: %s\n%s/worktree start moves this session into one; mytool --worktree starts in one%s", state, colorGray, colorReset)
+	}
+	wt := sessionWorktree
+	switch arg {
+	case "":
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Worktree: %s\nBranch:   %s (from %s)\nCheckout: %s", wt.Path, wt.Branch, wt.Base[:8], wt.Root))
+		if tree, err := worktreeTree(wt.Path); err == nil {
+			if stat, _ := gitIn(wt.Path, append([]string{"diff", "--stat=80", wt.Base, tree}, worktreePathspec()...)...); stat != "" {
+				b.WriteString("\n\n" + stat)
+			} else {
+				b.WriteString("\n\nNo changes yet")
+			}
+		}
+		b.WriteString(fmt.Sprintf("\n%s/worktree merge · leave · discard%s", colorGray, colorReset))
+		return b.String()
+
+	case "merge":
+		if status, _ := gitIn(wt.Path, append([]string{"status", "--porcelain"}, worktreePathspec()...)...); status != "" {
+			if _, err := gitIn(wt.Path, append([]string{"add", "-A"}, worktreePathspec()...)...); err != nil {
+				return fmt.Sprintf("Error: %s", err)
+			}
+			if msg := gitCommit(wt.Path, "mytool: changes from session "+sessionID); strings.HasPrefix(msg, "Error:") {
+				return msg
+			}
+		}
+		if ahead, _ := gitIn(wt.Path, "rev-list", "--count", wt.Base+"..HEAD"); ahead == "0" {
+			return "Nothing to merge: no changes in the worktree"
+		}
+		if _, err := gitIn(wt.Root, "merge", "--no-ff", "--no-edit", "-m", "Merge mytool session "+sessionID, wt.Branch); err != nil {
+			return fmt.Sprintf("Error: %s\n%sThe branch %s is kept; merge it by hand in %s (then /resolve for conflicts)%s", err, colorGray, wt.Branch, wt.Root, colorReset)
+		}
+		current, _ := gitIn(wt.Root, "branch", "--show-current")
+		stat, _ := gitIn(wt.Root, "diff", "--shortstat", "HEAD^1", "HEAD")
+		return fmt.Sprintf("%s✓ Merged %s into %s in %s:%s%s\n%sKeep working here, or /worktree discard to remove the worktree%s",
+			colorGreen, wt.Branch, current, wt.Root, stat, colorReset, colorGray, colorReset)
+
+	case "leave":
+		leaveWorktree()
+		return fmt.Sprintf("→ %s\n%sThe worktree stays at %s on branch %s%s", currentDir, colorGray, wt.Path, wt.Branch, colorReset)
+
+	case "discard":
+		if merged, _ := gitIn(wt.Root, "branch", "--merged", "HEAD", "--list", wt.Branch); merged == "" {
+			if !confirmAction(fmt.Sprintf("Discard the worktree and branch %s with its unmerged changes?", wt.Branch)) {
+				return "Cancelled"
+			}
+		}
+		leaveWorktree()
+		if _, err := gitIn(wt.Root, "worktree", "remove", "--force", wt.Path); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		if _, err := gitIn(wt.Root, "branch", "-D", wt.Branch); err != nil {
+			return fmt.Sprintf("Error: %s", err)
+		}
+		return fmt.Sprintf("%s✓ Removed the worktree and branch %s%s\n→ %s", colorGreen, wt.Branch, colorReset, currentDir)
+	}
+	return "Usage: /worktree [start|merge|leave|discard]"
+}
+
+// Where the work is, for the goodbye
+func worktreeReminder() {
+	if worktreeActive() {
+		fmt.Printf("%s🌿 This session's changes stay on branch %s (worktree %s) until you git merge %s%s\n",
+			colorGray, sessionWorktree.Branch, sessionWorktree.Path, sessionWorktree.Branch, colorReset)
+	}
+}